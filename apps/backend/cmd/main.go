@@ -1,17 +1,43 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"file-manager-backend/internal/annotate"
+	"file-manager-backend/internal/archive"
+	"file-manager-backend/internal/backup"
+	"file-manager-backend/internal/catalog"
+	"file-manager-backend/internal/classify"
+	"file-manager-backend/internal/collection"
 	"file-manager-backend/internal/config"
 	"file-manager-backend/internal/db"
+	"file-manager-backend/internal/diskusage"
+	"file-manager-backend/internal/extract"
 	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/journal"
+	"file-manager-backend/internal/media"
+	"file-manager-backend/internal/metadata"
+	"file-manager-backend/internal/organize"
+	"file-manager-backend/internal/rename"
+	"file-manager-backend/internal/search"
+	"file-manager-backend/internal/similar"
+	"file-manager-backend/internal/summarize"
+	"file-manager-backend/internal/thumbnail"
+	"file-manager-backend/internal/transfer"
+	"file-manager-backend/internal/trash"
+	"file-manager-backend/internal/watch"
 )
 
 func main() {
@@ -28,16 +54,128 @@ func main() {
 	}
 	defer dbConn.Close()
 
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		err = db.Migrate(dbConn, sqlPath)
+	// The init script only uses CREATE ... IF NOT EXISTS, so it is safe to
+	// run on every start and picks up tables added since the database was
+	// first created.
+	if err := db.Migrate(dbConn, sqlPath); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	fmt.Println("Database initialized.")
+
+	// Hashes and sniffed MIME types are kept in the files table so they
+	// survive restarts.
+	fileCatalog := catalog.NewStore(dbConn)
+	fileops.SetMetaCache(fileops.NewMetaCache(fileops.DefaultCacheCapacity, fileCatalog))
+	// Archives can be listed like folders, e.g. /backups/photos.zip/2019.
+	fileops.SetVirtualFS(archive.Resolve)
+	// Photo, audio and video tags are extracted on first use and kept by
+	// content hash.
+	meta := metadata.New(dbConn)
+	fileops.SetMediaLookup(meta.Lookup)
+	// Classes come from the built-in rule-based classifiers; catalog
+	// entries are classified in the background as they are hashed.
+	classifier := classify.New(dbConn, classify.Defaults()...)
+	fileops.SetClassLookup(classifier.Lookup)
+	go classifier.Run(context.Background(), time.Minute)
+	// User tags, ratings and notes follow files moved through fileops.
+	annotations := annotate.New(dbConn)
+	annotations.WriteXattrs = cfg.Annotations.Xattrs
+	fileops.SetAnnotationLookup(annotations.Lookup)
+	fileops.SetMoveHook(annotations.Moved)
+
+	scanner := diskusage.NewScanner(dbConn)
+
+	index := search.NewIndex(dbConn)
+	// Saved searches list as collection://<name> and can be synced.
+	collections := collection.New(dbConn, index)
+	for _, root := range cfg.Search.Roots {
+		if err := index.AddRoot(root); err != nil {
+			log.Printf("Failed to register search root %s: %v", root, err)
+		}
+	}
+	if cfg.Search.Content {
+		index.EnableContent(fileops.ListOptions{Exclude: cfg.Search.Exclude})
+	}
+	rescanInterval, err := time.ParseDuration(cfg.Search.RescanInterval)
+	if err != nil {
+		log.Fatalf("Invalid search rescan interval: %v", err)
+	}
+	go index.Run(context.Background(), rescanInterval)
+	// Changes below search roots reach the index as they happen; the
+	// periodic rescans catch whatever the watcher misses.
+	watcher, err := watch.New()
+	if err != nil {
+		log.Printf("Change watcher unavailable, relying on rescans: %v", err)
+	}
+	watchRoot := func(root string) {
+		if err := watcher.Add(root); err != nil {
+			log.Printf("Failed to watch %s: %v", root, err)
+		}
+	}
+	if watcher != nil {
+		roots, err := index.Roots()
+		if err != nil {
+			log.Printf("Failed to list search roots: %v", err)
+		}
+		go func() {
+			for _, root := range roots {
+				watchRoot(root)
+			}
+		}()
+	}
+
+	var maxUploadSize int64
+	if cfg.Transfer.MaxUploadSize != "" {
+		if maxUploadSize, err = fileops.ParseSize(cfg.Transfer.MaxUploadSize); err != nil {
+			log.Fatalf("Invalid max upload size: %v", err)
+		}
+	}
+	uploadExpiry, err := time.ParseDuration(cfg.Transfer.UploadExpiry)
+	if err != nil {
+		log.Fatalf("Invalid upload expiry: %v", err)
+	}
+	uploads, err := transfer.NewUploads(cfg.Transfer.UploadDir, maxUploadSize)
+	if err != nil {
+		log.Fatalf("Failed to create upload directory: %v", err)
+	}
+	trashHome, err := trash.HomeDir()
+	if err != nil {
+		log.Fatalf("Failed to locate trash: %v", err)
+	}
+	bin := trash.New(trashHome)
+	ops := journal.New(dbConn, bin)
+	thumbs := thumbnail.New(cfg.Thumbnails.Dir)
+	imageHashes := similar.New(dbConn)
+	organizer := organize.New(dbConn, fileCatalog, bin, ops)
+	organizePoll, err := time.ParseDuration(cfg.Organize.PollInterval)
+	if err != nil {
+		log.Fatalf("Invalid organize poll interval: %v", err)
+	}
+	// Document summaries are only produced when an endpoint is configured.
+	var summarizer *summarize.Summarizer
+	if cfg.Summarize.Endpoint != "" {
+		minInterval, err := time.ParseDuration(cfg.Summarize.MinInterval)
 		if err != nil {
-			log.Fatalf("Failed to migrate database: %v", err)
+			log.Fatalf("Invalid summarize interval: %v", err)
 		}
-		fmt.Println("Database initialized.")
-	} else {
-		fmt.Println("Database already exists.")
+		summarizer = summarize.New(dbConn,
+			summarize.NewOpenAI(cfg.Summarize.Endpoint, cfg.Summarize.Model, cfg.Summarize.APIKey),
+			summarize.Options{Exclude: cfg.Summarize.Exclude, MinInterval: minInterval, MaxChars: cfg.Summarize.MaxChars})
 	}
 
+	go func() {
+		for ; ; time.Sleep(time.Hour) {
+			if n, err := uploads.Expire(uploadExpiry); err != nil {
+				log.Printf("Failed to expire uploads: %v", err)
+			} else if n > 0 {
+				log.Printf("Removed %d expired uploads", n)
+			}
+			if err := ops.Prune(1000); err != nil {
+				log.Printf("Failed to prune operation journal: %v", err)
+			}
+		}
+	}()
+
 	http.HandleFunc("/api/list", func(w http.ResponseWriter, r *http.Request) {
 		dir := r.URL.Query().Get("dir")
 		if dir == "" {
@@ -64,24 +202,54 @@ func main() {
 		if showHidden := r.URL.Query().Get("hidden"); showHidden == "true" {
 			opts.ShowHidden = true
 		}
+		if q := r.URL.Query().Get("q"); q != "" {
+			query, err := fileops.ParseQuery(q)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			opts.Query = query
+		}
+		if r.URL.Query().Get("metadata") == "true" {
+			opts.Metadata = true
+		}
+		if r.URL.Query().Get("annotations") == "true" {
+			opts.Annotations = true
+		}
 
-		files, err := fileops.ListFilesMeta(dir, opts)
-		if err != nil {
-			switch err {
-			case fileops.ErrInvalidPath:
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			case fileops.ErrPathNotFound:
-				http.Error(w, err.Error(), http.StatusNotFound)
-			case fileops.ErrPermissionDenied:
-				http.Error(w, err.Error(), http.StatusForbidden)
-			case fileops.ErrPatternInvalid:
-				http.Error(w, err.Error(), http.StatusBadRequest)
-			default:
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+		var files []fileops.FileInfo
+		var err error
+		if name, ok := collection.PathName(dir); ok {
+			var c collection.Collection
+			if c, err = collections.ByName(name); err == nil {
+				files, err = collections.Files(c, opts)
 			}
+		} else {
+			files, err = fileops.ListFiles(dir, opts)
+		}
+		if err != nil {
+			writeError(w, err)
 			return
 		}
 
+		// Replace directory inode sizes with the size of their contents
+		// where a recent scan knows it. Other folders are scanned in the
+		// background and keep their inode size until a later listing.
+		if r.URL.Query().Get("dirSizes") == "true" {
+			var dirs []string
+			for _, f := range files {
+				if f.IsDirectory {
+					dirs = append(dirs, f.Path)
+				}
+			}
+			sizes := scanner.Sizes(dirs)
+			for i := range files {
+				if size, ok := sizes[files[i].Path]; ok && files[i].IsDirectory {
+					files[i].Size = size
+				}
+			}
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(files); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -95,7 +263,30 @@ func main() {
 			http.Error(w, "src and dst required", http.StatusBadRequest)
 			return
 		}
-		copied, err := fileops.SyncUniqueFiles(src, dst)
+		query, err := fileops.ParseQuery(r.URL.Query().Get("q"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		// A destination set up through /api/destinations/init stores files
+		// in its own format; any other folder receives plain copies.
+		dest, err := backup.OpenDestination(dst, r.Header.Get(backupPasswordHeader))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		dest.SetCatalog(fileCatalog)
+		var copied []string
+		if name, ok := collection.PathName(src); ok {
+			var c collection.Collection
+			if c, err = collections.ByName(name); err != nil {
+				writeError(w, err)
+				return
+			}
+			copied, err = collections.Sync(c, dest, query)
+		} else {
+			copied, err = fileops.SyncMatchingFiles(src, dest, query)
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -103,6 +294,1414 @@ func main() {
 		json.NewEncoder(w).Encode(copied)
 	})
 
-	fmt.Println("File Manager Backend API running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	http.HandleFunc("/api/du", func(w http.ResponseWriter, r *http.Request) {
+		dir := r.URL.Query().Get("dir")
+		if dir == "" {
+			dir = "."
+		}
+
+		opts := diskusage.Options{Depth: 3}
+		if depth := r.URL.Query().Get("depth"); depth != "" {
+			if d, err := strconv.Atoi(depth); err == nil {
+				opts.Depth = d
+			}
+		}
+		if r.URL.Query().Get("oneFileSystem") == "true" {
+			opts.OneFileSystem = true
+		}
+		if r.URL.Query().Get("hidden") == "true" {
+			opts.ShowHidden = true
+		}
+
+		tree, err := scanner.Scan(dir, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(tree); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fileops.CurrentMetaCache().Stats())
+	})
+
+	http.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			http.Error(w, "q required", http.StatusBadRequest)
+			return
+		}
+
+		limit := 0
+		if l := r.URL.Query().Get("limit"); l != "" {
+			if n, err := strconv.Atoi(l); err == nil {
+				limit = n
+			}
+		}
+
+		var results interface{}
+		var err error
+		if r.URL.Query().Get("mode") == "content" {
+			opts := search.ContentOptions{
+				Limit: limit,
+				Root:  r.URL.Query().Get("root"),
+			}
+			if include := r.URL.Query().Get("include"); include != "" {
+				opts.Rules.Include = strings.Split(include, ",")
+			}
+			if exclude := r.URL.Query().Get("exclude"); exclude != "" {
+				opts.Rules.Exclude = strings.Split(exclude, ",")
+			}
+			if r.URL.Query().Get("hidden") == "true" {
+				opts.Rules.ShowHidden = true
+			}
+			results, err = index.SearchContent(q, opts)
+		} else {
+			var filter *fileops.Query
+			if filter, err = fileops.ParseQuery(r.URL.Query().Get("filter")); err != nil {
+				writeError(w, err)
+				return
+			}
+			results, err = index.Search(q, search.Options{
+				Mode:   search.Mode(r.URL.Query().Get("mode")),
+				Limit:  limit,
+				Root:   r.URL.Query().Get("root"),
+				Filter: filter,
+			})
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/search/roots", func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := index.AddRoot(path); err != nil {
+				writeError(w, err)
+				return
+			}
+			go index.Refresh(path)
+			if watcher != nil {
+				go watchRoot(path)
+			}
+		case http.MethodDelete:
+			if err := index.RemoveRoot(path); err != nil {
+				writeError(w, err)
+				return
+			}
+			if watcher != nil {
+				watcher.Remove(path)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		roots, err := index.Roots()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(roots)
+	})
+
+	http.HandleFunc("/api/files/content", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		path := r.URL.Query().Get("path")
+		download := r.URL.Query().Get("download") == "true"
+		if archivePath, inner, ok := archive.Split(path); ok && inner != "." {
+			serveArchiveEntry(w, r, archivePath, inner, download)
+			return
+		}
+		if err := transfer.ServeFile(w, r, path, download); err != nil {
+			writeError(w, err)
+		}
+	})
+
+	// Metadata covers media tags and, when summaries are enabled, the
+	// stored document summary. summarize=true asks for a summary that has
+	// not been written yet.
+	http.HandleFunc("/api/files/metadata", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		path := r.URL.Query().Get("path")
+		document := summarizer != nil && extract.Supported(path)
+		m, hash, err := meta.Get(path)
+		if errors.Is(err, media.ErrUnsupported) && document {
+			if _, err = fileops.Stat(path); err == nil {
+				hash, err = fileops.FileHash(path)
+			}
+		}
+		if errors.Is(err, media.ErrUnsupported) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		var summary *summarize.Summary
+		if document {
+			if r.URL.Query().Get("summarize") == "true" {
+				summary, err = summarizer.Summarize(r.Context(), path)
+			} else {
+				summary, err = summarizer.Cached(path)
+			}
+			if errors.Is(err, summarize.ErrNoText) {
+				err = nil
+			}
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Path     string             `json:"path"`
+			Hash     string             `json:"hash"`
+			Metadata *media.Metadata    `json:"metadata"`
+			Summary  *summarize.Summary `json:"summary,omitempty"`
+		}{path, hash, m, summary})
+	})
+
+	// GET returns the tags, rating and note of a file or folder; POST
+	// replaces them.
+	http.HandleFunc("/api/files/annotation", func(w http.ResponseWriter, r *http.Request) {
+		var (
+			path string
+			a    *fileops.Annotation
+			err  error
+		)
+		if r.Method == http.MethodGet {
+			path = r.URL.Query().Get("path")
+			a, err = annotations.Get(path)
+		} else {
+			var req struct {
+				Path string `json:"path"`
+				fileops.Annotation
+			}
+			if !decodeJSON(w, r, &req) {
+				return
+			}
+			path = req.Path
+			a, err = annotations.Set(path, req.Annotation)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if a == nil {
+			a = &fileops.Annotation{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Path string `json:"path"`
+			*fileops.Annotation
+		}{path, a})
+	})
+
+	// GET lists tags in use; POST adds and removes tags on several files.
+	http.HandleFunc("/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			counts, err := annotations.Tags()
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(counts)
+			return
+		}
+		var req struct {
+			Paths  []string `json:"paths"`
+			Add    []string `json:"add"`
+			Remove []string `json:"remove"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		results := make([]itemResult, len(req.Paths))
+		for i, path := range req.Paths {
+			_, err := annotations.Tag(path, req.Add, req.Remove)
+			results[i] = newItemResult(path, "", err)
+		}
+		writeResults(w, results)
+	})
+
+	// GET returns a file's classes, classifying it first if needed; POST
+	// classifies the given paths again.
+	http.HandleFunc("/api/files/classes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			path := r.URL.Query().Get("path")
+			tags, err := classifier.Tags(path)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(struct {
+				Path string         `json:"path"`
+				Tags []classify.Tag `json:"tags"`
+			}{path, tags})
+			return
+		}
+		var req struct {
+			Paths []string `json:"paths"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		results := make([]itemResult, len(req.Paths))
+		for i, path := range req.Paths {
+			_, err := classifier.Classify(path)
+			results[i] = newItemResult(path, "", err)
+		}
+		writeResults(w, results)
+	})
+
+	http.HandleFunc("/api/classify/status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := classifier.Status()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+
+	// Thumbnails are JPEGs scaled to the small, medium or large size and
+	// generated on first request.
+	http.HandleFunc("/api/thumbnails", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		size, err := thumbnail.ParseSize(r.URL.Query().Get("size"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		thumbPath, hash, err := thumbs.Get(r.URL.Query().Get("path"), size)
+		if errors.Is(err, thumbnail.ErrUnsupported) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		f, err := os.Open(thumbPath)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, hash, size))
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		http.ServeContent(w, r, "", time.Time{}, f)
+	})
+
+	// Pre-generation runs in the background; GET lists recent jobs.
+	http.HandleFunc("/api/thumbnails/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(thumbs.Jobs())
+			return
+		}
+		var req struct {
+			Dir       string   `json:"dir"`
+			Recursive bool     `json:"recursive"`
+			Sizes     []string `json:"sizes"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if len(req.Sizes) == 0 {
+			req.Sizes = []string{thumbnail.DefaultSize}
+		}
+		sizes := make([]int, len(req.Sizes))
+		for i, name := range req.Sizes {
+			size, err := thumbnail.ParseSize(name)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			sizes[i] = size
+		}
+		job, err := thumbs.Generate(req.Dir, req.Recursive, sizes)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	})
+
+	// Similar images are near-duplicates by perceptual hash. Each cluster
+	// names the image with the highest resolution, and every member is
+	// within threshold bits of it.
+	http.HandleFunc("/api/images/similar", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		opts := similar.Options{
+			Root:      r.URL.Query().Get("root"),
+			Algorithm: r.URL.Query().Get("algorithm"),
+		}
+		if t := r.URL.Query().Get("threshold"); t != "" {
+			n, err := strconv.Atoi(t)
+			if err != nil {
+				http.Error(w, "invalid threshold", http.StatusBadRequest)
+				return
+			}
+			opts.Threshold = n
+		}
+		report, err := imageHashes.Report(r.Context(), opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+
+	// Archive downloads stream a zip or tar.gz of the selected paths. GET
+	// takes repeated path parameters for plain links; POST takes a JSON
+	// body for selections too large for a URL.
+	http.HandleFunc("/api/archive", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Paths   []string `json:"paths"`
+			Format  string   `json:"format"`
+			Include []string `json:"include"`
+			Exclude []string `json:"exclude"`
+			Hidden  bool     `json:"hidden"`
+		}
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			req.Paths = query["path"]
+			req.Format = query.Get("format")
+			if include := query.Get("include"); include != "" {
+				req.Include = strings.Split(include, ",")
+			}
+			if exclude := query.Get("exclude"); exclude != "" {
+				req.Exclude = strings.Split(exclude, ",")
+			}
+			req.Hidden = query.Get("hidden") == "true"
+		default:
+			if !decodeJSON(w, r, &req) {
+				return
+			}
+		}
+
+		format, err := archive.ParseFormat(req.Format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := archive.Check(req.Paths); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		name := "download"
+		if len(req.Paths) == 1 {
+			name = filepath.Base(filepath.Clean(req.Paths[0]))
+		}
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + format.Extension()}))
+		rules := fileops.ListOptions{Include: req.Include, Exclude: req.Exclude, ShowHidden: req.Hidden}
+		if err := archive.Write(w, format, req.Paths, rules); err != nil {
+			// The response has started, so the client sees a truncated
+			// archive; most often it went away.
+			log.Printf("Archive download failed: %v", err)
+		}
+	})
+
+	// Resumable uploads follow the tus 1.0 protocol: POST creates an
+	// upload, HEAD reports how many bytes arrived, PATCH appends from that
+	// offset and DELETE abandons it. The target folder and file name are
+	// passed as "dir" and "filename" in Upload-Metadata.
+	http.HandleFunc("/api/uploads", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", transfer.TusVersion)
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Tus-Version", transfer.TusVersion)
+			w.Header().Set("Tus-Extension", "creation,termination")
+			if uploads.MaxSize() > 0 {
+				w.Header().Set("Tus-Max-Size", strconv.FormatInt(uploads.MaxSize(), 10))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+			if err != nil {
+				http.Error(w, "Upload-Length required", http.StatusBadRequest)
+				return
+			}
+			meta, err := transfer.ParseMetadata(r.Header.Get("Upload-Metadata"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			dir := meta["dir"]
+			if dir == "" {
+				dir = r.URL.Query().Get("dir")
+			}
+			upload, err := uploads.Create(dir, meta["filename"], size, meta["overwrite"] == "true")
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Location", "/api/uploads/"+upload.ID)
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/uploads/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", transfer.TusVersion)
+		id := strings.TrimPrefix(r.URL.Path, "/api/uploads/")
+		switch r.Method {
+		case http.MethodHead:
+			upload, err := uploads.Get(id)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusOK)
+		case http.MethodPatch:
+			if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+				http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+				return
+			}
+			offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+			if err != nil {
+				http.Error(w, "Upload-Offset required", http.StatusBadRequest)
+				return
+			}
+			upload, err := uploads.Write(id, offset, r.Body)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			if err := uploads.Terminate(id); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// pathChanged keeps the search index and disk-usage cache in step with
+	// changes made through the API or reported by the watcher.
+	pathChanged := func(path string) {
+		index.Changed(path)
+		if err := scanner.Invalidate(filepath.Dir(path)); err != nil {
+			log.Printf("Failed to invalidate disk usage for %s: %v", path, err)
+		}
+	}
+	if watcher != nil {
+		go watcher.Run(context.Background(), pathChanged)
+	}
+
+	// record adds a completed operation to the undo journal.
+	record := func(kind string, items []journal.Item) {
+		if _, err := ops.Record(kind, items); err != nil {
+			log.Printf("Failed to record %s operation: %v", kind, err)
+		}
+	}
+
+	http.HandleFunc("/api/files/rename", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path string `json:"path"`
+			Name string `json:"name"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		newPath, err := fileops.Rename(req.Path, req.Name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		record("rename", []journal.Item{{Action: journal.ActionMove, Source: filepath.Clean(req.Path), Target: newPath}})
+		pathChanged(req.Path)
+		pathChanged(newPath)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"path": newPath})
+	})
+
+	// Batch renames take a template or a find/replace rule. With dryRun the
+	// plan is only returned; otherwise it is applied when free of
+	// conflicts, and a plan with conflicts is returned with 409.
+	http.HandleFunc("/api/files/batch-rename", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Paths []string `json:"paths"`
+			rename.Rule
+			DryRun bool `json:"dryRun"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		plan, err := rename.Preview(req.Paths, req.Rule)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.DryRun {
+			json.NewEncoder(w).Encode(plan)
+			return
+		}
+		if plan.Conflicts > 0 {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(plan)
+			return
+		}
+		done, err := rename.Apply(plan)
+		if err != nil {
+			w.Header().Del("Content-Type")
+			writeError(w, err)
+			return
+		}
+		items := make([]journal.Item, len(done))
+		for i, c := range done {
+			items[i] = journal.Item{Action: journal.ActionMove, Source: c.Path, Target: c.NewPath}
+			pathChanged(c.Path)
+			pathChanged(c.NewPath)
+		}
+		record("rename", items)
+		json.NewEncoder(w).Encode(plan)
+	})
+
+	http.HandleFunc("/api/files/mkdir", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path    string `json:"path"`
+			Parents bool   `json:"parents"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		created, err := fileops.MakeDir(req.Path, req.Parents)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		var items []journal.Item
+		for _, dir := range created {
+			items = append(items, journal.Item{Action: journal.ActionMkdir, Target: dir})
+		}
+		record("mkdir", items)
+		pathChanged(req.Path)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	// transferItems handles move, copy and extract, which take several
+	// paths and a destination folder and place each path there under the
+	// name given by targetName. Results are reported per item, with 207
+	// Multi-Status when only some of them succeeded. With overwrite set,
+	// existing items of the same kind are moved to the trash first, so
+	// undo can bring them back. Items the trash cannot take, such as those
+	// on a filesystem without a usable trash folder, are replaced in place
+	// and cannot be brought back.
+	transferItems := func(kind, action string, targetName func(string) string, op func(src, dst string, overwrite bool) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Paths     []string `json:"paths"`
+				Dest      string   `json:"dest"`
+				Overwrite bool     `json:"overwrite"`
+			}
+			if !decodeJSON(w, r, &req) {
+				return
+			}
+			if req.Dest == "" {
+				http.Error(w, "dest required", http.StatusBadRequest)
+				return
+			}
+			results := make([]itemResult, len(req.Paths))
+			var items []journal.Item
+			for i, src := range req.Paths {
+				src = filepath.Clean(src)
+				dst := filepath.Join(req.Dest, targetName(src))
+				err := op(src, dst, false)
+				if errors.Is(err, fileops.ErrAlreadyExists) && req.Overwrite && sameKind(src, dst) {
+					if replaced, terr := bin.Trash(dst); terr != nil {
+						err = op(src, dst, true)
+					} else if err = op(src, dst, false); err != nil {
+						bin.Restore(replaced.ID)
+					} else {
+						items = append(items, journal.Item{Action: journal.ActionTrash, Source: dst, Target: replaced.ID})
+					}
+				}
+				results[i] = newItemResult(src, dst, err)
+				if results[i].OK {
+					items = append(items, journal.Item{Action: action, Source: src, Target: dst})
+					pathChanged(src)
+					pathChanged(dst)
+				}
+			}
+			record(kind, items)
+			writeResults(w, results)
+		}
+	}
+	http.HandleFunc("/api/files/move", transferItems("move", journal.ActionMove, filepath.Base, fileops.Move))
+	http.HandleFunc("/api/files/copy", transferItems("copy", journal.ActionCopy, filepath.Base, fileops.Copy))
+	// Extracting takes paths inside archives, or whole archives, and
+	// unpacks just those entries into dest. Undo removes the extracted
+	// copies like it does for copies.
+	http.HandleFunc("/api/files/extract", transferItems("extract", journal.ActionCopy, archive.ExtractName, archive.Extract))
+
+	// Deleted items go to the trash unless permanent is set. Only trashed
+	// items can be undone.
+	http.HandleFunc("/api/files/delete", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Paths     []string `json:"paths"`
+			Permanent bool     `json:"permanent"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		results := make([]itemResult, len(req.Paths))
+		var items []journal.Item
+		for i, path := range req.Paths {
+			if req.Permanent {
+				results[i] = newItemResult(path, "", fileops.Delete(path))
+			} else {
+				item, err := bin.Trash(path)
+				results[i] = newItemResult(path, item.ID, err)
+				if err == nil {
+					items = append(items, journal.Item{Action: journal.ActionTrash, Source: item.OriginalPath, Target: item.ID})
+				}
+			}
+			if results[i].OK {
+				pathChanged(path)
+			}
+		}
+		record("delete", items)
+		writeResults(w, results)
+	})
+
+	// GET lists recent operations; POST undoes the last count operations,
+	// stopping at the first whose paths changed since.
+	http.HandleFunc("/api/undo", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			history, err := ops.List(limit)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(history)
+		case http.MethodPost:
+			count := 1
+			if c := r.URL.Query().Get("count"); c != "" {
+				n, err := strconv.Atoi(c)
+				if err != nil || n < 1 {
+					http.Error(w, "invalid count", http.StatusBadRequest)
+					return
+				}
+				count = n
+			}
+			undone, err := ops.Undo(count)
+			for _, op := range undone {
+				for _, path := range op.Paths() {
+					pathChanged(path)
+				}
+			}
+			if err != nil && len(undone) == 0 {
+				writeError(w, err)
+				return
+			}
+			resp := struct {
+				Undone []journal.Operation `json:"undone"`
+				Error  string              `json:"error,omitempty"`
+			}{Undone: undone}
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/trash", func(w http.ResponseWriter, r *http.Request) {
+		items, err := bin.List()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	})
+
+	http.HandleFunc("/api/trash/restore", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		results := make([]itemResult, len(req.IDs))
+		for i, id := range req.IDs {
+			restored, err := bin.Restore(id)
+			results[i] = newItemResult(id, restored, err)
+			if results[i].OK {
+				pathChanged(restored)
+			}
+		}
+		writeResults(w, results)
+	})
+
+	http.HandleFunc("/api/trash/delete", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		results := make([]itemResult, len(req.IDs))
+		for i, id := range req.IDs {
+			results[i] = newItemResult(id, "", bin.Delete(id))
+		}
+		writeResults(w, results)
+	})
+
+	http.HandleFunc("/api/trash/empty", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		removed, err := bin.Empty()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+	})
+
+	// Organize rules sort files from a source into a layout below a
+	// target. GET lists them, POST creates a rule or, with an id, replaces
+	// it, and DELETE removes the rule given by the id parameter.
+	organizer.Changed = pathChanged
+	go organizer.Schedule(context.Background(), organizePoll)
+	http.HandleFunc("/api/organize/rules", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			rules, err := organizer.Rules()
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(rules)
+		case http.MethodPost:
+			var rule organize.Rule
+			if !decodeJSON(w, r, &rule) {
+				return
+			}
+			created := rule.ID == 0
+			if err := organizer.SaveRule(&rule); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if created {
+				w.WriteHeader(http.StatusCreated)
+			}
+			json.NewEncoder(w).Encode(rule)
+		case http.MethodDelete:
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			if err := organizer.DeleteRule(id); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Collections are saved searches. GET lists them, POST creates one or
+	// updates it when an id is given, DELETE removes one by id. Their
+	// files are listed through /api/list?dir=collection://<name>.
+	http.HandleFunc("/api/collections", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list, err := collections.Collections()
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+		case http.MethodPost:
+			var c collection.Collection
+			if !decodeJSON(w, r, &c) {
+				return
+			}
+			created := c.ID == 0
+			if err := collections.Save(&c); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if created {
+				w.WriteHeader(http.StatusCreated)
+			}
+			json.NewEncoder(w).Encode(c)
+		case http.MethodDelete:
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			if err := collections.Delete(id); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Running takes a stored rule's id or an unsaved rule. With dryRun the
+	// plan is returned and nothing is touched; otherwise the result lists
+	// what was placed, skipped as a duplicate or failed. A run is undone
+	// as one operation.
+	http.HandleFunc("/api/organize/run", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     int64         `json:"id"`
+			Rule   organize.Rule `json:"rule"`
+			DryRun bool          `json:"dryRun"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		rule := req.Rule
+		if req.ID != 0 {
+			var err error
+			if rule, err = organizer.Rule(req.ID); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		var resp interface{}
+		var err error
+		if req.DryRun {
+			resp, err = organizer.Preview(rule)
+		} else {
+			resp, err = organizer.Apply(rule)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	http.HandleFunc("/api/organize/runs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(organizer.Runs())
+	})
+	// Backup repositories are deduplicating alternatives to sync targets:
+	// a directory holding chunked, content-addressed snapshots of folders.
+	// Requests name the repository by its directory. The password of an
+	// encrypted repository travels in a header, never in the URL; given to
+	// init, it creates an encrypted repository.
+	http.HandleFunc("/api/backup/init", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Repo        string `json:"repo"`
+			Compression string `json:"compression"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		repo, err := backup.Init(req.Repo, backup.Options{
+			Password:    r.Header.Get(backupPasswordHeader),
+			Compression: req.Compression,
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"repo": repo.Dir(), "encrypted": repo.Encrypted()})
+	})
+
+	// GET lists snapshots, or with id returns one with its files; POST
+	// starts backing up a folder in the background and returns the job,
+	// which /api/backup/jobs reports on; DELETE forgets a snapshot, whose
+	// data stays until the next prune.
+	backupJobs := backup.NewJobs(context.Background())
+	http.HandleFunc("/api/backup/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		repoDir := r.URL.Query().Get("repo")
+		var req struct {
+			Repo string `json:"repo"`
+			Src  string `json:"src"`
+		}
+		if r.Method == http.MethodPost {
+			if !decodeJSON(w, r, &req) {
+				return
+			}
+			repoDir = req.Repo
+		}
+		repo, err := backup.Open(repoDir, r.Header.Get(backupPasswordHeader))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			var resp interface{}
+			if id := r.URL.Query().Get("id"); id != "" {
+				resp, err = repo.Snapshot(id)
+			} else {
+				resp, err = repo.Snapshots()
+			}
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+		case http.MethodPost:
+			if req.Src == "" {
+				http.Error(w, "src required", http.StatusBadRequest)
+				return
+			}
+			job, err := backupJobs.Start(repo, req.Src)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(job)
+		case http.MethodDelete:
+			if err := repo.Forget(r.URL.Query().Get("id")); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/api/backup/jobs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(backupJobs.List())
+	})
+
+	// Restore writes a snapshot, or some paths of it, into a target folder
+	// and never overwrites existing files.
+	http.HandleFunc("/api/backup/restore", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Repo     string   `json:"repo"`
+			Snapshot string   `json:"snapshot"`
+			Target   string   `json:"target"`
+			Paths    []string `json:"paths"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		repo, err := backup.Open(req.Repo, r.Header.Get(backupPasswordHeader))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res, err := repo.Restore(r.Context(), req.Snapshot, req.Target, req.Paths)
+		pathChanged(req.Target)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	// Prune forgets the snapshots the policy does not keep, none for an
+	// empty policy, and frees the space no snapshot uses.
+	http.HandleFunc("/api/backup/prune", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Repo string `json:"repo"`
+			backup.Policy
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		repo, err := backup.Open(req.Repo, r.Header.Get(backupPasswordHeader))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res, err := repo.Prune(r.Context(), req.Policy)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	// Check verifies the index and snapshots; with readData it also reads
+	// every pack.
+	http.HandleFunc("/api/backup/check", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Repo     string `json:"repo"`
+			ReadData bool   `json:"readData"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		repo, err := backup.Open(req.Repo, r.Header.Get(backupPasswordHeader))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res, err := repo.Check(r.Context(), req.ReadData)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	// Stats show the space deduplication and compression save.
+	http.HandleFunc("/api/backup/stats", func(w http.ResponseWriter, r *http.Request) {
+		repo, err := backup.Open(r.URL.Query().Get("repo"), r.Header.Get(backupPasswordHeader))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		stats, err := repo.Stats()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+
+	// Changing the password of an encrypted repository takes the current
+	// one in the header and the new one in the body.
+	http.HandleFunc("/api/backup/password", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Repo     string `json:"repo"`
+			Password string `json:"password"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		repo, err := backup.Open(req.Repo, r.Header.Get(backupPasswordHeader))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := repo.ChangePassword(req.Password); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Sync destinations set up with a password hold /api/sync copies with
+	// their names and contents encrypted; set up with a compression method
+	// they compress files as they are copied. Restore decrypts and
+	// decompresses them into a target folder, never overwriting existing
+	// files; verify reads every file and reports those that are damaged.
+	http.HandleFunc("/api/destinations/init", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Dst         string `json:"dst"`
+			Compression string `json:"compression"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		dest, err := backup.InitDestination(req.Dst, backup.DestinationOptions{
+			Password:    r.Header.Get(backupPasswordHeader),
+			Compression: req.Compression,
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dst": dest.Root(), "encrypted": dest.Encrypted(), "compression": dest.Compression(),
+		})
+	})
+
+	http.HandleFunc("/api/destinations/restore", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Dst    string   `json:"dst"`
+			Target string   `json:"target"`
+			Paths  []string `json:"paths"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		dest, err := backup.OpenDestination(req.Dst, r.Header.Get(backupPasswordHeader))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res, err := dest.Restore(r.Context(), req.Target, req.Paths)
+		pathChanged(req.Target)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	http.HandleFunc("/api/destinations/verify", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Dst string `json:"dst"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		dest, err := backup.OpenDestination(req.Dst, r.Header.Get(backupPasswordHeader))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res, err := dest.Verify(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+
+	// Stats show the original and stored size of what was synced to a
+	// destination, as recorded in the catalog.
+	http.HandleFunc("/api/destinations/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("dst") == "" {
+			http.Error(w, "dst required", http.StatusBadRequest)
+			return
+		}
+		dst, err := filepath.Abs(r.URL.Query().Get("dst"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		stats, err := fileCatalog.DestinationStats(dst)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+
+	fmt.Println("File Manager Backend API running on :8080")
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+// backupPasswordHeader carries the password of an encrypted backup
+// repository or sync destination.
+const backupPasswordHeader = "X-Backup-Password"
+
+// sameKind reports whether a and b are both folders or both files, the
+// only case in which one may replace the other.
+func sameKind(a, b string) bool {
+	ai, err := lstatEntry(a)
+	if err != nil {
+		return false
+	}
+	bi, err := lstatEntry(b)
+	return err == nil && ai.IsDir() == bi.IsDir()
+}
+
+// lstatEntry is os.Lstat that also finds entries inside archives.
+func lstatEntry(path string) (fs.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err == nil {
+		return info, nil
+	}
+	archivePath, inner, ok := archive.Split(path)
+	if !ok {
+		return nil, err
+	}
+	fsys, err := archive.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Stat(inner)
+}
+
+// serveArchiveEntry sends a file from inside an archive, or a folder
+// inside it as a new zip or tar.gz chosen by the format parameter.
+func serveArchiveEntry(w http.ResponseWriter, r *http.Request, archivePath, inner string, download bool) {
+	fsys, err := archive.Open(archivePath)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	info, err := fsys.Stat(inner)
+	if err != nil {
+		writeError(w, fileops.ErrPathNotFound)
+		return
+	}
+	if info.IsDir() {
+		format, err := archive.ParseFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name() + format.Extension()}))
+		if err := archive.WriteFS(w, format, fsys, inner); err != nil {
+			log.Printf("Archive download failed: %v", err)
+		}
+		return
+	}
+	f, err := fsys.Open(inner)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+	if err := transfer.ServeStream(w, r, f, info, download); err != nil {
+		writeError(w, err)
+	}
+}
+
+// itemResult is the outcome for one path of a batch request.
+type itemResult struct {
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+	OK     bool   `json:"ok"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newItemResult(path, target string, err error) itemResult {
+	res := itemResult{Path: path, Target: target, OK: err == nil, Status: http.StatusOK}
+	if err != nil {
+		res.Status = errorStatus(err)
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// writeResults answers a batch request: 200 if every item succeeded, the
+// item's status if the only item failed, and 207 Multi-Status otherwise.
+func writeResults(w http.ResponseWriter, results []itemResult) {
+	status := http.StatusOK
+	failed := 0
+	for _, res := range results {
+		if !res.OK {
+			failed++
+			status = res.Status
+		}
+	}
+	if failed > 0 && len(results) > 1 {
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(results)
+}
+
+// decodeJSON reads a POST body into v, answering the request itself and
+// returning false when that fails.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeError maps fileops errors to HTTP status codes.
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
+// errorStatus returns the HTTP status code for an error.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, fileops.ErrInvalidPath):
+		return http.StatusBadRequest
+	case errors.Is(err, fileops.ErrPathNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, fileops.ErrPermissionDenied), errors.Is(err, summarize.ErrExcluded),
+		errors.Is(err, backup.ErrWrongPassword):
+		return http.StatusForbidden
+	case errors.Is(err, fileops.ErrPatternInvalid), errors.Is(err, fileops.ErrQueryInvalid),
+		errors.Is(err, annotate.ErrInvalid), errors.Is(err, similar.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, fileops.ErrAlreadyExists), errors.Is(err, transfer.ErrOffsetMismatch),
+		errors.Is(err, journal.ErrChanged), errors.Is(err, journal.ErrNothingToUndo),
+		errors.Is(err, rename.ErrConflict), errors.Is(err, organize.ErrRunning):
+		return http.StatusConflict
+	case errors.Is(err, transfer.ErrUploadNotFound), errors.Is(err, trash.ErrNotInTrash),
+		errors.Is(err, organize.ErrRuleNotFound), errors.Is(err, collection.ErrNotFound),
+		errors.Is(err, backup.ErrNotRepository), errors.Is(err, backup.ErrSnapshotNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, transfer.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, transfer.ErrUploadBusy), errors.Is(err, backup.ErrLocked):
+		return http.StatusLocked
+	case errors.Is(err, summarize.ErrProvider):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
 }
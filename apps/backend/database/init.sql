@@ -6,6 +6,10 @@ CREATE TABLE IF NOT EXISTS files (
     name TEXT NOT NULL,
     size INTEGER,
     hash TEXT,
+    mime_type TEXT,
+    device INTEGER,
+    inode INTEGER,
+    mod_time INTEGER,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
@@ -19,4 +23,225 @@ CREATE TABLE IF NOT EXISTS sync_jobs (
     finished_at DATETIME
 );
 
-CREATE INDEX IF NOT EXISTS idx_files_path ON files (path);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_files_path ON files (path);
+
+-- Cached per-directory totals for the disk-usage scanner. Only the files
+-- directly inside a directory are summed here; subtree totals are derived
+-- by walking subdirs. Rows are reused while mod_time matches the directory
+-- and scanned_at is recent enough. Files with several hard links are kept
+-- out of own_size and listed in links so each is counted once. total_*
+-- hold the subtree totals of the last full scan, for cheap folder sizes in
+-- listings.
+CREATE TABLE IF NOT EXISTS dir_usage (
+    path TEXT PRIMARY KEY,
+    mod_time INTEGER NOT NULL,
+    own_size INTEGER NOT NULL,
+    own_files INTEGER NOT NULL,
+    subdirs TEXT NOT NULL DEFAULT '',
+    links TEXT NOT NULL DEFAULT '',
+    total_size INTEGER,
+    total_files INTEGER,
+    total_dirs INTEGER,
+    totaled_at DATETIME,
+    scanned_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Filename search index. search_names shares its rowid with search_entries
+-- and holds the name split into words (for prefix matching) and trigrams
+-- (for fuzzy matching). seen stores the generation of the last rescan that
+-- found the entry, so stale rows can be removed in one statement.
+CREATE TABLE IF NOT EXISTS search_roots (
+    path TEXT PRIMARY KEY,
+    last_scan DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS search_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    root TEXT NOT NULL,
+    path TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    is_dir INTEGER NOT NULL DEFAULT 0,
+    size INTEGER NOT NULL DEFAULT 0,
+    mod_time INTEGER NOT NULL DEFAULT 0,
+    seen INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_search_entries_root ON search_entries (root, seen);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS search_names USING fts4(words, grams);
+
+-- Full-text content index. Text is stored once per content hash in
+-- content_fts (rowid = content_docs.id); content_files maps paths to hashes.
+CREATE TABLE IF NOT EXISTS content_docs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hash TEXT NOT NULL UNIQUE,
+    indexed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS content_files (
+    path TEXT PRIMARY KEY,
+    root TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    mod_time INTEGER NOT NULL,
+    seen INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_content_files_hash ON content_files (hash);
+CREATE INDEX IF NOT EXISTS idx_content_files_root ON content_files (root, seen);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS content_fts USING fts4(body, tokenize=unicode61);
+
+-- Undo journal. Each operation is one API request; its items are the
+-- individual changes, replayed in reverse to undo it. size, mod_time and
+-- inode describe the target right after the change, so undo can refuse
+-- when it has been modified since.
+CREATE TABLE IF NOT EXISTS operations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    undone_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS operation_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation_id INTEGER NOT NULL REFERENCES operations (id) ON DELETE CASCADE,
+    action TEXT NOT NULL,
+    source TEXT NOT NULL,
+    target TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    mod_time INTEGER NOT NULL,
+    inode INTEGER NOT NULL,
+    reverted INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_operation_items_operation ON operation_items (operation_id);
+
+-- Photo, audio and video metadata extracted by the media package, stored
+-- as JSON by content hash. Entries from an older extractor version are
+-- read again.
+CREATE TABLE IF NOT EXISTS media_metadata (
+    hash TEXT PRIMARY KEY,
+    version INTEGER NOT NULL,
+    data TEXT NOT NULL,
+    extracted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Organize rules move or copy files from source into a layout below
+-- target. layout is a naming template; one ending in "/" names a folder
+-- and files keep their names. interval is a Go duration for scheduled
+-- runs, and watched rules run when their source settles after a change.
+CREATE TABLE IF NOT EXISTS organize_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    source TEXT NOT NULL,
+    target TEXT NOT NULL,
+    layout TEXT NOT NULL,
+    action TEXT NOT NULL,
+    filter TEXT NOT NULL DEFAULT '',
+    recursive INTEGER NOT NULL DEFAULT 0,
+    duplicates TEXT NOT NULL DEFAULT 'skip',
+    interval TEXT NOT NULL DEFAULT '',
+    watch INTEGER NOT NULL DEFAULT 0,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    last_run DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_files_hash ON files (hash);
+
+-- Classes assigned by the classification pipeline, one row per tag and
+-- classifier. classified_files records which catalog entries have been
+-- through the current set of classifiers, including those that got no
+-- tags.
+CREATE TABLE IF NOT EXISTS file_classes (
+    path TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    confidence REAL NOT NULL,
+    source TEXT NOT NULL,
+    classified_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (path, tag, source)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_classes_tag ON file_classes (tag, confidence);
+
+CREATE TABLE IF NOT EXISTS classified_files (
+    path TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    classifiers TEXT NOT NULL,
+    classified_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (path, hash)
+);
+
+-- Document summaries and suggested tags written by a language model, by
+-- content hash so copies share one entry. tags is a JSON array.
+CREATE TABLE IF NOT EXISTS document_summaries (
+    hash TEXT PRIMARY KEY,
+    summary TEXT NOT NULL,
+    tags TEXT NOT NULL,
+    model TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tags, ratings and notes users attach to files and folders. Rows are keyed
+-- by path and remember the content hash, so a file that reappears under
+-- another path gets its annotation back.
+CREATE TABLE IF NOT EXISTS file_annotations (
+    path TEXT PRIMARY KEY,
+    hash TEXT NOT NULL DEFAULT '',
+    rating INTEGER NOT NULL DEFAULT 0,
+    note TEXT NOT NULL DEFAULT '',
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_annotations_hash ON file_annotations (hash);
+
+CREATE TABLE IF NOT EXISTS file_tags (
+    path TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (path, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_tags_tag ON file_tags (tag COLLATE NOCASE);
+
+-- Smart collections: saved searches listed as collection://<name>.
+-- roots, include, exclude and tags are JSON arrays.
+CREATE TABLE IF NOT EXISTS collections (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    roots TEXT NOT NULL,
+    include TEXT NOT NULL DEFAULT '[]',
+    exclude TEXT NOT NULL DEFAULT '[]',
+    hidden INTEGER NOT NULL DEFAULT 0,
+    query TEXT NOT NULL DEFAULT '',
+    tags TEXT NOT NULL DEFAULT '[]',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Perceptual hashes of images by content hash, for the similar-images
+-- report. dhash and phash hold the 64 bits as signed integers; rows with an
+-- older version are recomputed.
+CREATE TABLE IF NOT EXISTS image_hashes (
+    hash TEXT PRIMARY KEY,
+    dhash INTEGER NOT NULL,
+    phash INTEGER NOT NULL,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    version INTEGER NOT NULL DEFAULT 1,
+    computed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Files stored in sync destinations, with their original size and the
+-- size they take on the destination after compression and encryption.
+-- compression is empty for files stored as they are.
+CREATE TABLE IF NOT EXISTS destination_files (
+    destination TEXT NOT NULL,
+    path TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    stored_size INTEGER NOT NULL,
+    compression TEXT NOT NULL DEFAULT '',
+    encrypted INTEGER NOT NULL DEFAULT 0,
+    stored_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (destination, path)
+);
@@ -2,4 +2,10 @@ module file-manager-backend
 
 go 1.21
 
-require github.com/mattn/go-sqlite3 v1.14.17
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/mattn/go-sqlite3 v1.14.17
+	golang.org/x/crypto v0.33.0
+	golang.org/x/image v0.24.0
+	golang.org/x/sys v0.30.0
+)
@@ -0,0 +1,390 @@
+// Package annotate stores the tags, ratings and notes users attach to
+// files. Annotations are keyed by path and remember the file's content
+// hash: they follow moves made through fileops, and a file that turns up
+// elsewhere with the same contents, such as after a move outside the file
+// manager or a sync to another drive, picks them up again.
+package annotate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"file-manager-backend/internal/db"
+	"file-manager-backend/internal/fileops"
+)
+
+// ErrInvalid is returned for ratings out of range and malformed tags.
+var ErrInvalid = errors.New("invalid annotation")
+
+// MaxRating is the highest rating.
+const MaxRating = 5
+
+// emptyHash is the hash of an empty file, which says nothing about which
+// file it was.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Extended attributes written when Store.WriteXattrs is set: the
+// freedesktop.org tags and comment, and the 0-10 rating KDE uses.
+const (
+	xattrTags    = "user.xdg.tags"
+	xattrComment = "user.xdg.comment"
+	xattrRating  = "user.baloo.rating"
+)
+
+// Store reads and writes annotations.
+type Store struct {
+	db *sql.DB
+	// WriteXattrs mirrors annotations into user.* extended attributes so
+	// other tools can see them. Failures are logged, since many
+	// filesystems do not support them.
+	WriteXattrs bool
+}
+
+// New returns a store backed by db.
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// TagCount is a tag with the number of files carrying it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Files int    `json:"files"`
+}
+
+// Get returns the annotation of the file or folder at path, or nil when it
+// has none. A file without one inherits the annotation of another file
+// with the same contents; the other file's annotation is moved when that
+// file is gone and copied otherwise.
+func (s *Store) Get(path string) (*fileops.Annotation, error) {
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	if a, err := s.load(path); a != nil || err != nil {
+		return a, err
+	}
+	if info.IsDir() {
+		return nil, nil
+	}
+	hash, err := fileops.FileHash(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	return s.adopt(path, hash)
+}
+
+// Lookup is Get for listings and queries. It only matches by contents when
+// the hash is already known, so files are never read, and logs failures.
+func (s *Store) Lookup(path string) *fileops.Annotation {
+	path = filepath.Clean(path)
+	a, err := s.load(path)
+	if err == nil && a == nil {
+		if hash := fileops.KnownHash(path); hash != "" {
+			a, err = s.adopt(path, hash)
+		}
+	}
+	if err != nil {
+		log.Printf("annotate: %s: %v", path, err)
+		return nil
+	}
+	return a
+}
+
+// Set replaces the annotation of the file or folder at path and returns
+// it as stored. An empty annotation removes it.
+func (s *Store) Set(path string, a fileops.Annotation) (*fileops.Annotation, error) {
+	path = filepath.Clean(path)
+	if a.Rating < 0 || a.Rating > MaxRating {
+		return nil, fmt.Errorf("%w: rating must be between 0 and %d", ErrInvalid, MaxRating)
+	}
+	tags, err := cleanTags(a.Tags)
+	if err != nil {
+		return nil, err
+	}
+	a.Tags = tags
+	a.Note = strings.TrimSpace(a.Note)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	hash := ""
+	if !info.IsDir() {
+		if hash, err = fileops.FileHash(path); err != nil {
+			return nil, fileops.MapError(err)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM file_tags WHERE path = ?`, path); err != nil {
+		return nil, err
+	}
+	empty := len(a.Tags) == 0 && a.Rating == 0 && a.Note == ""
+	if empty {
+		_, err = tx.Exec(`DELETE FROM file_annotations WHERE path = ?`, path)
+	} else {
+		_, err = tx.Exec(`INSERT OR REPLACE INTO file_annotations (path, hash, rating, note, updated_at)
+			VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`, path, hash, a.Rating, a.Note)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range a.Tags {
+		if _, err := tx.Exec(`INSERT INTO file_tags (path, tag) VALUES (?, ?)`, path, tag); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if empty {
+		s.mirror(path, nil)
+		return nil, nil
+	}
+	s.mirror(path, &a)
+	return &a, nil
+}
+
+// Tag adds and removes tags on the file or folder at path, keeping its
+// rating and note.
+func (s *Store) Tag(path string, add, remove []string) (*fileops.Annotation, error) {
+	current, err := s.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	var a fileops.Annotation
+	if current != nil {
+		a = *current
+	}
+	var tags []string
+	for _, t := range a.Tags {
+		if !containsFold(remove, t) {
+			tags = append(tags, t)
+		}
+	}
+	a.Tags = append(tags, add...)
+	return s.Set(path, a)
+}
+
+// Tags returns every tag in use with the number of files carrying it.
+func (s *Store) Tags() ([]TagCount, error) {
+	rows, err := s.db.Query(`SELECT MIN(tag), COUNT(*) FROM file_tags GROUP BY tag COLLATE NOCASE ORDER BY COUNT(*) DESC, MIN(tag)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := []TagCount{}
+	for rows.Next() {
+		var c TagCount
+		if err := rows.Scan(&c.Tag, &c.Files); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// Moved moves the annotations of src, and of everything below it when it
+// is a folder, to dst. It is meant for fileops.SetMoveHook; failures are
+// logged.
+func (s *Store) Moved(src, dst string) {
+	if err := s.move(filepath.Clean(src), filepath.Clean(dst)); err != nil {
+		log.Printf("annotate: moving %s to %s: %v", src, dst, err)
+		return
+	}
+	if s.WriteXattrs {
+		// A copy across filesystems may have dropped the attributes.
+		if a, err := s.load(filepath.Clean(dst)); err == nil && a != nil {
+			s.mirror(filepath.Clean(dst), a)
+		}
+	}
+}
+
+func (s *Store) move(src, dst string) error {
+	if src == dst {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	srcPrefix := src + string(filepath.Separator)
+	dstPrefix := dst + string(filepath.Separator)
+	// LIKE ignores ASCII case, so the prefix is checked exactly as well.
+	below := `path LIKE ? ESCAPE '\' AND substr(path, 1, ?) = ?`
+	for _, table := range []string{"file_annotations", "file_tags"} {
+		// A replaced target loses its own annotations.
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE path = ? OR (`+below+`)`,
+			dst, db.EscapeLike(dstPrefix)+"%", utf8.RuneCountInString(dstPrefix), dstPrefix); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE `+table+` SET path = ? WHERE path = ?`, dst, src); err != nil {
+			return err
+		}
+		n := utf8.RuneCountInString(srcPrefix)
+		if _, err := tx.Exec(`UPDATE `+table+` SET path = ? || substr(path, ?) WHERE `+below,
+			dstPrefix, n+1, db.EscapeLike(srcPrefix)+"%", n, srcPrefix); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// adopt gives path the annotation of another file with the same contents,
+// or returns nil when there is none.
+func (s *Store) adopt(path, hash string) (*fileops.Annotation, error) {
+	if hash == emptyHash {
+		return nil, nil
+	}
+	rows, err := s.db.Query(`SELECT path FROM file_annotations WHERE hash = ? AND path != ? ORDER BY updated_at DESC`,
+		hash, path)
+	if err != nil {
+		return nil, err
+	}
+	var others []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		others = append(others, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(others) == 0 {
+		return nil, nil
+	}
+
+	from, gone := others[0], false
+	for _, p := range others {
+		if _, err := os.Lstat(p); errors.Is(err, fs.ErrNotExist) {
+			from, gone = p, true
+			break
+		}
+	}
+	if gone {
+		err = s.move(from, path)
+	} else {
+		err = s.copy(from, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a, err := s.load(path)
+	if err == nil && a != nil {
+		s.mirror(path, a)
+	}
+	return a, err
+}
+
+func (s *Store) copy(src, dst string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO file_annotations (path, hash, rating, note, updated_at)
+		SELECT ?, hash, rating, note, CURRENT_TIMESTAMP FROM file_annotations WHERE path = ?`, dst, src); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO file_tags (path, tag) SELECT ?, tag FROM file_tags WHERE path = ?`,
+		dst, src); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) load(path string) (*fileops.Annotation, error) {
+	var a fileops.Annotation
+	err := s.db.QueryRow(`SELECT rating, note FROM file_annotations WHERE path = ?`, path).Scan(&a.Rating, &a.Note)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT tag FROM file_tags WHERE path = ? ORDER BY rowid`, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		a.Tags = append(a.Tags, tag)
+	}
+	return &a, rows.Err()
+}
+
+// mirror writes a, or removes the attributes when a is nil.
+func (s *Store) mirror(path string, a *fileops.Annotation) {
+	if !s.WriteXattrs {
+		return
+	}
+	var tags, note, rating string
+	if a != nil {
+		tags, note = strings.Join(a.Tags, ","), a.Note
+		if a.Rating > 0 {
+			rating = strconv.Itoa(a.Rating * 2)
+		}
+	}
+	for _, attr := range [][2]string{{xattrTags, tags}, {xattrComment, note}, {xattrRating, rating}} {
+		var err error
+		if attr[1] == "" {
+			err = removeXattr(path, attr[0])
+		} else {
+			err = setXattr(path, attr[0], []byte(attr[1]))
+		}
+		if err != nil {
+			log.Printf("annotate: writing %s on %s: %v", attr[0], path, err)
+			return
+		}
+	}
+}
+
+// cleanTags trims tags and drops empty ones and case-insensitive
+// duplicates. Commas are rejected since they separate tags in
+// user.xdg.tags.
+func cleanTags(tags []string) ([]string, error) {
+	var out []string
+	for _, t := range tags {
+		t = strings.Join(strings.Fields(t), " ")
+		if t == "" || containsFold(out, t) {
+			continue
+		}
+		if strings.Contains(t, ",") {
+			return nil, fmt.Errorf("%w: tag %q contains a comma", ErrInvalid, t)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
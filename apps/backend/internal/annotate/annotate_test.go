@@ -0,0 +1,171 @@
+package annotate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"file-manager-backend/internal/catalog"
+	"file-manager-backend/internal/db/dbtest"
+	"file-manager-backend/internal/fileops"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	conn := dbtest.Open(t)
+	dbtest.UseMetaCache(t, catalog.NewStore(conn))
+	return New(conn)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetAndTag(t *testing.T) {
+	s := newTestStore(t)
+	path := filepath.Join(t.TempDir(), "bill.pdf")
+	writeFile(t, path, "pdf")
+
+	a, err := s.Set(path, fileops.Annotation{Tags: []string{" invoice ", "client-X", "Invoice", ""}, Rating: 4, Note: " pay by May "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &fileops.Annotation{Tags: []string{"invoice", "client-X"}, Rating: 4, Note: "pay by May"}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("Set = %+v", a)
+	}
+	if got, err := s.Get(path); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Get = %+v, %v", got, err)
+	}
+
+	if a, err = s.Tag(path, []string{"paid"}, []string{"INVOICE"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Tags, []string{"client-X", "paid"}) || a.Rating != 4 {
+		t.Errorf("Tag = %+v", a)
+	}
+	if counts, _ := s.Tags(); len(counts) != 2 || counts[0].Files != 1 {
+		t.Errorf("Tags = %+v", counts)
+	}
+
+	for _, bad := range []fileops.Annotation{{Rating: 6}, {Rating: -1}, {Tags: []string{"a,b"}}} {
+		if _, err := s.Set(path, bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Set(%+v) = %v", bad, err)
+		}
+	}
+	if a, err := s.Set(path, fileops.Annotation{}); a != nil || err != nil {
+		t.Errorf("clearing = %+v, %v", a, err)
+	}
+	if got, _ := s.Get(path); got != nil {
+		t.Errorf("after clearing = %+v", got)
+	}
+	if _, err := s.Get(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, fileops.ErrPathNotFound) {
+		t.Errorf("missing file = %v", err)
+	}
+}
+
+func TestFollowsMoves(t *testing.T) {
+	s := newTestStore(t)
+	fileops.SetMoveHook(s.Moved)
+	defer fileops.SetMoveHook(nil)
+	root := t.TempDir()
+	photo := filepath.Join(root, "in", "trip", "a.jpg")
+	writeFile(t, photo, "jpeg")
+	if _, err := s.Set(photo, fileops.Annotation{Tags: []string{"holiday"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Set(filepath.Dir(photo), fileops.Annotation{Note: "2023"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving the folder carries both annotations along.
+	album := filepath.Join(root, "albums", "trip")
+	os.MkdirAll(filepath.Dir(album), 0o755)
+	if err := fileops.Move(filepath.Dir(photo), album, false); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(album, "a.jpg")
+	if a, _ := s.Get(moved); a == nil || a.Tags[0] != "holiday" {
+		t.Errorf("moved file = %+v", a)
+	}
+	if a, _ := s.Get(album); a == nil || a.Note != "2023" {
+		t.Errorf("moved folder = %+v", a)
+	}
+	renamed, err := fileops.Rename(moved, "b.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := s.Lookup(renamed); a == nil {
+		t.Error("annotation lost on rename")
+	}
+
+	// A move behind our back is recognised by content.
+	elsewhere := filepath.Join(root, "c.jpg")
+	if err := os.Rename(renamed, elsewhere); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := s.Get(elsewhere); a == nil || a.Tags[0] != "holiday" {
+		t.Errorf("after outside move = %+v", a)
+	}
+	if a := s.Lookup(renamed); a != nil {
+		t.Errorf("old path kept %+v", a)
+	}
+
+	// A copy, as after a sync, gets its own annotation.
+	synced := filepath.Join(root, "backup", "c.jpg")
+	writeFile(t, synced, "jpeg")
+	if a, _ := s.Get(synced); a == nil || a.Tags[0] != "holiday" {
+		t.Errorf("synced copy = %+v", a)
+	}
+	s.Tag(synced, []string{"backup"}, nil)
+	if a := s.Lookup(elsewhere); len(a.Tags) != 1 {
+		t.Errorf("original changed with the copy: %+v", a)
+	}
+}
+
+func TestQueryTags(t *testing.T) {
+	s := newTestStore(t)
+	fileops.SetAnnotationLookup(s.Lookup)
+	defer fileops.SetAnnotationLookup(nil)
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, filepath.Join(root, name), name)
+	}
+	s.Set(filepath.Join(root, "a.txt"), fileops.Annotation{Tags: []string{"Client-X"}, Rating: 5})
+	s.Set(filepath.Join(root, "b.txt"), fileops.Annotation{Tags: []string{"client-y"}, Rating: 2, Note: "Renewal due"})
+
+	for query, want := range map[string][]string{
+		"tag:client-x":          {"a.txt"},
+		"-tag:client-x":         {"b.txt", "c.txt"},
+		"rating>=2":             {"a.txt", "b.txt"},
+		"note:renewal":          {"b.txt"},
+		"tag:client-y rating<3": {"b.txt"},
+	} {
+		q, err := fileops.ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := fileops.ListFiles(root, fileops.ListOptions{Query: q, Annotations: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, f.Name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+		}
+		if len(files) > 0 && files[0].Name == "a.txt" && files[0].Annotation == nil {
+			t.Errorf("%s: annotation not filled in", query)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package annotate
+
+import "golang.org/x/sys/unix"
+
+func setXattr(path, name string, value []byte) error {
+	return unix.Setxattr(path, name, value, 0)
+}
+
+// removeXattr removes an attribute, ignoring one that is not set.
+func removeXattr(path, name string) error {
+	if err := unix.Removexattr(path, name); err != nil && err != unix.ENODATA {
+		return err
+	}
+	return nil
+}
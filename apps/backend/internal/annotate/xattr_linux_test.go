@@ -0,0 +1,40 @@
+package annotate
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"file-manager-backend/internal/fileops"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWriteXattrs(t *testing.T) {
+	s := newTestStore(t)
+	s.WriteXattrs = true
+	path := filepath.Join(t.TempDir(), "a.txt")
+	writeFile(t, path, "a")
+	if err := unix.Setxattr(path, "user.probe", []byte("1"), 0); errors.Is(err, unix.ENOTSUP) {
+		t.Skip("filesystem has no user extended attributes")
+	}
+
+	if _, err := s.Set(path, fileops.Annotation{Tags: []string{"a", "b c"}, Rating: 3}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	for name, want := range map[string]string{xattrTags: "a,b c", xattrRating: "6"} {
+		n, err := unix.Getxattr(path, name, buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Errorf("%s = %q, %v", name, buf[:n], err)
+		}
+	}
+	if _, err := unix.Getxattr(path, xattrComment, buf); err != unix.ENODATA {
+		t.Errorf("comment without a note: %v", err)
+	}
+
+	s.Set(path, fileops.Annotation{})
+	if _, err := unix.Getxattr(path, xattrTags, buf); err != unix.ENODATA {
+		t.Errorf("tags left after clearing: %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package annotate
+
+import "errors"
+
+var errNoXattrs = errors.New("extended attributes are not supported on this platform")
+
+func setXattr(path, name string, value []byte) error {
+	return errNoXattrs
+}
+
+func removeXattr(path, name string) error {
+	return errNoXattrs
+}
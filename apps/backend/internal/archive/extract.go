@@ -0,0 +1,317 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// ExtractName returns the name an extracted copy of p gets: the entry's
+// own name, or for a whole archive the archive's name without its
+// extension.
+func ExtractName(p string) string {
+	base := filepath.Base(filepath.Clean(p))
+	if suffix := archiveSuffix(base); suffix != "" && suffix != base {
+		if _, inner, ok := Split(p); ok && inner == "." {
+			return strings.TrimSuffix(base, suffix)
+		}
+	}
+	return base
+}
+
+// Extract copies src, a file or folder inside an archive or a whole
+// archive, to target without unpacking anything else. Symbolic links and
+// special files are skipped: a link taken from an archive could point
+// anywhere. With overwrite, an existing target is only replaced once the
+// extracted copy is complete.
+func Extract(src, target string, overwrite bool) error {
+	archivePath, name, ok := Split(src)
+	if !ok {
+		return fileops.ErrInvalidPath
+	}
+	fsys, err := Open(archivePath)
+	if err != nil {
+		return err
+	}
+	info, err := fsys.Stat(name)
+	if err != nil {
+		return fileops.ErrPathNotFound
+	}
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		return fileops.ErrInvalidPath
+	}
+	target = filepath.Clean(target)
+	if _, err := os.Stat(filepath.Dir(target)); err != nil {
+		return fileops.MapError(err)
+	}
+	existing, err := os.Lstat(target)
+	if err != nil {
+		return extract(fsys, name, target, info)
+	}
+	if !overwrite || existing.IsDir() != info.IsDir() {
+		return fileops.ErrAlreadyExists
+	}
+	return fileops.Replace(target, func(tmp string) error {
+		return extract(fsys, name, tmp, info)
+	})
+}
+
+// extract writes the entry name, described by info, to target, which
+// must not exist yet.
+func extract(fsys *FS, name, target string, info fs.FileInfo) error {
+	if !info.IsDir() {
+		return extractFile(fsys, name, target, info)
+	}
+
+	// Folder times are set after their contents, which would otherwise
+	// bump them again.
+	type dirTime struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []dirTime
+	err := fs.WalkDir(fsys, name, func(current string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(target, filepath.FromSlash(relName(name, current)))
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(out, 0o755); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTime{out, info.ModTime()})
+		case info.Mode().IsRegular() && fsys.kind == kindZip:
+			return extractFile(fsys, current, out, info)
+		}
+		return nil
+	})
+	if err == nil && fsys.kind != kindZip {
+		// Opening tar members one by one would decompress the archive
+		// from the start for each of them, so they are written in a
+		// single pass instead.
+		err = extractTar(fsys, name, target)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chtimes(dirs[i].path, dirs[i].modTime, dirs[i].modTime)
+	}
+	if err != nil {
+		return fmt.Errorf("extracting %s: %w", name, err)
+	}
+	return nil
+}
+
+// extractTar writes the regular files below the folder name of a tar
+// archive into target, whose folders already exist, reading the archive
+// once. A member stored more than once ends up with its last copy, as in
+// the listing.
+func extractTar(fsys *FS, name, target string) error {
+	tr, closer, err := fsys.openTar()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		member := cleanName(hdr.Name)
+		if member == "" || !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if name != "." && !strings.HasPrefix(member, name+"/") {
+			continue
+		}
+		out := filepath.Join(target, filepath.FromSlash(relName(name, member)))
+		os.Remove(out)
+		if err := writeFile(tr, out, hdr.FileInfo()); err != nil {
+			return err
+		}
+	}
+}
+
+// writeTarMembers passes the regular files below the folder name of a tar
+// archive to add, reading the archive once, as extractTar does. A member
+// stored more than once is added with its first copy, the one Open
+// returns. A damaged archive ends the pass and is listed in skipped.
+func writeTarMembers(fsys *FS, name string, add func(current string, info fs.FileInfo, r io.Reader) error, skipped *[]string) error {
+	tr, closer, err := fsys.openTar()
+	if err != nil {
+		*skipped = append(*skipped, fmt.Sprintf("%s: %v", name, err))
+		return nil
+	}
+	defer closer.Close()
+	seen := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			*skipped = append(*skipped, fmt.Sprintf("%s: %v", name, err))
+			return nil
+		}
+		member := cleanName(hdr.Name)
+		if member == "" || seen[member] || !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if name != "." && !strings.HasPrefix(member, name+"/") && member != name {
+			continue
+		}
+		seen[member] = true
+		if err := add(member, hdr.FileInfo(), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// relName returns current relative to the folder name, both archive
+// paths.
+func relName(name, current string) string {
+	if name == "." {
+		return current
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(current, name), "/")
+}
+
+func extractFile(fsys *FS, name, target string, info fs.FileInfo) error {
+	src, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return writeFile(src, target, info)
+}
+
+// writeFile creates target with the contents of src and the permissions
+// and modification time of info.
+func writeFile(src io.Reader, target string, info fs.FileInfo) error {
+	perm := info.Mode().Perm()
+	if perm == 0 {
+		perm = 0o644
+	}
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fileops.MapError(err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(target)
+		return err
+	}
+	if !info.ModTime().IsZero() {
+		os.Chtimes(target, info.ModTime(), info.ModTime())
+	}
+	return nil
+}
+
+// archiveSuffix returns the archive extension of name as written, so
+// "Photos.TAR.GZ" yields ".TAR.GZ".
+func archiveSuffix(name string) string {
+	lower := strings.ToLower(name)
+	for _, s := range readableSuffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return name[len(name)-len(s.suffix):]
+		}
+	}
+	return ""
+}
+
+// WriteFS streams the folder name of fsys as a new archive, stored under
+// the folder's base name, for downloading part of an archive without
+// extracting it first.
+func WriteFS(w io.Writer, format Format, fsys *FS, name string) error {
+	var ew entryWriter
+	switch format {
+	case FormatZip:
+		ew = newZipWriter(w)
+	case FormatTarGz:
+		ew = newTarWriter(w)
+	default:
+		return ErrUnsupportedFormat
+	}
+
+	top := path.Base(name)
+	if name == "." {
+		top = strings.TrimSuffix(filepath.Base(fsys.path), archiveSuffix(fsys.path))
+	}
+	entryName := func(current string) string {
+		if current == name {
+			return top
+		}
+		return path.Join(top, relName(name, current))
+	}
+	var archived, skipped []string
+	addFile := func(current string, info fs.FileInfo, r io.Reader) error {
+		src := &trackingReader{r: r}
+		if err := ew.add(entryName(current), info, "", src); err != nil {
+			return err
+		}
+		if src.err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", current, src.err))
+			return nil
+		}
+		archived = append(archived, entryName(current))
+		return nil
+	}
+
+	err := fs.WalkDir(fsys, name, func(current string, d fs.DirEntry, err error) error {
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", current, err))
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return ew.add(entryName(current)+"/", info, "", nil)
+		case info.Mode().IsRegular() && fsys.kind == kindZip:
+			f, err := fsys.Open(current)
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %v", current, err))
+				return nil
+			}
+			defer f.Close()
+			return addFile(current, info, f)
+		case info.Mode().IsRegular():
+			// Tar members are written in one pass below.
+		default:
+			skipped = append(skipped, fmt.Sprintf("%s: not a regular file", current))
+		}
+		return nil
+	})
+	if err == nil && fsys.kind != kindZip {
+		err = writeTarMembers(fsys, name, addFile, &skipped)
+	}
+	if err != nil {
+		return err
+	}
+
+	manifest := buildManifest(archived, skipped)
+	info := manifestInfo{size: int64(len(manifest)), modTime: time.Now()}
+	if err := ew.add(ManifestName, info, "", strings.NewReader(manifest)); err != nil {
+		return err
+	}
+	return ew.close()
+}
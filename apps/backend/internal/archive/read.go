@@ -0,0 +1,525 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// kind is a readable archive format.
+type kind int
+
+const (
+	kindNone kind = iota
+	kindZip
+	kindTar
+	kindTarGz
+	kindTarBz2
+	kindTarZst
+)
+
+// readableSuffixes maps file name suffixes to archive kinds. Longer
+// suffixes come first so "x.tar.gz" is not taken for a plain gzip file.
+var readableSuffixes = []struct {
+	suffix string
+	kind   kind
+}{
+	{".tar.gz", kindTarGz},
+	{".tar.bz2", kindTarBz2},
+	{".tar.zst", kindTarZst},
+	{".tgz", kindTarGz},
+	{".tbz2", kindTarBz2},
+	{".tzst", kindTarZst},
+	{".tar", kindTar},
+	{".zip", kindZip},
+	{".jar", kindZip},
+	{".cbz", kindZip},
+}
+
+func kindOf(name string) kind {
+	lower := strings.ToLower(name)
+	for _, s := range readableSuffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return s.kind
+		}
+	}
+	return kindNone
+}
+
+// IsArchive reports whether name has the extension of an archive that can
+// be browsed.
+func IsArchive(name string) bool {
+	return kindOf(name) != kindNone
+}
+
+// Split finds the archive file in p and returns its path and the location
+// inside it, using forward slashes and "." for the archive's root. ok is
+// false when no component of p is an archive file.
+func Split(p string) (archivePath, inner string, ok bool) {
+	p = filepath.Clean(p)
+	rest := ""
+	for cur := p; ; {
+		if IsArchive(cur) {
+			if info, err := os.Stat(cur); err == nil && info.Mode().IsRegular() {
+				if rest == "" {
+					rest = "."
+				}
+				return cur, rest, true
+			}
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return "", "", false
+		}
+		rest = path.Join(filepath.Base(cur), rest)
+		cur = parent
+	}
+}
+
+// Resolve is a fileops.VirtualFS: it opens the archive containing p and
+// returns it with the folder p names inside it. fsys is nil when p does
+// not lead into an archive.
+func Resolve(p string) (fsys fs.FS, dir string, err error) {
+	archivePath, inner, ok := Split(p)
+	if !ok {
+		return nil, "", nil
+	}
+	afs, err := Open(archivePath)
+	if err != nil {
+		return nil, "", err
+	}
+	return afs, inner, nil
+}
+
+// entry is one file or folder inside an archive. It serves as fs.FileInfo
+// and fs.DirEntry.
+type entry struct {
+	name    string // full slash-separated path inside the archive
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (e *entry) Name() string               { return path.Base(e.name) }
+func (e *entry) Size() int64                { return e.size }
+func (e *entry) Mode() fs.FileMode          { return e.mode }
+func (e *entry) ModTime() time.Time         { return e.modTime }
+func (e *entry) IsDir() bool                { return e.mode.IsDir() }
+func (e *entry) Sys() interface{}           { return nil }
+func (e *entry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *entry) Info() (fs.FileInfo, error) { return e, nil }
+
+// FS is a read-only view of an archive. It implements fs.FS, fs.ReadDirFS
+// and fs.StatFS. The directory listing is read once when the archive is
+// opened; file contents are read on demand, which for compressed tar
+// archives means decompressing up to the requested entry. A zip archive
+// stays open while the FS is cached, so its central directory is only
+// parsed once.
+type FS struct {
+	path     string
+	kind     kind
+	entries  map[string]*entry
+	children map[string][]fs.DirEntry
+
+	zmu      sync.Mutex
+	zr       *zip.ReadCloser
+	zipFiles map[string]*zip.File
+	readers  int  // members open on zr
+	evicted  bool // dropped from fsCache; zr closes with its last reader
+}
+
+// fsCache keeps recently opened archives so browsing a large archive does
+// not re-read its listing for every request.
+var fsCache = struct {
+	sync.Mutex
+	entries map[string]cachedFS
+}{entries: make(map[string]cachedFS)}
+
+type cachedFS struct {
+	fsys    *FS
+	size    int64
+	modTime time.Time
+	used    time.Time
+}
+
+const fsCacheSize = 16
+
+// Open reads the listing of the archive at p.
+func Open(p string) (*FS, error) {
+	k := kindOf(p)
+	if k == kindNone {
+		return nil, ErrUnsupportedFormat
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+
+	fsCache.Lock()
+	if c, ok := fsCache.entries[p]; ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		c.used = time.Now()
+		fsCache.entries[p] = c
+		fsCache.Unlock()
+		return c.fsys, nil
+	}
+	fsCache.Unlock()
+
+	afs := &FS{path: p, kind: k, entries: make(map[string]*entry)}
+	if k == kindZip {
+		err = afs.indexZip()
+	} else {
+		err = afs.indexTar()
+	}
+	if err != nil {
+		return nil, err
+	}
+	afs.link()
+
+	fsCache.Lock()
+	defer fsCache.Unlock()
+	if old, ok := fsCache.entries[p]; ok {
+		old.fsys.evict()
+	} else if len(fsCache.entries) >= fsCacheSize {
+		var oldest string
+		for key, c := range fsCache.entries {
+			if oldest == "" || c.used.Before(fsCache.entries[oldest].used) {
+				oldest = key
+			}
+		}
+		fsCache.entries[oldest].fsys.evict()
+		delete(fsCache.entries, oldest)
+	}
+	fsCache.entries[p] = cachedFS{fsys: afs, size: info.Size(), modTime: info.ModTime(), used: time.Now()}
+	return afs, nil
+}
+
+func (a *FS) indexZip() error {
+	zr, err := zip.OpenReader(a.path)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		a.add(f.Name, int64(f.UncompressedSize64), f.Mode(), f.Modified)
+	}
+	a.setZip(zr)
+	return nil
+}
+
+// setZip keeps zr open for reading members. Called with zmu held, or
+// before the FS is shared.
+func (a *FS) setZip(zr *zip.ReadCloser) {
+	a.zr = zr
+	a.zipFiles = make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		if name := cleanName(f.Name); name != "" {
+			a.zipFiles[name] = f
+		}
+	}
+}
+
+// openZipMember opens the zip member name, reopening the archive if it
+// was closed after the FS left the cache.
+func (a *FS) openZipMember(name string) (io.ReadCloser, error) {
+	a.zmu.Lock()
+	defer a.zmu.Unlock()
+	if a.zr == nil {
+		zr, err := zip.OpenReader(a.path)
+		if err != nil {
+			return nil, fileops.MapError(err)
+		}
+		a.setZip(zr)
+	}
+	f, ok := a.zipFiles[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	a.readers++
+	return &zipMember{ReadCloser: rc, fsys: a}, nil
+}
+
+// evict marks the FS as no longer cached and closes the zip archive once
+// no member is being read from it.
+func (a *FS) evict() {
+	a.zmu.Lock()
+	defer a.zmu.Unlock()
+	a.evicted = true
+	a.closeIdleZip()
+}
+
+// closeIdleZip closes an evicted FS's archive when nothing reads from it.
+// Called with zmu held.
+func (a *FS) closeIdleZip() {
+	if a.evicted && a.readers == 0 && a.zr != nil {
+		a.zr.Close()
+		a.zr, a.zipFiles = nil, nil
+	}
+}
+
+// zipMember is a member being read from an FS's open zip archive.
+type zipMember struct {
+	io.ReadCloser
+	fsys *FS
+	once sync.Once
+}
+
+func (m *zipMember) Close() error {
+	err := m.ReadCloser.Close()
+	m.once.Do(func() {
+		m.fsys.zmu.Lock()
+		defer m.fsys.zmu.Unlock()
+		m.fsys.readers--
+		m.fsys.closeIdleZip()
+	})
+	return err
+}
+
+func (a *FS) indexTar() error {
+	tr, closer, err := a.openTar()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		a.add(hdr.Name, hdr.Size, hdr.FileInfo().Mode(), hdr.ModTime)
+	}
+}
+
+// add records an entry, skipping names that would escape the archive.
+func (a *FS) add(name string, size int64, mode fs.FileMode, modTime time.Time) {
+	isDir := strings.HasSuffix(name, "/") || mode.IsDir()
+	name = cleanName(name)
+	if name == "" {
+		return
+	}
+	if isDir {
+		mode = fs.ModeDir | mode.Perm()
+		if mode.Perm() == 0 {
+			mode |= 0o755
+		}
+		size = 0
+	}
+	a.entries[name] = &entry{name: name, size: size, mode: mode, modTime: modTime}
+}
+
+// cleanName turns an archive member name into a path valid for fs.FS.
+// Absolute names are taken as relative to the archive; names that climb
+// out of it yield "".
+func cleanName(name string) string {
+	name = path.Clean(strings.TrimLeft(strings.ReplaceAll(name, `\`, "/"), "/"))
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") || !fs.ValidPath(name) {
+		return ""
+	}
+	return name
+}
+
+// link creates folders that are only implied by their contents and builds
+// the sorted child lists.
+func (a *FS) link() {
+	for name, e := range a.entries {
+		modTime := e.modTime
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := a.entries[dir]; ok {
+				break
+			}
+			a.entries[dir] = &entry{name: dir, mode: fs.ModeDir | 0o755, modTime: modTime}
+		}
+	}
+	a.children = make(map[string][]fs.DirEntry)
+	for name, e := range a.entries {
+		parent := path.Dir(name)
+		a.children[parent] = append(a.children[parent], e)
+	}
+	for _, list := range a.children {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	}
+}
+
+// Stat returns information about the entry name.
+func (a *FS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return &entry{name: ".", mode: fs.ModeDir | 0o755}, nil
+	}
+	e, ok := a.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+// ReadDir lists the folder name.
+func (a *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := a.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	list := a.children[name]
+	out := make([]fs.DirEntry, len(list))
+	copy(out, list)
+	return out, nil
+}
+
+// Open opens the entry name for reading.
+func (a *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := a.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, _ := a.ReadDir(name)
+		return &dirFile{info: info, entries: entries}, nil
+	}
+	if !info.Mode().IsRegular() {
+		return &memberFile{info: info, r: strings.NewReader(""), closer: io.NopCloser(nil)}, nil
+	}
+
+	if a.kind == kindZip {
+		rc, err := a.openZipMember(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &memberFile{info: info, r: rc, closer: rc}, nil
+	}
+
+	tr, closer, err := a.openTar()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			closer.Close()
+			if err == io.EOF {
+				err = fs.ErrNotExist
+			}
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		if cleanName(hdr.Name) == name && hdr.Typeflag != tar.TypeDir {
+			return &memberFile{info: info, r: tr, closer: closer}, nil
+		}
+	}
+}
+
+// openTar opens the archive file with the decompressor for its kind.
+func (a *FS) openTar() (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, nil, fileops.MapError(err)
+	}
+	var r io.Reader = f
+	closer := multiCloser{f}
+	switch a.kind {
+	case kindTarGz:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r, closer = gz, multiCloser{gz, f}
+	case kindTarBz2:
+		r = bzip2.NewReader(f)
+	case kindTarZst:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		r, closer = zr, multiCloser{zstdCloser{zr}, f}
+	}
+	return tar.NewReader(r), closer, nil
+}
+
+type zstdCloser struct{ d *zstd.Decoder }
+
+func (z zstdCloser) Close() error {
+	z.d.Close()
+	return nil
+}
+
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// memberFile is an open file inside an archive.
+type memberFile struct {
+	info   fs.FileInfo
+	r      io.Reader
+	closer io.Closer
+}
+
+func (f *memberFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memberFile) Read(p []byte) (int, error) { return f.r.Read(p) }
+func (f *memberFile) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+// dirFile is an open folder inside an archive.
+type dirFile struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+func (d *dirFile) Close() error { return nil }
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		list := d.entries
+		d.entries = nil
+		return list, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	list := d.entries[:n]
+	d.entries = d.entries[n:]
+	return list, nil
+}
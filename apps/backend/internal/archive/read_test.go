@@ -0,0 +1,309 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"file-manager-backend/internal/fileops"
+)
+
+var sampleEntries = map[string]string{
+	"2019/a.jpg":       "jpeg data",
+	"2019/trip/b.txt":  "bravo",
+	"readme.md":        "readme",
+	"../escape.txt":    "outside",
+	"/etc/absolute.sh": "absolute",
+}
+
+func writeZipFile(t *testing.T, p string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarFile(t *testing.T, p string, entries map[string]string, compress func(io.Writer) io.WriteCloser) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range entries {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, content)
+	}
+	tw.Close()
+
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cw := compress(f)
+	if _, err := cw.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenFormats(t *testing.T) {
+	dir := t.TempDir()
+	paths := map[string]func(string){
+		"a.zip": func(p string) { writeZipFile(t, p, sampleEntries) },
+		"a.tar.gz": func(p string) {
+			writeTarFile(t, p, sampleEntries, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+		},
+		"a.tar.zst": func(p string) {
+			writeTarFile(t, p, sampleEntries, func(w io.Writer) io.WriteCloser {
+				zw, err := zstd.NewWriter(w)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return zw
+			})
+		},
+	}
+	for name, create := range paths {
+		p := filepath.Join(dir, name)
+		create(p)
+		fsys, err := Open(p)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		entries, err := fsys.ReadDir(".")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		// "../escape.txt" is dropped; "/etc/absolute.sh" stays inside.
+		if got := strings.Join(names, ","); got != "2019,etc,readme.md" {
+			t.Errorf("%s: root = %s", name, got)
+		}
+		if info, err := fsys.Stat("2019/trip"); err != nil || !info.IsDir() {
+			t.Errorf("%s: implied folder missing: %v", name, err)
+		}
+		b, err := fs.ReadFile(fsys, "2019/trip/b.txt")
+		if err != nil || string(b) != "bravo" {
+			t.Errorf("%s: b.txt = %q, %v", name, b, err)
+		}
+	}
+}
+
+func TestSplitAndList(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "photos.zip")
+	writeZipFile(t, p, sampleEntries)
+
+	archivePath, inner, ok := Split(filepath.Join(p, "2019", "trip"))
+	if !ok || archivePath != p || inner != "2019/trip" {
+		t.Errorf("Split = %q, %q, %v", archivePath, inner, ok)
+	}
+	if _, _, ok := Split(filepath.Join(dir, "missing.zip", "x")); ok {
+		t.Error("Split matched an archive that does not exist")
+	}
+
+	fileops.SetVirtualFS(Resolve)
+	defer fileops.SetVirtualFS(nil)
+	files, err := fileops.ListFiles(filepath.Join(p, "2019"), fileops.DefaultListOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("ListFiles = %+v", files)
+	}
+	for _, f := range files {
+		if !f.Virtual {
+			t.Errorf("%s not marked virtual", f.Path)
+		}
+		if f.Name == "a.jpg" && (f.Path != filepath.Join(p, "2019", "a.jpg") || f.MimeType != "image/jpeg" || f.Size != 9) {
+			t.Errorf("a.jpg = %+v", f)
+		}
+	}
+	if _, err := fileops.ListFiles(filepath.Join(p, "nope"), fileops.DefaultListOptions()); !errors.Is(err, fileops.ErrPathNotFound) {
+		t.Errorf("missing folder: err = %v", err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "photos.tgz")
+	writeTarFile(t, p, sampleEntries, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Extract(filepath.Join(p, "2019"), filepath.Join(out, "2019"), false); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "2019", "trip", "b.txt")); err != nil || string(b) != "bravo" {
+		t.Errorf("b.txt = %q, %v", b, err)
+	}
+	if err := Extract(filepath.Join(p, "2019"), filepath.Join(out, "2019"), false); !errors.Is(err, fileops.ErrAlreadyExists) {
+		t.Errorf("extract onto existing: err = %v", err)
+	}
+
+	if name := ExtractName(p); name != "photos" {
+		t.Errorf("ExtractName = %q", name)
+	}
+	if err := Extract(p, filepath.Join(out, "photos"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "photos", "etc", "absolute.sh")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.txt")); !os.IsNotExist(err) {
+		t.Error("entry escaped the target folder")
+	}
+}
+
+func TestExtractTarZstOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "photos.tar.zst")
+	writeTarFile(t, p, sampleEntries, func(w io.Writer) io.WriteCloser {
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return zw
+	})
+	target := filepath.Join(dir, "2019")
+	if err := os.MkdirAll(filepath.Join(target, "old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Extract(filepath.Join(p, "2019", "a.jpg"), target, true); !errors.Is(err, fileops.ErrAlreadyExists) {
+		t.Errorf("file onto folder: err = %v", err)
+	}
+	if err := Extract(filepath.Join(p, "2019"), target, true); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.jpg": "jpeg data", "trip/b.txt": "bravo"} {
+		if b, err := os.ReadFile(filepath.Join(target, name)); err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v", name, b, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(target, "old")); !os.IsNotExist(err) {
+		t.Error("replaced folder kept its old contents")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("left behind %d entries, want the archive and the target", len(entries))
+	}
+}
+
+func TestExtractZipReusesReader(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "photos.zip")
+	writeZipFile(t, p, sampleEntries)
+	out := filepath.Join(dir, "photos")
+	if err := Extract(p, out, false); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "2019", "trip", "b.txt")); err != nil || string(b) != "bravo" {
+		t.Errorf("b.txt = %q, %v", b, err)
+	}
+
+	fsys, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Open("readme.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys.evict()
+	if fsys.zr == nil {
+		t.Error("archive closed while a member was being read")
+	}
+	if b, err := io.ReadAll(f); err != nil || string(b) != "readme" {
+		t.Errorf("readme.md = %q, %v", b, err)
+	}
+	f.Close()
+	if fsys.zr != nil {
+		t.Error("evicted archive still open after its last member closed")
+	}
+	f, err = fsys.Open("readme.md")
+	if err != nil {
+		t.Fatalf("reopening evicted archive: %v", err)
+	}
+	f.Close()
+}
+
+func TestWriteFS(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "photos.zip")
+	writeZipFile(t, p, sampleEntries)
+	fsys, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteFS(&buf, FormatZip, fsys, "2019"); err != nil {
+		t.Fatal(err)
+	}
+	files := readZip(t, buf.Bytes())
+	want := []string{"2019/", "2019/a.jpg", "2019/trip/", "2019/trip/b.txt", "MANIFEST.txt"}
+	if got := keys(files); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+}
+
+func TestWriteFSFromTar(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "photos.tar.gz")
+	writeTarFile(t, p, sampleEntries, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	fsys, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]map[string]string{
+		"2019":       {"2019/": "", "2019/a.jpg": "jpeg data", "2019/trip/": "", "2019/trip/b.txt": "bravo"},
+		"2019/a.jpg": {"a.jpg": "jpeg data"},
+	} {
+		var buf bytes.Buffer
+		if err := WriteFS(&buf, FormatZip, fsys, name); err != nil {
+			t.Fatal(err)
+		}
+		files := readZip(t, buf.Bytes())
+		delete(files, ManifestName)
+		if strings.Join(keys(files), ",") != strings.Join(keys(want), ",") {
+			t.Errorf("%s: entries = %v, want %v", name, keys(files), keys(want))
+		}
+		for entry, content := range want {
+			if files[entry] != content {
+				t.Errorf("%s: %s = %q, want %q", name, entry, files[entry], content)
+			}
+		}
+	}
+}
@@ -0,0 +1,350 @@
+// Package archive streams selections of files as zip or tar.gz archives
+// and reads the contents of existing archives.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// Format is an archive format that can be written.
+type Format string
+
+const (
+	FormatZip   Format = "zip"
+	FormatTarGz Format = "tar.gz"
+)
+
+// ErrUnsupportedFormat is returned for unknown archive formats.
+var ErrUnsupportedFormat = errors.New("unsupported archive format")
+
+// ManifestName is the file added at the end of every archive listing what
+// it contains and which files could not be read.
+const ManifestName = "MANIFEST.txt"
+
+// ParseFormat accepts "zip", "tar.gz" and "tgz"; empty means zip.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "zip":
+		return FormatZip, nil
+	case "tar.gz", "tgz":
+		return FormatTarGz, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnsupportedFormat, s)
+}
+
+// Extension returns the file extension for the format, including the dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == FormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// entryWriter is implemented for each output format.
+type entryWriter interface {
+	// add writes one entry. For regular files, r yields the contents;
+	// a short read is padded so the archive stays valid.
+	add(name string, info fs.FileInfo, link string, r io.Reader) error
+	close() error
+}
+
+// Check verifies that every path can be archived, so callers can report
+// errors before they start streaming a response.
+func Check(paths []string) error {
+	if len(paths) == 0 {
+		return fileops.ErrInvalidPath
+	}
+	for _, p := range paths {
+		if p == "" {
+			return fileops.ErrInvalidPath
+		}
+		if _, err := os.Lstat(p); err != nil {
+			if os.IsNotExist(err) {
+				return fileops.ErrPathNotFound
+			}
+			if os.IsPermission(err) {
+				return fileops.ErrPermissionDenied
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Write streams an archive of paths to w. Each path is stored under its
+// base name, made unique when selections from different folders share a
+// name. Entries below the selected paths are filtered with the hidden,
+// include, exclude and regex rules of rules. Files that cannot be read are
+// skipped and listed in the manifest instead of failing the download;
+// only errors writing to w are returned.
+func Write(w io.Writer, format Format, paths []string, rules fileops.ListOptions) error {
+	var ew entryWriter
+	switch format {
+	case FormatZip:
+		ew = newZipWriter(w)
+	case FormatTarGz:
+		ew = newTarWriter(w)
+	default:
+		return ErrUnsupportedFormat
+	}
+
+	var archived, skipped []string
+	used := make(map[string]bool)
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		top := uniqueName(used, filepath.Base(p))
+		err := filepath.WalkDir(p, func(current string, d fs.DirEntry, err error) error {
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %v", current, err))
+				return nil
+			}
+			if current != p && !rules.Matches(d.Name(), d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel, err := filepath.Rel(p, current)
+			if err != nil {
+				return err
+			}
+			name := path.Join(top, filepath.ToSlash(rel))
+
+			info, err := d.Info()
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %v", current, err))
+				return nil
+			}
+			if err := addEntry(ew, name, current, info); err != nil {
+				var readErr *readError
+				if errors.As(err, &readErr) {
+					skipped = append(skipped, fmt.Sprintf("%s: %v", current, readErr.err))
+					return nil
+				}
+				return err
+			}
+			if !info.IsDir() {
+				archived = append(archived, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest := buildManifest(archived, skipped)
+	info := manifestInfo{size: int64(len(manifest)), modTime: time.Now()}
+	if err := ew.add(uniqueName(used, ManifestName), info, "", strings.NewReader(manifest)); err != nil {
+		return err
+	}
+	return ew.close()
+}
+
+// readError marks failures reading the source, as opposed to writing the
+// archive.
+type readError struct{ err error }
+
+func (e *readError) Error() string { return e.err.Error() }
+
+// addEntry writes one file, folder or symbolic link.
+func addEntry(ew entryWriter, name, path string, info fs.FileInfo) error {
+	switch {
+	case info.IsDir():
+		return ew.add(name+"/", info, "", nil)
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return &readError{err}
+		}
+		return ew.add(name, info, link, nil)
+	case info.Mode().IsRegular():
+		f, err := os.Open(path)
+		if err != nil {
+			return &readError{err}
+		}
+		defer f.Close()
+		src := &trackingReader{r: f}
+		if err := ew.add(name, info, "", src); err != nil {
+			if src.err != nil {
+				return &readError{src.err}
+			}
+			return err
+		}
+		if src.err != nil {
+			return &readError{src.err}
+		}
+		return nil
+	}
+	return nil // devices, sockets and pipes are left out
+}
+
+// trackingReader remembers read errors so they can be told apart from
+// write errors after a copy, and turns them into EOF so the copy ends.
+type trackingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (t *trackingReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func uniqueName(used map[string]bool, name string) string {
+	candidate := name
+	ext := path.Ext(name)
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+func buildManifest(archived, skipped []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Created %s\n\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&sb, "%d files archived:\n", len(archived))
+	for _, name := range archived {
+		fmt.Fprintf(&sb, "  %s\n", name)
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(&sb, "\n%d entries could not be read and are missing or incomplete:\n", len(skipped))
+		for _, s := range skipped {
+			fmt.Fprintf(&sb, "  %s\n", s)
+		}
+	}
+	return sb.String()
+}
+
+// manifestInfo describes the generated manifest file.
+type manifestInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (m manifestInfo) Name() string       { return ManifestName }
+func (m manifestInfo) Size() int64        { return m.size }
+func (m manifestInfo) Mode() fs.FileMode  { return 0o644 }
+func (m manifestInfo) ModTime() time.Time { return m.modTime }
+func (m manifestInfo) IsDir() bool        { return false }
+func (m manifestInfo) Sys() interface{}   { return nil }
+
+// storedExtensions are already compressed and are stored as they are
+// rather than deflated again.
+var storedExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".webm": true, ".mp3": true, ".aac": true, ".ogg": true, ".flac": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true, ".jar": true, ".apk": true,
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func newZipWriter(w io.Writer) *zipWriter {
+	return &zipWriter{zw: zip.NewWriter(w)}
+}
+
+func (z *zipWriter) add(name string, info fs.FileInfo, link string, r io.Reader) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Deflate
+	if info.IsDir() || storedExtensions[strings.ToLower(path.Ext(name))] {
+		hdr.Method = zip.Store
+	}
+	w, err := z.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	switch {
+	case link != "":
+		_, err = io.WriteString(w, link)
+	case r != nil:
+		_, err = io.Copy(w, r)
+	}
+	return err
+}
+
+func (z *zipWriter) close() error {
+	return z.zw.Close()
+}
+
+type tarWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarWriter(w io.Writer) *tarWriter {
+	gz := gzip.NewWriter(w)
+	return &tarWriter{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (t *tarWriter) add(name string, info fs.FileInfo, link string, r io.Reader) error {
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Format = tar.FormatPAX
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if r == nil || hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	// The header promised Size bytes: a file that shrank while being read
+	// is padded with zeros and one that grew is cut off.
+	n, err := io.Copy(t.tw, io.LimitReader(r, hdr.Size))
+	if err != nil {
+		return err
+	}
+	if n < hdr.Size {
+		if _, err := io.CopyN(t.tw, zeroReader{}, hdr.Size-n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tarWriter) close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
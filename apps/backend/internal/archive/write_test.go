@@ -0,0 +1,177 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"file-manager-backend/internal/fileops"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func setupSelection(t *testing.T) []string {
+	t.Helper()
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"one/docs/a.txt":             "alpha",
+		"one/docs/.secret":           "hidden",
+		"one/docs/node_modules/x.js": "x",
+		"two/docs/b.txt":             "bravo",
+		"two/readme.md":              "readme",
+	})
+	return []string{
+		filepath.Join(root, "one", "docs"),
+		filepath.Join(root, "two", "docs"),
+		filepath.Join(root, "two", "readme.md"),
+	}
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	return files
+}
+
+func readTarGz(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+	return files
+}
+
+func keys(m map[string]string) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWriteFormats(t *testing.T) {
+	paths := setupSelection(t)
+	rules := fileops.ListOptions{Exclude: []string{"node_modules"}}
+
+	for _, format := range []Format{FormatZip, FormatTarGz} {
+		var buf bytes.Buffer
+		if err := Write(&buf, format, paths, rules); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		var files map[string]string
+		if format == FormatZip {
+			files = readZip(t, buf.Bytes())
+		} else {
+			files = readTarGz(t, buf.Bytes())
+		}
+
+		want := []string{"MANIFEST.txt", "docs (2)/", "docs (2)/b.txt", "docs/", "docs/a.txt", "readme.md"}
+		if got := keys(files); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s entries = %v, want %v", format, got, want)
+		}
+		if files["docs/a.txt"] != "alpha" || files["docs (2)/b.txt"] != "bravo" {
+			t.Errorf("%s: wrong contents", format)
+		}
+		if !strings.Contains(files["MANIFEST.txt"], "3 files archived") {
+			t.Errorf("%s manifest:\n%s", format, files["MANIFEST.txt"])
+		}
+	}
+}
+
+func TestWriteReportsUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	paths := setupSelection(t)
+	locked := filepath.Join(paths[0], "a.txt")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0o644)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, FormatZip, paths[:1], fileops.ListOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	files := readZip(t, buf.Bytes())
+	if _, ok := files["docs/a.txt"]; ok {
+		t.Error("unreadable file was archived")
+	}
+	if !strings.Contains(files["MANIFEST.txt"], locked) {
+		t.Errorf("manifest does not list %s:\n%s", locked, files["MANIFEST.txt"])
+	}
+}
+
+func TestTarPadsShortReads(t *testing.T) {
+	var buf bytes.Buffer
+	tw := newTarWriter(&buf)
+	info := manifestInfo{size: 10}
+	if err := tw.add("short.txt", info, "", strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.close(); err != nil {
+		t.Fatal(err)
+	}
+	files := readTarGz(t, buf.Bytes())
+	if got := files["short.txt"]; got != "abc\x00\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("short.txt = %q", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(nil); err != fileops.ErrInvalidPath {
+		t.Errorf("empty selection: err = %v", err)
+	}
+	if err := Check([]string{filepath.Join(t.TempDir(), "missing")}); err != fileops.ErrPathNotFound {
+		t.Errorf("missing path: err = %v", err)
+	}
+	if _, err := ParseFormat("rar"); err == nil {
+		t.Error("ParseFormat accepted rar")
+	}
+}
@@ -0,0 +1,652 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+var testOptions = Options{Chunker: ChunkerParams{Min: 256, Avg: 1024, Max: 4096}, PackSize: 16 << 10}
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunks(t *testing.T, data []byte, gear *[256]uint64) [][]byte {
+	t.Helper()
+	var out [][]byte
+	c := NewChunker(bytes.NewReader(data), gear, testOptions.Chunker)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, append([]byte(nil), chunk...))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	gear := gearTable([]byte("seed"))
+	data := randomBytes(1, 200<<10)
+	original := chunks(t, data, gear)
+	if joined := bytes.Join(original, nil); !bytes.Equal(joined, data) {
+		t.Fatal("chunks do not add up to the input")
+	}
+	for i, c := range original[:len(original)-1] {
+		if len(c) < testOptions.Chunker.Min || len(c) > testOptions.Chunker.Max {
+			t.Errorf("chunk %d has %d bytes", i, len(c))
+		}
+	}
+	if n := len(original); n < 100 || n > 400 {
+		t.Errorf("%d chunks for 200 KiB", n)
+	}
+
+	// Inserting a byte only changes the chunks around it.
+	edited := append(append(append([]byte(nil), data[:100000]...), 'x'), data[100000:]...)
+	known := make(map[string]bool)
+	for _, c := range original {
+		known[hashOf(c)] = true
+	}
+	changed := 0
+	for _, c := range chunks(t, edited, gear) {
+		if !known[hashOf(c)] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 3 {
+		t.Errorf("%d chunks changed after an insertion", changed)
+	}
+}
+
+func writeTree(t *testing.T, root string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func sameFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the original", path)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(filepath.Join(t.TempDir(), "repo"), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Init(repo.Dir(), testOptions); !errors.Is(err, fileops.ErrAlreadyExists) {
+		t.Errorf("second Init = %v", err)
+	}
+	src := t.TempDir()
+	image := randomBytes(2, 256<<10)
+	writeTree(t, src, map[string][]byte{
+		"vm/disk.img":      image,
+		"docs/a.txt":       []byte("hello"),
+		"docs/copy.txt":    []byte("hello"),
+		"docs/deep/b.txt":  []byte("world"),
+		"docs/empty.txt":   nil,
+		"other/notes.md":   []byte("# notes"),
+		"other/.hidden.md": []byte("hidden"),
+	})
+	os.Symlink("a.txt", filepath.Join(src, "docs", "link"))
+
+	first, err := repo.Backup(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Files != 7 || first.Parent != "" || first.Added >= first.Size {
+		t.Errorf("first snapshot = %+v", first)
+	}
+
+	// A one-byte change stores only the chunks around it.
+	image[100000] ^= 1
+	os.WriteFile(filepath.Join(src, "vm", "disk.img"), image, 0o644)
+	second, err := repo.Backup(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Parent != first.ID || second.Added == 0 || second.Added > 2*int64(testOptions.Chunker.Max) {
+		t.Errorf("second snapshot = %+v", second)
+	}
+	if snaps, _ := repo.Snapshots(); len(snaps) != 2 || snaps[0].ID != second.ID || snaps[0].Nodes != nil {
+		t.Errorf("Snapshots = %+v", snaps)
+	}
+
+	// The first snapshot still has the original image.
+	target := filepath.Join(t.TempDir(), "restore")
+	res, err := repo.Restore(ctx, first.ID[:8], target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 7 || res.Size != first.Size {
+		t.Errorf("restored %+v", res)
+	}
+	image[100000] ^= 1
+	sameFile(t, filepath.Join(target, "vm", "disk.img"), image)
+	sameFile(t, filepath.Join(target, "docs", "deep", "b.txt"), []byte("world"))
+	sameFile(t, filepath.Join(target, "docs", "empty.txt"), nil)
+	if link, err := os.Readlink(filepath.Join(target, "docs", "link")); err != nil || link != "a.txt" {
+		t.Errorf("link = %q, %v", link, err)
+	}
+	if _, err := repo.Restore(ctx, first.ID, target, nil); !errors.Is(err, fileops.ErrAlreadyExists) {
+		t.Errorf("restore onto existing files = %v", err)
+	}
+
+	partial := filepath.Join(t.TempDir(), "partial")
+	if res, err := repo.Restore(ctx, second.ID, partial, []string{"docs/deep"}); err != nil || res.Files != 1 {
+		t.Fatalf("partial restore = %+v, %v", res, err)
+	}
+	if _, err := os.Stat(filepath.Join(partial, "docs", "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("unselected file restored: %v", err)
+	}
+	if _, err := repo.Snapshot("ffff"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("unknown snapshot = %v", err)
+	}
+	if _, err := Open(t.TempDir(), ""); !errors.Is(err, ErrNotRepository) {
+		t.Errorf("Open of a plain folder = %v", err)
+	}
+}
+
+func TestPruneAndCheck(t *testing.T) {
+	ctx := context.Background()
+	repo, err := Init(filepath.Join(t.TempDir(), "repo"), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	writeTree(t, src, map[string][]byte{"a.bin": randomBytes(3, 64<<10), "b.bin": randomBytes(4, 64<<10)})
+	old, err := repo.Backup(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(src, "b.bin"))
+	writeTree(t, src, map[string][]byte{"c.bin": randomBytes(5, 64<<10)})
+	latest, err := repo.Backup(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A pack left behind by an interrupted backup.
+	orphan := filepath.Join(repo.Dir(), "data", "00", "00"+hashOf([]byte("x"))[2:])
+	os.MkdirAll(filepath.Dir(orphan), 0o700)
+	os.WriteFile(orphan, []byte("partial"), 0o600)
+
+	res, err := repo.Prune(ctx, Policy{KeepLast: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Forgotten) != 1 || res.Forgotten[0] != old.ID || res.BytesFreed < 60<<10 {
+		t.Errorf("Prune = %+v", res)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphaned pack kept")
+	}
+	check, err := repo.Check(ctx, true)
+	if err != nil || check.Snapshots != 1 || len(check.Errors) != 0 {
+		t.Fatalf("Check = %+v, %v", check, err)
+	}
+	if indexes, _ := repo.list("index"); len(indexes) != 1 {
+		t.Errorf("%d index files after prune", len(indexes))
+	}
+	target := t.TempDir()
+	if _, err := repo.Restore(ctx, latest.ID, target, nil); err != nil {
+		t.Fatal(err)
+	}
+	sameFile(t, filepath.Join(target, "c.bin"), randomBytes(5, 64<<10))
+
+	// Damage is found by reading the data, and again on restore.
+	for id := range repo.packs {
+		path := repo.packPath(id)
+		data, _ := os.ReadFile(path)
+		data[len(data)/2] ^= 1
+		os.WriteFile(path, data, 0o600)
+	}
+	if check, _ := repo.Check(ctx, false); len(check.Errors) != 0 {
+		t.Errorf("metadata check found %v", check.Errors)
+	}
+	if check, _ := repo.Check(ctx, true); len(check.Errors) == 0 {
+		t.Error("damaged packs not found")
+	}
+	if _, err := repo.Restore(ctx, latest.ID, t.TempDir(), nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("restore of damaged data = %v", err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	saved := kdfParams
+	kdfParams.time, kdfParams.memory = 1, 64
+	t.Cleanup(func() { kdfParams = saved })
+	ctx := context.Background()
+	opts := testOptions
+	opts.Password = "correct horse"
+	repo, err := Init(filepath.Join(t.TempDir(), "repo"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	secret := bytes.Repeat([]byte("confidential "), 1000)
+	writeTree(t, src, map[string][]byte{"tax-return-2023.txt": secret, "photos/a.bin": randomBytes(6, 32<<10)})
+	snap, err := repo.Backup(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither names nor contents are readable on the drive.
+	filepath.WalkDir(repo.Dir(), func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, _ := os.ReadFile(p)
+		for _, plain := range [][]byte{[]byte("tax-return"), []byte("confidential"), []byte(src)} {
+			if bytes.Contains(data, plain) {
+				t.Errorf("%s contains %q", p, plain)
+			}
+		}
+		return nil
+	})
+
+	for _, password := range []string{"", "wrong"} {
+		if _, err := Open(repo.Dir(), password); !errors.Is(err, ErrWrongPassword) {
+			t.Errorf("Open with %q = %v", password, err)
+		}
+	}
+	plain, _ := Init(filepath.Join(t.TempDir(), "plain"), testOptions)
+	if _, err := Open(plain.Dir(), "password"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("password for a plain repository = %v", err)
+	}
+
+	reopened, err := Open(repo.Dir(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.ChangePassword("battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(repo.Dir(), "correct horse"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("old password still opens: %v", err)
+	}
+	reopened, err = Open(repo.Dir(), "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snaps, err := reopened.Snapshots(); err != nil || len(snaps) != 1 || snaps[0].Root != src {
+		t.Fatalf("Snapshots = %+v, %v", snaps, err)
+	}
+	target := t.TempDir()
+	if _, err := reopened.Restore(ctx, snap.ID, target, nil); err != nil {
+		t.Fatal(err)
+	}
+	sameFile(t, filepath.Join(target, "tax-return-2023.txt"), secret)
+	if check, err := reopened.Check(ctx, true); err != nil || len(check.Errors) != 0 {
+		t.Errorf("Check = %+v, %v", check, err)
+	}
+
+	// Tampering fails authentication.
+	for id := range reopened.packs {
+		path := reopened.packPath(id)
+		data, _ := os.ReadFile(path)
+		data[20] ^= 1
+		os.WriteFile(path, data, 0o600)
+		break
+	}
+	if check, _ := reopened.Check(ctx, true); len(check.Errors) < 2 {
+		t.Errorf("tampering found %v", check.Errors)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	saved := kdfParams
+	kdfParams.time, kdfParams.memory = 1, 64
+	t.Cleanup(func() { kdfParams = saved })
+	ctx := context.Background()
+	src := t.TempDir()
+	// Numbered lines compress well without repeating chunks, so the space
+	// saved does not depend on how deduplication cuts the text.
+	var text []byte
+	for i := 0; i < 2000; i++ {
+		text = fmt.Appendf(text, "Line %d of the quarterly report is attached.\n", i)
+	}
+	photo := append([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0x10, 'J', 'F', 'I', 'F', 0}, randomBytes(7, 16<<10)...)
+	noise := randomBytes(8, 16<<10)
+	writeTree(t, src, map[string][]byte{"report.txt": text, "photo.jpg": photo, "noise.bin": noise})
+
+	for _, opts := range []Options{
+		{Compression: CompressionZstd},
+		{Compression: CompressionGzip, Password: "secret"},
+	} {
+		opts.Chunker, opts.PackSize = testOptions.Chunker, testOptions.PackSize
+		repo, err := Init(filepath.Join(t.TempDir(), "repo"), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap, err := repo.Backup(ctx, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snap.Stored >= snap.Added {
+			t.Errorf("%s: stored %d of %d bytes", opts.Compression, snap.Stored, snap.Added)
+		}
+		// Small chunks of text may not shrink and are kept as they are.
+		full, _ := repo.Snapshot(snap.ID)
+		textCompressed := false
+		for _, n := range full.Nodes {
+			for _, id := range n.Content {
+				compressed := repo.blobs[id].compressed
+				if n.Path == "report.txt" {
+					textCompressed = textCompressed || compressed
+				} else if compressed {
+					t.Errorf("%s: chunk of %s compressed", opts.Compression, n.Path)
+				}
+			}
+		}
+		if !textCompressed {
+			t.Errorf("%s: no chunk of report.txt compressed", opts.Compression)
+		}
+
+		target := t.TempDir()
+		if _, err := repo.Restore(ctx, snap.ID, target, nil); err != nil {
+			t.Fatal(err)
+		}
+		sameFile(t, filepath.Join(target, "report.txt"), text)
+		sameFile(t, filepath.Join(target, "photo.jpg"), photo)
+		sameFile(t, filepath.Join(target, "noise.bin"), noise)
+		if check, err := repo.Check(ctx, true); err != nil || len(check.Errors) != 0 {
+			t.Errorf("%s: Check = %+v, %v", opts.Compression, check, err)
+		}
+		stats, err := repo.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.TotalSize != snap.Size || stats.CompressedSize == 0 || stats.CompressedSaved < int64(len(text))/2 ||
+			stats.StoredSize >= stats.UniqueSize {
+			t.Errorf("%s: stats = %+v", opts.Compression, stats)
+		}
+	}
+	if _, err := Init(filepath.Join(t.TempDir(), "repo"), Options{Compression: "lz4"}); !errors.Is(err, fileops.ErrInvalidPath) {
+		t.Errorf("unknown compression = %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	repo, err := Init(filepath.Join(t.TempDir(), "repo"), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := repo.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Backup(context.Background(), t.TempDir()); !errors.Is(err, ErrLocked) {
+		t.Errorf("Backup while locked = %v", err)
+	}
+	unlock()
+	if _, err := repo.Backup(context.Background(), t.TempDir()); err != nil {
+		t.Errorf("Backup after unlock = %v", err)
+	}
+
+	// A stale lock is broken once; the holder that lost it neither
+	// refreshes nor removes its successor's.
+	unlock, err = repo.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(repo.Dir(), "lock")
+	old := time.Now().Add(-2 * staleLock)
+	os.Chtimes(path, old, old)
+	unlockNext, err := repo.lock()
+	if err != nil {
+		t.Fatalf("breaking stale lock: %v", err)
+	}
+	unlock()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("old holder removed the new lock: %v", err)
+	}
+	if _, err := repo.lock(); !errors.Is(err, ErrLocked) {
+		t.Errorf("lock while held = %v", err)
+	}
+	unlockNext()
+	if entries, _ := os.ReadDir(repo.Dir()); len(entries) == 0 {
+		t.Fatal("repository emptied")
+	} else {
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), "lock") {
+				t.Errorf("left behind %s", e.Name())
+			}
+		}
+	}
+}
+
+func TestBackupJob(t *testing.T) {
+	repo, err := Init(filepath.Join(t.TempDir(), "repo"), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	writeTree(t, src, map[string][]byte{"a.txt": []byte("alpha")})
+
+	jobs := NewJobs(context.Background())
+	if _, err := jobs.Start(repo, filepath.Join(src, "missing")); !errors.Is(err, fileops.ErrPathNotFound) {
+		t.Errorf("Start(missing) = %v", err)
+	}
+	job, err := jobs.Start(repo, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list := jobs.List()
+		if list[0].ID == job.ID && list[0].EndedAt != nil {
+			if list[0].Error != "" || list[0].Snapshot == nil || list[0].Snapshot.Root != src {
+				t.Errorf("job = %+v", list[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if snaps, err := repo.Snapshots(); err != nil || len(snaps) != 1 {
+		t.Errorf("snapshots = %v, %v", snaps, err)
+	}
+}
+
+func TestEncryptedDestination(t *testing.T) {
+	ctx := context.Background()
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "nas")
+	files := map[string][]byte{
+		"secret-plans.txt": []byte("meet at dawn"),
+		"photo.raw":        randomBytes(1, 3*segmentSize+17),
+		"empty":            {},
+	}
+	writeTree(t, src, files)
+	if _, err := InitDestination(dst, DestinationOptions{Password: "pw"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDestination(dst, ""); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("open without password: %v", err)
+	}
+	if _, err := OpenDestination(dst, "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("open with wrong password: %v", err)
+	}
+	dest, err := OpenDestination(dst, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := fileops.SyncMatchingFiles(src, dest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) != len(files) {
+		t.Fatalf("first sync copied %v", copied)
+	}
+	filepath.WalkDir(dst, func(path string, e os.DirEntry, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(e.Name(), "secret") || strings.Contains(e.Name(), "photo") {
+			t.Errorf("%s leaks a file name", path)
+		}
+		if !e.IsDir() {
+			data, _ := os.ReadFile(path)
+			if bytes.Contains(data, []byte("meet at dawn")) {
+				t.Errorf("%s leaks file contents", path)
+			}
+		}
+		return nil
+	})
+
+	if copied, err = fileops.SyncMatchingFiles(src, dest, nil); err != nil || len(copied) != 0 {
+		t.Fatalf("second sync copied %v: %v", copied, err)
+	}
+	// A changed file replaces its stored copy.
+	files["secret-plans.txt"] = []byte("meet at dusk")
+	writeTree(t, src, map[string][]byte{"secret-plans.txt": files["secret-plans.txt"]})
+	if copied, err = fileops.SyncMatchingFiles(src, dest, nil); err != nil || len(copied) != 1 {
+		t.Fatalf("changed file: copied %v, %v", copied, err)
+	}
+
+	target := t.TempDir()
+	report, err := dest.Restore(ctx, target, nil)
+	if err != nil || len(report.Files) != len(files) {
+		t.Fatalf("restore: %+v, %v", report, err)
+	}
+	for name, data := range files {
+		sameFile(t, filepath.Join(target, name), data)
+	}
+	if report, err = dest.Restore(ctx, target, []string{"empty"}); err != nil || len(report.Skipped) != 1 {
+		t.Errorf("restore over existing files: %+v, %v", report, err)
+	}
+
+	if report, err = dest.Verify(ctx); err != nil || len(report.Errors) != 0 {
+		t.Fatalf("verify: %+v, %v", report, err)
+	}
+	stored, err := dest.storedPath("photo.raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 1
+	if err := os.WriteFile(stored, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = dest.Verify(ctx); err != nil || len(report.Errors) != 1 {
+		t.Errorf("verify after tampering: %+v, %v", report, err)
+	}
+
+	// A record claiming 4 GiB is rejected before it is read.
+	if stored, err = dest.storedPath("empty"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = os.ReadFile(stored); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[len(storedMagic)+2+16:], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	if err := os.WriteFile(stored, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = dest.Verify(ctx); err != nil || len(report.Errors) != 2 ||
+		!strings.Contains(strings.Join(report.Errors, "\n"), "empty: "+ErrCorrupt.Error()+": record of 4294967295 bytes") {
+		t.Errorf("verify with a huge record length: %+v, %v", report, err)
+	}
+}
+
+type memCatalog []StoredFile
+
+func (c *memCatalog) RecordStored(f StoredFile) error {
+	*c = append(*c, f)
+	return nil
+}
+
+func TestCompressedDestination(t *testing.T) {
+	ctx := context.Background()
+	var text []byte
+	for i := 0; i < 5000; i++ {
+		text = fmt.Appendf(text, "Line %d of the minutes.\n", i)
+	}
+	photo := append([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 0x10, 'J', 'F', 'I', 'F', 0}, randomBytes(9, 16<<10)...)
+	src := t.TempDir()
+	writeTree(t, src, map[string][]byte{"minutes.txt": text, "photo.jpg": photo})
+
+	for _, opts := range []DestinationOptions{
+		{Compression: CompressionZstd},
+		{Compression: CompressionGzip, Password: "pw"},
+	} {
+		dst := filepath.Join(t.TempDir(), "nas")
+		if _, err := InitDestination(dst, opts); err != nil {
+			t.Fatal(err)
+		}
+		dest, err := OpenDestination(dst, opts.Password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var catalog memCatalog
+		dest.SetCatalog(&catalog)
+		if copied, err := fileops.SyncMatchingFiles(src, dest, nil); err != nil || len(copied) != 2 {
+			t.Fatalf("%s: sync copied %v, %v", opts.Compression, copied, err)
+		}
+		if len(catalog) != 2 {
+			t.Fatalf("%s: catalog = %+v", opts.Compression, catalog)
+		}
+		for _, f := range catalog {
+			switch f.Path {
+			case "minutes.txt":
+				if f.Compression != opts.Compression || f.Size != int64(len(text)) || f.StoredSize >= f.Size/2 {
+					t.Errorf("%s: recorded %+v", opts.Compression, f)
+				}
+			case "photo.jpg":
+				if f.Compression != CompressionNone || f.StoredSize <= f.Size {
+					t.Errorf("%s: recorded %+v", opts.Compression, f)
+				}
+			}
+			if f.Encrypted != (opts.Password != "") {
+				t.Errorf("%s: recorded %+v", opts.Compression, f)
+			}
+		}
+
+		target := t.TempDir()
+		if report, err := dest.Restore(ctx, target, nil); err != nil || len(report.Files) != 2 {
+			t.Fatalf("%s: restore = %+v, %v", opts.Compression, report, err)
+		}
+		sameFile(t, filepath.Join(target, "minutes.txt"), text)
+		sameFile(t, filepath.Join(target, "photo.jpg"), photo)
+		if report, err := dest.Verify(ctx); err != nil || len(report.Errors) != 0 {
+			t.Errorf("%s: verify = %+v, %v", opts.Compression, report, err)
+		}
+	}
+	if _, err := InitDestination(t.TempDir(), DestinationOptions{Compression: "lz4"}); !errors.Is(err, fileops.ErrInvalidPath) {
+		t.Errorf("unknown compression = %v", err)
+	}
+}
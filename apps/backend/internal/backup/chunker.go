@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// ChunkerParams bound the size of content-defined chunks. Avg must be a
+// power of two.
+type ChunkerParams struct {
+	Min int `json:"min"`
+	Avg int `json:"avg"`
+	Max int `json:"max"`
+}
+
+// DefaultChunker suits files from a few megabytes to disk images.
+var DefaultChunker = ChunkerParams{Min: 512 << 10, Avg: 1 << 20, Max: 8 << 20}
+
+func (p ChunkerParams) validate() error {
+	if p.Min < 64 || p.Avg <= p.Min || p.Max <= p.Avg || bits.OnesCount(uint(p.Avg)) != 1 {
+		return fmt.Errorf("invalid chunk sizes %d/%d/%d", p.Min, p.Avg, p.Max)
+	}
+	return nil
+}
+
+// gearTable derives the table of the rolling hash from the repository's
+// seed, so chunk boundaries differ between repositories.
+func gearTable(seed []byte) *[256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256(append(append([]byte(nil), seed...), byte(i)))
+		table[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return &table
+}
+
+// Chunker splits a stream into content-defined chunks with FastCDC: a
+// rolling gear hash over the last 64 bytes picks the cut points, so an
+// insertion or a change only moves the boundaries around it and the rest
+// of a file deduplicates against earlier snapshots.
+type Chunker struct {
+	r            io.Reader
+	gear         *[256]uint64
+	params       ChunkerParams
+	maskS, maskL uint64
+	buf          []byte
+	n            int
+	eof          bool
+	// returned is the length of the chunk last returned, still at the
+	// front of buf.
+	returned int
+}
+
+// NewChunker returns a chunker reading r.
+func NewChunker(r io.Reader, gear *[256]uint64, params ChunkerParams) *Chunker {
+	// Before the average size a cut needs two more matching bits than
+	// after it, which narrows the spread of chunk sizes. The hash shifts
+	// left, so only its high bits depend on a full window.
+	b := bits.TrailingZeros(uint(params.Avg))
+	return &Chunker{
+		r:      r,
+		gear:   gear,
+		params: params,
+		maskS:  ^uint64(0) << (64 - (b + 2)),
+		maskL:  ^uint64(0) << (64 - (b - 2)),
+		buf:    make([]byte, params.Max),
+	}
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the following call.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.returned > 0 {
+		c.n = copy(c.buf, c.buf[c.returned:c.n])
+		c.returned = 0
+	}
+	if !c.eof && c.n < len(c.buf) {
+		m, err := io.ReadFull(c.r, c.buf[c.n:])
+		c.n += m
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+	c.returned = c.cut(c.buf[:c.n])
+	return c.buf[:c.returned], nil
+}
+
+// cut returns the length of the chunk at the start of data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.params.Min {
+		return n
+	}
+	normal := min(c.params.Avg, n)
+	var h uint64
+	i := c.params.Min
+	for ; i < normal; i++ {
+		h = h<<1 + c.gear[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = h<<1 + c.gear[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// Compression methods for Options.Compression.
+const (
+	CompressionNone = ""
+	CompressionZstd = "zstd"
+	CompressionGzip = "gzip"
+)
+
+// The zstd encoder and decoder are safe for concurrent EncodeAll and
+// DecodeAll calls.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func validCompression(method string) error {
+	switch method {
+	case CompressionNone, CompressionZstd, CompressionGzip:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown compression %q", fileops.ErrInvalidPath, method)
+}
+
+// compress returns data compressed with the repository's method. Without
+// one, data is returned as it is.
+func (r *Repository) compress(data []byte) []byte {
+	switch r.cfg.Compression {
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(data, nil)
+	case CompressionGzip:
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(data)
+		gz.Close()
+		return buf.Bytes()
+	}
+	return data
+}
+
+// decompress reverses compress. size is the expected length, or -1 when
+// unknown.
+func (r *Repository) decompress(data []byte, size int64) ([]byte, error) {
+	var out []byte
+	var err error
+	switch r.cfg.Compression {
+	case CompressionZstd:
+		out, err = zstdDecoder.DecodeAll(data, nil)
+	case CompressionGzip:
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			out, err = io.ReadAll(gz)
+		}
+	default:
+		return nil, fmt.Errorf("%w: compressed data in a repository without compression", ErrCorrupt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if size >= 0 && int64(len(out)) != size {
+		return nil, fmt.Errorf("%w: decompressed %d bytes, want %d", ErrCorrupt, len(out), size)
+	}
+	return out, nil
+}
+
+// compressible reports whether files of a MIME type are worth compressing;
+// media, archives and zip-based documents already are compressed.
+func compressible(mimeType string) bool {
+	switch mimeType {
+	case "image/svg+xml", "image/bmp", "image/tiff", "audio/wav", "audio/x-wav", "application/x-tar":
+		return true
+	case "application/epub+zip", "application/pdf":
+		return false
+	}
+	switch fileops.MimeCategory(mimeType) {
+	case "image", "video", "audio", "archive":
+		return false
+	}
+	return !strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument.") &&
+		!strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument.")
+}
+
+// methodNames are the compression methods of the method bytes of stored
+// files.
+var methodNames = map[byte]string{
+	methodNone: CompressionNone,
+	methodGzip: CompressionGzip,
+	methodZstd: CompressionZstd,
+}
+
+// compressWriter returns a writer compressing into w with method. Closing
+// it flushes the compressed stream but leaves w open. Without a method, w
+// is returned as it is.
+func compressWriter(w io.WriteCloser, method byte) (io.WriteCloser, error) {
+	switch method {
+	case methodGzip:
+		return gzip.NewWriter(w), nil
+	case methodZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return w, nil
+}
+
+// decompressReader returns a reader of the contents of r compressed with
+// method.
+func decompressReader(r io.Reader, method byte) (io.ReadCloser, error) {
+	switch method {
+	case methodNone:
+		return io.NopCloser(r), nil
+	case methodGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		return gz, nil
+	case methodZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: unknown compression method %d", ErrCorrupt, method)
+}
@@ -0,0 +1,162 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrWrongPassword is returned when an encrypted repository is opened
+// without its password or with a wrong one, and when a password is given
+// for a repository that is not encrypted.
+var ErrWrongPassword = errors.New("wrong backup repository password")
+
+// kdfParams are the Argon2id costs for new keys; time passes, memory in
+// KiB.
+var kdfParams = struct {
+	time, memory uint32
+	threads      uint8
+}{time: 3, memory: 64 << 10, threads: 4}
+
+// keyHeader is stored in the config of an encrypted repository. The
+// master key is sealed with a key derived from the password, so the
+// password can change without encrypting the data again.
+type keyHeader struct {
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	// Sealed is the master key encrypted with the derived key.
+	Sealed []byte `json:"sealed"`
+}
+
+// masterKey encrypts everything in the repository. The chunker seed is
+// kept with it, since chunk boundaries would otherwise reveal whether a
+// known file is stored.
+type masterKey struct {
+	Encrypt     []byte `json:"encrypt"`
+	ID          []byte `json:"id"`
+	ChunkerSeed []byte `json:"chunkerSeed"`
+}
+
+func newMasterKey() (masterKey, error) {
+	k := masterKey{Encrypt: make([]byte, 32), ID: make([]byte, 32), ChunkerSeed: make([]byte, 16)}
+	for _, b := range [][]byte{k.Encrypt, k.ID, k.ChunkerSeed} {
+		if _, err := rand.Read(b); err != nil {
+			return k, err
+		}
+	}
+	return k, nil
+}
+
+// seal returns a header holding k, sealed with password.
+func seal(k masterKey, password string) (*keyHeader, error) {
+	h := &keyHeader{KDF: "argon2id", Salt: make([]byte, 16),
+		Time: kdfParams.time, Memory: kdfParams.memory, Threads: kdfParams.threads}
+	if _, err := rand.Read(h.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(h.derive(password))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(k)
+	if err != nil {
+		return nil, err
+	}
+	if h.Sealed, err = encrypt(aead, plain, []byte("key")); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// open returns the master key, or ErrWrongPassword.
+func (h *keyHeader) open(password string) (masterKey, error) {
+	var k masterKey
+	if h.KDF != "argon2id" {
+		return k, fmt.Errorf("%w: unknown key derivation %q", ErrNotRepository, h.KDF)
+	}
+	aead, err := newAEAD(h.derive(password))
+	if err != nil {
+		return k, err
+	}
+	plain, err := decrypt(aead, h.Sealed, []byte("key"))
+	if err != nil {
+		return k, ErrWrongPassword
+	}
+	if err := json.Unmarshal(plain, &k); err != nil || len(k.Encrypt) != 32 || len(k.ID) == 0 {
+		return k, fmt.Errorf("%w: invalid master key", ErrCorrupt)
+	}
+	return k, nil
+}
+
+func (h *keyHeader) derive(password string) []byte {
+	return argon2.IDKey([]byte(password), h.Salt, h.Time, h.Memory, h.Threads, 32)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns a random nonce followed by the sealed data. ad binds the
+// ciphertext to where it belongs, so it cannot be passed off as another
+// file or chunk.
+func encrypt(aead cipher.AEAD, plain, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, ad), nil
+}
+
+func decrypt(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], ad)
+}
+
+// blobID names a chunk. In an encrypted repository it is keyed, so the
+// index does not reveal the hashes of the stored contents.
+func (r *Repository) blobID(data []byte) string {
+	if r.idKey == nil {
+		return hashOf(data)
+	}
+	mac := hmac.New(sha256.New, r.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encode prepares data for storage under the name ad.
+func (r *Repository) encode(data []byte, ad string) ([]byte, error) {
+	if r.aead == nil {
+		return data, nil
+	}
+	return encrypt(r.aead, data, []byte(ad))
+}
+
+// decode reverses encode.
+func (r *Repository) decode(stored []byte, ad string) ([]byte, error) {
+	if r.aead == nil {
+		return stored, nil
+	}
+	plain, err := decrypt(r.aead, stored, []byte(ad))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s fails authentication", ErrCorrupt, ad)
+	}
+	return plain, nil
+}
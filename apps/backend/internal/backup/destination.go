@@ -0,0 +1,803 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// A sync destination is a folder that fileops.SyncFiles copies files
+// into, such as a drive taken offsite. Unlike a repository it keeps one
+// stored file per synced file, in the same layout. A destination set up
+// with InitDestination has a header file naming how its files are
+// stored; a folder without one is plain and holds ordinary copies.
+//
+// In an encrypted destination every path component is encrypted
+// deterministically, so a file keeps the same stored name and can be
+// found again, and file contents are sealed in segments with AES-256-GCM
+// under a key derived per file. The master key is sealed with the
+// password as in a repository and kept in the header, so the destination
+// can be restored on any machine that knows the password.
+//
+// A destination may also compress files as they are copied, except those
+// whose type is already compressed. Compressed and encrypted files are
+// stored in the FMD1 format, which records the original size and hash so
+// restore and verify need nothing but the destination.
+
+// destinationHeader is the name of the header file of a destination.
+const destinationHeader = ".backup-destination"
+
+// destinationVersion is the header format written by InitDestination.
+const destinationVersion = 1
+
+// storedMagic starts every file stored in a transformed form.
+const storedMagic = "FMD1"
+
+// segmentSize is how much file content is sealed at a time, so large
+// files are never held in memory whole.
+const segmentSize = 64 << 10
+
+// flagEncrypted marks a stored file whose metadata and contents are
+// sealed.
+const flagEncrypted = 1
+
+// Method bytes of stored files, naming how their contents are compressed.
+const (
+	methodNone byte = iota
+	methodGzip
+	methodZstd
+)
+
+// maxMetaSize bounds the metadata of a stored file.
+const maxMetaSize = 64 << 10
+
+// DestinationOptions configure a new sync destination.
+type DestinationOptions struct {
+	// Password, when set, encrypts file names and contents.
+	Password string
+	// Compression is CompressionZstd or CompressionGzip to compress files
+	// as they are copied, or CompressionNone.
+	Compression string
+}
+
+type destinationConfig struct {
+	Version     int        `json:"version"`
+	Compression string     `json:"compression,omitempty"`
+	Encryption  *keyHeader `json:"encryption,omitempty"`
+}
+
+// Destination is a sync destination. It implements fileops.SyncTarget.
+type Destination struct {
+	dir     string
+	cfg     destinationConfig
+	catalog Catalog
+
+	// Set when encrypted.
+	fileKey  []byte // derives the key of each file
+	nameKey  []byte // derives the nonce of each name
+	nameAEAD cipher.AEAD
+}
+
+// StoredFile describes a file Put stored in a destination.
+type StoredFile struct {
+	Destination string
+	// Path is the slash-separated path below the destination.
+	Path string
+	Hash string
+	// Size is the original size, StoredSize the size on the destination.
+	Size       int64
+	StoredSize int64
+	// Compression is the method the file was compressed with, empty when
+	// its type is already compressed or the destination does not compress.
+	Compression string
+	Encrypted   bool
+}
+
+// Catalog records the files stored in destinations, so reports can show
+// the space compression saves without reading the destinations.
+type Catalog interface {
+	RecordStored(f StoredFile) error
+}
+
+// storedMeta is kept at the start of each stored file, so it can be
+// compared with a source without reading the rest.
+type storedMeta struct {
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// InitDestination sets up the folder dir, which is created if needed and
+// must be empty, as a sync destination.
+func InitDestination(dir string, opts DestinationOptions) (*Destination, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", fileops.ErrInvalidPath, err)
+	}
+	if err := validCompression(opts.Compression); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fileops.MapError(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("%w: %s is not empty", fileops.ErrAlreadyExists, dir)
+	}
+	cfg := destinationConfig{Version: destinationVersion, Compression: opts.Compression}
+	if opts.Password != "" {
+		k, err := newMasterKey()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Encryption, err = seal(k, opts.Password); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeAtomic(filepath.Join(dir, destinationHeader), data); err != nil {
+		return nil, err
+	}
+	return OpenDestination(dir, opts.Password)
+}
+
+// OpenDestination opens the sync destination dir. A folder without a
+// header is a plain destination. An encrypted one needs its password;
+// ErrWrongPassword is returned without it, with a wrong one, or with one
+// for a destination that is not encrypted.
+func OpenDestination(dir, password string) (*Destination, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", fileops.ErrInvalidPath, err)
+	}
+	d := &Destination{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, destinationHeader))
+	if errors.Is(err, fs.ErrNotExist) {
+		if password != "" {
+			return nil, fmt.Errorf("%w: %s is not encrypted", ErrWrongPassword, dir)
+		}
+		return d, nil
+	}
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	if err := json.Unmarshal(data, &d.cfg); err != nil || d.cfg.Version != destinationVersion ||
+		validCompression(d.cfg.Compression) != nil {
+		return nil, fmt.Errorf("%w: unreadable destination header in %s", ErrNotRepository, dir)
+	}
+	if d.cfg.Encryption == nil {
+		if password != "" {
+			return nil, fmt.Errorf("%w: %s is not encrypted", ErrWrongPassword, dir)
+		}
+		return d, nil
+	}
+	if password == "" {
+		return nil, fmt.Errorf("%w: %s is encrypted", ErrWrongPassword, dir)
+	}
+	k, err := d.cfg.Encryption.open(password)
+	if err != nil {
+		return nil, err
+	}
+	d.fileKey, d.nameKey = k.Encrypt, k.ID
+	if d.nameAEAD, err = newAEAD(mac(k.Encrypt, []byte("names"))); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Root returns the destination folder.
+func (d *Destination) Root() string {
+	return d.dir
+}
+
+// Encrypted reports whether file names and contents are encrypted.
+func (d *Destination) Encrypted() bool {
+	return d.fileKey != nil
+}
+
+// Compression returns the compression method of the destination.
+func (d *Destination) Compression() string {
+	return d.cfg.Compression
+}
+
+// SetCatalog makes Put record every file it stores in c.
+func (d *Destination) SetCatalog(c Catalog) {
+	d.catalog = c
+}
+
+// transformed reports whether files are stored in the FMD1 format rather
+// than as plain copies.
+func (d *Destination) transformed() bool {
+	return d.Encrypted() || d.cfg.Compression != CompressionNone
+}
+
+// method returns how to compress the file src: with the destination's
+// method unless its type is already compressed.
+func (d *Destination) method(src string) byte {
+	if mimeType, err := fileops.DetectMimeType(src); err == nil && !compressible(mimeType) {
+		return methodNone
+	}
+	switch d.cfg.Compression {
+	case CompressionGzip:
+		return methodGzip
+	case CompressionZstd:
+		return methodZstd
+	}
+	return methodNone
+}
+
+// Put stores the file src as rel. An existing file is kept when it holds
+// the same contents and replaced otherwise, once the new one is complete.
+func (d *Destination) Put(src, rel string) (bool, error) {
+	if !d.transformed() {
+		copied, err := fileops.Dir(d.dir).Put(src, rel)
+		if err != nil || !copied {
+			return copied, err
+		}
+		return true, d.record(src, rel, methodNone)
+	}
+	stored, err := d.storedPath(rel)
+	if err != nil {
+		return false, err
+	}
+	hash, err := fileops.FileHash(src)
+	if err != nil {
+		return false, fileops.MapError(err)
+	}
+	if meta, err := d.readMeta(stored); err == nil && meta.Hash == hash {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(stored), 0o755); err != nil {
+		return false, fileops.MapError(err)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return false, fileops.MapError(err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return false, fileops.MapError(err)
+	}
+	method := d.method(src)
+	err = fileops.Replace(stored, func(tmp string) error {
+		out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return fileops.MapError(err)
+		}
+		err = d.encode(out, in, storedMeta{Size: info.Size(), Hash: hash}, method)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, d.record(src, rel, method)
+}
+
+// record adds the file src, just stored as rel, to the catalog.
+func (d *Destination) record(src, rel string, method byte) error {
+	if d.catalog == nil {
+		return nil
+	}
+	stored, err := d.storedPath(rel)
+	if err != nil {
+		return err
+	}
+	storedInfo, err := os.Stat(stored)
+	if err != nil {
+		return fileops.MapError(err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return fileops.MapError(err)
+	}
+	hash, err := fileops.FileHash(src)
+	if err != nil {
+		return fileops.MapError(err)
+	}
+	return d.catalog.RecordStored(StoredFile{
+		Destination: d.dir,
+		Path:        filepath.ToSlash(rel),
+		Hash:        hash,
+		Size:        info.Size(),
+		StoredSize:  storedInfo.Size(),
+		Compression: methodNames[method],
+		Encrypted:   d.Encrypted(),
+	})
+}
+
+// storedPath returns where the file rel is stored.
+func (d *Destination) storedPath(rel string) (string, error) {
+	rel = filepath.Clean(rel)
+	if rel == "." || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %s", fileops.ErrInvalidPath, rel)
+	}
+	if !d.Encrypted() {
+		return filepath.Join(d.dir, rel), nil
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		nonce := mac(d.nameKey, []byte(part))[:d.nameAEAD.NonceSize()]
+		name := base64.RawURLEncoding.EncodeToString(d.nameAEAD.Seal(nonce, nonce, []byte(part), []byte("name")))
+		if len(name) > 255 {
+			return "", fmt.Errorf("%w: name too long to encrypt: %s", fileops.ErrInvalidPath, part)
+		}
+		parts[i] = name
+	}
+	return filepath.Join(d.dir, filepath.Join(parts...)), nil
+}
+
+// relPath reverses storedPath for a path below the destination.
+func (d *Destination) relPath(stored string) (string, error) {
+	rel, err := filepath.Rel(d.dir, stored)
+	if err != nil || !d.Encrypted() {
+		return rel, err
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		sealed, err := base64.RawURLEncoding.DecodeString(part)
+		n := d.nameAEAD.NonceSize()
+		if err != nil || len(sealed) < n {
+			return "", fmt.Errorf("%w: %s is not an encrypted name", ErrCorrupt, part)
+		}
+		plain, err := d.nameAEAD.Open(nil, sealed[:n], sealed[n:], []byte("name"))
+		if err != nil {
+			return "", fmt.Errorf("%w: %s fails authentication", ErrCorrupt, part)
+		}
+		parts[i] = string(plain)
+	}
+	return filepath.Join(parts...), nil
+}
+
+// encode writes the stored form of the contents of r with meta to w,
+// compressed with method:
+//
+//	"FMD1", the method byte, a flags byte
+//	encrypted: a 16-byte salt, then records of a 4-byte length and the
+//	  sealed bytes, the first holding meta and the rest the compressed
+//	  contents in segments, the last one marked as such in its nonce
+//	otherwise: a 4-byte length and meta, then the compressed contents
+func (d *Destination) encode(w io.Writer, r io.Reader, meta storedMeta, method byte) error {
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	header := []byte{storedMagic[0], storedMagic[1], storedMagic[2], storedMagic[3], method, 0}
+	var body io.WriteCloser
+	if d.Encrypted() {
+		header[5] = flagEncrypted
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		header = append(header, salt...)
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		sw, err := d.newSealWriter(w, salt, header)
+		if err != nil {
+			return err
+		}
+		if err := sw.record(metaData, false); err != nil {
+			return err
+		}
+		body = sw
+	} else {
+		header = binary.BigEndian.AppendUint32(header, uint32(len(metaData)))
+		if _, err := w.Write(append(header, metaData...)); err != nil {
+			return err
+		}
+		body = nopWriteCloser{w}
+	}
+	cw, err := compressWriter(body, method)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(cw, r); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if cw != body {
+		return body.Close()
+	}
+	return nil
+}
+
+// open returns a reader of the decompressed contents of the stored file
+// f, with its metadata. Reading fails with ErrCorrupt when the file was
+// tampered with or cut short.
+func (d *Destination) open(f io.Reader) (io.ReadCloser, storedMeta, error) {
+	var meta storedMeta
+	br := bufio.NewReader(f)
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:4]) != storedMagic {
+		return nil, meta, fmt.Errorf("%w: not a stored file", ErrCorrupt)
+	}
+	if (header[5]&flagEncrypted != 0) != d.Encrypted() {
+		return nil, meta, fmt.Errorf("%w: unexpected stored file format", ErrCorrupt)
+	}
+	var body io.Reader
+	var metaData []byte
+	if d.Encrypted() {
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(br, salt); err != nil {
+			return nil, meta, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		header = append(header, salt...)
+		sr, err := d.newSealReader(br, salt, header)
+		if err != nil {
+			return nil, meta, err
+		}
+		if metaData, _, err = sr.record(); err != nil {
+			return nil, meta, err
+		}
+		body = sr
+	} else {
+		var length [4]byte
+		if _, err := io.ReadFull(br, length[:]); err != nil {
+			return nil, meta, fmt.Errorf("%w: stored file cut short", ErrCorrupt)
+		}
+		n := binary.BigEndian.Uint32(length[:])
+		if n > maxMetaSize {
+			return nil, meta, fmt.Errorf("%w: unreadable metadata", ErrCorrupt)
+		}
+		metaData = make([]byte, n)
+		if _, err := io.ReadFull(br, metaData); err != nil {
+			return nil, meta, fmt.Errorf("%w: stored file cut short", ErrCorrupt)
+		}
+		body = br
+	}
+	if err := json.Unmarshal(metaData, &meta); err != nil {
+		return nil, meta, fmt.Errorf("%w: unreadable metadata", ErrCorrupt)
+	}
+	r, err := decompressReader(body, header[4])
+	if err != nil {
+		return nil, meta, err
+	}
+	return r, meta, nil
+}
+
+// readMeta returns the metadata of the stored file at path.
+func (d *Destination) readMeta(path string) (storedMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return storedMeta{}, fileops.MapError(err)
+	}
+	defer f.Close()
+	r, meta, err := d.open(f)
+	if err != nil {
+		return meta, err
+	}
+	r.Close()
+	return meta, nil
+}
+
+// DestinationFile is a file found in a destination by Restore or Verify.
+type DestinationFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// DestinationReport is the outcome of Restore or Verify.
+type DestinationReport struct {
+	Files []DestinationFile `json:"files"`
+	// Skipped lists files Restore did not write because the target
+	// already held them.
+	Skipped []string `json:"skipped,omitempty"`
+	// Errors lists files that are damaged or could not be read.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Restore writes the files of the destination, or those below paths,
+// decrypted into target. Existing files are never overwritten. Files
+// that fail to decrypt or whose contents do not match their recorded
+// hash are removed again and listed as errors.
+func (d *Destination) Restore(ctx context.Context, target string, paths []string) (DestinationReport, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return DestinationReport{}, fmt.Errorf("%w: %v", fileops.ErrInvalidPath, err)
+	}
+	clean := make([]string, len(paths))
+	for i, p := range paths {
+		if clean[i] = strings.Trim(filepath.ToSlash(filepath.Clean(p)), "/"); clean[i] == "." {
+			clean[i] = ""
+		}
+	}
+	return d.each(ctx, clean, func(rel string, r io.Reader, meta storedMeta) (bool, error) {
+		out := filepath.Join(target, rel)
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			return false, err
+		}
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(out)
+		}
+		return true, err
+	})
+}
+
+// Verify reads every file of the destination and reports those that are
+// damaged: failing authentication, cut short, or not matching the hash
+// recorded when they were stored.
+func (d *Destination) Verify(ctx context.Context) (DestinationReport, error) {
+	return d.each(ctx, nil, func(rel string, r io.Reader, meta storedMeta) (bool, error) {
+		_, err := io.Copy(io.Discard, r)
+		return true, err
+	})
+}
+
+// each walks the stored files below paths, or all of them, calling fn with
+// a reader of each file's contents. The reader checks the size and hash
+// recorded for the file when it reaches the end. fn returns false for a
+// file it skipped.
+func (d *Destination) each(ctx context.Context, paths []string, fn func(rel string, r io.Reader, meta storedMeta) (bool, error)) (DestinationReport, error) {
+	report := DestinationReport{Files: []DestinationFile{}}
+	err := filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == d.dir || entry.IsDir() {
+			return nil
+		}
+		if path == filepath.Join(d.dir, destinationHeader) || !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := d.relPath(path)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", path, err))
+			return nil
+		}
+		if !selected(filepath.ToSlash(rel), paths) {
+			return nil
+		}
+		done, size, err := d.read(path, func(r io.Reader, meta storedMeta) (bool, error) {
+			return fn(rel, r, meta)
+		})
+		switch {
+		case err != nil:
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", rel, err))
+		case done:
+			report.Files = append(report.Files, DestinationFile{Path: rel, Size: size})
+		default:
+			report.Skipped = append(report.Skipped, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fileops.MapError(err)
+	}
+	return report, nil
+}
+
+// read opens the stored file at path and passes its contents to fn,
+// checking them against the recorded metadata.
+func (d *Destination) read(path string, fn func(io.Reader, storedMeta) (bool, error)) (bool, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, 0, err
+	}
+	defer f.Close()
+	if !d.transformed() {
+		info, err := f.Stat()
+		if err != nil {
+			return false, 0, err
+		}
+		done, err := fn(f, storedMeta{Size: info.Size()})
+		return done, info.Size(), err
+	}
+	r, meta, err := d.open(f)
+	if err != nil {
+		return false, 0, err
+	}
+	defer r.Close()
+	cr := &checkingReader{r: r, h: sha256.New(), meta: meta}
+	done, err := fn(cr, meta)
+	return done, meta.Size, err
+}
+
+// checkingReader hashes what is read and fails at the end if the size or
+// hash differs from meta.
+type checkingReader struct {
+	r    io.Reader
+	h    hash.Hash
+	n    int64
+	meta storedMeta
+}
+
+func (c *checkingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	if err == io.EOF && (c.n != c.meta.Size || hex.EncodeToString(c.h.Sum(nil)) != c.meta.Hash) {
+		return n, fmt.Errorf("%w: contents do not match the recorded hash", ErrCorrupt)
+	}
+	return n, err
+}
+
+// sealWriter writes the sealed records of one stored file. Each file has
+// its own key, derived from the salt, so nonces can simply count records.
+// Written contents are sealed in segments; Close seals the last one.
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	ad     []byte
+	n      uint64
+	nonce  []byte
+	length [4]byte
+	buf    []byte
+}
+
+func (d *Destination) newSealWriter(w io.Writer, salt, ad []byte) (*sealWriter, error) {
+	aead, err := newAEAD(mac(d.fileKey, salt))
+	if err != nil {
+		return nil, err
+	}
+	return &sealWriter{w: w, aead: aead, ad: ad, nonce: make([]byte, aead.NonceSize()),
+		buf: make([]byte, 0, segmentSize)}, nil
+}
+
+// Write buffers p, sealing full segments once more follows them, since
+// only the last segment is sealed as such.
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(s.buf) == segmentSize {
+			if err := s.record(s.buf, false); err != nil {
+				return written, err
+			}
+			s.buf = s.buf[:0]
+		}
+		n := copy(s.buf[len(s.buf):segmentSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals what is buffered as the last segment.
+func (s *sealWriter) Close() error {
+	return s.record(s.buf, true)
+}
+
+func (s *sealWriter) record(plain []byte, last bool) error {
+	recordNonce(s.nonce, s.n, last)
+	s.n++
+	sealed := s.aead.Seal(nil, s.nonce, plain, s.ad)
+	binary.BigEndian.PutUint32(s.length[:], uint32(len(sealed)))
+	if _, err := s.w.Write(s.length[:]); err != nil {
+		return err
+	}
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+// sealReader reads the records written by a sealWriter and returns the
+// contents of those after the first.
+type sealReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	ad    []byte
+	n     uint64
+	nonce []byte
+	buf   []byte
+	done  bool
+}
+
+func (d *Destination) newSealReader(r *bufio.Reader, salt, ad []byte) (*sealReader, error) {
+	aead, err := newAEAD(mac(d.fileKey, salt))
+	if err != nil {
+		return nil, err
+	}
+	return &sealReader{r: r, aead: aead, ad: ad, nonce: make([]byte, aead.NonceSize())}, nil
+}
+
+// record opens the next record. A record is the last one when nothing
+// follows it, and must then have been sealed as the last one, so a file
+// cut short at a record boundary is detected. Lengths beyond what a
+// sealWriter writes are rejected before anything is read into memory.
+func (s *sealReader) record() ([]byte, bool, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(s.r, length[:]); err != nil {
+		return nil, false, fmt.Errorf("%w: stored file cut short", ErrCorrupt)
+	}
+	n, limit := binary.BigEndian.Uint32(length[:]), segmentSize
+	if s.n == 0 {
+		limit = maxMetaSize
+	}
+	if int64(n) > int64(limit+s.aead.Overhead()) {
+		return nil, false, fmt.Errorf("%w: record of %d bytes", ErrCorrupt, n)
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(s.r, sealed); err != nil {
+		return nil, false, fmt.Errorf("%w: stored file cut short", ErrCorrupt)
+	}
+	_, peekErr := s.r.Peek(1)
+	last := peekErr == io.EOF
+	recordNonce(s.nonce, s.n, last)
+	s.n++
+	plain, err := s.aead.Open(nil, s.nonce, sealed, s.ad)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: stored file fails authentication", ErrCorrupt)
+	}
+	return plain, last, nil
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		plain, last, err := s.record()
+		if err != nil {
+			return 0, err
+		}
+		s.buf, s.done = plain, last
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// recordNonce fills nonce with the record counter n, marking the last
+// record in the final byte.
+func recordNonce(nonce []byte, n uint64, last bool) {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], n)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
+
+func mac(key, data []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(data)
+	return m.Sum(nil)
+}
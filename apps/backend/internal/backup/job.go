@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// maxJobs is how many finished jobs are remembered for List.
+const maxJobs = 50
+
+// Job is a backup running in the background.
+type Job struct {
+	ID        int        `json:"id"`
+	Repo      string     `json:"repo"`
+	Src       string     `json:"src"`
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+	// Snapshot is set once the backup succeeded.
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Jobs runs backups in the background, so a large folder does not hold a
+// request open, and remembers the recent ones.
+type Jobs struct {
+	ctx context.Context
+
+	mu   sync.Mutex
+	next int
+	jobs []*Job
+}
+
+// NewJobs returns a job list whose backups stop when ctx is done.
+func NewJobs(ctx context.Context) *Jobs {
+	return &Jobs{ctx: ctx}
+}
+
+// Start begins backing up the folder src into repo and returns the new
+// job. The folder is checked first, so a wrong path is reported right
+// away rather than as a failed job.
+func (j *Jobs) Start(repo *Repository, src string) (Job, error) {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return Job{}, fmt.Errorf("%w: %v", fileops.ErrInvalidPath, err)
+	}
+	if info, err := os.Stat(src); err != nil {
+		return Job{}, fileops.MapError(err)
+	} else if !info.IsDir() {
+		return Job{}, fmt.Errorf("%w: %s is not a folder", fileops.ErrInvalidPath, src)
+	}
+
+	j.mu.Lock()
+	j.next++
+	job := &Job{ID: j.next, Repo: repo.Dir(), Src: src, StartedAt: time.Now()}
+	j.jobs = append(j.jobs, job)
+	if len(j.jobs) > maxJobs {
+		j.jobs = j.jobs[len(j.jobs)-maxJobs:]
+	}
+	snapshot := *job
+	j.mu.Unlock()
+
+	go j.run(job, repo)
+	return snapshot, nil
+}
+
+// List returns the recent jobs, newest first.
+func (j *Jobs) List() []Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	jobs := make([]Job, 0, len(j.jobs))
+	for i := len(j.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, *j.jobs[i])
+	}
+	return jobs
+}
+
+func (j *Jobs) run(job *Job, repo *Repository) {
+	snap, err := repo.Backup(j.ctx, job.Src)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	job.EndedAt = &now
+	if err != nil {
+		job.Error = err.Error()
+		return
+	}
+	job.Snapshot = &snap
+}
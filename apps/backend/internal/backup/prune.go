@@ -0,0 +1,270 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// maxUnused is the share of a pack that may be unused before Prune
+// rewrites the rest of it into a new pack.
+const maxUnused = 0.1
+
+// Policy says which snapshots of each root Prune keeps: the latest
+// KeepLast, and the latest of each of the last KeepDaily days, KeepWeekly
+// weeks and KeepMonthly months that have a snapshot. The zero policy keeps
+// every snapshot.
+type Policy struct {
+	KeepLast    int `json:"keepLast"`
+	KeepDaily   int `json:"keepDaily"`
+	KeepWeekly  int `json:"keepWeekly"`
+	KeepMonthly int `json:"keepMonthly"`
+}
+
+// keep returns the ids to keep of snaps, which are newest first.
+func (p Policy) keep(snaps []Snapshot) map[string]bool {
+	kept := make(map[string]bool)
+	if p == (Policy{}) {
+		for _, s := range snaps {
+			kept[s.ID] = true
+		}
+		return kept
+	}
+	byRoot := make(map[string][]Snapshot)
+	for _, s := range snaps {
+		byRoot[s.Root] = append(byRoot[s.Root], s)
+	}
+	for _, group := range byRoot {
+		for i := 0; i < p.KeepLast && i < len(group); i++ {
+			kept[group[i].ID] = true
+		}
+		for _, bucket := range []struct {
+			n      int
+			period func(Snapshot) string
+		}{
+			{p.KeepDaily, func(s Snapshot) string { return s.Time.Local().Format("2006-01-02") }},
+			{p.KeepWeekly, func(s Snapshot) string {
+				year, week := s.Time.Local().ISOWeek()
+				return fmt.Sprintf("%d-%d", year, week)
+			}},
+			{p.KeepMonthly, func(s Snapshot) string { return s.Time.Local().Format("2006-01") }},
+		} {
+			seen := make(map[string]bool)
+			for _, s := range group {
+				if len(seen) >= bucket.n {
+					break
+				}
+				if key := bucket.period(s); !seen[key] {
+					seen[key] = true
+					kept[s.ID] = true
+				}
+			}
+		}
+	}
+	return kept
+}
+
+// PruneResult summarizes a prune.
+type PruneResult struct {
+	Forgotten      []string `json:"forgotten"`
+	PacksDeleted   int      `json:"packsDeleted"`
+	PacksRewritten int      `json:"packsRewritten"`
+	BytesFreed     int64    `json:"bytesFreed"`
+}
+
+// Forget removes snapshots by id or unique id prefix. Their data stays
+// until the next Prune.
+func (r *Repository) Forget(ids ...string) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, id := range ids {
+		full, err := r.resolve(id)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(r.dir, "snapshots", full)); err != nil {
+			return fileops.MapError(err)
+		}
+	}
+	return nil
+}
+
+// Prune forgets the snapshots policy does not keep and then frees the
+// space of chunks no snapshot uses: packs without used chunks are deleted,
+// packs that are mostly unused are rewritten, and packs left behind by
+// interrupted backups are removed. The index is consolidated into a single
+// file.
+func (r *Repository) Prune(ctx context.Context, policy Policy) (PruneResult, error) {
+	res := PruneResult{Forgotten: []string{}}
+	unlock, err := r.lock()
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+	if err := r.loadIndex(); err != nil {
+		return res, err
+	}
+
+	snaps, err := r.snapshots()
+	if err != nil {
+		return res, err
+	}
+	kept := policy.keep(snaps)
+	used := make(map[string]bool)
+	for _, s := range snaps {
+		if !kept[s.ID] {
+			if err := os.Remove(filepath.Join(r.dir, "snapshots", s.ID)); err != nil {
+				return res, fileops.MapError(err)
+			}
+			res.Forgotten = append(res.Forgotten, s.ID)
+			continue
+		}
+		for _, n := range s.Nodes {
+			for _, id := range n.Content {
+				used[id] = true
+			}
+		}
+	}
+
+	// Sort the packs into those kept as they are and those to rewrite or
+	// delete.
+	keep := make(map[string]packInfo)
+	var rewrite, remove []string
+	for id, p := range r.packs {
+		var total, unused int64
+		for _, b := range p.Blobs {
+			total += b.Length
+			// A chunk stored twice is used from one pack only.
+			if !used[b.ID] || r.blobs[b.ID].pack != id {
+				unused += b.Length
+			}
+		}
+		switch {
+		case unused == total:
+			remove = append(remove, id)
+		case float64(unused) > maxUnused*float64(total):
+			rewrite = append(rewrite, id)
+		default:
+			keep[id] = p
+		}
+	}
+	sort.Strings(rewrite)
+
+	// Copy the used chunks of rewritten packs before anything is deleted.
+	// The reader keeps finding chunks through the old index.
+	oldPacks := r.packs
+	reader := r.newPackReader()
+	defer reader.close()
+	r.blobs, r.packs = make(map[string]location), make(map[string]packInfo)
+	for _, p := range keep {
+		r.addPack(p)
+	}
+	w := r.newPackWriter()
+	for _, id := range rewrite {
+		for _, b := range oldPacks[id].Blobs {
+			if err := ctx.Err(); err != nil {
+				return res, err
+			}
+			if !used[b.ID] {
+				continue
+			}
+			if _, ok := r.blobs[b.ID]; ok {
+				continue
+			}
+			data, err := reader.blob(b.ID)
+			if err != nil {
+				return res, err
+			}
+			if err := w.add(b.ID, data, b.Compressed); err != nil {
+				return res, err
+			}
+		}
+	}
+	if err := w.flush(); err != nil {
+		return res, err
+	}
+	reader.close()
+	res.PacksRewritten = len(rewrite)
+	res.PacksDeleted = len(remove)
+
+	// Write the consolidated index before removing the old files, so a
+	// crash in between leaves an index that still finds every chunk.
+	if len(rewrite)+len(remove) > 0 || len(r.indexes) > 1 {
+		oldIndexes := r.indexes
+		r.indexes = nil
+		packs := make([]packInfo, 0, len(r.packs))
+		for _, p := range r.packs {
+			packs = append(packs, p)
+		}
+		sort.Slice(packs, func(i, j int) bool { return packs[i].ID < packs[j].ID })
+		newIndex, err := r.writeIndex(packs)
+		if err != nil {
+			return res, err
+		}
+		for _, id := range oldIndexes {
+			if id != newIndex {
+				os.Remove(filepath.Join(r.dir, "index", id))
+			}
+		}
+	}
+	for _, id := range append(rewrite, remove...) {
+		res.BytesFreed += removeFile(r.packPath(id))
+	}
+
+	// Packs without an index entry were left by interrupted backups.
+	orphans, err := r.orphanPacks()
+	if err != nil {
+		return res, err
+	}
+	for _, p := range orphans {
+		res.BytesFreed += removeFile(p)
+		res.PacksDeleted++
+	}
+	// The space of rewritten chunks is used again by the new packs.
+	res.BytesFreed -= w.stored
+	return res, nil
+}
+
+// orphanPacks returns the paths of pack files not in the index.
+func (r *Repository) orphanPacks() ([]string, error) {
+	var orphans []string
+	err := filepath.WalkDir(filepath.Join(r.dir, "data"), func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, ok := r.packs[d.Name()]; !ok {
+			orphans = append(orphans, p)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	return orphans, nil
+}
+
+// removeFile deletes path and returns the bytes it took.
+func removeFile(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	if err := os.Remove(path); err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,653 @@
+// Package backup keeps snapshots of folders in a deduplicating repository
+// on a plain local directory, as an alternative to copying whole files to
+// a backup drive. Files are split into content-defined chunks, each chunk
+// is stored once in a pack file, an index records which pack holds which
+// chunk, and a snapshot is a manifest of the files of a folder with the
+// chunks of their contents. A 1-byte change to a large file only stores
+// the chunk around it again.
+//
+// A repository directory holds:
+//
+//	config          format version, chunker parameters, compression
+//	                and, when encrypted, the sealed key
+//	data/ab/<id>    pack files
+//	index/<id>      where each chunk is, by pack and offset
+//	snapshots/<id>  snapshot manifests
+//	lock            present while an operation runs
+//
+// Files are named by the SHA-256 of their contents and chunks by the
+// SHA-256 of theirs, so damage is detected on reading.
+//
+// A repository created with a password is encrypted: chunks, index files
+// and snapshot manifests, which hold the file names, are sealed with
+// AES-256-GCM under a random master key, chunks are named by a keyed hash,
+// and the master key is stored in the config, sealed with a key derived
+// from the password with Argon2id.
+//
+// A repository created with compression compresses chunks before they are
+// encrypted, except those of media and archive files, which would not get
+// smaller, and always compresses its index and snapshot files. The index
+// records the original and stored size of every chunk.
+package backup
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+var (
+	// ErrNotRepository is returned for directories without a repository.
+	ErrNotRepository = errors.New("not a backup repository")
+	// ErrSnapshotNotFound is returned for unknown snapshot ids.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrLocked is returned while another operation uses the repository.
+	ErrLocked = errors.New("backup repository is in use")
+	// ErrCorrupt is returned when stored data does not match its hash.
+	ErrCorrupt = errors.New("backup repository is damaged")
+)
+
+// version is the repository format written by Init.
+const version = 1
+
+// DefaultPackSize is the size at which pack files are closed.
+const DefaultPackSize = 16 << 20
+
+// staleLock is how long a lock survives its holder; running operations
+// refresh it well within that time.
+const staleLock = 5 * time.Minute
+
+// Options configure a new repository.
+type Options struct {
+	// Chunker defaults to DefaultChunker.
+	Chunker ChunkerParams
+	// PackSize defaults to DefaultPackSize.
+	PackSize int
+	// Password, when set, encrypts the repository.
+	Password string
+	// Compression is CompressionZstd, CompressionGzip or, the default,
+	// CompressionNone.
+	Compression string
+}
+
+type config struct {
+	Version     int           `json:"version"`
+	ID          string        `json:"id"`
+	Created     time.Time     `json:"created"`
+	ChunkerSeed string        `json:"chunkerSeed,omitempty"`
+	Chunker     ChunkerParams `json:"chunker"`
+	PackSize    int           `json:"packSize"`
+	Compression string        `json:"compression,omitempty"`
+	Encryption  *keyHeader    `json:"encryption,omitempty"`
+}
+
+// location is where a chunk is stored.
+type location struct {
+	pack           string
+	offset, length int64
+	size           int64
+	compressed     bool
+}
+
+type blobEntry struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	// Length is the stored length, Size the original one.
+	Length     int64 `json:"length"`
+	Size       int64 `json:"size"`
+	Compressed bool  `json:"compressed,omitempty"`
+}
+
+func (b blobEntry) location(pack string) location {
+	return location{pack: pack, offset: b.Offset, length: b.Length, size: b.Size, compressed: b.Compressed}
+}
+
+type packInfo struct {
+	ID    string      `json:"id"`
+	Blobs []blobEntry `json:"blobs"`
+}
+
+type indexFile struct {
+	Packs []packInfo `json:"packs"`
+}
+
+// Repository is a backup repository. Operations take the repository lock,
+// so only one runs at a time, also across processes sharing the drive.
+type Repository struct {
+	dir  string
+	cfg  config
+	gear *[256]uint64
+	// Set for encrypted repositories.
+	key   masterKey
+	aead  cipher.AEAD
+	idKey []byte
+
+	// Loaded from the index while the lock is held.
+	blobs   map[string]location
+	packs   map[string]packInfo
+	indexes []string
+}
+
+// Init creates a repository in dir, which must be empty or missing.
+func Init(dir string, opts Options) (*Repository, error) {
+	if opts.Chunker == (ChunkerParams{}) {
+		opts.Chunker = DefaultChunker
+	}
+	if err := opts.Chunker.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", fileops.ErrInvalidPath, err)
+	}
+	if opts.PackSize <= 0 {
+		opts.PackSize = DefaultPackSize
+	}
+	if err := validCompression(opts.Compression); err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("%w: %q is not absolute", fileops.ErrInvalidPath, dir)
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%w: %s is not empty", fileops.ErrAlreadyExists, dir)
+	}
+	for _, sub := range []string{"data", "index", "snapshots"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fileops.MapError(err)
+		}
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	cfg := config{
+		Version:     version,
+		ID:          hex.EncodeToString(id),
+		Created:     time.Now().UTC(),
+		Chunker:     opts.Chunker,
+		PackSize:    opts.PackSize,
+		Compression: opts.Compression,
+	}
+	if opts.Password != "" {
+		key, err := newMasterKey()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Encryption, err = seal(key, opts.Password); err != nil {
+			return nil, err
+		}
+	} else {
+		seed := make([]byte, 16)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		cfg.ChunkerSeed = hex.EncodeToString(seed)
+	}
+	if err := writeConfig(dir, cfg); err != nil {
+		return nil, err
+	}
+	return Open(dir, opts.Password)
+}
+
+func writeConfig(dir string, cfg config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Join(dir, "config"), data)
+}
+
+// Open opens the repository in dir. Encrypted repositories need their
+// password; others must be opened without one.
+func Open(dir, password string) (*Repository, error) {
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("%w: %q is not absolute", fileops.ErrInvalidPath, dir)
+	}
+	dir = filepath.Clean(dir)
+	data, err := os.ReadFile(filepath.Join(dir, "config"))
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return nil, fileops.MapError(statErr)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotRepository, dir)
+	}
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrNotRepository, dir, err)
+	}
+	if cfg.Version != version {
+		return nil, fmt.Errorf("%w: %s has unsupported version %d", ErrNotRepository, dir, cfg.Version)
+	}
+	if cfg.Chunker.validate() != nil || cfg.PackSize <= 0 || validCompression(cfg.Compression) != nil {
+		return nil, fmt.Errorf("%w: %s has an invalid config", ErrNotRepository, dir)
+	}
+	r := &Repository{dir: dir, cfg: cfg}
+	if cfg.Encryption == nil {
+		if password != "" {
+			return nil, fmt.Errorf("%w: %s is not encrypted", ErrWrongPassword, dir)
+		}
+		seed, err := hex.DecodeString(cfg.ChunkerSeed)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s has an invalid config", ErrNotRepository, dir)
+		}
+		r.gear = gearTable(seed)
+		return r, nil
+	}
+	key, err := cfg.Encryption.open(password)
+	if err != nil {
+		return nil, err
+	}
+	if r.aead, err = newAEAD(key.Encrypt); err != nil {
+		return nil, err
+	}
+	r.key, r.idKey = key, key.ID
+	r.gear = gearTable(key.ChunkerSeed)
+	return r, nil
+}
+
+// Encrypted reports whether the repository is encrypted.
+func (r *Repository) Encrypted() bool {
+	return r.aead != nil
+}
+
+// ChangePassword seals the master key of an encrypted repository with a
+// new password. The data is not touched.
+func (r *Repository) ChangePassword(password string) error {
+	if r.cfg.Encryption == nil {
+		return fmt.Errorf("%w: %s is not encrypted", ErrWrongPassword, r.dir)
+	}
+	if password == "" {
+		return fmt.Errorf("%w: empty password", ErrWrongPassword)
+	}
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	cfg := r.cfg
+	if cfg.Encryption, err = seal(r.key, password); err != nil {
+		return err
+	}
+	if err := writeConfig(r.dir, cfg); err != nil {
+		return err
+	}
+	r.cfg = cfg
+	return nil
+}
+
+// Dir returns the repository directory.
+func (r *Repository) Dir() string {
+	return r.dir
+}
+
+// lock takes the repository lock, replacing one left behind by a crash,
+// and keeps it fresh until the returned function releases it. The lock
+// file holds a token unique to this holder, so a stale lock is only
+// broken by the one process that moved it aside, and a holder whose lock
+// was broken never refreshes or removes its successor's.
+func (r *Repository) lock() (func(), error) {
+	path := filepath.Join(r.dir, "lock")
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	token := fmt.Sprintf("%s %d %s\n", host, os.Getpid(), hex.EncodeToString(nonce))
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			_, err = f.WriteString(token)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fileops.MapError(err)
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fileops.MapError(err)
+		}
+		if attempt > 0 || !breakStaleLock(path) {
+			return nil, ErrLocked
+		}
+	}
+	owned := func() bool {
+		b, err := os.ReadFile(path)
+		return err == nil && string(b) == token
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(staleLock / 5)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if owned() {
+					os.Chtimes(path, now, now)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		if owned() {
+			os.Remove(path)
+		}
+	}, nil
+}
+
+// breakStaleLock removes the lock at path if it is stale. The lock is
+// renamed to a name of its own first, which only one of several
+// processes breaking it at once can do, and then checked again: if what
+// was moved aside is not the stale lock that was seen, because it was
+// refreshed or replaced meanwhile, it is put back and false returned.
+func breakStaleLock(path string) bool {
+	seen, err := os.ReadFile(path)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) < staleLock {
+		return false
+	}
+	aside := fmt.Sprintf("%s.stale-%d-%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, aside); err != nil {
+		return false
+	}
+	moved, err := os.ReadFile(aside)
+	info, statErr := os.Stat(aside)
+	if err != nil || statErr != nil || string(moved) != string(seen) || time.Since(info.ModTime()) < staleLock {
+		// A live lock: give it back unless a new one took its place, in
+		// which case its holder finds out it lost the lock.
+		os.Link(aside, path)
+		os.Remove(aside)
+		return false
+	}
+	os.Remove(aside)
+	return true
+}
+
+// loadIndex reads all index files. It is called with the lock held, since
+// other processes may have changed the index since Open.
+func (r *Repository) loadIndex() error {
+	r.blobs = make(map[string]location)
+	r.packs = make(map[string]packInfo)
+	r.indexes = nil
+	ids, err := r.list("index")
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		data, err := r.loadFile("index", id)
+		if err != nil {
+			return err
+		}
+		var idx indexFile
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return fmt.Errorf("%w: index %s: %v", ErrCorrupt, id, err)
+		}
+		for _, p := range idx.Packs {
+			r.addPack(p)
+		}
+		r.indexes = append(r.indexes, id)
+	}
+	return nil
+}
+
+func (r *Repository) addPack(p packInfo) {
+	r.packs[p.ID] = p
+	for _, b := range p.Blobs {
+		r.blobs[b.ID] = b.location(p.ID)
+	}
+}
+
+// writeIndex stores an index file listing packs.
+func (r *Repository) writeIndex(packs []packInfo) (string, error) {
+	data, err := json.Marshal(indexFile{Packs: packs})
+	if err != nil {
+		return "", err
+	}
+	id, err := r.saveFile("index", data)
+	if err == nil {
+		r.indexes = append(r.indexes, id)
+	}
+	return id, err
+}
+
+// saveFile stores data under dir named by the hash of what is stored.
+func (r *Repository) saveFile(dir string, data []byte) (string, error) {
+	stored, err := r.encode(r.compress(data), dir)
+	if err != nil {
+		return "", err
+	}
+	id := hashOf(stored)
+	return id, writeAtomic(filepath.Join(r.dir, dir, id), stored)
+}
+
+// loadFile reads the file id under dir and checks it against its name.
+func (r *Repository) loadFile(dir, id string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, dir, id))
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	if hashOf(data) != id {
+		return nil, fmt.Errorf("%w: %s/%s does not match its hash", ErrCorrupt, dir, id)
+	}
+	if data, err = r.decode(data, dir); err != nil || r.cfg.Compression == CompressionNone {
+		return data, err
+	}
+	return r.decompress(data, -1)
+}
+
+// list returns the names of the files under dir.
+func (r *Repository) list(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, dir))
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() && len(e.Name()) == sha256.Size*2 {
+			ids = append(ids, e.Name())
+		}
+	}
+	return ids, nil
+}
+
+func (r *Repository) packPath(id string) string {
+	return filepath.Join(r.dir, "data", id[:2], id)
+}
+
+// packWriter collects new chunks into pack files.
+type packWriter struct {
+	r       *Repository
+	buf     bytes.Buffer
+	blobs   []blobEntry
+	pending map[string]bool
+	// packs lists the packs written so far.
+	packs []packInfo
+	// added and stored count the bytes of new chunks before and after
+	// compression and encryption.
+	added, stored int64
+}
+
+func (r *Repository) newPackWriter() *packWriter {
+	return &packWriter{r: r, pending: make(map[string]bool)}
+}
+
+// add stores a chunk unless the repository already has it, compressing it
+// when compress is set and that makes it smaller.
+func (w *packWriter) add(id string, data []byte, compress bool) error {
+	if _, ok := w.r.blobs[id]; ok || w.pending[id] {
+		return nil
+	}
+	entry := blobEntry{ID: id, Offset: int64(w.buf.Len()), Size: int64(len(data))}
+	payload := data
+	if compress && w.r.cfg.Compression != CompressionNone {
+		if c := w.r.compress(data); len(c) < len(data) {
+			payload, entry.Compressed = c, true
+		}
+	}
+	stored, err := w.r.encode(payload, id)
+	if err != nil {
+		return err
+	}
+	entry.Length = int64(len(stored))
+	w.blobs = append(w.blobs, entry)
+	w.buf.Write(stored)
+	w.pending[id] = true
+	w.added += entry.Size
+	w.stored += entry.Length
+	if w.buf.Len() >= w.r.cfg.PackSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the current pack, if any.
+func (w *packWriter) flush() error {
+	if len(w.blobs) == 0 {
+		return nil
+	}
+	data := w.buf.Bytes()
+	id := hashOf(data)
+	path := w.r.packPath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fileops.MapError(err)
+	}
+	if err := writeAtomic(path, data); err != nil {
+		return err
+	}
+	p := packInfo{ID: id, Blobs: w.blobs}
+	w.r.addPack(p)
+	w.packs = append(w.packs, p)
+	w.buf.Reset()
+	w.blobs = nil
+	w.pending = make(map[string]bool)
+	return nil
+}
+
+// maxOpenPacks bounds the pack files a packReader keeps open.
+const maxOpenPacks = 32
+
+// packReader reads chunks, keeping recently used pack files open until
+// closed.
+type packReader struct {
+	r     *Repository
+	blobs map[string]location
+	files map[string]*os.File
+}
+
+// newPackReader returns a reader finding chunks through the index as it
+// is now.
+func (r *Repository) newPackReader() *packReader {
+	return &packReader{r: r, blobs: r.blobs, files: make(map[string]*os.File)}
+}
+
+// blob returns the chunk id, checked against its hash.
+func (p *packReader) blob(id string) ([]byte, error) {
+	loc, ok := p.blobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: chunk %s is missing", ErrCorrupt, id)
+	}
+	f, ok := p.files[loc.pack]
+	if !ok {
+		if len(p.files) >= maxOpenPacks {
+			p.close()
+		}
+		var err error
+		if f, err = os.Open(p.r.packPath(loc.pack)); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("%w: pack %s is missing", ErrCorrupt, loc.pack)
+			}
+			return nil, fileops.MapError(err)
+		}
+		p.files[loc.pack] = f
+	}
+	stored := make([]byte, loc.length)
+	if _, err := f.ReadAt(stored, loc.offset); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: pack %s is truncated", ErrCorrupt, loc.pack)
+		}
+		return nil, err
+	}
+	return p.r.openBlob(id, loc, stored)
+}
+
+// openBlob decodes a stored chunk and checks it against its id.
+func (r *Repository) openBlob(id string, loc location, stored []byte) ([]byte, error) {
+	data, err := r.decode(stored, id)
+	if err != nil {
+		return nil, err
+	}
+	if loc.compressed {
+		if data, err = r.decompress(data, loc.size); err != nil {
+			return nil, fmt.Errorf("chunk %s in pack %s: %w", shortID(id), shortID(loc.pack), err)
+		}
+	}
+	if r.blobID(data) != id {
+		return nil, fmt.Errorf("%w: chunk %s in pack %s does not match its hash", ErrCorrupt, shortID(id), shortID(loc.pack))
+	}
+	return data, nil
+}
+
+func (p *packReader) close() {
+	for id, f := range p.files {
+		f.Close()
+		delete(p.files, id)
+	}
+}
+
+func hashOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeAtomic writes data to path through a synced temporary file, so a
+// crash never leaves a partial file under the final name.
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fileops.MapError(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// shortID is the prefix of an id shown in messages.
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
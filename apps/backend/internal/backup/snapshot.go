@@ -0,0 +1,604 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// Node types in a snapshot.
+const (
+	NodeFile    = "file"
+	NodeDir     = "dir"
+	NodeSymlink = "symlink"
+)
+
+// Node is one file, folder or symbolic link of a snapshot.
+type Node struct {
+	// Path is relative to the snapshot root, with forward slashes.
+	Path    string      `json:"path"`
+	Type    string      `json:"type"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	// Content lists the chunks of a file in order.
+	Content []string `json:"content,omitempty"`
+	// Target is the destination of a symbolic link.
+	Target string `json:"target,omitempty"`
+}
+
+// Snapshot is the state of a folder at one point in time.
+type Snapshot struct {
+	ID       string    `json:"id,omitempty"`
+	Time     time.Time `json:"time"`
+	Root     string    `json:"root"`
+	Hostname string    `json:"hostname"`
+	// Parent is the earlier snapshot of the same root whose unchanged
+	// files were taken over without reading them.
+	Parent string `json:"parent,omitempty"`
+	Files  int    `json:"files"`
+	Dirs   int    `json:"dirs"`
+	Size   int64  `json:"size"`
+	// Added counts the bytes of chunks new to the repository, and Stored
+	// what they take in it after compression.
+	Added  int64 `json:"added"`
+	Stored int64 `json:"stored"`
+	// Skipped lists paths that could not be read.
+	Skipped []string `json:"skipped,omitempty"`
+	// Nodes is left out of listings.
+	Nodes []Node `json:"nodes,omitempty"`
+}
+
+// Backup stores a snapshot of the folder root. Unchanged files, by size
+// and modification time against the latest snapshot of the same root, are
+// not read again. Files that cannot be read are skipped and listed in the
+// snapshot. The snapshot is returned without its nodes.
+func (r *Repository) Backup(ctx context.Context, root string) (Snapshot, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("%w: %v", fileops.ErrInvalidPath, err)
+	}
+	if info, err := os.Stat(root); err != nil {
+		return Snapshot{}, fileops.MapError(err)
+	} else if !info.IsDir() {
+		return Snapshot{}, fmt.Errorf("%w: %s is not a folder", fileops.ErrInvalidPath, root)
+	}
+	unlock, err := r.lock()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer unlock()
+	if err := r.loadIndex(); err != nil {
+		return Snapshot{}, err
+	}
+
+	snap := Snapshot{Time: time.Now().UTC(), Root: root, Nodes: []Node{}}
+	snap.Hostname, _ = os.Hostname()
+	previous := make(map[string]Node)
+	if parent, err := r.latest(root); err != nil {
+		return Snapshot{}, err
+	} else if parent != nil {
+		snap.Parent = parent.ID
+		for _, n := range parent.Nodes {
+			previous[n.Path] = n
+		}
+	}
+
+	w := r.newPackWriter()
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if p == root {
+				return fileops.MapError(err)
+			}
+			log.Printf("backup: %s: %v", p, err)
+			snap.Skipped = append(snap.Skipped, p)
+			return nil
+		}
+		if p == root {
+			return nil
+		}
+		// A repository inside the folder must not back itself up.
+		if p == r.dir {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			snap.Skipped = append(snap.Skipped, p)
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		n := Node{Path: filepath.ToSlash(rel), Mode: info.Mode(), ModTime: info.ModTime().UTC()}
+		switch {
+		case info.IsDir():
+			n.Type = NodeDir
+			snap.Dirs++
+		case info.Mode()&fs.ModeSymlink != 0:
+			n.Type = NodeSymlink
+			if n.Target, err = os.Readlink(p); err != nil {
+				snap.Skipped = append(snap.Skipped, p)
+				return nil
+			}
+		case info.Mode().IsRegular():
+			n.Type, n.Size = NodeFile, info.Size()
+			if old, ok := previous[n.Path]; ok && r.unchanged(old, n) {
+				n.Content = old.Content
+			} else if n.Content, n.Size, err = r.saveContent(w, p); err != nil {
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					return err
+				}
+				log.Printf("backup: %s: %v", p, err)
+				snap.Skipped = append(snap.Skipped, p)
+				return nil
+			}
+			snap.Files++
+			snap.Size += n.Size
+		default:
+			// Sockets, devices and pipes are not backed up.
+			return nil
+		}
+		snap.Nodes = append(snap.Nodes, n)
+		return nil
+	})
+	// Chunks stored so far are kept even when the backup fails, so a
+	// retry does not store them again.
+	if flushErr := w.flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	if len(w.packs) > 0 {
+		if _, indexErr := r.writeIndex(w.packs); indexErr != nil && err == nil {
+			err = indexErr
+		}
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snap.Added, snap.Stored = w.added, w.stored
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if snap.ID, err = r.saveFile("snapshots", data); err != nil {
+		return Snapshot{}, err
+	}
+	snap.Nodes = nil
+	return snap, nil
+}
+
+// unchanged reports whether the file n can take over the chunks of old.
+func (r *Repository) unchanged(old, n Node) bool {
+	if old.Type != NodeFile || old.Size != n.Size || !old.ModTime.Equal(n.ModTime) {
+		return false
+	}
+	for _, id := range old.Content {
+		if _, ok := r.blobs[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// saveContent chunks the file at p and stores new chunks, compressed
+// unless the file's type is already compressed.
+func (r *Repository) saveContent(w *packWriter, p string) ([]string, int64, error) {
+	compress := false
+	if r.cfg.Compression != CompressionNone {
+		mimeType, err := fileops.DetectMimeType(p)
+		compress = err != nil || compressible(mimeType)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	content := []string{}
+	var size int64
+	c := NewChunker(f, r.gear, r.cfg.Chunker)
+	for {
+		chunk, err := c.Next()
+		if err != nil {
+			if err == io.EOF {
+				return content, size, nil
+			}
+			return nil, 0, err
+		}
+		id := r.blobID(chunk)
+		if err := w.add(id, chunk, compress); err != nil {
+			return nil, 0, err
+		}
+		content = append(content, id)
+		size += int64(len(chunk))
+	}
+}
+
+// Snapshots returns the snapshots in the repository, newest first, without
+// their nodes.
+func (r *Repository) Snapshots() ([]Snapshot, error) {
+	all, err := r.snapshots()
+	if err != nil {
+		return nil, err
+	}
+	for i := range all {
+		all[i].Nodes = nil
+	}
+	return all, nil
+}
+
+// Stats shows the space a repository saves.
+type Stats struct {
+	Snapshots   int    `json:"snapshots"`
+	Chunks      int    `json:"chunks"`
+	Encrypted   bool   `json:"encrypted"`
+	Compression string `json:"compression,omitempty"`
+	// TotalSize adds up the files of all snapshots, what copying each of
+	// them in full would take.
+	TotalSize int64 `json:"totalSize"`
+	// UniqueSize is the size of the distinct chunks, after deduplication.
+	UniqueSize int64 `json:"uniqueSize"`
+	// StoredSize is what the chunks take in the pack files, after
+	// compression.
+	StoredSize int64 `json:"storedSize"`
+	// CompressedSize is the original size of the compressed chunks and
+	// CompressedSaved what compression saved on them.
+	CompressedSize  int64 `json:"compressedSize"`
+	CompressedSaved int64 `json:"compressedSaved"`
+}
+
+// Stats returns the sizes of the repository's contents at each stage.
+func (r *Repository) Stats() (Stats, error) {
+	st := Stats{Encrypted: r.Encrypted(), Compression: r.cfg.Compression}
+	unlock, err := r.lock()
+	if err != nil {
+		return st, err
+	}
+	defer unlock()
+	if err := r.loadIndex(); err != nil {
+		return st, err
+	}
+	snaps, err := r.snapshots()
+	if err != nil {
+		return st, err
+	}
+	st.Snapshots = len(snaps)
+	for _, s := range snaps {
+		st.TotalSize += s.Size
+	}
+	st.Chunks = len(r.blobs)
+	for _, loc := range r.blobs {
+		st.UniqueSize += loc.size
+		st.StoredSize += loc.length
+		if loc.compressed {
+			st.CompressedSize += loc.size
+			st.CompressedSaved += loc.size - loc.length
+		}
+	}
+	return st, nil
+}
+
+// snapshots loads all snapshots, newest first.
+func (r *Repository) snapshots() ([]Snapshot, error) {
+	ids, err := r.list("snapshots")
+	if err != nil {
+		return nil, err
+	}
+	snaps := []Snapshot{}
+	for _, id := range ids {
+		s, err := r.load(id)
+		if errors.Is(err, fileops.ErrPathNotFound) {
+			// Forgotten in the meantime.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, s)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Time.After(snaps[j].Time) })
+	return snaps, nil
+}
+
+// Snapshot returns the snapshot whose id is or starts with id, with its
+// nodes.
+func (r *Repository) Snapshot(id string) (Snapshot, error) {
+	full, err := r.resolve(id)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return r.load(full)
+}
+
+// resolve expands a unique id prefix.
+func (r *Repository) resolve(prefix string) (string, error) {
+	if prefix == "" {
+		return "", ErrSnapshotNotFound
+	}
+	ids, err := r.list("snapshots")
+	if err != nil {
+		return "", err
+	}
+	var match string
+	for _, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			if match != "" {
+				return "", fmt.Errorf("%w: %q is ambiguous", ErrSnapshotNotFound, prefix)
+			}
+			match = id
+		}
+	}
+	if match == "" {
+		return "", fmt.Errorf("%w: %s", ErrSnapshotNotFound, prefix)
+	}
+	return match, nil
+}
+
+func (r *Repository) load(id string) (Snapshot, error) {
+	data, err := r.loadFile("snapshots", id)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Snapshot{}, fmt.Errorf("%w: snapshot %s: %v", ErrCorrupt, shortID(id), err)
+	}
+	s.ID = id
+	return s, nil
+}
+
+// latest returns the newest snapshot of root, or nil.
+func (r *Repository) latest(root string) (*Snapshot, error) {
+	all, err := r.snapshots()
+	if err != nil {
+		return nil, err
+	}
+	for i := range all {
+		if all[i].Root == root {
+			return &all[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// RestoreResult summarizes a restore.
+type RestoreResult struct {
+	Files int   `json:"files"`
+	Dirs  int   `json:"dirs"`
+	Size  int64 `json:"size"`
+}
+
+// Restore writes the snapshot id, or only the given paths of it and
+// everything below them, into the folder target. Existing files are never
+// overwritten; restoring onto one fails with fileops.ErrAlreadyExists.
+// Every chunk is checked against its hash as it is read.
+func (r *Repository) Restore(ctx context.Context, id, target string, paths []string) (RestoreResult, error) {
+	var res RestoreResult
+	if !filepath.IsAbs(target) {
+		return res, fmt.Errorf("%w: %q is not absolute", fileops.ErrInvalidPath, target)
+	}
+	unlock, err := r.lock()
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+	if err := r.loadIndex(); err != nil {
+		return res, err
+	}
+	snap, err := r.Snapshot(id)
+	if err != nil {
+		return res, err
+	}
+	selection := make([]string, len(paths))
+	for i, p := range paths {
+		selection[i] = strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/")
+	}
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return res, fileops.MapError(err)
+	}
+
+	reader := r.newPackReader()
+	defer reader.close()
+	var dirs []Node
+	for _, n := range snap.Nodes {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if !selected(n.Path, selection) {
+			continue
+		}
+		if !fs.ValidPath(n.Path) {
+			return res, fmt.Errorf("%w: snapshot %s has an invalid path %q", ErrCorrupt, shortID(snap.ID), n.Path)
+		}
+		dst := filepath.Join(target, filepath.FromSlash(n.Path))
+		switch n.Type {
+		case NodeDir:
+			if err := os.MkdirAll(dst, 0o700); err != nil {
+				return res, fileops.MapError(err)
+			}
+			dirs = append(dirs, n)
+			res.Dirs++
+		case NodeSymlink:
+			if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+				return res, fileops.MapError(err)
+			}
+			if err := os.Symlink(n.Target, dst); err != nil {
+				return res, restoreError(dst, err)
+			}
+		case NodeFile:
+			if err := restoreFile(reader, n, dst); err != nil {
+				return res, err
+			}
+			res.Files++
+			res.Size += n.Size
+		}
+	}
+	// Folders get their mode and time last, after their contents are
+	// written; deepest first, so parents are not touched again.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dst := filepath.Join(target, filepath.FromSlash(dirs[i].Path))
+		os.Chmod(dst, dirs[i].Mode.Perm())
+		os.Chtimes(dst, dirs[i].ModTime, dirs[i].ModTime)
+	}
+	return res, nil
+}
+
+func restoreFile(reader *packReader, n Node, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fileops.MapError(err)
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return restoreError(dst, err)
+	}
+	var written int64
+	for _, id := range n.Content {
+		data, err := reader.blob(id)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: %w", n.Path, err)
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		written += int64(len(data))
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if written != n.Size {
+		return fmt.Errorf("%w: %s restored %d of %d bytes", ErrCorrupt, n.Path, written, n.Size)
+	}
+	os.Chmod(dst, n.Mode.Perm())
+	return os.Chtimes(dst, n.ModTime, n.ModTime)
+}
+
+func restoreError(dst string, err error) error {
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: %s", fileops.ErrAlreadyExists, dst)
+	}
+	return fileops.MapError(err)
+}
+
+// selected reports whether the node at p is in one of paths or below it.
+// No paths selects everything.
+func selected(p string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, sel := range paths {
+		if sel == "" || p == sel || strings.HasPrefix(p, sel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckResult lists the problems found by Check.
+type CheckResult struct {
+	Snapshots int      `json:"snapshots"`
+	Packs     int      `json:"packs"`
+	Chunks    int      `json:"chunks"`
+	Errors    []string `json:"errors"`
+}
+
+// Check verifies that every chunk of every snapshot is in the index and
+// that every pack in the index exists. With readData it also reads all
+// packs and checks each chunk against its hash. Problems are listed in the
+// result; the error is only for failures to run the check.
+func (r *Repository) Check(ctx context.Context, readData bool) (CheckResult, error) {
+	res := CheckResult{Errors: []string{}}
+	unlock, err := r.lock()
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+	if err := r.loadIndex(); err != nil {
+		return res, err
+	}
+	res.Packs, res.Chunks = len(r.packs), len(r.blobs)
+
+	for id, p := range r.packs {
+		info, err := os.Stat(r.packPath(id))
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("pack %s: %v", shortID(id), fileops.MapError(err)))
+			continue
+		}
+		for _, b := range p.Blobs {
+			if b.Offset+b.Length > info.Size() {
+				res.Errors = append(res.Errors, fmt.Sprintf("pack %s is truncated", shortID(id)))
+				break
+			}
+		}
+	}
+
+	ids, err := r.list("snapshots")
+	if err != nil {
+		return res, err
+	}
+	for _, id := range ids {
+		snap, err := r.load(id)
+		if err != nil {
+			res.Errors = append(res.Errors, err.Error())
+			continue
+		}
+		res.Snapshots++
+		missing := 0
+		for _, n := range snap.Nodes {
+			for _, c := range n.Content {
+				if _, ok := r.blobs[c]; !ok {
+					missing++
+				}
+			}
+		}
+		if missing > 0 {
+			res.Errors = append(res.Errors, fmt.Sprintf("snapshot %s: %d chunks missing from the index", shortID(id), missing))
+		}
+	}
+
+	if readData {
+		for id, p := range r.packs {
+			if err := ctx.Err(); err != nil {
+				return res, err
+			}
+			res.Errors = append(res.Errors, r.checkPack(id, p)...)
+		}
+	}
+	sort.Strings(res.Errors)
+	return res, nil
+}
+
+// checkPack reads a pack and checks it and its chunks against their
+// hashes.
+func (r *Repository) checkPack(id string, p packInfo) []string {
+	data, err := os.ReadFile(r.packPath(id))
+	if err != nil {
+		// Reported above.
+		return nil
+	}
+	var errs []string
+	if hashOf(data) != id {
+		errs = append(errs, fmt.Sprintf("pack %s does not match its hash", shortID(id)))
+	}
+	for _, b := range p.Blobs {
+		if b.Offset+b.Length > int64(len(data)) {
+			break
+		}
+		if _, err := r.openBlob(b.ID, b.location(id), data[b.Offset:b.Offset+b.Length]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	return errs
+}
@@ -0,0 +1,116 @@
+package catalog
+
+import (
+	"database/sql"
+	"path/filepath"
+
+	"file-manager-backend/internal/backup"
+	"file-manager-backend/internal/fileops"
+)
+
+// Store records per-file metadata in the files table. It implements
+// fileops.MetaStore, so hashes and sniffed MIME types survive restarts and
+// are found again by inode after a file is renamed.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore returns a store backed by db.
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// LoadMeta returns the metadata recorded for a file with the given identity.
+func (s *Store) LoadMeta(key fileops.MetaKey) (fileops.FileMeta, bool, error) {
+	var hash, mimeType sql.NullString
+	err := s.db.QueryRow(`SELECT hash, mime_type FROM files
+		WHERE device = ? AND inode = ? AND size = ? AND mod_time = ?
+		ORDER BY updated_at DESC LIMIT 1`,
+		int64(key.Device), int64(key.Inode), key.Size, key.ModTime).Scan(&hash, &mimeType)
+	if err == sql.ErrNoRows {
+		return fileops.FileMeta{}, false, nil
+	}
+	if err != nil {
+		return fileops.FileMeta{}, false, err
+	}
+	meta := fileops.FileMeta{
+		Hash:        hash.String,
+		Detected:    mimeType.String,
+		HasDetected: mimeType.Valid,
+	}
+	return meta, true, nil
+}
+
+// SaveMeta records meta for path, replacing what was stored for it before.
+func (s *Store) SaveMeta(path string, key fileops.MetaKey, meta fileops.FileMeta) error {
+	hash := sql.NullString{String: meta.Hash, Valid: meta.Hash != ""}
+	mimeType := sql.NullString{String: meta.Detected, Valid: meta.HasDetected}
+	res, err := s.db.Exec(`UPDATE files SET size = ?, hash = ?, mime_type = ?, device = ?, inode = ?,
+		mod_time = ?, updated_at = CURRENT_TIMESTAMP WHERE path = ?`,
+		key.Size, hash, mimeType, int64(key.Device), int64(key.Inode), key.ModTime, path)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = s.db.Exec(`INSERT INTO files (path, name, size, hash, mime_type, device, inode, mod_time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		path, filepath.Base(path), key.Size, hash, mimeType, int64(key.Device), int64(key.Inode), key.ModTime)
+	return err
+}
+
+// PathsWithHash returns the recorded paths whose contents had the given
+// hash when last seen. Callers should verify a path before relying on it,
+// since files may have changed or gone since.
+func (s *Store) PathsWithHash(hash string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT path FROM files WHERE hash = ? ORDER BY path`, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// RecordStored records a file stored in a sync destination, replacing what
+// was recorded for its path there before. It implements backup.Catalog.
+func (s *Store) RecordStored(f backup.StoredFile) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO destination_files
+		(destination, path, hash, size, stored_size, compression, encrypted)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		f.Destination, f.Path, f.Hash, f.Size, f.StoredSize, f.Compression, f.Encrypted)
+	return err
+}
+
+// DestinationStats sum up the files recorded for a sync destination.
+type DestinationStats struct {
+	Files int `json:"files"`
+	// Size is the original size of the files and StoredSize what they
+	// take on the destination; Saved is the difference.
+	Size       int64 `json:"size"`
+	StoredSize int64 `json:"storedSize"`
+	Saved      int64 `json:"saved"`
+	// CompressedFiles counts the files stored compressed, and
+	// CompressedSize is their original size.
+	CompressedFiles int   `json:"compressedFiles"`
+	CompressedSize  int64 `json:"compressedSize"`
+}
+
+// DestinationStats returns the totals recorded for the destination dir.
+func (s *Store) DestinationStats(dir string) (DestinationStats, error) {
+	var st DestinationStats
+	err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(stored_size), 0),
+		COALESCE(SUM(compression != ''), 0), COALESCE(SUM(CASE WHEN compression != '' THEN size END), 0)
+		FROM destination_files WHERE destination = ?`, dir).
+		Scan(&st.Files, &st.Size, &st.StoredSize, &st.CompressedFiles, &st.CompressedSize)
+	st.Saved = st.Size - st.StoredSize
+	return st, err
+}
@@ -0,0 +1,112 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"file-manager-backend/internal/backup"
+	"file-manager-backend/internal/db/dbtest"
+	"file-manager-backend/internal/fileops"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	conn := dbtest.Open(t)
+	return NewStore(conn)
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	s := newTestStore(t)
+	key := fileops.MetaKey{Device: 1, Inode: 42, Size: 10, ModTime: 1000}
+
+	if _, ok, err := s.LoadMeta(key); err != nil || ok {
+		t.Fatalf("LoadMeta on empty store = %v, %v", ok, err)
+	}
+
+	if err := s.SaveMeta("/a/b.txt", key, fileops.FileMeta{Detected: "text/plain", HasDetected: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveMeta("/a/b.txt", key, fileops.FileMeta{Detected: "text/plain", HasDetected: true, Hash: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	meta, ok, err := s.LoadMeta(key)
+	if err != nil || !ok {
+		t.Fatalf("LoadMeta = %v, %v", ok, err)
+	}
+	if meta.Hash != "abc" || !meta.HasDetected || meta.Detected != "text/plain" {
+		t.Errorf("LoadMeta = %+v", meta)
+	}
+
+	var rows int
+	s.db.QueryRow("SELECT COUNT(*) FROM files").Scan(&rows)
+	if rows != 1 {
+		t.Errorf("files has %d rows, want 1", rows)
+	}
+
+	paths, err := s.PathsWithHash("abc")
+	if err != nil || len(paths) != 1 || paths[0] != "/a/b.txt" {
+		t.Errorf("PathsWithHash = %v, %v", paths, err)
+	}
+
+	changed := key
+	changed.ModTime++
+	if _, ok, _ := s.LoadMeta(changed); ok {
+		t.Error("LoadMeta matched a file with a different mtime")
+	}
+}
+
+func TestCacheUsesStoreAcrossInstances(t *testing.T) {
+	s := newTestStore(t)
+	path := filepath.Join(t.TempDir(), "f.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fileops.MetaKeyOf(info); !ok {
+		t.Skip("no inode numbers on this platform")
+	}
+
+	first := fileops.NewMetaCache(10, s)
+	want, err := first.Hash(path, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := fileops.NewMetaCache(10, s)
+	got, err := second.Hash(path, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Hash = %q, want %q", got, want)
+	}
+	if st := second.Stats(); st.StoreHits != 1 || st.Misses != 0 {
+		t.Errorf("Stats = %+v, want one store hit", st)
+	}
+}
+
+func TestDestinationStats(t *testing.T) {
+	s := newTestStore(t)
+	for _, f := range []backup.StoredFile{
+		{Destination: "/nas", Path: "a.txt", Hash: "a", Size: 1000, StoredSize: 300, Compression: "zstd"},
+		{Destination: "/nas", Path: "b.jpg", Hash: "b", Size: 500, StoredSize: 500},
+		{Destination: "/nas", Path: "a.txt", Hash: "a2", Size: 2000, StoredSize: 400, Compression: "zstd"},
+		{Destination: "/other", Path: "c.txt", Hash: "c", Size: 10, StoredSize: 10},
+	} {
+		if err := s.RecordStored(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st, err := s.DestinationStats("/nas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DestinationStats{Files: 2, Size: 2500, StoredSize: 900, Saved: 1600, CompressedFiles: 1, CompressedSize: 2000}
+	if st != want {
+		t.Errorf("DestinationStats = %+v, want %+v", st, want)
+	}
+}
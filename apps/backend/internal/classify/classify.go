@@ -0,0 +1,358 @@
+// Package classify assigns classes such as "invoice" or "photo" to files.
+// Classifiers are pluggable; the pipeline runs them, stores their tags in
+// SQLite with a confidence and the name of the classifier that produced
+// them, and works through new catalog entries in the background.
+package classify
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"file-manager-backend/internal/extract"
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/media"
+)
+
+// Tag is one class assigned to a file.
+type Tag struct {
+	Name string `json:"name"`
+	// Confidence is between 0 and 1.
+	Confidence float64 `json:"confidence"`
+	// Source names the classifier that assigned the tag.
+	Source string `json:"source"`
+}
+
+// Classifier assigns tags to a file. Classifiers must be safe for
+// concurrent use; they return no tags, rather than an error, for files
+// they have nothing to say about.
+type Classifier interface {
+	// Name identifies the classifier in stored tags. Changing the set of
+	// names makes the pipeline classify files again.
+	Name() string
+	Classify(f *File) ([]Tag, error)
+}
+
+// File is what classifiers look at. Content-derived attributes are
+// computed on first use and shared between classifiers.
+type File struct {
+	Path string
+	Info fs.FileInfo
+	Hash string
+
+	mimeOnce sync.Once
+	mime     fileops.MimeDetection
+
+	mediaOnce sync.Once
+	media     *media.Metadata
+
+	textOnce sync.Once
+	text     string
+}
+
+// Mime returns the file's MIME detection result.
+func (f *File) Mime() fileops.MimeDetection {
+	f.mimeOnce.Do(func() {
+		f.mime, _ = fileops.DetectMime(f.Path)
+	})
+	return f.mime
+}
+
+// Media returns the file's photo, audio or video metadata, or nil.
+func (f *File) Media() *media.Metadata {
+	f.mediaOnce.Do(func() {
+		if media.Supported(f.Path) {
+			f.media = fileops.LookupMedia(f.Path)
+		}
+	})
+	return f.media
+}
+
+// Text returns the extracted text of a document, or "" for other files.
+func (f *File) Text() string {
+	f.textOnce.Do(func() {
+		if extract.Supported(f.Path) {
+			f.text, _ = extract.Text(f.Path)
+		}
+	})
+	return f.text
+}
+
+// Pipeline runs classifiers and stores their tags.
+type Pipeline struct {
+	db          *sql.DB
+	classifiers []Classifier
+	signature   string
+
+	mu        sync.Mutex
+	processed int
+	failed    int
+}
+
+// New returns a pipeline running classifiers, storing tags in db.
+func New(db *sql.DB, classifiers ...Classifier) *Pipeline {
+	names := make([]string, len(classifiers))
+	for i, c := range classifiers {
+		names[i] = c.Name()
+	}
+	sort.Strings(names)
+	return &Pipeline{db: db, classifiers: classifiers, signature: strings.Join(names, ",")}
+}
+
+// Classify runs every classifier on the file at path and replaces its
+// stored tags. Tags with the same name and source are merged, keeping the
+// highest confidence.
+func (p *Pipeline) Classify(path string) ([]Tag, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	if info.IsDir() {
+		return nil, fileops.ErrInvalidPath
+	}
+	hash, err := fileops.FileHash(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	tags := p.run(&File{Path: path, Info: info, Hash: hash})
+	if err := p.save(path, hash, tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// run collects the tags of every classifier. A failing classifier is
+// logged and skipped so the others still count.
+func (p *Pipeline) run(f *File) []Tag {
+	best := make(map[[2]string]Tag)
+	for _, c := range p.classifiers {
+		tags, err := c.Classify(f)
+		if err != nil {
+			log.Printf("classify: %s on %s: %v", c.Name(), f.Path, err)
+			continue
+		}
+		for _, t := range tags {
+			t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+			t.Source = c.Name()
+			if t.Name == "" || t.Confidence <= 0 {
+				continue
+			}
+			if t.Confidence > 1 {
+				t.Confidence = 1
+			}
+			key := [2]string{t.Name, t.Source}
+			if prev, ok := best[key]; !ok || t.Confidence > prev.Confidence {
+				best[key] = t
+			}
+		}
+	}
+	tags := make([]Tag, 0, len(best))
+	for _, t := range best {
+		tags = append(tags, t)
+	}
+	sortTags(tags)
+	return tags
+}
+
+// Tags returns the stored tags of the file at path, classifying it first
+// when it is new or has changed since.
+func (p *Pipeline) Tags(path string) ([]Tag, error) {
+	hash, err := fileops.FileHash(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	var done int
+	err = p.db.QueryRow(`SELECT COUNT(*) FROM classified_files WHERE path = ? AND hash = ? AND classifiers = ?`,
+		path, hash, p.signature).Scan(&done)
+	if err != nil {
+		return nil, err
+	}
+	if done == 0 {
+		return p.Classify(path)
+	}
+
+	rows, err := p.db.Query(`SELECT tag, confidence, source FROM file_classes WHERE path = ? AND hash = ?`,
+		path, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.Confidence, &t.Source); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	sortTags(tags)
+	return tags, rows.Err()
+}
+
+// Lookup returns the best confidence per class of the file at path, for
+// fileops.SetClassLookup. Failures are logged.
+func (p *Pipeline) Lookup(path string) map[string]float64 {
+	tags, err := p.Tags(path)
+	if err != nil {
+		log.Printf("classify: %s: %v", path, err)
+		return nil
+	}
+	classes := make(map[string]float64, len(tags))
+	for _, t := range tags {
+		if t.Confidence > classes[t.Name] {
+			classes[t.Name] = t.Confidence
+		}
+	}
+	return classes
+}
+
+func (p *Pipeline) save(path, hash string, tags []Tag) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM file_classes WHERE path = ?`, path); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if _, err := tx.Exec(`INSERT INTO file_classes (path, hash, tag, confidence, source) VALUES (?, ?, ?, ?, ?)`,
+			path, hash, t.Name, t.Confidence, t.Source); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO classified_files (path, hash, classifiers, classified_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)`, path, hash, p.signature); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// markDone takes a catalog entry off the queue without touching tags.
+func (p *Pipeline) markDone(path, hash string) error {
+	_, err := p.db.Exec(`INSERT OR REPLACE INTO classified_files (path, hash, classifiers, classified_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)`, path, hash, p.signature)
+	return err
+}
+
+// queueBatch is how many catalog entries are picked up at a time.
+const queueBatch = 100
+
+// pending returns catalog entries that have a hash but have not been
+// classified with the current classifiers.
+func (p *Pipeline) pending(limit int) ([][2]string, error) {
+	rows, err := p.db.Query(`SELECT f.path, f.hash FROM files f
+		LEFT JOIN classified_files c ON c.path = f.path AND c.hash = f.hash AND c.classifiers = ?
+		WHERE f.hash IS NOT NULL AND f.hash != '' AND c.path IS NULL
+		ORDER BY f.updated_at LIMIT ?`, p.signature, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries [][2]string
+	for rows.Next() {
+		var e [2]string
+		if err := rows.Scan(&e[0], &e[1]); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Process classifies up to limit pending catalog entries and returns how
+// many it took. Entries whose file is gone or has changed are marked done;
+// the changed file gets a new catalog entry when it is next hashed.
+func (p *Pipeline) Process(limit int) (int, error) {
+	entries, err := p.pending(limit)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		path, hash := e[0], e[1]
+		var err error
+		classified := false
+		if current, herr := fileops.FileHash(path); herr == nil && current == hash {
+			_, err = p.Classify(path)
+			classified = err == nil
+		}
+		if err != nil {
+			log.Printf("classify: %s: %v", path, err)
+		}
+		if !classified {
+			// Mark it done so a stale or broken entry does not stall the
+			// queue.
+			if err := p.markDone(path, hash); err != nil {
+				return 0, err
+			}
+		}
+		p.mu.Lock()
+		p.processed++
+		if err != nil {
+			p.failed++
+		}
+		p.mu.Unlock()
+	}
+	return len(entries), nil
+}
+
+// Run works through pending catalog entries, checking for new ones every
+// interval once the queue is empty, until ctx is cancelled.
+func (p *Pipeline) Run(ctx context.Context, interval time.Duration) {
+	for {
+		n, err := p.Process(queueBatch)
+		if err != nil {
+			log.Printf("classify: reading queue: %v", err)
+		}
+		if n == queueBatch && err == nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Status reports the background queue.
+type Status struct {
+	Pending   int    `json:"pending"`
+	Processed int    `json:"processed"`
+	Failed    int    `json:"failed"`
+	Sources   string `json:"classifiers"`
+}
+
+// Status returns how many catalog entries are waiting and how many were
+// processed since start.
+func (p *Pipeline) Status() (Status, error) {
+	s := Status{Sources: p.signature}
+	err := p.db.QueryRow(`SELECT COUNT(*) FROM files f
+		LEFT JOIN classified_files c ON c.path = f.path AND c.hash = f.hash AND c.classifiers = ?
+		WHERE f.hash IS NOT NULL AND f.hash != '' AND c.path IS NULL`, p.signature).Scan(&s.Pending)
+	p.mu.Lock()
+	s.Processed, s.Failed = p.processed, p.failed
+	p.mu.Unlock()
+	return s, err
+}
+
+func sortTags(tags []Tag) {
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Confidence != tags[j].Confidence {
+			return tags[i].Confidence > tags[j].Confidence
+		}
+		if tags[i].Name != tags[j].Name {
+			return tags[i].Name < tags[j].Name
+		}
+		return tags[i].Source < tags[j].Source
+	})
+}
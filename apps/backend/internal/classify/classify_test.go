@@ -0,0 +1,163 @@
+package classify
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"file-manager-backend/internal/catalog"
+	"file-manager-backend/internal/db/dbtest"
+	"file-manager-backend/internal/fileops"
+)
+
+func setupPipeline(t *testing.T, classifiers ...Classifier) (*Pipeline, string) {
+	t.Helper()
+	conn := dbtest.Open(t)
+	dbtest.UseMetaCache(t, catalog.NewStore(conn))
+	return New(conn, classifiers...), t.TempDir()
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func names(tags []Tag) []string {
+	var out []string
+	for _, t := range tags {
+		out = append(out, t.Name+"/"+t.Source)
+	}
+	return out
+}
+
+func TestClassify(t *testing.T) {
+	p, root := setupPipeline(t, Defaults()...)
+	invoice := filepath.Join(root, "Invoices", "2023-04.txt")
+	writeFile(t, invoice, "INVOICE\nInvoice number: 42\nBill to: ACME Ltd\nSubtotal 100.00\nVAT 20.00\nAmount due: 120.00")
+
+	tags, err := p.Classify(invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"invoice/keywords", "text/mime", "invoice/path"}
+	if got := names(tags); !reflect.DeepEqual(got, want) {
+		t.Errorf("tags = %v, want %v", got, want)
+	}
+	if tags[0].Confidence != 1 {
+		t.Errorf("keyword confidence = %v", tags[0].Confidence)
+	}
+
+	stored, err := p.Tags(invoice)
+	if err != nil || !reflect.DeepEqual(stored, tags) {
+		t.Errorf("Tags = %v, %v", stored, err)
+	}
+	if classes := p.Lookup(invoice); classes["invoice"] != 1 || classes["text"] != 0.9 {
+		t.Errorf("Lookup = %v", classes)
+	}
+
+	// Changed content is classified again on lookup.
+	writeFile(t, invoice, "just a note")
+	if got := names(storedTags(t, p, invoice)); !reflect.DeepEqual(got, []string{"text/mime", "invoice/path"}) {
+		t.Errorf("after edit = %v", got)
+	}
+
+	if _, err := p.Classify(filepath.Join(root, "missing.txt")); !errors.Is(err, fileops.ErrPathNotFound) {
+		t.Errorf("missing file error = %v", err)
+	}
+}
+
+// storedTags returns the tags of path, failing the test on error.
+func storedTags(t *testing.T, p *Pipeline, path string) []Tag {
+	t.Helper()
+	tags, err := p.Tags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tags
+}
+
+type failing struct{}
+
+func (failing) Name() string                  { return "failing" }
+func (failing) Classify(*File) ([]Tag, error) { return nil, errors.New("boom") }
+
+func TestQueue(t *testing.T) {
+	p, root := setupPipeline(t, failing{}, PathClassifier{Rules: DefaultPathRules})
+	shot := filepath.Join(root, "Screenshot 2024-01-01.png")
+	gone := filepath.Join(root, "scan-1.pdf")
+	writeFile(t, shot, "png")
+	writeFile(t, gone, "pdf")
+	// Hashing puts both into the catalog, which the queue reads.
+	for _, path := range []string{shot, gone} {
+		if _, err := fileops.FileHash(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Remove(gone)
+
+	if s, _ := p.Status(); s.Pending != 2 {
+		t.Fatalf("Status = %+v", s)
+	}
+	n, err := p.Process(10)
+	if err != nil || n != 2 {
+		t.Fatalf("Process = %d, %v", n, err)
+	}
+	if s, _ := p.Status(); s.Pending != 0 || s.Processed != 2 {
+		t.Errorf("Status after = %+v", s)
+	}
+	if got := names(storedTags(t, p, shot)); !reflect.DeepEqual(got, []string{"screenshot/path"}) {
+		t.Errorf("screenshot tags = %v", got)
+	}
+	if n, _ := p.Process(10); n != 0 {
+		t.Errorf("second Process took %d entries", n)
+	}
+}
+
+func TestQueryClass(t *testing.T) {
+	p, root := setupPipeline(t, PathClassifier{Rules: DefaultPathRules})
+	fileops.SetClassLookup(p.Lookup)
+	defer fileops.SetClassLookup(nil)
+	writeFile(t, filepath.Join(root, "contracts", "lease.txt"), "x")
+	writeFile(t, filepath.Join(root, "contracts", "notes.bak"), "y")
+
+	q, err := fileops.ParseQuery("class:contract -class:backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := fileops.ListFiles(filepath.Join(root, "contracts"), fileops.ListOptions{Query: q})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "lease.txt" {
+		t.Errorf("ListFiles = %v", files)
+	}
+}
+
+func TestKeywordConfidence(t *testing.T) {
+	c := KeywordClassifier{Rules: []KeywordRule{{Tag: "x", Keywords: []string{"alpha", "beta gamma", "delta", "eps"}, MinMatches: 2}}}
+	for text, want := range map[string]float64{
+		"alpha only":                 0,
+		"Alpha; BETA-gamma":          0.5,
+		"alpha beta gamma delta":     0.75,
+		"alpha beta gamma delta eps": 1,
+		"alphabet betagamma":         0,
+	} {
+		f := &File{Path: "x.txt"}
+		f.textOnce.Do(func() { f.text = text })
+		tags, _ := c.Classify(f)
+		got := 0.0
+		if len(tags) > 0 {
+			got = tags[0].Confidence
+		}
+		if got != want {
+			t.Errorf("%q: confidence = %v, want %v", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package classify
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/media"
+)
+
+// Defaults returns the built-in rule-based classifiers with their default
+// rules.
+func Defaults() []Classifier {
+	return []Classifier{
+		MimeClassifier{},
+		PathClassifier{Rules: DefaultPathRules},
+		EXIFClassifier{},
+		KeywordClassifier{Rules: DefaultKeywordRules},
+	}
+}
+
+// MimeClassifier tags files with their MIME category, such as "image" or
+// "spreadsheet". Files whose content contradicts their extension get a
+// lower confidence.
+type MimeClassifier struct{}
+
+// Name implements Classifier.
+func (MimeClassifier) Name() string { return "mime" }
+
+// Classify implements Classifier.
+func (MimeClassifier) Classify(f *File) ([]Tag, error) {
+	d := f.Mime()
+	category := fileops.MimeCategory(d.MimeType)
+	if d.MimeType == "" || category == "other" {
+		return nil, nil
+	}
+	confidence := 0.9
+	if d.Mismatch {
+		confidence = 0.5
+	}
+	return []Tag{{Name: category, Confidence: confidence}}, nil
+}
+
+// PathRule tags files by name or location. Pattern is a case-insensitive
+// glob matched against the file name, or against each folder above the
+// file when it ends in "/".
+type PathRule struct {
+	Pattern    string  `json:"pattern"`
+	Tag        string  `json:"tag"`
+	Confidence float64 `json:"confidence"`
+}
+
+// DefaultPathRules recognise common naming habits.
+var DefaultPathRules = []PathRule{
+	{"screenshot*", "screenshot", 0.9},
+	{"screen shot*", "screenshot", 0.9},
+	{"invoices/", "invoice", 0.7},
+	{"*invoice*", "invoice", 0.6},
+	{"receipts/", "receipt", 0.7},
+	{"*receipt*", "receipt", 0.6},
+	{"contracts/", "contract", 0.7},
+	{"*contract*", "contract", 0.6},
+	{"scans/", "scan", 0.7},
+	{"scan*", "scan", 0.5},
+	{"*.bak", "backup", 0.8},
+	{"backups/", "backup", 0.6},
+	{"whatsapp*", "messaging", 0.6},
+}
+
+// PathClassifier applies PathRules.
+type PathClassifier struct {
+	Rules []PathRule
+}
+
+// Name implements Classifier.
+func (PathClassifier) Name() string { return "path" }
+
+// Classify implements Classifier.
+func (c PathClassifier) Classify(f *File) ([]Tag, error) {
+	name := strings.ToLower(filepath.Base(f.Path))
+	folders := strings.Split(strings.ToLower(filepath.ToSlash(filepath.Dir(f.Path))), "/")
+	var tags []Tag
+	for _, r := range c.Rules {
+		pattern := strings.ToLower(r.Pattern)
+		matched := false
+		if dir := strings.TrimSuffix(pattern, "/"); dir != pattern {
+			for _, folder := range folders {
+				if ok, _ := filepath.Match(dir, folder); ok {
+					matched = true
+					break
+				}
+			}
+		} else {
+			matched, _ = filepath.Match(pattern, name)
+		}
+		if matched {
+			tags = append(tags, Tag{Name: r.Tag, Confidence: r.Confidence})
+		}
+	}
+	return tags, nil
+}
+
+// EXIFClassifier tags photos, music and videos from their embedded
+// metadata: camera photos, geotagged photos, music with artist or album
+// tags, and videos.
+type EXIFClassifier struct{}
+
+// Name implements Classifier.
+func (EXIFClassifier) Name() string { return "exif" }
+
+// Classify implements Classifier.
+func (EXIFClassifier) Classify(f *File) ([]Tag, error) {
+	m := f.Media()
+	if m == nil {
+		return nil, nil
+	}
+	var tags []Tag
+	switch m.Kind {
+	case media.KindImage:
+		if m.Make != "" || m.Model != "" {
+			tags = append(tags, Tag{Name: "photo", Confidence: 0.9})
+		}
+		if m.GPS != nil {
+			tags = append(tags, Tag{Name: "geotagged", Confidence: 1})
+		}
+	case media.KindAudio:
+		if m.Artist != "" || m.Album != "" {
+			tags = append(tags, Tag{Name: "music", Confidence: 0.8})
+		}
+	case media.KindVideo:
+		tags = append(tags, Tag{Name: "video", Confidence: 0.9})
+		if m.Make != "" || m.Model != "" {
+			tags = append(tags, Tag{Name: "footage", Confidence: 0.7})
+		}
+	}
+	return tags, nil
+}
+
+// KeywordRule tags documents whose text contains at least MinMatches of
+// Keywords. Keywords match whole words, case-insensitively; confidence
+// grows with the number found.
+type KeywordRule struct {
+	Tag        string   `json:"tag"`
+	Keywords   []string `json:"keywords"`
+	MinMatches int      `json:"minMatches"`
+}
+
+// DefaultKeywordRules recognise common paperwork.
+var DefaultKeywordRules = []KeywordRule{
+	{"invoice", []string{"invoice", "invoice number", "amount due", "bill to", "due date", "subtotal", "vat", "payment terms"}, 3},
+	{"receipt", []string{"receipt", "total", "cash", "change", "card", "thank you for your purchase", "paid"}, 3},
+	{"contract", []string{"agreement", "hereby", "parties", "terms and conditions", "termination", "governing law", "signature", "effective date"}, 3},
+	{"resume", []string{"curriculum vitae", "resume", "work experience", "education", "skills", "references", "employment history"}, 3},
+	{"bank-statement", []string{"statement", "account number", "opening balance", "closing balance", "transactions", "iban"}, 3},
+	{"tax", []string{"tax return", "taxable income", "deductions", "withholding", "tax year", "assessment"}, 2},
+}
+
+// KeywordClassifier applies KeywordRules to extracted document text.
+type KeywordClassifier struct {
+	Rules []KeywordRule
+}
+
+// Name implements Classifier.
+func (KeywordClassifier) Name() string { return "keywords" }
+
+// Classify implements Classifier.
+func (c KeywordClassifier) Classify(f *File) ([]Tag, error) {
+	text := f.Text()
+	if text == "" {
+		return nil, nil
+	}
+	normalized := " " + normalizeWords(text) + " "
+	var tags []Tag
+	for _, r := range c.Rules {
+		found := 0
+		for _, k := range r.Keywords {
+			if strings.Contains(normalized, " "+normalizeWords(k)+" ") {
+				found++
+			}
+		}
+		min := r.MinMatches
+		if min < 1 {
+			min = 1
+		}
+		if found < min {
+			continue
+		}
+		// Meeting the minimum is a fair guess; finding twice as many is
+		// as sure as keywords get.
+		confidence := 0.5 + 0.5*float64(found-min)/float64(min)
+		tags = append(tags, Tag{Name: r.Tag, Confidence: confidence})
+	}
+	return tags, nil
+}
+
+// normalizeWords lower-cases s and replaces every run of non-alphanumeric
+// characters with a single space.
+func normalizeWords(s string) string {
+	var sb strings.Builder
+	space := true
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(unicode.ToLower(r))
+			space = false
+		} else if !space {
+			sb.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
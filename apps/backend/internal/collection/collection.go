@@ -0,0 +1,348 @@
+// Package collection stores saved searches: named sets of root folders,
+// name patterns, metadata filters and tags. A collection is evaluated live
+// whenever it is listed, against the search index where it covers the
+// roots, and appears in the explorer as the virtual folder
+// collection://<name>. It can also be the source of a sync.
+package collection
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/search"
+)
+
+// Scheme prefixes the virtual folder of a collection.
+const Scheme = "collection://"
+
+// ErrNotFound is returned for unknown collections.
+var ErrNotFound = errors.New("collection not found")
+
+// Collection is a saved search. Only files are members; folders are
+// searched but never listed.
+type Collection struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Roots are the folders searched, including everything below them.
+	Roots []string `json:"roots"`
+	// Include, Exclude and Hidden are name rules as in fileops.ListOptions.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	Hidden  bool     `json:"hidden"`
+	// Query filters by metadata; see fileops.ParseQuery.
+	Query string `json:"query,omitempty"`
+	// Tags lists user tags a file must all carry.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Path returns the virtual folder of the collection called name.
+func Path(name string) string {
+	return Scheme + name
+}
+
+// PathName returns the collection name of a virtual folder path, and false
+// for any other path.
+func PathName(p string) (string, bool) {
+	return strings.CutPrefix(p, Scheme)
+}
+
+// compile checks c and returns its rules.
+func (c *Collection) compile() (fileops.ListOptions, error) {
+	c.Name = strings.TrimSpace(c.Name)
+	if !fileops.ValidName(c.Name) {
+		return fileops.ListOptions{}, fmt.Errorf("%w: invalid collection name %q", fileops.ErrInvalidPath, c.Name)
+	}
+	if len(c.Roots) == 0 {
+		return fileops.ListOptions{}, fmt.Errorf("%w: a collection needs at least one root", fileops.ErrInvalidPath)
+	}
+	for i, root := range c.Roots {
+		if !filepath.IsAbs(root) {
+			return fileops.ListOptions{}, fmt.Errorf("%w: root %q is not absolute", fileops.ErrInvalidPath, root)
+		}
+		c.Roots[i] = filepath.Clean(root)
+	}
+	for _, pattern := range append(append([]string(nil), c.Include...), c.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fileops.ListOptions{}, fmt.Errorf("%w: %q", fileops.ErrPatternInvalid, pattern)
+		}
+	}
+	query := c.Query
+	for _, tag := range c.Tags {
+		if strings.Contains(tag, `"`) {
+			return fileops.ListOptions{}, fmt.Errorf("%w: tag %q contains a quote", fileops.ErrQueryInvalid, tag)
+		}
+		query += ` tag:"` + tag + `"`
+	}
+	q, err := fileops.ParseQuery(query)
+	if err != nil {
+		return fileops.ListOptions{}, err
+	}
+	return fileops.ListOptions{Include: c.Include, Exclude: c.Exclude, ShowHidden: c.Hidden, Query: q}, nil
+}
+
+// Store keeps collections and evaluates them.
+type Store struct {
+	db    *sql.DB
+	index *search.Index
+}
+
+// New returns a store backed by db that evaluates collections against
+// index.
+func New(db *sql.DB, index *search.Index) *Store {
+	return &Store{db: db, index: index}
+}
+
+const columns = `id, name, roots, include, exclude, hidden, query, tags`
+
+// Collections returns the stored collections by name.
+func (s *Store) Collections() ([]Collection, error) {
+	rows, err := s.db.Query(`SELECT ` + columns + ` FROM collections ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	collections := []Collection{}
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// Collection returns the stored collection with the given id.
+func (s *Store) Collection(id int64) (Collection, error) {
+	return s.scanOne(`SELECT `+columns+` FROM collections WHERE id = ?`, id)
+}
+
+// ByName returns the stored collection called name.
+func (s *Store) ByName(name string) (Collection, error) {
+	return s.scanOne(`SELECT `+columns+` FROM collections WHERE name = ?`, name)
+}
+
+func (s *Store) scanOne(query string, arg interface{}) (Collection, error) {
+	c, err := scanCollection(s.db.QueryRow(query, arg))
+	if err == sql.ErrNoRows {
+		return Collection{}, ErrNotFound
+	}
+	return c, err
+}
+
+// Save validates c and stores it, creating it when c.ID is zero. Names are
+// unique.
+func (s *Store) Save(c *Collection) error {
+	if _, err := c.compile(); err != nil {
+		return err
+	}
+	if existing, err := s.ByName(c.Name); err == nil && existing.ID != c.ID {
+		return fmt.Errorf("%w: collection %q", fileops.ErrAlreadyExists, c.Name)
+	} else if err != nil && err != ErrNotFound {
+		return err
+	}
+	roots, _ := json.Marshal(c.Roots)
+	include, _ := json.Marshal(c.Include)
+	exclude, _ := json.Marshal(c.Exclude)
+	tags, _ := json.Marshal(c.Tags)
+	if c.ID == 0 {
+		res, err := s.db.Exec(`INSERT INTO collections (name, roots, include, exclude, hidden, query, tags)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			c.Name, string(roots), string(include), string(exclude), c.Hidden, c.Query, string(tags))
+		if err != nil {
+			return err
+		}
+		c.ID, err = res.LastInsertId()
+		return err
+	}
+	res, err := s.db.Exec(`UPDATE collections SET name = ?, roots = ?, include = ?, exclude = ?,
+		hidden = ?, query = ?, tags = ? WHERE id = ?`,
+		c.Name, string(roots), string(include), string(exclude), c.Hidden, c.Query, string(tags), c.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes a stored collection. The files in it are not touched.
+func (s *Store) Delete(id int64) error {
+	res, err := s.db.Exec(`DELETE FROM collections WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Files evaluates c and returns its member files ordered by path. extra
+// narrows the result further, as when the explorer filters a listing; its
+// Metadata and Annotations flags fill in those fields.
+func (s *Store) Files(c Collection, extra fileops.ListOptions) ([]fileops.FileInfo, error) {
+	rules, err := c.compile()
+	if err != nil {
+		return nil, err
+	}
+	// The collection decides about hidden files; extra only narrows by
+	// pattern and query.
+	extra.ShowHidden = true
+	files := []fileops.FileInfo{}
+	seen := make(map[string]bool)
+	for _, root := range c.Roots {
+		paths, err := s.candidates(root, rules)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			if seen[p] || !extra.Matches(filepath.Base(p), false) {
+				continue
+			}
+			seen[p] = true
+			info, err := fileops.Stat(p)
+			if err != nil || info.IsDirectory || !rules.Query.Match(&info) || !extra.Query.Match(&info) {
+				continue
+			}
+			if extra.Metadata {
+				info.Media = fileops.LookupMedia(info.Path)
+			}
+			if extra.Annotations {
+				info.Annotation = fileops.LookupAnnotation(info.Path)
+			}
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// candidates returns the files below root that pass the name rules. The
+// search index is used when it covers root; hidden files are not indexed,
+// so collections including them always walk the disk.
+func (s *Store) candidates(root string, rules fileops.ListOptions) ([]string, error) {
+	indexed := false
+	if s.index != nil && !rules.ShowHidden {
+		var err error
+		if indexed, err = s.index.Covers(root); err != nil {
+			return nil, err
+		}
+	}
+	if !indexed {
+		opts := rules
+		opts.Depth, opts.Query = 0, nil
+		infos, err := fileops.ListFiles(root, opts)
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for _, f := range infos {
+			if !f.IsDirectory {
+				paths = append(paths, f.Path)
+			}
+		}
+		return paths, nil
+	}
+
+	entries, err := s.index.Entries(root)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDirectory && nameRulesPass(root, e.Path, rules) {
+			paths = append(paths, e.Path)
+		}
+	}
+	return paths, nil
+}
+
+// nameRulesPass applies rules to the file at path and to each folder
+// between root and it, as ListFiles does while walking.
+func nameRulesPass(root, path string, rules fileops.ListOptions) bool {
+	if !fileops.IsWithin(path, root) {
+		return false
+	}
+	rel, _ := filepath.Rel(root, path)
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		if !rules.Matches(part, i < len(parts)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sync stores the files of c, narrowed by q, in dst, keeping their
+// layout below their root. With several roots each gets a folder named
+// after it. Files whose copy already exists with the same contents are
+// skipped. It returns the copied paths relative to dst.
+func (s *Store) Sync(c Collection, dst fileops.SyncTarget, q *fileops.Query) ([]string, error) {
+	files, err := s.Files(c, fileops.ListOptions{Query: q})
+	if err != nil {
+		return nil, err
+	}
+	dstDir := filepath.Clean(dst.Root())
+	var items []fileops.SyncItem
+	for _, f := range files {
+		// Earlier copies inside a root must not be copied again.
+		if f.Path == dstDir || fileops.IsWithin(f.Path, dstDir) {
+			continue
+		}
+		items = append(items, fileops.SyncItem{Path: f.Path, Rel: c.relative(f.Path)})
+	}
+	return fileops.SyncFiles(items, dst)
+}
+
+// relative returns where a member file goes below a sync target.
+func (c Collection) relative(path string) string {
+	for i, root := range c.Roots {
+		if !fileops.IsWithin(path, root) {
+			continue
+		}
+		rel, _ := filepath.Rel(root, path)
+		if len(c.Roots) == 1 {
+			return rel
+		}
+		// Roots may share a base name, as in /a/docs and /b/docs.
+		base := filepath.Base(root)
+		for _, other := range c.Roots[:i] {
+			if filepath.Base(other) == base {
+				base += "-" + strconv.Itoa(i+1)
+				break
+			}
+		}
+		return filepath.Join(base, rel)
+	}
+	return filepath.Base(path)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCollection(row scanner) (Collection, error) {
+	var c Collection
+	var roots, include, exclude, tags string
+	if err := row.Scan(&c.ID, &c.Name, &roots, &include, &exclude, &c.Hidden, &c.Query, &tags); err != nil {
+		return c, err
+	}
+	for _, f := range []struct {
+		data string
+		dest *[]string
+	}{{roots, &c.Roots}, {include, &c.Include}, {exclude, &c.Exclude}, {tags, &c.Tags}} {
+		if err := json.Unmarshal([]byte(f.data), f.dest); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
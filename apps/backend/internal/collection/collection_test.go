@@ -0,0 +1,189 @@
+package collection
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"file-manager-backend/internal/annotate"
+	"file-manager-backend/internal/catalog"
+	"file-manager-backend/internal/db/dbtest"
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/search"
+)
+
+func setupStore(t *testing.T) (*Store, *search.Index, *annotate.Store) {
+	t.Helper()
+	conn := dbtest.Open(t)
+	dbtest.UseMetaCache(t, catalog.NewStore(conn))
+	notes := annotate.New(conn)
+	fileops.SetAnnotationLookup(notes.Lookup)
+	t.Cleanup(func() { fileops.SetAnnotationLookup(nil) })
+	index := search.NewIndex(conn)
+	return New(conn, index), index, notes
+}
+
+func writeFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func paths(root string, files []fileops.FileInfo) []string {
+	var out []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(root, f.Path)
+		out = append(out, filepath.ToSlash(rel))
+	}
+	return out
+}
+
+func TestFiles(t *testing.T) {
+	s, index, notes := setupStore(t)
+	root := t.TempDir()
+	docs, other := filepath.Join(root, "docs"), filepath.Join(root, "other")
+	writeFiles(t, docs, "lease.pdf", "old/contract.pdf", "drafts/draft.pdf", "notes.txt", ".hidden.pdf")
+	writeFiles(t, other, "nda.pdf")
+	for _, name := range []string{"docs/lease.pdf", "docs/old/contract.pdf", "docs/drafts/draft.pdf", "docs/notes.txt", "other/nda.pdf"} {
+		if _, err := notes.Set(filepath.Join(root, name), fileops.Annotation{Tags: []string{"Contract"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// docs is indexed, other is walked.
+	if err := index.AddRoot(docs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.Rescan(docs); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Collection{Name: "Contracts", Roots: []string{docs, other}, Include: []string{"*.pdf"},
+		Exclude: []string{"drafts"}, Tags: []string{"contract"}}
+	if err := s.Save(&c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.ByName("Contracts")
+	if err != nil || !reflect.DeepEqual(got, c) {
+		t.Fatalf("ByName = %+v, %v", got, err)
+	}
+
+	files, err := s.Files(c, fileops.ListOptions{Annotations: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"docs/lease.pdf", "docs/old/contract.pdf", "other/nda.pdf"}
+	if got := paths(root, files); !reflect.DeepEqual(got, want) {
+		t.Errorf("Files = %v, want %v", got, want)
+	}
+	if files[0].Annotation == nil {
+		t.Error("annotation not filled in")
+	}
+
+	// Evaluation is live: untagging and deleting take effect at once.
+	notes.Set(filepath.Join(docs, "lease.pdf"), fileops.Annotation{})
+	os.Remove(filepath.Join(other, "nda.pdf"))
+	q, _ := fileops.ParseQuery("name:*contract*")
+	files, _ = s.Files(c, fileops.ListOptions{})
+	if got := paths(root, files); !reflect.DeepEqual(got, []string{"docs/old/contract.pdf"}) {
+		t.Errorf("after changes = %v", got)
+	}
+	if files, _ = s.Files(c, fileops.ListOptions{Query: q, Exclude: []string{"contract*"}}); len(files) != 0 {
+		t.Errorf("narrowed = %v", paths(root, files))
+	}
+}
+
+func TestSaveValidates(t *testing.T) {
+	s, _, _ := setupStore(t)
+	root := t.TempDir()
+	c := Collection{Name: "Photos", Roots: []string{root}, Query: "kind:image"}
+	if err := s.Save(&c); err != nil {
+		t.Fatal(err)
+	}
+	dup := Collection{Name: "Photos", Roots: []string{root}}
+	if err := s.Save(&dup); !errors.Is(err, fileops.ErrAlreadyExists) {
+		t.Errorf("duplicate name = %v", err)
+	}
+	for _, bad := range []Collection{
+		{Name: "a/b", Roots: []string{root}},
+		{Name: "x"},
+		{Name: "x", Roots: []string{"relative"}},
+		{Name: "x", Roots: []string{root}, Include: []string{"["}},
+		{Name: "x", Roots: []string{root}, Query: "size>lots"},
+		{Name: "x", Roots: []string{root}, Tags: []string{`a"b`}},
+	} {
+		if err := s.Save(&bad); err == nil {
+			t.Errorf("Save(%+v) succeeded", bad)
+		}
+	}
+	if err := s.Delete(c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Collection(c.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("after delete = %v", err)
+	}
+	if name, ok := PathName(Path("Photos")); !ok || name != "Photos" {
+		t.Errorf("PathName = %q, %v", name, ok)
+	}
+}
+
+func TestSync(t *testing.T) {
+	s, _, _ := setupStore(t)
+	root := t.TempDir()
+	a, b := filepath.Join(root, "a", "docs"), filepath.Join(root, "b", "docs")
+	writeFiles(t, a, "x.txt", "sub/y.txt", "skip.log")
+	writeFiles(t, b, "z.txt")
+	nas := filepath.Join(t.TempDir(), "nas")
+	c := Collection{Name: "Text", Roots: []string{a, b}, Include: []string{"*.txt"}}
+
+	copied, err := s.Sync(c, fileops.Dir(nas), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"docs/sub/y.txt", "docs/x.txt", "docs-2/z.txt"}
+	for i := range want {
+		want[i] = filepath.FromSlash(want[i])
+	}
+	if !reflect.DeepEqual(copied, want) {
+		t.Errorf("copied = %v, want %v", copied, want)
+	}
+	if data, err := os.ReadFile(filepath.Join(nas, "docs", "sub", "y.txt")); err != nil || string(data) != "sub/y.txt" {
+		t.Errorf("copy = %q, %v", data, err)
+	}
+	if copied, _ = s.Sync(c, fileops.Dir(nas), nil); len(copied) != 0 {
+		t.Errorf("second sync copied %v", copied)
+	}
+
+	// A source file that changed replaces its old copy.
+	if err := os.WriteFile(filepath.Join(a, "x.txt"), []byte("edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if copied, err = s.Sync(c, fileops.Dir(nas), nil); err != nil || !reflect.DeepEqual(copied, []string{filepath.Join("docs", "x.txt")}) {
+		t.Errorf("sync after a change = %v, %v", copied, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(nas, "docs", "x.txt")); string(data) != "edited" {
+		t.Errorf("changed file synced as %q", data)
+	}
+}
+
+func TestNameRulesDotDotNames(t *testing.T) {
+	root := t.TempDir()
+	if !nameRulesPass(root, filepath.Join(root, "..notes.txt"), fileops.ListOptions{ShowHidden: true}) {
+		t.Error("..notes.txt treated as outside its root")
+	}
+	if nameRulesPass(root, filepath.Join(filepath.Dir(root), "other.txt"), fileops.ListOptions{}) {
+		t.Error("file outside the root passed")
+	}
+	c := Collection{Roots: []string{root}}
+	if rel := c.relative(filepath.Join(root, "..notes.txt")); rel != "..notes.txt" {
+		t.Errorf("relative = %q", rel)
+	}
+}
@@ -45,6 +45,59 @@ type Config struct {
 	Server struct {
 		Port string `json:"port"`
 	} `json:"server"`
+	Search struct {
+		// Roots are folders registered with the filename index at startup.
+		Roots []string `json:"roots"`
+		// RescanInterval is how often indexed roots are rescanned, as a
+		// Go duration string such as "30m".
+		RescanInterval string `json:"rescanInterval"`
+		// Content enables full-text indexing of document contents. It is
+		// off by default since it hashes and reads every document below
+		// every root.
+		Content bool `json:"content"`
+		// Exclude lists name patterns skipped by content indexing.
+		Exclude []string `json:"exclude"`
+	} `json:"search"`
+	Transfer struct {
+		// UploadDir stages unfinished uploads. It should be on the same
+		// filesystem as the upload targets so completion is a rename.
+		UploadDir string `json:"uploadDir"`
+		// MaxUploadSize limits a single upload, e.g. "20GB". Empty or "0"
+		// means no limit.
+		MaxUploadSize string `json:"maxUploadSize"`
+		// UploadExpiry is how long an unfinished upload is kept, as a Go
+		// duration string.
+		UploadExpiry string `json:"uploadExpiry"`
+	} `json:"transfer"`
+	Thumbnails struct {
+		// Dir caches generated thumbnails, named by content hash.
+		Dir string `json:"dir"`
+	} `json:"thumbnails"`
+	Organize struct {
+		// PollInterval is how often organize rules are checked for being
+		// due and watched sources for changes, as a Go duration string.
+		PollInterval string `json:"pollInterval"`
+	} `json:"organize"`
+	Summarize struct {
+		// Endpoint is the base URL of an OpenAI-compatible API, such as
+		// "http://localhost:11434/v1". Summaries are off when it is empty.
+		Endpoint string `json:"endpoint"`
+		Model    string `json:"model"`
+		APIKey   string `json:"apiKey"`
+		// MinInterval is the least time between two requests, as a Go
+		// duration string.
+		MinInterval string `json:"minInterval"`
+		// MaxChars caps how much of a document's text is sent.
+		MaxChars int `json:"maxChars"`
+		// Exclude lists name or path patterns that are never sent, on top
+		// of built-in ones for keys and credentials.
+		Exclude []string `json:"exclude"`
+	} `json:"summarize"`
+	Annotations struct {
+		// Xattrs also writes tags, ratings and notes to user.* extended
+		// attributes so other tools can see them.
+		Xattrs bool `json:"xattrs"`
+	} `json:"annotations"`
 }
 
 var cfg *Config
@@ -73,6 +126,13 @@ func Load() (*Config, error) {
 	cfg.Database.Path = filepath.Join(projectRoot, "apps", "backend", "database", "filemanager.db")
 	cfg.Database.SQLInit = filepath.Join(projectRoot, "apps", "backend", "database", "init.sql")
 	cfg.Server.Port = "8080"
+	cfg.Search.RescanInterval = "1h"
+	cfg.Transfer.UploadDir = filepath.Join(projectRoot, "apps", "backend", "database", "uploads")
+	cfg.Transfer.UploadExpiry = "24h"
+	cfg.Thumbnails.Dir = filepath.Join(projectRoot, "apps", "backend", "database", "thumbnails")
+	cfg.Organize.PollInterval = "30s"
+	cfg.Summarize.MinInterval = "2s"
+	cfg.Summarize.MaxChars = 12000
 
 	// Get config file path from environment, default to development
 	env := os.Getenv("APP_ENV")
@@ -105,6 +165,39 @@ func Load() (*Config, error) {
 	if port := os.Getenv("SERVER_PORT"); port != "" {
 		cfg.Server.Port = port
 	}
+	if roots := os.Getenv("SEARCH_ROOTS"); roots != "" {
+		cfg.Search.Roots = strings.Split(roots, ",")
+	}
+	if interval := os.Getenv("SEARCH_RESCAN_INTERVAL"); interval != "" {
+		cfg.Search.RescanInterval = interval
+	}
+	if content := os.Getenv("SEARCH_CONTENT"); content != "" {
+		cfg.Search.Content = content == "true"
+	}
+	if uploadDir := os.Getenv("UPLOAD_DIR"); uploadDir != "" {
+		cfg.Transfer.UploadDir = uploadDir
+	}
+	if maxSize := os.Getenv("MAX_UPLOAD_SIZE"); maxSize != "" {
+		cfg.Transfer.MaxUploadSize = maxSize
+	}
+	if thumbDir := os.Getenv("THUMBNAIL_DIR"); thumbDir != "" {
+		cfg.Thumbnails.Dir = thumbDir
+	}
+	if poll := os.Getenv("ORGANIZE_POLL_INTERVAL"); poll != "" {
+		cfg.Organize.PollInterval = poll
+	}
+	if endpoint := os.Getenv("SUMMARIZE_ENDPOINT"); endpoint != "" {
+		cfg.Summarize.Endpoint = endpoint
+	}
+	if model := os.Getenv("SUMMARIZE_MODEL"); model != "" {
+		cfg.Summarize.Model = model
+	}
+	if key := os.Getenv("SUMMARIZE_API_KEY"); key != "" {
+		cfg.Summarize.APIKey = key
+	}
+	if xattrs := os.Getenv("ANNOTATION_XATTRS"); xattrs != "" {
+		cfg.Annotations.Xattrs = xattrs == "true"
+	}
 
 	// If paths from env/config are relative, make them absolute
 	if !filepath.IsAbs(cfg.Database.Path) {
@@ -113,6 +206,12 @@ func Load() (*Config, error) {
 	if !filepath.IsAbs(cfg.Database.SQLInit) {
 		cfg.Database.SQLInit = filepath.Join(projectRoot, cfg.Database.SQLInit)
 	}
+	if !filepath.IsAbs(cfg.Transfer.UploadDir) {
+		cfg.Transfer.UploadDir = filepath.Join(projectRoot, cfg.Transfer.UploadDir)
+	}
+	if !filepath.IsAbs(cfg.Thumbnails.Dir) {
+		cfg.Thumbnails.Dir = filepath.Join(projectRoot, cfg.Thumbnails.Dir)
+	}
 
 	return cfg, nil
 }
@@ -2,25 +2,102 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"os"
+	"strings"
 )
 
 // InitDB initializes the SQLite database and returns the connection.
+// WAL mode and a busy timeout let background indexers write while API
+// handlers read.
 func InitDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000&_journal_mode=WAL")
 	if err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
-// Migrate runs the database initialization SQL script.
+// column is a column added to a table after its first release. Databases
+// created before then get it through ALTER TABLE, since CREATE TABLE IF
+// NOT EXISTS leaves existing tables untouched.
+type column struct {
+	table, name, definition string
+}
+
+var addedColumns = []column{
+	{"files", "mime_type", "TEXT"},
+	{"files", "device", "INTEGER"},
+	{"files", "inode", "INTEGER"},
+	{"files", "mod_time", "INTEGER"},
+	{"dir_usage", "links", "TEXT NOT NULL DEFAULT ''"},
+	{"dir_usage", "total_size", "INTEGER"},
+	{"dir_usage", "total_files", "INTEGER"},
+	{"dir_usage", "total_dirs", "INTEGER"},
+	{"dir_usage", "totaled_at", "DATETIME"},
+	{"operation_items", "reverted", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// afterColumns runs once every added column exists.
+var afterColumns = []string{
+	"CREATE INDEX IF NOT EXISTS idx_files_identity ON files (device, inode, size, mod_time)",
+}
+
+// Migrate runs the database initialization SQL script and brings older
+// tables up to date. It is safe to run on every start.
 func Migrate(db *sql.DB, sqlPath string) error {
 	content, err := os.ReadFile(sqlPath)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(string(content))
+	if _, err = db.Exec(string(content)); err != nil {
+		return err
+	}
+	for _, c := range addedColumns {
+		if err := addColumn(db, c); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range afterColumns {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn adds c unless the table already has it.
+func addColumn(db *sql.DB, c column) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", c.table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == c.name {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition))
 	return err
 }
+
+// EscapeLike escapes the wildcards of a LIKE pattern in s, for queries
+// that use ESCAPE '\'.
+func EscapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
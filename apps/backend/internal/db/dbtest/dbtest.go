@@ -0,0 +1,42 @@
+// Package dbtest provides the database fixture shared by package tests.
+package dbtest
+
+import (
+	"database/sql"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"file-manager-backend/internal/db"
+	"file-manager-backend/internal/fileops"
+)
+
+// Open returns a database in a temporary folder with the schema applied.
+// It is closed when the test ends.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	conn, err := db.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := db.Migrate(conn, schema()); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// UseMetaCache installs a metadata cache backed by store for the rest of
+// the test, putting the previous one back when the test ends.
+func UseMetaCache(t testing.TB, store fileops.MetaStore) {
+	previous := fileops.CurrentMetaCache()
+	fileops.SetMetaCache(fileops.NewMetaCache(100, store))
+	t.Cleanup(func() { fileops.SetMetaCache(previous) })
+}
+
+// schema returns the path of database/init.sql, found relative to this
+// file so tests of any package can use it.
+func schema() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "database", "init.sql")
+}
@@ -0,0 +1,410 @@
+package diskusage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"file-manager-backend/internal/db"
+	"file-manager-backend/internal/fileops"
+)
+
+// DefaultMaxAge is how long a directory's cached contents are trusted
+// while its mtime is unchanged.
+const DefaultMaxAge = 10 * time.Minute
+
+// batchSize is the number of cache rows written per transaction, so a long
+// scan lets other writers in between batches.
+const batchSize = 500
+
+// Node is one directory in a disk-usage tree. Size and Files cover the
+// whole subtree; OwnSize and OwnFiles cover only the files directly inside
+// the directory, so OwnSize plus the children's sizes equals Size. A file
+// with several hard links is counted once, in the first directory the
+// scan finds it in.
+type Node struct {
+	Name     string  `json:"name"`
+	Path     string  `json:"path"`
+	Size     int64   `json:"size"`
+	Files    int64   `json:"files"`
+	Dirs     int64   `json:"dirs"`
+	OwnSize  int64   `json:"ownSize"`
+	OwnFiles int64   `json:"ownFiles"`
+	Error    string  `json:"error,omitempty"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+// Options controls a scan.
+type Options struct {
+	// Depth limits how many directory levels are returned in the tree.
+	// Sizes are always computed over the full subtree. Zero or less
+	// returns only the root node.
+	Depth int
+	// OneFileSystem stops the scan at mount points, like `du -x`.
+	OneFileSystem bool
+	// ShowHidden includes dot-directories as separate children. Hidden
+	// entries are always counted towards their parent's size.
+	ShowHidden bool
+}
+
+// Scanner computes recursive directory sizes. Results for each directory
+// are cached in SQLite and reused while the directory's mtime is unchanged
+// and the cached row is younger than MaxAge, so rescans only stat the
+// files of directories whose entries changed. Appending to an existing
+// file does not touch its directory's mtime; such growth is picked up once
+// the row is older than MaxAge, or right away after Invalidate.
+type Scanner struct {
+	db *sql.DB
+	// MaxAge bounds how long a directory's cached contents are reused.
+	// Zero re-reads every directory on every scan.
+	MaxAge time.Duration
+
+	mu      sync.Mutex
+	queue   []string // folders waiting for a background scan
+	pending map[string]bool
+	working bool
+}
+
+// dirEntry is the cached summary of a single directory.
+type dirEntry struct {
+	modTime  int64
+	ownSize  int64
+	ownFiles int64
+	subdirs  []string
+	links    []link
+}
+
+// link is a file with several hard links, identified by device and inode
+// so that it is only counted once per scan.
+type link struct {
+	dev, ino uint64
+	size     int64
+}
+
+// NewScanner returns a scanner caching into db. A nil db disables caching.
+func NewScanner(db *sql.DB) *Scanner {
+	return &Scanner{db: db, MaxAge: DefaultMaxAge}
+}
+
+// Scan walks root and returns its usage tree.
+func (s *Scanner) Scan(root string, opts Options) (*Node, error) {
+	if root == "" {
+		return nil, fileops.ErrInvalidPath
+	}
+	root = filepath.Clean(root)
+
+	info, err := os.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fileops.ErrPathNotFound
+		}
+		if os.IsPermission(err) {
+			return nil, fileops.ErrPermissionDenied
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fileops.ErrInvalidPath
+	}
+
+	w := &writer{db: s.db}
+	defer w.rollback()
+	node := s.scanDir(w, root, info, deviceOf(info), 0, opts, make(map[link]bool))
+	if err := w.commit(); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (s *Scanner) scanDir(w *writer, path string, info os.FileInfo, rootDev uint64, level int, opts Options, seen map[link]bool) *Node {
+	node := &Node{Name: info.Name(), Path: path}
+
+	entry, err := s.summary(w, path, info)
+	if err != nil {
+		node.Error = err.Error()
+		return node
+	}
+	node.OwnSize = entry.ownSize
+	node.OwnFiles = entry.ownFiles
+	for _, l := range entry.links {
+		if !seen[l] {
+			seen[l] = true
+			node.OwnSize += l.size
+			node.OwnFiles++
+		}
+	}
+	node.Size = node.OwnSize
+	node.Files = node.OwnFiles
+
+	for _, name := range entry.subdirs {
+		childPath := filepath.Join(path, name)
+		childInfo, err := os.Lstat(childPath)
+		if err != nil || !childInfo.IsDir() {
+			continue
+		}
+		if opts.OneFileSystem && deviceOf(childInfo) != rootDev {
+			continue
+		}
+
+		child := s.scanDir(w, childPath, childInfo, rootDev, level+1, opts, seen)
+		node.Size += child.Size
+		node.Files += child.Files
+		node.Dirs += child.Dirs + 1
+
+		if level < opts.Depth && (opts.ShowHidden || !strings.HasPrefix(name, ".")) {
+			node.Children = append(node.Children, child)
+		}
+	}
+
+	// Totals stopped at mount points are not the folder's real size.
+	if !opts.OneFileSystem {
+		err := w.exec("UPDATE dir_usage SET total_size = ?, total_files = ?, total_dirs = ?, totaled_at = ? WHERE path = ?",
+			node.Size, node.Files, node.Dirs, time.Now(), path)
+		if err != nil {
+			node.Error = err.Error()
+		}
+	}
+	return node
+}
+
+// summary returns the direct contents of a directory, from the cache when
+// the directory's mtime matches and the row is fresh, and from disk
+// otherwise.
+func (s *Scanner) summary(w *writer, path string, info os.FileInfo) (dirEntry, error) {
+	modTime := info.ModTime().UnixNano()
+	if s.db != nil {
+		var entry dirEntry
+		var subdirs, links string
+		var scannedAt sql.NullTime
+		err := s.db.QueryRow(
+			"SELECT mod_time, own_size, own_files, subdirs, links, scanned_at FROM dir_usage WHERE path = ?", path,
+		).Scan(&entry.modTime, &entry.ownSize, &entry.ownFiles, &subdirs, &links, &scannedAt)
+		if err == nil && entry.modTime == modTime && s.fresh(scannedAt) {
+			if subdirs != "" {
+				entry.subdirs = strings.Split(subdirs, "\n")
+			}
+			entry.links = parseLinks(links)
+			return entry, nil
+		}
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return dirEntry{}, err
+	}
+	entry := dirEntry{modTime: modTime}
+	for _, e := range entries {
+		if e.IsDir() {
+			entry.subdirs = append(entry.subdirs, e.Name())
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if fi.Mode().IsRegular() && fileops.LinkCount(fi) > 1 {
+			dev, ino := fileops.FileID(fi)
+			entry.links = append(entry.links, link{dev, ino, fi.Size()})
+			continue
+		}
+		entry.ownSize += fi.Size()
+		entry.ownFiles++
+	}
+
+	err = w.exec(`INSERT INTO dir_usage (path, mod_time, own_size, own_files, subdirs, links, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET mod_time = excluded.mod_time, own_size = excluded.own_size,
+			own_files = excluded.own_files, subdirs = excluded.subdirs, links = excluded.links,
+			scanned_at = excluded.scanned_at`,
+		path, entry.modTime, entry.ownSize, entry.ownFiles, strings.Join(entry.subdirs, "\n"),
+		formatLinks(entry.links), time.Now())
+	if err != nil {
+		return dirEntry{}, err
+	}
+	return entry, nil
+}
+
+// Total returns the size, file and folder counts of dir's subtree from
+// the last full scan, without walking it. It reports false when there is
+// no such scan, dir changed since, or the scan is older than MaxAge.
+func (s *Scanner) Total(dir string) (*Node, bool) {
+	if s.db == nil {
+		return nil, false
+	}
+	dir = filepath.Clean(dir)
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return nil, false
+	}
+	node := &Node{Name: info.Name(), Path: dir}
+	var modTime int64
+	var totaledAt sql.NullTime
+	err = s.db.QueryRow(`SELECT mod_time, total_size, total_files, total_dirs, totaled_at
+		FROM dir_usage WHERE path = ? AND totaled_at IS NOT NULL`, dir).
+		Scan(&modTime, &node.Size, &node.Files, &node.Dirs, &totaledAt)
+	if err != nil || modTime != info.ModTime().UnixNano() || !s.fresh(totaledAt) {
+		return nil, false
+	}
+	return node, true
+}
+
+// Sizes returns the subtree sizes of dirs known from recent scans, keyed
+// by path. Folders without one are scanned in the background, one at a
+// time, so a later call can report them.
+func (s *Scanner) Sizes(dirs []string) map[string]int64 {
+	sizes := make(map[string]int64, len(dirs))
+	for _, dir := range dirs {
+		if node, ok := s.Total(dir); ok {
+			sizes[dir] = node.Size
+		} else if s.db != nil {
+			s.enqueue(filepath.Clean(dir))
+		}
+	}
+	return sizes
+}
+
+func (s *Scanner) enqueue(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		s.pending = make(map[string]bool)
+	}
+	if s.pending[dir] {
+		return
+	}
+	s.pending[dir] = true
+	s.queue = append(s.queue, dir)
+	if !s.working {
+		s.working = true
+		go s.work()
+	}
+}
+
+// work scans queued folders until the queue is empty.
+func (s *Scanner) work() {
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.working = false
+			s.mu.Unlock()
+			return
+		}
+		dir := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		if _, err := s.Scan(dir, Options{}); err != nil {
+			log.Printf("diskusage: scanning %s: %v", dir, err)
+		}
+
+		s.mu.Lock()
+		delete(s.pending, dir)
+		s.mu.Unlock()
+	}
+}
+
+// Invalidate drops cached results for path and everything below it, and
+// the subtree totals of the folders above it.
+func (s *Scanner) Invalidate(path string) error {
+	if s.db == nil {
+		return nil
+	}
+	path = filepath.Clean(path)
+	if _, err := s.db.Exec("DELETE FROM dir_usage WHERE path = ? OR path LIKE ? ESCAPE '\\'",
+		path, db.EscapeLike(path+string(filepath.Separator))+"%"); err != nil {
+		return err
+	}
+	var parents []string
+	var args []interface{}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		parents = append(parents, "?")
+		args = append(args, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	_, err := s.db.Exec("UPDATE dir_usage SET totaled_at = NULL WHERE path IN ("+strings.Join(parents, ", ")+")", args...)
+	return err
+}
+
+// fresh reports whether a cache row written at t may still be used.
+func (s *Scanner) fresh(t sql.NullTime) bool {
+	return t.Valid && time.Since(t.Time) < s.MaxAge
+}
+
+// writer batches cache writes into transactions of at most batchSize
+// rows, so a long scan never holds SQLite's write lock for its whole run.
+// Without a database it discards everything.
+type writer struct {
+	db *sql.DB
+	tx *sql.Tx
+	n  int
+}
+
+func (w *writer) exec(query string, args ...interface{}) error {
+	if w.db == nil {
+		return nil
+	}
+	if w.tx == nil {
+		tx, err := w.db.Begin()
+		if err != nil {
+			return err
+		}
+		w.tx = tx
+	}
+	if _, err := w.tx.Exec(query, args...); err != nil {
+		return err
+	}
+	if w.n++; w.n >= batchSize {
+		return w.commit()
+	}
+	return nil
+}
+
+func (w *writer) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Commit()
+	w.tx, w.n = nil, 0
+	return err
+}
+
+func (w *writer) rollback() {
+	if w.tx != nil {
+		w.tx.Rollback()
+	}
+}
+
+// formatLinks stores hard-linked files one per line as "dev ino size".
+func formatLinks(links []link) string {
+	lines := make([]string, len(links))
+	for i, l := range links {
+		lines[i] = fmt.Sprintf("%d %d %d", l.dev, l.ino, l.size)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func parseLinks(s string) []link {
+	if s == "" {
+		return nil
+	}
+	var links []link
+	for _, line := range strings.Split(s, "\n") {
+		var l link
+		if _, err := fmt.Sscan(line, &l.dev, &l.ino, &l.size); err == nil {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+func deviceOf(info os.FileInfo) uint64 {
+	dev, _ := fileops.FileID(info)
+	return dev
+}
@@ -0,0 +1,185 @@
+package diskusage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/db/dbtest"
+	"file-manager-backend/internal/fileops"
+)
+
+func setupDB(t *testing.T) *Scanner {
+	conn := dbtest.Open(t)
+	return NewScanner(conn)
+}
+
+func writeFile(t *testing.T, path string, size int) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.bin"), 100)
+	writeFile(t, filepath.Join(root, "sub", "b.bin"), 200)
+	writeFile(t, filepath.Join(root, "sub", "deep", "c.bin"), 300)
+	writeFile(t, filepath.Join(root, ".cache", "d.bin"), 50)
+
+	scanner := setupDB(t)
+
+	tree, err := scanner.Scan(root, Options{Depth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Size != 650 || tree.Files != 4 || tree.Dirs != 3 {
+		t.Errorf("root totals: size=%d files=%d dirs=%d", tree.Size, tree.Files, tree.Dirs)
+	}
+	if tree.OwnSize != 100 || tree.OwnFiles != 1 {
+		t.Errorf("root own totals: size=%d files=%d", tree.OwnSize, tree.OwnFiles)
+	}
+	if len(tree.Children) != 1 || tree.Children[0].Name != "sub" {
+		t.Fatalf("expected only the visible sub child, got %+v", tree.Children)
+	}
+	sub := tree.Children[0]
+	if sub.Size != 500 || sub.Files != 2 {
+		t.Errorf("sub totals: size=%d files=%d", sub.Size, sub.Files)
+	}
+	if len(sub.Children) != 0 {
+		t.Errorf("depth 1 should not return grandchildren, got %d", len(sub.Children))
+	}
+
+	// Adding a file bumps the directory mtime, which invalidates its row.
+	deep := filepath.Join(root, "sub", "deep")
+	writeFile(t, filepath.Join(deep, "e.bin"), 1000)
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(deep, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = scanner.Scan(root, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Size != 1650 || tree.Files != 5 {
+		t.Errorf("after change: size=%d files=%d", tree.Size, tree.Files)
+	}
+}
+
+func TestScanUsesCache(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.bin"), 100)
+
+	scanner := setupDB(t)
+	if _, err := scanner.Scan(root, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Growing a file in place leaves the directory mtime alone, so the
+	// cached total is served while the row is fresh.
+	writeFile(t, filepath.Join(root, "a.bin"), 400)
+	tree, err := scanner.Scan(root, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Size != 100 {
+		t.Errorf("expected cached size 100, got %d", tree.Size)
+	}
+
+	if err := scanner.Invalidate(root); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = scanner.Scan(root, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Size != 400 {
+		t.Errorf("expected size 400 after invalidate, got %d", tree.Size)
+	}
+}
+
+func TestScanNoticesGrowthAfterMaxAge(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.bin"), 100)
+
+	scanner := setupDB(t)
+	scanner.MaxAge = 0
+	if _, err := scanner.Scan(root, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "a.bin"), 400)
+	tree, err := scanner.Scan(root, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Size != 400 {
+		t.Errorf("expected expired row to be re-read, got size %d", tree.Size)
+	}
+}
+
+func TestScanCountsHardLinksOnce(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a", "big.bin"), 1000)
+	if err := os.MkdirAll(filepath.Join(root, "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "a", "big.bin"), filepath.Join(root, "b", "big.bin")); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+
+	scanner := setupDB(t)
+	for i := 0; i < 2; i++ { // the second scan is served from the cache
+		tree, err := scanner.Scan(root, Options{Depth: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.Size != 1000 || tree.Files != 1 {
+			t.Errorf("scan %d: size=%d files=%d, want 1000 and 1", i, tree.Size, tree.Files)
+		}
+	}
+}
+
+func TestTotal(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "sub", "a.bin"), 100)
+	writeFile(t, filepath.Join(root, "sub", "deep", "b.bin"), 200)
+
+	scanner := setupDB(t)
+	sub := filepath.Join(root, "sub")
+	if _, ok := scanner.Total(sub); ok {
+		t.Fatal("expected no total before a scan")
+	}
+	if _, err := scanner.Scan(root, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	node, ok := scanner.Total(sub)
+	if !ok || node.Size != 300 || node.Files != 2 || node.Dirs != 1 {
+		t.Fatalf("Total(sub) = %+v, %v", node, ok)
+	}
+
+	// Changes below a folder drop the totals of the folders above it.
+	if err := scanner.Invalidate(filepath.Join(sub, "deep")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := scanner.Total(sub); ok {
+		t.Error("expected total of sub to be dropped")
+	}
+	if _, ok := scanner.Total(root); ok {
+		t.Error("expected total of root to be dropped")
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	scanner := NewScanner(nil)
+	if _, err := scanner.Scan("", Options{}); err != fileops.ErrInvalidPath {
+		t.Errorf("expected ErrInvalidPath, got %v", err)
+	}
+	if _, err := scanner.Scan("/non/existent/path", Options{}); err != fileops.ErrPathNotFound {
+		t.Errorf("expected ErrPathNotFound, got %v", err)
+	}
+}
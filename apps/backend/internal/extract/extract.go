@@ -0,0 +1,99 @@
+package extract
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// MaxTextBytes caps how much text is returned per document so a single
+// huge log file cannot blow up the index.
+const MaxTextBytes = 8 << 20
+
+// ErrUnsupported is returned for files no extractor understands.
+var ErrUnsupported = errors.New("unsupported document type")
+
+// extractor pulls plain text out of one document format.
+type extractor func(path string) (string, error)
+
+// byExt maps extensions to format-specific extractors. Anything else that
+// DetectMimeType reports as text falls back to plainText.
+var byExt = map[string]extractor{
+	".pdf":  pdfText,
+	".docx": docxText,
+	".odt":  odtText,
+	".epub": epubText,
+}
+
+// textMimeTypes are non-text/* types whose content is plain text.
+var textMimeTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/typescript": true,
+	"application/x-yaml":     true,
+	"application/toml":       true,
+	"application/xml":        true,
+	"application/x-sh":       true,
+}
+
+// Supported reports whether Text can handle the file at path.
+func Supported(path string) bool {
+	if _, ok := byExt[strings.ToLower(filepath.Ext(path))]; ok {
+		return true
+	}
+	return isText(path)
+}
+
+// Text returns the plain text content of the document at path.
+func Text(path string) (string, error) {
+	if fn, ok := byExt[strings.ToLower(filepath.Ext(path))]; ok {
+		text, err := fn(path)
+		if err != nil {
+			return "", err
+		}
+		return truncate(text), nil
+	}
+	if isText(path) {
+		return plainText(path)
+	}
+	return "", ErrUnsupported
+}
+
+func isText(path string) bool {
+	mimeType, err := fileops.DetectMimeType(path)
+	if err != nil {
+		return false
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	return strings.HasPrefix(mimeType, "text/") || textMimeTypes[mimeType]
+}
+
+func plainText(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, MaxTextBytes))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToValidUTF8(string(data), ""), nil
+}
+
+// truncate cuts text at MaxTextBytes on a rune boundary.
+func truncate(text string) string {
+	if len(text) <= MaxTextBytes {
+		return text
+	}
+	cut := MaxTextBytes
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut]
+}
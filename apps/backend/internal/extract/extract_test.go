@@ -0,0 +1,140 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// buildPDF returns a minimal PDF whose page content is stored once
+// uncompressed and once Flate-compressed.
+func buildPDF(plain, compressed string) []byte {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte(compressed))
+	zw.Close()
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	fmt.Fprintf(&b, "4 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(plain), plain)
+	fmt.Fprintf(&b, "5 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", z.Len())
+	b.Write(z.Bytes())
+	b.WriteString("\nendstream\nendobj\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestText(t *testing.T) {
+	dir := t.TempDir()
+
+	writeZip(t, filepath.Join(dir, "report.docx"), map[string]string{
+		"word/document.xml": `<?xml version="1.0"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+			`<w:p><w:r><w:t>Quarterly</w:t></w:r><w:r><w:t xml:space="preserve"> revenue grew</w:t></w:r></w:p>` +
+			`<w:p><w:r><w:t>Second paragraph</w:t></w:r></w:p></w:body></w:document>`,
+	})
+	writeZip(t, filepath.Join(dir, "letter.odt"), map[string]string{
+		"content.xml": `<?xml version="1.0"?><office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0">` +
+			`<office:body><office:text><text:h>Dear reader</text:h><text:p>Thanks for the <text:span>invoice</text:span>.</text:p></office:text></office:body></office:document-content>`,
+	})
+	writeZip(t, filepath.Join(dir, "book.epub"), map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0"?><container><rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles></container>`,
+		"OEBPS/content.opf": `<?xml version="1.0"?><package><manifest><item id="c1" href="ch1.xhtml"/><item id="c2" href="ch2.xhtml"/></manifest>` +
+			`<spine><itemref idref="c2"/><itemref idref="c1"/></spine></package>`,
+		"OEBPS/ch1.xhtml": `<html><head><title>skip me</title></head><body><p>Chapter one&nbsp;text</p></body></html>`,
+		"OEBPS/ch2.xhtml": `<html><body><p>Prologue<br/>comes first</p><script>var x;</script></body></html>`,
+	})
+	pdf := buildPDF(
+		"BT /F1 12 Tf 72 700 Td (Hello \\(PDF\\) world) Tj ET",
+		"BT /F1 12 Tf [(Kerned) -300 (words)] TJ T* (caf\\351) Tj ET",
+	)
+	if err := os.WriteFile(filepath.Join(dir, "scan.pdf"), pdf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main // entry point"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file string
+		want []string
+		not  []string
+	}{
+		{"report.docx", []string{"Quarterly revenue grew\n", "Second paragraph"}, nil},
+		{"letter.odt", []string{"Dear reader\n", "Thanks for the invoice."}, nil},
+		{"book.epub", []string{"Prologue\ncomes first", "Chapter one text"}, []string{"skip me", "var x"}},
+		{"scan.pdf", []string{"Hello (PDF) world", "Kerned words", "café"}, nil},
+		{"main.go", []string{"entry point"}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			if !Supported(path) {
+				t.Fatalf("%s should be supported", tc.file)
+			}
+			text, err := Text(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("text %q does not contain %q", text, want)
+				}
+			}
+			for _, not := range tc.not {
+				if strings.Contains(text, not) {
+					t.Errorf("text %q should not contain %q", text, not)
+				}
+			}
+		})
+	}
+
+	if strings.Index(mustText(t, filepath.Join(dir, "book.epub")), "Prologue") > strings.Index(mustText(t, filepath.Join(dir, "book.epub")), "Chapter") {
+		t.Error("epub chapters should follow spine order")
+	}
+}
+
+func mustText(t *testing.T, path string) string {
+	text, err := Text(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+func TestTextUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(path, []byte("\x89PNG\r\n\x1a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if Supported(path) {
+		t.Error("png should not be supported")
+	}
+	if _, err := Text(path); err != ErrUnsupported {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package extract
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// docxText reads the main document part of a Word file.
+func docxText(p string) (string, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	return xmlPartText(&zr.Reader, "word/document.xml", map[string]string{
+		"p":   "\n",
+		"br":  "\n",
+		"tab": "\t",
+	})
+}
+
+// odtText reads the content part of an OpenDocument text file.
+func odtText(p string) (string, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	return xmlPartText(&zr.Reader, "content.xml", map[string]string{
+		"p":          "\n",
+		"h":          "\n",
+		"line-break": "\n",
+		"tab":        "\t",
+		"s":          " ",
+	})
+}
+
+// epubText reads the XHTML chapters of an EPUB in spine order.
+func epubText(p string) (string, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	var sb strings.Builder
+	for _, name := range epubChapters(&zr.Reader) {
+		text, err := xmlPartText(&zr.Reader, name, map[string]string{
+			"p": "\n", "div": "\n", "br": "\n", "li": "\n",
+			"h1": "\n", "h2": "\n", "h3": "\n", "h4": "\n", "h5": "\n", "h6": "\n",
+		})
+		if err != nil {
+			continue
+		}
+		sb.WriteString(text)
+		sb.WriteString("\n")
+		if sb.Len() > MaxTextBytes {
+			break
+		}
+	}
+	return sb.String(), nil
+}
+
+// epubChapters returns the content documents listed in the package spine,
+// or every HTML file in the archive when the package cannot be read.
+func epubChapters(zr *zip.Reader) []string {
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := decodeZipXML(zr, "META-INF/container.xml", &container); err == nil && len(container.Rootfiles) > 0 {
+		opfPath := container.Rootfiles[0].FullPath
+		var pkg struct {
+			Items []struct {
+				ID   string `xml:"id,attr"`
+				Href string `xml:"href,attr"`
+			} `xml:"manifest>item"`
+			Spine []struct {
+				IDRef string `xml:"idref,attr"`
+			} `xml:"spine>itemref"`
+		}
+		if err := decodeZipXML(zr, opfPath, &pkg); err == nil {
+			hrefs := make(map[string]string)
+			for _, item := range pkg.Items {
+				hrefs[item.ID] = item.Href
+			}
+			var chapters []string
+			for _, ref := range pkg.Spine {
+				if href, ok := hrefs[ref.IDRef]; ok {
+					chapters = append(chapters, path.Join(path.Dir(opfPath), href))
+				}
+			}
+			if len(chapters) > 0 {
+				return chapters
+			}
+		}
+	}
+
+	var chapters []string
+	for _, f := range zr.File {
+		switch strings.ToLower(path.Ext(f.Name)) {
+		case ".xhtml", ".html", ".htm":
+			chapters = append(chapters, f.Name)
+		}
+	}
+	sort.Strings(chapters)
+	return chapters
+}
+
+func openZipFile(zr *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, f := range zr.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+	return nil, ErrUnsupported
+}
+
+func decodeZipXML(zr *zip.Reader, name string, v interface{}) error {
+	rc, err := openZipFile(zr, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// xmlPartText concatenates the character data of an XML part. breaks maps
+// element local names to the separator written when the element ends (or
+// starts, for empty elements such as <br/>). Script and style content is
+// skipped.
+func xmlPartText(zr *zip.Reader, name string, breaks map[string]string) (string, error) {
+	rc, err := openZipFile(zr, name)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	dec := xml.NewDecoder(io.LimitReader(rc, 4*MaxTextBytes))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var sb strings.Builder
+	skip := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return sb.String(), nil // keep what was readable
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "script", "style", "head":
+				skip++
+			case "br", "tab", "s", "line-break":
+				sb.WriteString(breaks[t.Name.Local])
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "script", "style", "head":
+				if skip > 0 {
+					skip--
+				}
+			case "br", "tab", "s", "line-break":
+			default:
+				sb.WriteString(breaks[t.Name.Local])
+			}
+		case xml.CharData:
+			if skip == 0 {
+				sb.Write(t)
+			}
+		}
+		if sb.Len() > MaxTextBytes {
+			break
+		}
+	}
+	return sb.String(), nil
+}
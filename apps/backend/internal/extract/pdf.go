@@ -0,0 +1,261 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPDFBytes bounds how much of a PDF is loaded for text extraction.
+const maxPDFBytes = 256 << 20
+
+var (
+	pdfStreamStart = regexp.MustCompile(`stream\r?\n`)
+	pdfLength      = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+)
+
+// pdfText extracts the text shown by content streams. It is a best-effort
+// reader: it decodes uncompressed and Flate streams, interprets the text
+// operators Tj, TJ, ' and ", and ignores font encodings, so documents
+// relying on custom CMaps may come out partly garbled.
+func pdfText(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxPDFBytes))
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", ErrUnsupported
+	}
+
+	var sb strings.Builder
+	pos := 0
+	for pos < len(data) && sb.Len() < MaxTextBytes {
+		loc := pdfStreamStart.FindIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		start := pos + loc[1]
+		dict := data[pos : pos+loc[0]]
+		if objStart := bytes.LastIndex(dict, []byte("obj")); objStart >= 0 {
+			dict = dict[objStart:]
+		}
+
+		end := -1
+		if m := pdfLength.FindSubmatch(dict); m != nil && len(m[2]) == 0 {
+			if n, err := strconv.Atoi(string(m[1])); err == nil && start+n <= len(data) {
+				end = start + n
+			}
+		}
+		if end < 0 {
+			idx := bytes.Index(data[start:], []byte("endstream"))
+			if idx < 0 {
+				break
+			}
+			end = start + idx
+		}
+		pos = end
+		if idx := bytes.Index(data[end:], []byte("endstream")); idx >= 0 {
+			pos = end + idx + len("endstream")
+		}
+
+		raw := data[start:end]
+		var content []byte
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			content, _ = io.ReadAll(io.LimitReader(zr, 4*MaxTextBytes))
+			zr.Close()
+		case bytes.Contains(dict, []byte("/Filter")):
+			continue // images and other encodings carry no text
+		default:
+			content = raw
+		}
+		if bytes.Contains(content, []byte("BT")) {
+			pdfContentText(content, &sb)
+		}
+	}
+	return sb.String(), nil
+}
+
+// pdfContentText interprets the text-showing operators of a content stream.
+func pdfContentText(content []byte, sb *strings.Builder) {
+	var operands []string
+	inArray := false
+	var arrayText strings.Builder
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, n := pdfLiteral(content[i:])
+			i += n
+			if inArray {
+				arrayText.WriteString(s)
+			} else {
+				operands = append(operands, s)
+			}
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			s := pdfHex(content[i+1 : i+end])
+			i += end + 1
+			if inArray {
+				arrayText.WriteString(s)
+			} else {
+				operands = append(operands, s)
+			}
+		case c == '[':
+			inArray = true
+			arrayText.Reset()
+			i++
+		case c == ']':
+			inArray = false
+			operands = append(operands, arrayText.String())
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPDFSpace(c):
+			i++
+		default:
+			start := i
+			for i < len(content) && !isPDFSpace(content[i]) && !strings.ContainsRune("()<>[]/%", rune(content[i])) {
+				i++
+			}
+			if i == start {
+				i++ // lone delimiter such as '/'
+				continue
+			}
+			word := string(content[start:i])
+			if inArray {
+				// Large negative kerning inside TJ arrays marks a word gap.
+				if n, err := strconv.ParseFloat(word, 64); err == nil && n < -200 {
+					arrayText.WriteByte(' ')
+				}
+				continue
+			}
+			switch word {
+			case "Tj", "TJ":
+				if len(operands) > 0 {
+					sb.WriteString(operands[len(operands)-1])
+				}
+				operands = operands[:0]
+			case "'", "\"":
+				sb.WriteByte('\n')
+				if len(operands) > 0 {
+					sb.WriteString(operands[len(operands)-1])
+				}
+				operands = operands[:0]
+			case "T*", "Td", "TD":
+				sb.WriteByte('\n')
+				operands = operands[:0]
+			case "ET":
+				sb.WriteByte('\n')
+				operands = operands[:0]
+			default:
+				if _, err := strconv.ParseFloat(word, 64); err != nil {
+					operands = operands[:0]
+				}
+			}
+		}
+	}
+}
+
+// pdfLiteral decodes a (...) string starting at b[0], returning the text
+// and the number of bytes consumed.
+func pdfLiteral(b []byte) (string, int) {
+	var sb strings.Builder
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '(':
+			if depth > 0 {
+				sb.WriteByte(c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return sb.String(), i + 1
+			}
+			sb.WriteByte(c)
+		case '\\':
+			i++
+			if i >= len(b) {
+				break
+			}
+			switch e := b[i]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					j := i
+					for j < len(b) && j < i+3 && b[j] >= '0' && b[j] <= '7' {
+						j++
+					}
+					n, _ := strconv.ParseUint(string(b[i:j]), 8, 8)
+					sb.WriteRune(rune(n))
+					i = j - 1
+				} else {
+					sb.WriteRune(rune(e))
+				}
+			}
+		default:
+			// Bytes are read as Latin-1, close enough to PDFDocEncoding
+			// and WinAnsiEncoding for indexing.
+			sb.WriteRune(rune(c))
+		}
+	}
+	return sb.String(), len(b)
+}
+
+// pdfHex decodes a <...> string, keeping only printable single-byte text.
+func pdfHex(h []byte) string {
+	h = bytes.Map(func(r rune) rune {
+		if isPDFSpace(byte(r)) {
+			return -1
+		}
+		return r
+	}, h)
+	if len(h)%2 == 1 {
+		h = append(h, '0')
+	}
+	var sb strings.Builder
+	for i := 0; i+1 < len(h); i += 2 {
+		n, err := strconv.ParseUint(string(h[i:i+2]), 16, 8)
+		if err != nil {
+			return ""
+		}
+		if n >= 0x20 && n < 0x7f {
+			sb.WriteByte(byte(n))
+		}
+	}
+	return sb.String()
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
@@ -0,0 +1,106 @@
+package fileops
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Annotation is what a user attached to a file: tags, a rating from 1 to 5
+// and a note.
+type Annotation struct {
+	Tags   []string `json:"tags,omitempty"`
+	Rating int      `json:"rating,omitempty"`
+	Note   string   `json:"note,omitempty"`
+}
+
+// AnnotationLookup returns the annotation of the file at path, or nil.
+type AnnotationLookup func(path string) *Annotation
+
+var annotationLookup atomic.Pointer[AnnotationLookup]
+
+// SetAnnotationLookup installs the source of FileInfo.Annotation and of
+// the tag, note and rating query fields.
+func SetAnnotationLookup(lookup AnnotationLookup) {
+	annotationLookup.Store(&lookup)
+}
+
+// LookupAnnotation returns the annotation of the file at path from the
+// installed AnnotationLookup, or nil.
+func LookupAnnotation(path string) *Annotation {
+	if lookup := annotationLookup.Load(); lookup != nil && *lookup != nil {
+		return (*lookup)(path)
+	}
+	return nil
+}
+
+// annotation returns f's annotation, looking it up once on first use.
+// Folders can be annotated too.
+func (f *FileInfo) annotation() *Annotation {
+	if f.annotationLoaded {
+		return f.annotationData
+	}
+	f.annotationLoaded = true
+	if !f.Virtual {
+		f.annotationData = LookupAnnotation(f.Path)
+	}
+	return f.annotationData
+}
+
+// MoveHook is told about every file or folder moved or renamed by Move,
+// MoveFile and Rename, so data keyed by path can follow it.
+type MoveHook func(src, dst string)
+
+var moveHook atomic.Pointer[MoveHook]
+
+// SetMoveHook installs the hook called after successful moves.
+func SetMoveHook(hook MoveHook) {
+	moveHook.Store(&hook)
+}
+
+func notifyMoved(src, dst string) {
+	if hook := moveHook.Load(); hook != nil && *hook != nil {
+		(*hook)(src, dst)
+	}
+}
+
+// userRating is the rating a user gave the file, or the one embedded in
+// its metadata when there is none.
+func userRating(f *FileInfo) float64 {
+	if a := f.annotation(); a != nil && a.Rating > 0 {
+		return float64(a.Rating)
+	}
+	if m := f.metadata(); m != nil {
+		return float64(m.Rating)
+	}
+	return 0
+}
+
+func parseTagTerm(op, value string) (func(*FileInfo) bool, error) {
+	if op != ":" && op != "=" {
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+	return func(f *FileInfo) bool {
+		a := f.annotation()
+		if a == nil {
+			return false
+		}
+		for _, t := range a.Tags {
+			if strings.EqualFold(t, value) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func parseNoteTerm(op, value string) (func(*FileInfo) bool, error) {
+	if op != ":" && op != "=" {
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+	needle := strings.ToLower(value)
+	return func(f *FileInfo) bool {
+		a := f.annotation()
+		return a != nil && strings.Contains(strings.ToLower(a.Note), needle)
+	}, nil
+}
@@ -0,0 +1,53 @@
+package fileops
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// MinClassConfidence is the confidence from which a file counts as being
+// in a class for queries and name templates.
+const MinClassConfidence = 0.5
+
+// ClassLookup returns the classes assigned to a file with their best
+// confidence, between 0 and 1.
+type ClassLookup func(path string) map[string]float64
+
+var classLookup atomic.Pointer[ClassLookup]
+
+// SetClassLookup installs the source of the class query field.
+func SetClassLookup(lookup ClassLookup) {
+	classLookup.Store(&lookup)
+}
+
+// LookupClasses returns the classes of the file at path from the installed
+// ClassLookup, or nil.
+func LookupClasses(path string) map[string]float64 {
+	if lookup := classLookup.Load(); lookup != nil && *lookup != nil {
+		return (*lookup)(path)
+	}
+	return nil
+}
+
+// classes returns f's classes, looking them up once on first use.
+func (f *FileInfo) classes() map[string]float64 {
+	if f.classesLoaded {
+		return f.classMap
+	}
+	f.classesLoaded = true
+	if !f.IsDirectory && !f.Virtual {
+		f.classMap = LookupClasses(f.Path)
+	}
+	return f.classMap
+}
+
+func parseClassTerm(op, value string) (func(*FileInfo) bool, error) {
+	if op != ":" && op != "=" {
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+	class := strings.ToLower(value)
+	return func(f *FileInfo) bool {
+		return f.classes()[class] >= MinClassConfidence
+	}, nil
+}
@@ -6,10 +6,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"strings"
-	"syscall"
 	"time"
+
+	"file-manager-backend/internal/media"
 )
 
 type FileInfo struct {
@@ -18,9 +18,42 @@ type FileInfo struct {
 	Size        int64     `json:"size"`
 	IsDirectory bool      `json:"isDirectory"`
 	ModTime     time.Time `json:"modTime"`
-	CreateTime  time.Time `json:"createTime"`
-	Permissions string    `json:"permissions"`
-	MimeType    string    `json:"mimeType,omitempty"`
+	AccessTime  time.Time `json:"accessTime"`
+	// ChangeTime is the inode change time (ctime), not the creation time.
+	ChangeTime time.Time `json:"changeTime"`
+	// BirthTime is the creation time reported by the filesystem, or nil
+	// when the platform or filesystem does not record it.
+	BirthTime   *time.Time `json:"birthTime"`
+	Permissions string     `json:"permissions"`
+	MimeType    string     `json:"mimeType,omitempty"`
+	// DetectedMimeType and ExtensionMimeType are the content-sniffed and
+	// extension-implied types; MimeMismatch flags when they disagree.
+	DetectedMimeType  string `json:"detectedMimeType,omitempty"`
+	ExtensionMimeType string `json:"extensionMimeType,omitempty"`
+	MimeMismatch      bool   `json:"mimeMismatch,omitempty"`
+	UID               uint32 `json:"uid"`
+	GID               uint32 `json:"gid"`
+	Owner             string `json:"owner,omitempty"`
+	Group             string `json:"group,omitempty"`
+	Inode             uint64 `json:"inode"`
+	Device            uint64 `json:"device"`
+	Links             uint64 `json:"links"`
+	// Virtual marks entries that live inside an archive rather than on
+	// disk; they have no inode, owner or sniffed MIME type.
+	Virtual bool `json:"virtual,omitempty"`
+	// Media is the embedded photo, audio or video metadata, filled in when
+	// ListOptions.Metadata is set.
+	Media *media.Metadata `json:"media,omitempty"`
+	// Annotation holds the user's tags, rating and note, filled in when
+	// ListOptions.Annotations is set.
+	Annotation *Annotation `json:"annotation,omitempty"`
+
+	mediaMeta        *media.Metadata
+	mediaLoaded      bool
+	classMap         map[string]float64
+	classesLoaded    bool
+	annotationData   *Annotation
+	annotationLoaded bool
 }
 
 type ListOptions struct {
@@ -29,6 +62,13 @@ type ListOptions struct {
 	Exclude      []string
 	RegexPattern string
 	ShowHidden   bool
+	// Query filters entries by metadata; see ParseQuery.
+	Query *Query
+	// Metadata fills in FileInfo.Media for photos, audio and video whose
+	// metadata has already been extracted; see SetMediaLookup.
+	Metadata bool
+	// Annotations fills in FileInfo.Annotation.
+	Annotations bool
 }
 
 var (
@@ -36,6 +76,8 @@ var (
 	ErrPathNotFound     = errors.New("path not found")
 	ErrPermissionDenied = errors.New("permission denied")
 	ErrPatternInvalid   = errors.New("invalid pattern")
+	ErrQueryInvalid     = errors.New("invalid query")
+	ErrAlreadyExists    = errors.New("already exists")
 )
 
 func DefaultListOptions() ListOptions {
@@ -45,18 +87,6 @@ func DefaultListOptions() ListOptions {
 	}
 }
 
-func getCreateTime(info fs.FileInfo) time.Time {
-	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
-		switch runtime.GOOS {
-		case "linux":
-			return time.Unix(stat.Ctim.Sec, stat.Ctim.Nsec)
-		case "darwin", "windows":
-			return time.Unix(0, 0)
-		}
-	}
-	return info.ModTime()
-}
-
 func createFileInfo(d fs.DirEntry, path string) (FileInfo, error) {
 	info, err := d.Info()
 	if err != nil {
@@ -70,18 +100,38 @@ func createFileInfo(d fs.DirEntry, path string) (FileInfo, error) {
 		IsDirectory: d.IsDir(),
 		ModTime:     info.ModTime(),
 		Permissions: info.Mode().String(),
-		CreateTime:  getCreateTime(info),
 	}
+	fillStatInfo(&fileInfo, info)
+	fileInfo.Owner = lookupOwner(fileInfo.UID)
+	fileInfo.Group = lookupGroup(fileInfo.GID)
 
 	if !d.IsDir() {
-		if mime, err := DetectMimeType(path); err == nil {
-			fileInfo.MimeType = mime
+		if d, err := detectMimeInfo(path, info); err == nil {
+			fileInfo.MimeType = d.MimeType
+			fileInfo.DetectedMimeType = d.Detected
+			fileInfo.ExtensionMimeType = d.Extension
+			fileInfo.MimeMismatch = d.Mismatch
 		}
 	}
 
 	return fileInfo, nil
 }
 
+// Stat returns the FileInfo of a single path, as ListFiles would report it.
+func Stat(path string) (FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return FileInfo{}, ErrPathNotFound
+		}
+		if os.IsPermission(err) {
+			return FileInfo{}, ErrPermissionDenied
+		}
+		return FileInfo{}, err
+	}
+	return createFileInfo(fs.FileInfoToDirEntry(info), filepath.Clean(path))
+}
+
 func shouldIncludeFile(name string, isDir bool, opts ListOptions, depth int) bool {
 	// Skip hidden files unless ShowHidden is true
 	if !opts.ShowHidden && strings.HasPrefix(name, ".") {
@@ -123,6 +173,13 @@ func shouldIncludeFile(name string, isDir bool, opts ListOptions, depth int) boo
 	return true
 }
 
+// Matches reports whether an entry with the given name passes the hidden,
+// include, exclude and regex rules of opts. Query is not evaluated since it
+// needs full metadata.
+func (opts ListOptions) Matches(name string, isDir bool) bool {
+	return shouldIncludeFile(name, isDir, opts, 0)
+}
+
 func ListFiles(root string, opts ListOptions) ([]FileInfo, error) {
 	if root == "" {
 		return nil, ErrInvalidPath
@@ -137,7 +194,17 @@ func ListFiles(root string, opts ListOptions) ([]FileInfo, error) {
 		}
 	}
 
+	readDir := os.ReadDir
+	makeInfo := createFileInfo
 	_, err := os.ReadDir(root)
+	if err != nil {
+		var fsys fs.FS
+		var dir string
+		if fsys, dir, err = resolveVirtual(root, err); fsys != nil {
+			readDir = virtualReadDir(fsys, root, dir)
+			makeInfo = createVirtualInfo
+		}
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, ErrPathNotFound
@@ -157,7 +224,7 @@ func ListFiles(root string, opts ListOptions) ([]FileInfo, error) {
 			return nil
 		}
 
-		entries, err := os.ReadDir(currentPath)
+		entries, err := readDir(currentPath)
 		if err != nil {
 			return err
 		}
@@ -182,11 +249,21 @@ func ListFiles(root string, opts ListOptions) ([]FileInfo, error) {
 				continue
 			}
 
-			fileInfo, err := createFileInfo(entry, fullPath)
+			fileInfo, err := makeInfo(entry, fullPath)
 			if err != nil {
 				continue
 			}
 
+			if !opts.Query.Match(&fileInfo) {
+				continue
+			}
+			if opts.Metadata {
+				fileInfo.Media = fileInfo.metadata()
+			}
+			if opts.Annotations {
+				fileInfo.Annotation = fileInfo.annotation()
+			}
+
 			files = append(files, fileInfo)
 		}
 		return nil
@@ -128,6 +128,17 @@ func TestListFiles(t *testing.T) {
 				return true
 			},
 		},
+		{
+			name: "metadata query",
+			opts: ListOptions{
+				Depth: -1,
+				Query: mustParseQuery(t, "type:image/* size<1KB"),
+			},
+			expectedFiles: []string{"dir3/file5.jpg", "file2.jpg"},
+			check: func(files []FileInfo) bool {
+				return true
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -167,6 +178,14 @@ func TestListFiles(t *testing.T) {
 	}
 }
 
+func mustParseQuery(t *testing.T, s string) *Query {
+	q, err := ParseQuery(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
 func TestListFilesErrors(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -4,34 +4,93 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 )
 
+// SyncItem is a file to sync and where it goes below the destination.
+type SyncItem struct {
+	Path string
+	Rel  string
+}
+
+// SyncTarget stores the files of a sync. Dir copies them as they are;
+// other targets, such as encrypted backup destinations, transform them.
+type SyncTarget interface {
+	// Root returns the folder the files are stored in.
+	Root() string
+	// Put stores the file src as rel, replacing what rel held before. It
+	// returns false when rel already holds the same contents.
+	Put(src, rel string) (bool, error)
+}
+
+// Dir is a SyncTarget copying files into a folder unchanged.
+type Dir string
+
+// Root returns the folder itself.
+func (d Dir) Root() string {
+	return string(d)
+}
+
+// Put copies src to rel below d, creating folders as needed and replacing
+// a copy whose contents differ.
+func (d Dir) Put(src, rel string) (bool, error) {
+	target := filepath.Join(string(d), rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return false, MapError(err)
+	}
+	dup, err := sameContents(src, target)
+	if err != nil || dup {
+		return false, err
+	}
+	if err := Copy(src, target, true); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // SyncUniqueFiles copies only unique files from srcDir to dstDir, skipping duplicates.
 func SyncUniqueFiles(srcDir, dstDir string) ([]string, error) {
+	return SyncMatchingFiles(srcDir, Dir(dstDir), nil)
+}
+
+// SyncMatchingFiles is SyncUniqueFiles restricted to files matching q,
+// storing them in dst. A nil query syncs every file.
+func SyncMatchingFiles(srcDir string, dst SyncTarget, q *Query) ([]string, error) {
 	files, err := ListFileNames(srcDir)
 	if err != nil {
 		return nil, err
 	}
-	var copied []string
+	var items []SyncItem
 	for _, name := range files {
 		srcPath := filepath.Join(srcDir, name)
 		info, err := os.Stat(srcPath)
 		if err != nil || info.IsDir() {
 			continue // skip directories and errors
 		}
-		isDup, err := IsDuplicate(srcPath, dstDir)
+		if q != nil {
+			fileInfo, err := createFileInfo(fs.FileInfoToDirEntry(info), srcPath)
+			if err != nil || !q.Match(&fileInfo) {
+				continue
+			}
+		}
+		items = append(items, SyncItem{Path: srcPath, Rel: name})
+	}
+	return SyncFiles(items, dst)
+}
+
+// SyncFiles stores each item in dst and returns the paths, relative to
+// dst, it copied. Items already stored with the same contents are skipped.
+func SyncFiles(items []SyncItem, dst SyncTarget) ([]string, error) {
+	var copied []string
+	for _, item := range items {
+		ok, err := dst.Put(item.Path, item.Rel)
 		if err != nil {
 			return copied, err
 		}
-		if !isDup {
-			dstPath := filepath.Join(dstDir, name)
-			err := CopyFile(srcPath, dstPath)
-			if err != nil {
-				return copied, err
-			}
-			copied = append(copied, name)
+		if ok {
+			copied = append(copied, item.Rel)
 		}
 	}
 	return copied, nil
@@ -50,39 +109,36 @@ func ListFileNames(dir string) ([]string, error) {
 	return files, nil
 }
 
-// CopyFile copies a file from src to dst.
+// CopyFile copies a file or folder from src to dst, replacing an existing
+// dst of the same kind. It is Copy with overwrite set.
 func CopyFile(src, dst string) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer dstFile.Close()
-	_, err = srcFile.WriteTo(dstFile)
-	return err
+	return Copy(src, dst, true)
 }
 
-// MoveFile moves a file from src to dst.
+// MoveFile moves a file or folder from src to dst, replacing an existing
+// dst of the same kind and copying and deleting when they are on different
+// filesystems. It is Move with overwrite set.
 func MoveFile(src, dst string) error {
-	return os.Rename(src, dst)
+	return Move(src, dst, true)
 }
 
-// DeleteFile deletes the specified file.
+// DeleteFile deletes the specified file or folder. It is Delete.
 func DeleteFile(path string) error {
-	return os.Remove(path)
+	return Delete(path)
 }
 
 // IsDuplicate checks if a file with the same name, size, and hash exists in the destination directory.
 func IsDuplicate(src, dstDir string) (bool, error) {
+	return sameContents(src, filepath.Join(dstDir, filepath.Base(src)))
+}
+
+// sameContents reports whether the file dstPath exists with the same size
+// and hash as src.
+func sameContents(src, dstPath string) (bool, error) {
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return false, err
 	}
-	dstPath := filepath.Join(dstDir, filepath.Base(src))
 	dstInfo, err := os.Stat(dstPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -93,19 +149,45 @@ func IsDuplicate(src, dstDir string) (bool, error) {
 	if srcInfo.Size() != dstInfo.Size() {
 		return false, nil
 	}
-	srcHash, err := fileHash(src)
+	srcHash, err := FileHash(src)
 	if err != nil {
 		return false, err
 	}
-	dstHash, err := fileHash(dstPath)
+	dstHash, err := FileHash(dstPath)
 	if err != nil {
 		return false, err
 	}
 	return srcHash == dstHash, nil
 }
 
-// fileHash returns the SHA256 hash of a file.
-func fileHash(path string) (string, error) {
+// FileHash returns the SHA256 hash of a file. Results are cached by inode
+// and mtime, so unchanged files are read only once.
+func FileHash(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return CurrentMetaCache().Hash(path, info)
+}
+
+// KnownHash returns the hash of the file at path when the cache already
+// holds it, or "" otherwise. It never reads the file, so it is cheap enough
+// for listings.
+func KnownHash(path string) string {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return ""
+	}
+	key, ok := MetaKeyOf(info)
+	if !ok {
+		return ""
+	}
+	meta, _ := CurrentMetaCache().lookup(key)
+	return meta.Hash
+}
+
+// hashFile computes the SHA256 hash of a file.
+func hashFile(path string) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return "", err
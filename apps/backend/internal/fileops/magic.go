@@ -0,0 +1,430 @@
+package fileops
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// sniffLen is how much of a file is read for signature matching. It covers
+// the tar header at 257 and TIFF IFD0 of camera RAW files.
+const sniffLen = 4096
+
+// signature matches a fixed byte sequence at an offset.
+type signature struct {
+	offset int
+	magic  []byte
+	mime   string
+}
+
+// signatures are checked in order; the first match wins. Container formats
+// that need a closer look (RIFF, ISO BMFF, TIFF, zip, EBML) are handled by
+// the sniffers in containerSniffers instead.
+var signatures = []signature{
+	// Images
+	{0, []byte("\xFF\xD8\xFF"), "image/jpeg"},
+	{0, []byte("\x89PNG\r\n\x1A\n"), "image/png"},
+	{0, []byte("GIF87a"), "image/gif"},
+	{0, []byte("GIF89a"), "image/gif"},
+	{0, []byte("\x00\x00\x01\x00"), "image/vnd.microsoft.icon"},
+	{0, []byte("8BPS"), "image/vnd.adobe.photoshop"},
+	{0, []byte("\xFF\x0A"), "image/jxl"},
+	{0, []byte("\x00\x00\x00\x0CJXL \x0D\x0A\x87\x0A"), "image/jxl"},
+	{0, []byte("IIRO"), "image/x-olympus-orf"},
+	{0, []byte("IIRS"), "image/x-olympus-orf"},
+	{0, []byte("MMOR"), "image/x-olympus-orf"},
+	{0, []byte("IIU\x00"), "image/x-panasonic-rw2"},
+	{0, []byte("FUJIFILMCCD-RAW"), "image/x-fuji-raf"},
+
+	// Audio and video
+	{0, []byte("ID3"), "audio/mpeg"},
+	{0, []byte("fLaC"), "audio/flac"},
+	{0, []byte("MThd"), "audio/midi"},
+	{0, []byte("#!AMR"), "audio/amr"},
+	{0, []byte("FLV\x01"), "video/x-flv"},
+	{0, []byte("\x00\x00\x01\xBA"), "video/mpeg"},
+	{0, []byte("\x00\x00\x01\xB3"), "video/mpeg"},
+	{0, []byte("\x30\x26\xB2\x75\x8E\x66\xCF\x11"), "video/x-ms-asf"},
+
+	// Documents and ebooks
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("%!PS"), "application/postscript"},
+	{0, []byte("{\\rtf"), "application/rtf"},
+	{0, []byte("AT&TFORM"), "image/vnd.djvu"},
+	{60, []byte("BOOKMOBI"), "application/x-mobipocket-ebook"},
+	{0, []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), "application/x-ole-storage"},
+	{0, []byte("SQLite format 3\x00"), "application/vnd.sqlite3"},
+
+	// Archives and compression
+	{0, []byte("\x1F\x8B"), "application/gzip"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("\xFD7zXZ\x00"), "application/x-xz"},
+	{0, []byte("\x28\xB5\x2F\xFD"), "application/zstd"},
+	{0, []byte("7z\xBC\xAF\x27\x1C"), "application/x-7z-compressed"},
+	{0, []byte("Rar!\x1A\x07"), "application/vnd.rar"},
+	{0, []byte("\x04\x22\x4D\x18"), "application/x-lz4"},
+	{0, []byte("MSCF"), "application/vnd.ms-cab-compressed"},
+	{0, []byte("!<arch>\ndebian"), "application/vnd.debian.binary-package"},
+	{0, []byte("\xED\xAB\xEE\xDB"), "application/x-rpm"},
+	{257, []byte("ustar"), "application/x-tar"},
+
+	// Executables
+	{0, []byte("\xFE\xED\xFA\xCE"), "application/x-mach-binary"},
+	{0, []byte("\xFE\xED\xFA\xCF"), "application/x-mach-binary"},
+	{0, []byte("\xCE\xFA\xED\xFE"), "application/x-mach-binary"},
+	{0, []byte("\xCF\xFA\xED\xFE"), "application/x-mach-binary"},
+	{0, []byte("\x00asm"), "application/wasm"},
+	{0, []byte("dex\n"), "application/vnd.android.dex"},
+
+	// Fonts
+	{0, []byte("wOFF"), "font/woff"},
+	{0, []byte("wOF2"), "font/woff2"},
+	{0, []byte("OTTO"), "font/otf"},
+	{0, []byte("\x00\x01\x00\x00\x00"), "font/ttf"},
+}
+
+// containerSniffers inspect formats whose type depends on more than a
+// fixed prefix. Each returns "" when the data is not its format.
+var containerSniffers = []func(header []byte, r io.ReaderAt, size int64) string{
+	sniffRIFF,
+	sniffISOBMFF,
+	sniffTIFF,
+	sniffEBML,
+	sniffOgg,
+	sniffZip,
+	sniffELF,
+	sniffCafeBabe,
+	sniffMPEGAudio,
+	sniffMPEGTS,
+	sniffISO9660,
+	sniffAIFF,
+	sniffBMP,
+	sniffPE,
+}
+
+// sniffContent identifies a file from its leading bytes. It returns "" when
+// no signature matches, leaving text detection to the caller.
+func sniffContent(r io.ReaderAt, size int64) string {
+	header := make([]byte, sniffLen)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+	if len(header) == 0 {
+		return ""
+	}
+
+	for _, sniff := range containerSniffers {
+		if mimeType := sniff(header, r, size); mimeType != "" {
+			return mimeType
+		}
+	}
+	for _, sig := range signatures {
+		if len(header) >= sig.offset+len(sig.magic) && bytes.Equal(header[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return sig.mime
+		}
+	}
+	return ""
+}
+
+func sniffRIFF(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) < 12 || !bytes.HasPrefix(h, []byte("RIFF")) {
+		return ""
+	}
+	switch string(h[8:12]) {
+	case "WEBP":
+		return "image/webp"
+	case "WAVE":
+		return "audio/wav"
+	case "AVI ":
+		return "video/x-msvideo"
+	}
+	return ""
+}
+
+func sniffAIFF(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) >= 12 && bytes.HasPrefix(h, []byte("FORM")) {
+		switch string(h[8:12]) {
+		case "AIFF", "AIFC":
+			return "audio/aiff"
+		}
+	}
+	return ""
+}
+
+// sniffISOBMFF classifies MP4, QuickTime, HEIF/AVIF and Canon CR3 files by
+// the major and compatible brands of their ftyp box.
+func sniffISOBMFF(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) < 12 || string(h[4:8]) != "ftyp" {
+		return ""
+	}
+	boxSize := int(binary.BigEndian.Uint32(h[0:4]))
+	if boxSize < 16 || boxSize > len(h) {
+		boxSize = len(h)
+	}
+	brands := []string{string(h[8:12])}
+	for i := 16; i+4 <= boxSize; i += 4 {
+		brands = append(brands, string(h[i:i+4]))
+	}
+
+	for _, brand := range brands {
+		switch brand {
+		case "crx ":
+			return "image/x-canon-cr3"
+		case "heic", "heix", "heim", "heis":
+			return "image/heic"
+		case "hevc", "hevx":
+			return "image/heic-sequence"
+		case "avif":
+			return "image/avif"
+		case "avis":
+			return "image/avif-sequence"
+		}
+	}
+	switch brands[0] {
+	case "mif1", "msf1":
+		return "image/heif"
+	case "qt  ":
+		return "video/quicktime"
+	case "M4A ", "M4B ", "M4P ":
+		return "audio/mp4"
+	case "3gp4", "3gp5", "3gp6", "3ge6", "3gg6":
+		return "video/3gpp"
+	case "3g2a", "3g2b", "3g2c":
+		return "video/3gpp2"
+	}
+	return "video/mp4"
+}
+
+// sniffTIFF recognises TIFF and the camera RAW formats built on it by
+// looking at IFD0's Make and DNGVersion tags.
+func sniffTIFF(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) < 8 {
+		return ""
+	}
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(h, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(h, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return ""
+	}
+	if len(h) >= 11 && string(h[8:10]) == "CR" && h[10] == 2 {
+		return "image/x-canon-cr2"
+	}
+
+	camera := ""
+	ifd := int(order.Uint32(h[4:8]))
+	if ifd+2 <= len(h) {
+		count := int(order.Uint16(h[ifd:]))
+		for i := 0; i < count; i++ {
+			entry := ifd + 2 + i*12
+			if entry+12 > len(h) {
+				break
+			}
+			tag := order.Uint16(h[entry:])
+			switch tag {
+			case 0xC612: // DNGVersion
+				return "image/x-adobe-dng"
+			case 0x010F: // Make
+				n := int(order.Uint32(h[entry+4:]))
+				off := entry + 8
+				if n > 4 {
+					off = int(order.Uint32(h[entry+8:]))
+				}
+				if off >= 0 && off+n <= len(h) {
+					camera = strings.ToUpper(strings.TrimRight(string(h[off:off+n]), "\x00 "))
+				}
+			}
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(camera, "NIKON"):
+		return "image/x-nikon-nef"
+	case strings.HasPrefix(camera, "SONY"):
+		return "image/x-sony-arw"
+	case strings.HasPrefix(camera, "PENTAX"), strings.HasPrefix(camera, "RICOH"):
+		return "image/x-pentax-pef"
+	case strings.HasPrefix(camera, "CANON"):
+		return "image/x-canon-cr2"
+	}
+	return "image/tiff"
+}
+
+// sniffEBML separates WebM from other Matroska files by the DocType.
+func sniffEBML(h []byte, _ io.ReaderAt, _ int64) string {
+	if !bytes.HasPrefix(h, []byte("\x1A\x45\xDF\xA3")) {
+		return ""
+	}
+	head := h
+	if len(head) > 64 {
+		head = head[:64]
+	}
+	if bytes.Contains(head, []byte("webm")) {
+		return "video/webm"
+	}
+	return "video/x-matroska"
+}
+
+func sniffOgg(h []byte, _ io.ReaderAt, _ int64) string {
+	if !bytes.HasPrefix(h, []byte("OggS")) {
+		return ""
+	}
+	head := h
+	if len(head) > 64 {
+		head = head[:64]
+	}
+	switch {
+	case bytes.Contains(head, []byte("OpusHead")):
+		return "audio/opus"
+	case bytes.Contains(head, []byte("\x01vorbis")):
+		return "audio/ogg"
+	case bytes.Contains(head, []byte("\x80theora")):
+		return "video/ogg"
+	}
+	return "application/ogg"
+}
+
+// sniffZip peeks into zip archives to identify OOXML, OpenDocument, EPUB,
+// Java and Android packages.
+func sniffZip(h []byte, r io.ReaderAt, size int64) string {
+	if !bytes.HasPrefix(h, []byte("PK\x03\x04")) {
+		return ""
+	}
+	// ODF and EPUB store their type uncompressed as the first entry.
+	if len(h) > 38 && string(h[30:38]) == "mimetype" {
+		nameLen := int(binary.LittleEndian.Uint16(h[26:28]))
+		extraLen := int(binary.LittleEndian.Uint16(h[28:30]))
+		dataLen := int(binary.LittleEndian.Uint32(h[18:22]))
+		start := 30 + nameLen + extraLen
+		if nameLen == 8 && dataLen > 0 && dataLen < 128 && start+dataLen <= len(h) {
+			return string(h[start : start+dataLen])
+		}
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return "application/zip"
+	}
+	hasContentTypes := false
+	for _, f := range zr.File {
+		switch {
+		case f.Name == "[Content_Types].xml":
+			hasContentTypes = true
+		case strings.HasPrefix(f.Name, "word/"):
+			return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		case strings.HasPrefix(f.Name, "xl/"):
+			return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		case strings.HasPrefix(f.Name, "ppt/"):
+			return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+		case f.Name == "AndroidManifest.xml":
+			return "application/vnd.android.package-archive"
+		case f.Name == "META-INF/MANIFEST.MF":
+			return "application/java-archive"
+		case f.Name == "mimetype":
+			if rc, err := f.Open(); err == nil {
+				buf := make([]byte, 128)
+				n, _ := io.ReadFull(rc, buf)
+				rc.Close()
+				if mimeType := strings.TrimSpace(string(buf[:n])); strings.Contains(mimeType, "/") {
+					return mimeType
+				}
+			}
+		}
+	}
+	if hasContentTypes {
+		return "application/vnd.ms-xpsdocument"
+	}
+	return "application/zip"
+}
+
+func sniffELF(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) < 18 || !bytes.HasPrefix(h, []byte("\x7FELF")) {
+		return ""
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if h[5] == 2 {
+		order = binary.BigEndian
+	}
+	switch order.Uint16(h[16:18]) {
+	case 1:
+		return "application/x-object"
+	case 3:
+		return "application/x-sharedlib"
+	case 4:
+		return "application/x-coredump"
+	}
+	return "application/x-executable"
+}
+
+// sniffCafeBabe tells Java class files from Mach-O universal binaries,
+// which share the same magic number.
+func sniffCafeBabe(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) < 8 || !bytes.HasPrefix(h, []byte("\xCA\xFE\xBA\xBE")) {
+		return ""
+	}
+	if binary.BigEndian.Uint16(h[6:8]) >= 45 {
+		return "application/java-vm"
+	}
+	return "application/x-mach-binary"
+}
+
+// sniffMPEGAudio recognises MP3 frames without an ID3 tag and raw AAC
+// ADTS streams.
+func sniffMPEGAudio(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) < 3 || h[0] != 0xFF {
+		return ""
+	}
+	if h[1]&0xF6 == 0xF0 {
+		return "audio/aac"
+	}
+	layer := (h[1] >> 1) & 0x3
+	bitrate := h[2] >> 4
+	if h[1]&0xE0 == 0xE0 && layer != 0 && bitrate != 0 && bitrate != 0xF {
+		return "audio/mpeg"
+	}
+	return ""
+}
+
+// sniffBMP checks the reserved header fields, since "BM" alone is common
+// at the start of text.
+func sniffBMP(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) >= 14 && bytes.HasPrefix(h, []byte("BM")) && bytes.Equal(h[6:10], []byte{0, 0, 0, 0}) {
+		return "image/bmp"
+	}
+	return ""
+}
+
+// sniffPE separates Windows PE images from plain DOS executables.
+func sniffPE(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) < 64 || !bytes.HasPrefix(h, []byte("MZ")) {
+		return ""
+	}
+	off := int(binary.LittleEndian.Uint32(h[0x3C:0x40]))
+	if off+4 <= len(h) && bytes.Equal(h[off:off+4], []byte("PE\x00\x00")) {
+		return "application/vnd.microsoft.portable-executable"
+	}
+	return "application/x-dosexec"
+}
+
+// sniffMPEGTS requires the 0x47 sync byte at three consecutive packets.
+func sniffMPEGTS(h []byte, _ io.ReaderAt, _ int64) string {
+	if len(h) >= 3*188 && h[0] == 0x47 && h[188] == 0x47 && h[376] == 0x47 {
+		return "video/mp2t"
+	}
+	return ""
+}
+
+func sniffISO9660(_ []byte, r io.ReaderAt, size int64) string {
+	if size < 32774 {
+		return ""
+	}
+	buf := make([]byte, 5)
+	if _, err := r.ReadAt(buf, 32769); err == nil && string(buf) == "CD001" {
+		return "application/x-iso9660-image"
+	}
+	return ""
+}
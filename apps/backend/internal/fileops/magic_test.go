@@ -0,0 +1,122 @@
+package fileops
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func zipBytes(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		method := zip.Deflate
+		if files[i] == "mimetype" {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: files[i], Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(files[i+1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tiffWithMake builds a little-endian TIFF header whose IFD0 holds a Make tag.
+func tiffWithMake(camera string) []byte {
+	b := make([]byte, 64)
+	copy(b, "II*\x00")
+	binary.LittleEndian.PutUint32(b[4:], 8)
+	binary.LittleEndian.PutUint16(b[8:], 1)
+	binary.LittleEndian.PutUint16(b[10:], 0x010F)
+	binary.LittleEndian.PutUint16(b[12:], 2)
+	binary.LittleEndian.PutUint32(b[14:], uint32(len(camera)+1))
+	binary.LittleEndian.PutUint32(b[18:], 32)
+	copy(b[32:], camera)
+	return b
+}
+
+func ftyp(major string, compatible ...string) []byte {
+	b := make([]byte, 16+4*len(compatible))
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	copy(b[4:], "ftyp"+major+"\x00\x00\x00\x00")
+	for i, c := range compatible {
+		copy(b[16+4*i:], c)
+	}
+	return b
+}
+
+func TestDetectMime(t *testing.T) {
+	elf := make([]byte, 64)
+	copy(elf, "\x7FELF\x02\x01\x01")
+	elf[16] = 2
+
+	tar := make([]byte, 512)
+	copy(tar, "file.txt")
+	copy(tar[257:], "ustar\x0000")
+
+	tests := []struct {
+		name         string
+		content      []byte
+		wantMime     string
+		wantDetected string
+		wantMismatch bool
+	}{
+		{"photo.txt", []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF"), "image/jpeg", "image/jpeg", true},
+		{"photo.jpg", []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF"), "image/jpeg", "image/jpeg", false},
+		{"image.png", []byte("\x89PNG\r\n\x1A\n\x00\x00"), "image/png", "image/png", false},
+		{"IMG_0001.CR2", append([]byte("II*\x00\x10\x00\x00\x00CR\x02\x00"), make([]byte, 16)...), "image/x-canon-cr2", "image/x-canon-cr2", false},
+		{"DSC_0001.NEF", tiffWithMake("NIKON CORPORATION"), "image/x-nikon-nef", "image/x-nikon-nef", false},
+		{"DSC01234.ARW", tiffWithMake("SONY"), "image/x-sony-arw", "image/x-sony-arw", false},
+		{"IMG_1234.HEIC", ftyp("heic", "mif1", "heic"), "image/heic", "image/heic", false},
+		{"clip.mp4", ftyp("isom", "isom", "avc1"), "video/mp4", "video/mp4", false},
+		{"clip.mov", ftyp("qt  ", "qt  "), "video/quicktime", "video/quicktime", false},
+		{"clip.webm", []byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x84webm"), "video/webm", "video/webm", false},
+		{"report.docx", zipBytes(t, "[Content_Types].xml", "<Types/>", "word/document.xml", "<w:document/>"),
+			"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+			"application/vnd.openxmlformats-officedocument.wordprocessingml.document", false},
+		{"sheet.bin", zipBytes(t, "[Content_Types].xml", "<Types/>", "xl/workbook.xml", "<workbook/>"),
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", true},
+		{"letter.odt", zipBytes(t, "mimetype", "application/vnd.oasis.opendocument.text", "content.xml", "<x/>"),
+			"application/vnd.oasis.opendocument.text", "application/vnd.oasis.opendocument.text", false},
+		{"book.epub", zipBytes(t, "mimetype", "application/epub+zip", "META-INF/container.xml", "<x/>"),
+			"application/epub+zip", "application/epub+zip", false},
+		{"archive.tar", tar, "application/x-tar", "application/x-tar", false},
+		{"backup.gz", []byte("\x1F\x8B\x08\x00"), "application/gzip", "application/gzip", false},
+		{"program", elf, "application/x-executable", "application/x-executable", false},
+		{"main.go", []byte("package main\n"), "text/x-go", "text/plain; charset=utf-8", false},
+		{"picture.png", []byte("just some text"), "image/png", "text/plain; charset=utf-8", true},
+		{"empty.json", nil, "application/json", "", false},
+	}
+
+	dir := t.TempDir()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			if err := os.WriteFile(path, tc.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			d, err := DetectMime(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.MimeType != tc.wantMime {
+				t.Errorf("MimeType = %q, want %q", d.MimeType, tc.wantMime)
+			}
+			if d.Detected != tc.wantDetected {
+				t.Errorf("Detected = %q, want %q", d.Detected, tc.wantDetected)
+			}
+			if d.Mismatch != tc.wantMismatch {
+				t.Errorf("Mismatch = %v, want %v (extension %q)", d.Mismatch, tc.wantMismatch, d.Extension)
+			}
+		})
+	}
+}
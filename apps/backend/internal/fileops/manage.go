@@ -0,0 +1,360 @@
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Rename gives path a new name in the same folder and returns the new path.
+func Rename(path, newName string) (string, error) {
+	if path == "" || !ValidName(newName) {
+		return "", ErrInvalidPath
+	}
+	path = filepath.Clean(path)
+	dst := filepath.Join(filepath.Dir(path), newName)
+	if dst == path {
+		return dst, nil
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return "", MapError(err)
+	}
+	if err := checkTarget(path, dst, false); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, dst); err != nil {
+		return "", MapError(err)
+	}
+	notifyMoved(path, dst)
+	return dst, nil
+}
+
+// Move moves src, which may be a folder, to dst. When src and dst are on
+// different filesystems the tree is copied and then removed. An existing
+// dst is replaced only when overwrite is set; it stays in place until the
+// moved item is complete, so a failed move leaves it untouched.
+func Move(src, dst string, overwrite bool) error {
+	src, dst = filepath.Clean(src), filepath.Clean(dst)
+	if _, err := os.Lstat(src); err != nil {
+		return MapError(err)
+	}
+	if err := checkTarget(src, dst, overwrite); err != nil {
+		return err
+	}
+	replacing, err := checkReplace(src, dst)
+	if err != nil {
+		return err
+	}
+	if !replacing {
+		if err := moveTree(src, dst); err != nil {
+			return MapError(err)
+		}
+		notifyMoved(src, dst)
+		return nil
+	}
+
+	tmp := tempSibling(dst)
+	if err := moveTree(src, tmp); err != nil {
+		return MapError(err)
+	}
+	if err := swapIn(tmp, dst); err != nil {
+		if rerr := moveTree(tmp, src); rerr != nil {
+			return fmt.Errorf("%w; the moved item was left at %s", MapError(err), tmp)
+		}
+		return MapError(err)
+	}
+	notifyMoved(src, dst)
+	return nil
+}
+
+// Copy copies src, which may be a folder, to dst, preserving permissions,
+// modification times and symbolic links. An existing dst is replaced only
+// when overwrite is set; the copy is made under a temporary name first,
+// so a failed copy leaves it untouched.
+func Copy(src, dst string, overwrite bool) error {
+	src, dst = filepath.Clean(src), filepath.Clean(dst)
+	if _, err := os.Lstat(src); err != nil {
+		return MapError(err)
+	}
+	if err := checkTarget(src, dst, overwrite); err != nil {
+		return err
+	}
+	replacing, err := checkReplace(src, dst)
+	if err != nil {
+		return err
+	}
+	if !replacing {
+		return MapError(copyTree(src, dst))
+	}
+
+	return Replace(dst, func(tmp string) error {
+		return MapError(copyTree(src, tmp))
+	})
+}
+
+// Replace creates a new item through create at a temporary name next to
+// dst and then swaps it in for dst, so dst stays untouched when create
+// fails. Whatever create left behind is removed on failure.
+func Replace(dst string, create func(tmp string) error) error {
+	tmp := tempSibling(dst)
+	if err := create(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := swapIn(tmp, dst); err != nil {
+		os.RemoveAll(tmp)
+		return MapError(err)
+	}
+	return nil
+}
+
+// MakeDir creates a folder and returns the folders it created, outermost
+// first. With parents set, missing parent folders are created as well and
+// an existing folder is not an error, like mkdir -p.
+func MakeDir(path string, parents bool) ([]string, error) {
+	if path == "" {
+		return nil, ErrInvalidPath
+	}
+	path = filepath.Clean(path)
+	if !parents {
+		if err := os.Mkdir(path, 0o755); err != nil {
+			return nil, MapError(err)
+		}
+		return []string{path}, nil
+	}
+
+	var missing []string
+	for dir := path; ; {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, MapError(err)
+	}
+	return missing, nil
+}
+
+// Delete removes path and, for a folder, everything inside it.
+func Delete(path string) error {
+	if path == "" {
+		return ErrInvalidPath
+	}
+	path = filepath.Clean(path)
+	if path == filepath.Dir(path) {
+		return ErrInvalidPath // refuse to delete a filesystem root
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return MapError(err)
+	}
+	return MapError(os.RemoveAll(path))
+}
+
+// checkTarget rejects moving or copying a path onto itself or into its own
+// subtree, and existing targets unless overwrite is set.
+func checkTarget(src, dst string, overwrite bool) error {
+	if src == dst || IsWithin(dst, src) {
+		return ErrInvalidPath
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		if !overwrite {
+			return ErrAlreadyExists
+		}
+	} else if !os.IsNotExist(err) {
+		return MapError(err)
+	}
+	if info, err := os.Stat(filepath.Dir(dst)); err != nil {
+		return MapError(err)
+	} else if !info.IsDir() {
+		return ErrInvalidPath
+	}
+	return nil
+}
+
+// checkReplace reports whether dst exists and would be replaced. A folder
+// is only replaced by a folder and a file by a file.
+func checkReplace(src, dst string) (bool, error) {
+	dstInfo, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, MapError(err)
+	}
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return false, MapError(err)
+	}
+	if srcInfo.IsDir() != dstInfo.IsDir() {
+		return false, ErrAlreadyExists
+	}
+	return true, nil
+}
+
+// swapIn replaces dst with the complete item at tmp. A file takes one
+// rename. A folder cannot be renamed over a non-empty one, so dst is moved
+// aside first and put back if tmp cannot take its place. tmp is left alone
+// on failure.
+func swapIn(tmp, dst string) error {
+	info, err := os.Lstat(tmp)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return os.Rename(tmp, dst)
+	}
+	aside := tempSibling(dst)
+	if err := os.Rename(dst, aside); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Rename(aside, dst)
+		return err
+	}
+	return os.RemoveAll(aside)
+}
+
+// tempSibling returns an unused hidden name next to path, on the same
+// filesystem so renaming between the two is atomic.
+func tempSibling(path string) string {
+	dir, name := filepath.Split(path)
+	for {
+		tmp := filepath.Join(dir, fmt.Sprintf(".%s.%x.tmp", name, rand.Uint32()))
+		if _, err := os.Lstat(tmp); err != nil {
+			return tmp
+		}
+	}
+}
+
+// moveTree renames src to dst, falling back to copy and delete when they
+// are on different devices.
+func moveTree(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyTree(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies a file, symbolic link or folder.
+func copyTree(src, dst string) error {
+	// Folders are created writable so their contents can be copied in;
+	// their permissions and times are restored once everything is copied.
+	type dirAttrs struct {
+		path string
+		info fs.FileInfo
+	}
+	var dirs []dirAttrs
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if err := os.Mkdir(target, info.Mode().Perm()|0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirAttrs{target, info})
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			if err := copyRegular(path, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		}
+		return nil // sockets, devices and pipes are skipped
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(d.path, d.info.ModTime(), d.info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyRegular copies one file's contents, failing if dst exists.
+func copyRegular(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// IsWithin reports whether path lies strictly inside dir. Names that
+// merely start with two dots, such as "..notes.txt", are inside.
+func IsWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ValidName reports whether name is a single path component that is not
+// "." or "..", so joining it to a folder cannot escape that folder.
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`+"\x00")
+}
+
+// MapError converts os errors to the package's error values, so callers
+// outside the package report missing files and permission problems alike.
+func MapError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrPathNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrPermissionDenied
+	case errors.Is(err, fs.ErrExist):
+		return ErrAlreadyExists
+	}
+	return err
+}
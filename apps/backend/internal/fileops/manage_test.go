@@ -0,0 +1,195 @@
+package fileops
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	writeTree(t, src, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "sub"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(root, "dst")
+	if err := Copy(src, dst, false); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dst, "sub", "b.txt")); err != nil || string(b) != "b" {
+		t.Errorf("sub/b.txt = %q, %v", b, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil || link != "a.txt" {
+		t.Errorf("link = %q, %v", link, err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "sub")); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("sub mtime not preserved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "a.txt")); err != nil {
+		t.Error("copy removed the source")
+	}
+
+	if err := Copy(src, dst, false); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("copy onto existing: err = %v", err)
+	}
+	if err := Copy(src, filepath.Join(src, "sub", "inside"), false); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("copy into itself: err = %v", err)
+	}
+}
+
+func TestMoveOverwrite(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "new", "dst/a.txt": "old"})
+	dst := filepath.Join(root, "dst", "a.txt")
+
+	if err := Move(filepath.Join(root, "a.txt"), dst, false); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("move without overwrite: err = %v", err)
+	}
+	if err := Move(filepath.Join(root, "a.txt"), dst, true); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "new" {
+		t.Errorf("dst = %q, want new", b)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a.txt")); !os.IsNotExist(err) {
+		t.Error("source still exists after move")
+	}
+	if err := Move(filepath.Join(root, "missing"), dst, true); !errors.Is(err, ErrPathNotFound) {
+		t.Errorf("move missing: err = %v", err)
+	}
+}
+
+func TestRenameMakeDirDelete(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "a", "b.txt": "b", "dir/c.txt": "c"})
+
+	if _, err := Rename(filepath.Join(root, "a.txt"), "b.txt"); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("rename onto existing: err = %v", err)
+	}
+	if _, err := Rename(filepath.Join(root, "a.txt"), "../x.txt"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("rename with separator: err = %v", err)
+	}
+	newPath, err := Rename(filepath.Join(root, "a.txt"), "z.txt")
+	if err != nil || newPath != filepath.Join(root, "z.txt") {
+		t.Fatalf("Rename = %q, %v", newPath, err)
+	}
+
+	if _, err := MakeDir(filepath.Join(root, "dir"), false); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("mkdir existing: err = %v", err)
+	}
+	created, err := MakeDir(filepath.Join(root, "x", "y"), true)
+	if err != nil {
+		t.Errorf("mkdir -p: %v", err)
+	}
+	if len(created) != 2 || created[0] != filepath.Join(root, "x") {
+		t.Errorf("mkdir -p created %v", created)
+	}
+	if created, err := MakeDir(filepath.Join(root, "x", "y"), true); err != nil || len(created) != 0 {
+		t.Errorf("mkdir -p existing = %v, %v", created, err)
+	}
+
+	if err := Delete(filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "dir")); !os.IsNotExist(err) {
+		t.Error("folder still exists after delete")
+	}
+	if err := Delete(filepath.Join(root, "dir")); !errors.Is(err, ErrPathNotFound) {
+		t.Errorf("delete missing: err = %v", err)
+	}
+}
+
+func TestOverwriteFolder(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"src/new.txt":     "new",
+		"dst/src/old.txt": "old",
+		"copy/new.txt":    "copied",
+	})
+	dst := filepath.Join(root, "dst", "src")
+	if err := Move(filepath.Join(root, "src"), dst, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "old.txt")); !os.IsNotExist(err) {
+		t.Error("old contents survived the overwrite")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dst, "new.txt")); string(b) != "new" {
+		t.Errorf("new.txt = %q", b)
+	}
+
+	if err := Copy(filepath.Join(root, "copy"), dst, true); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dst, "new.txt")); string(b) != "copied" {
+		t.Errorf("new.txt after copy = %q", b)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "dst"))
+	if len(entries) != 1 {
+		t.Errorf("temporary names left behind: %v", entries)
+	}
+}
+
+func TestFailedOverwriteKeepsTarget(t *testing.T) {
+	root := t.TempDir()
+	// The temporary name next to a 250-byte name is too long, so the
+	// copy fails before the target could be touched.
+	name := strings.Repeat("n", 250)
+	writeTree(t, root, map[string]string{"src.txt": "new", name: "old"})
+	dst := filepath.Join(root, name)
+
+	if err := Copy(filepath.Join(root, "src.txt"), dst, true); err == nil {
+		t.Fatal("expected the copy to fail")
+	}
+	if err := Move(filepath.Join(root, "src.txt"), dst, true); err == nil {
+		t.Fatal("expected the move to fail")
+	}
+	if b, err := os.ReadFile(dst); err != nil || string(b) != "old" {
+		t.Errorf("target after failed overwrite = %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "src.txt")); err != nil {
+		t.Errorf("source lost after failed move: %v", err)
+	}
+}
+
+func TestLegacyFunctionsWrapManage(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "a", "b.txt": "b", "dir/c.txt": "c"})
+
+	if err := CopyFile(filepath.Join(root, "a.txt"), filepath.Join(root, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "b.txt")); string(b) != "a" {
+		t.Errorf("b.txt = %q after CopyFile", b)
+	}
+	if err := CopyFile(filepath.Join(root, "a.txt"), filepath.Join(root, "dir")); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("CopyFile onto a folder: err = %v", err)
+	}
+	if err := DeleteFile(filepath.Join(root, "dir")); err != nil {
+		t.Errorf("DeleteFile on a folder: %v", err)
+	}
+	if err := MoveFile(filepath.Join(root, "missing"), filepath.Join(root, "x")); !errors.Is(err, ErrPathNotFound) {
+		t.Errorf("MoveFile missing: err = %v", err)
+	}
+}
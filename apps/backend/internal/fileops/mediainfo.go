@@ -0,0 +1,188 @@
+package fileops
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"file-manager-backend/internal/media"
+)
+
+// MediaLookup returns the embedded metadata of a photo, audio or video
+// file, or nil when there is none. It is called for every listed entry,
+// so it must not read the file.
+type MediaLookup func(path string) *media.Metadata
+
+var mediaLookup atomic.Pointer[MediaLookup]
+
+// SetMediaLookup installs the source of FileInfo.Media and of the media
+// query fields. Without one those fields never match.
+func SetMediaLookup(lookup MediaLookup) {
+	mediaLookup.Store(&lookup)
+}
+
+// LookupMedia returns the embedded metadata of the file at path from the
+// installed MediaLookup, or nil.
+func LookupMedia(path string) *media.Metadata {
+	if lookup := mediaLookup.Load(); lookup != nil && *lookup != nil {
+		return (*lookup)(path)
+	}
+	return nil
+}
+
+// metadata returns f's media metadata, looking it up once on first use.
+func (f *FileInfo) metadata() *media.Metadata {
+	if f.mediaLoaded {
+		return f.mediaMeta
+	}
+	f.mediaLoaded = true
+	if f.IsDirectory || f.Virtual {
+		return nil
+	}
+	f.mediaMeta = LookupMedia(f.Path)
+	return f.mediaMeta
+}
+
+// takenTime returns when a photo was taken or a video recorded, or nil
+// when unknown.
+func takenTime(f *FileInfo) *time.Time {
+	if m := f.metadata(); m != nil && !m.DateTime.IsZero() {
+		return &m.DateTime
+	}
+	return nil
+}
+
+// mediaText builds a parser for a text field of the media metadata, which
+// matches case-insensitively on a substring.
+func mediaText(get func(*media.Metadata) string) func(op, value string) (func(*FileInfo) bool, error) {
+	return func(op, value string) (func(*FileInfo) bool, error) {
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("unsupported operator %q", op)
+		}
+		needle := strings.ToLower(value)
+		return func(f *FileInfo) bool {
+			m := f.metadata()
+			return m != nil && strings.Contains(strings.ToLower(get(m)), needle)
+		}, nil
+	}
+}
+
+// mediaNumber builds a parser for a numeric field of the media metadata.
+func mediaNumber(get func(*media.Metadata) float64, parse func(string) (float64, error)) func(op, value string) (func(*FileInfo) bool, error) {
+	return numberTerm(func(f *FileInfo) float64 {
+		if m := f.metadata(); m != nil {
+			return get(m)
+		}
+		return 0
+	}, parse)
+}
+
+// numberTerm builds a parser for a numeric field, accepting comparisons
+// and lo..hi ranges. parse converts a bound to the field's unit. Files
+// where get returns 0 lack the field and never match.
+func numberTerm(get func(*FileInfo) float64, parse func(string) (float64, error)) func(op, value string) (func(*FileInfo) bool, error) {
+	return func(op, value string) (func(*FileInfo) bool, error) {
+		a, b, isRange := strings.Cut(value, "..")
+		if !isRange || (op != ":" && op != "=") {
+			n, err := parse(value)
+			if err != nil {
+				return nil, err
+			}
+			return func(f *FileInfo) bool {
+				v := get(f)
+				return v != 0 && compareFloat(v, op, n)
+			}, nil
+		}
+
+		lo, hi := 0.0, -1.0
+		var err error
+		if a != "" {
+			if lo, err = parse(a); err != nil {
+				return nil, err
+			}
+		}
+		if b != "" {
+			if hi, err = parse(b); err != nil {
+				return nil, err
+			}
+		}
+		return func(f *FileInfo) bool {
+			v := get(f)
+			return v != 0 && v >= lo && (hi < 0 || v <= hi)
+		}, nil
+	}
+}
+
+func compareFloat(v float64, op string, n float64) bool {
+	switch op {
+	case ">":
+		return v > n
+	case ">=":
+		return v >= n
+	case "<":
+		return v < n
+	case "<=":
+		return v <= n
+	default:
+		return v == n
+	}
+}
+
+func parseCount(s string) (float64, error) {
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return float64(n), nil
+}
+
+// parseSeconds accepts plain seconds or Go durations such as "3m" or
+// "1h30m".
+func parseSeconds(s string) (float64, error) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil && n >= 0 {
+		return n, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d.Seconds(), nil
+}
+
+func parseKeywordTerm(op, value string) (func(*FileInfo) bool, error) {
+	if op != ":" && op != "=" {
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+	return func(f *FileInfo) bool {
+		m := f.metadata()
+		if m == nil {
+			return false
+		}
+		for _, k := range m.Keywords {
+			if strings.EqualFold(k, value) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func parseKindTerm(op, value string) (func(*FileInfo) bool, error) {
+	if op != ":" && op != "=" {
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+	kind := strings.ToLower(value)
+	switch kind {
+	case "photo":
+		kind = media.KindImage
+	case media.KindImage, media.KindAudio, media.KindVideo:
+	default:
+		return nil, fmt.Errorf("unknown media kind %q", value)
+	}
+	return func(f *FileInfo) bool {
+		m := f.metadata()
+		return m != nil && m.Kind == kind
+	}, nil
+}
@@ -0,0 +1,223 @@
+package fileops
+
+import (
+	"container/list"
+	"io/fs"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// MetaKey identifies a file's contents without reading them: the same
+// device and inode with unchanged size and mtime are assumed to hold the
+// same bytes.
+type MetaKey struct {
+	Device  uint64
+	Inode   uint64
+	Size    int64
+	ModTime int64 // nanoseconds since the Unix epoch
+}
+
+// FileMeta holds content-derived attributes that are expensive to compute.
+// Empty fields have not been computed yet.
+type FileMeta struct {
+	// Detected is the content-sniffed MIME type; it is independent of the
+	// file name, so renames keep their cache entry.
+	Detected    string
+	HasDetected bool
+	Hash        string
+}
+
+// MetaStore persists FileMeta beyond the in-memory cache.
+type MetaStore interface {
+	LoadMeta(key MetaKey) (FileMeta, bool, error)
+	SaveMeta(path string, key MetaKey, meta FileMeta) error
+}
+
+// CacheStats reports cache effectiveness.
+type CacheStats struct {
+	MemoryHits uint64 `json:"memoryHits"`
+	StoreHits  uint64 `json:"storeHits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+	Entries    int    `json:"entries"`
+	Capacity   int    `json:"capacity"`
+}
+
+// MetaCache is an LRU of FileMeta keyed by MetaKey, optionally backed by a
+// MetaStore, so repeated listings and syncs of unchanged trees never touch
+// file contents.
+type MetaCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	entries  map[MetaKey]*list.Element
+	store    MetaStore
+
+	memoryHits, storeHits, misses, evictions atomic.Uint64
+}
+
+type cacheEntry struct {
+	key  MetaKey
+	meta FileMeta
+}
+
+// DefaultCacheCapacity is the number of entries kept in memory by default.
+const DefaultCacheCapacity = 100000
+
+var metaCache atomic.Pointer[MetaCache]
+
+func init() {
+	metaCache.Store(NewMetaCache(DefaultCacheCapacity, nil))
+}
+
+// NewMetaCache returns a cache holding up to capacity entries in memory.
+// store may be nil.
+func NewMetaCache(capacity int, store MetaStore) *MetaCache {
+	return &MetaCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[MetaKey]*list.Element),
+		store:    store,
+	}
+}
+
+// SetMetaCache replaces the cache used by ListFiles, DetectMime and
+// FileHash.
+func SetMetaCache(c *MetaCache) {
+	metaCache.Store(c)
+}
+
+// CurrentMetaCache returns the cache used by package functions.
+func CurrentMetaCache() *MetaCache {
+	return metaCache.Load()
+}
+
+// MetaKeyOf builds the cache key for info. ok is false on platforms
+// without inode numbers, where caching is skipped.
+func MetaKeyOf(info fs.FileInfo) (MetaKey, bool) {
+	dev, ino := FileID(info)
+	if ino == 0 {
+		return MetaKey{}, false
+	}
+	return MetaKey{Device: dev, Inode: ino, Size: info.Size(), ModTime: info.ModTime().UnixNano()}, true
+}
+
+// Stats returns a snapshot of the hit counters.
+func (c *MetaCache) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.order.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		MemoryHits: c.memoryHits.Load(),
+		StoreHits:  c.storeHits.Load(),
+		Misses:     c.misses.Load(),
+		Evictions:  c.evictions.Load(),
+		Entries:    entries,
+		Capacity:   c.capacity,
+	}
+}
+
+// lookup returns the cached meta for key, consulting the store on a memory
+// miss, and the counter to bump if it holds what the caller needs. The
+// returned meta may be partially filled.
+func (c *MetaCache) lookup(key MetaKey) (FileMeta, *atomic.Uint64) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		meta := el.Value.(*cacheEntry).meta
+		c.mu.Unlock()
+		return meta, &c.memoryHits
+	}
+	c.mu.Unlock()
+
+	if c.store != nil {
+		meta, ok, err := c.store.LoadMeta(key)
+		if err != nil {
+			log.Printf("fileops: loading cached metadata: %v", err)
+		}
+		if ok {
+			c.put(key, meta)
+			return meta, &c.storeHits
+		}
+	}
+	return FileMeta{}, nil
+}
+
+// put inserts or replaces key in memory, evicting the least recently used
+// entry when full.
+func (c *MetaCache) put(key MetaKey, meta FileMeta) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cacheEntry).meta = meta
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, meta: meta})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions.Add(1)
+	}
+}
+
+// get returns the attribute selected by has, computing and recording it on
+// a miss.
+func (c *MetaCache) get(path string, info fs.FileInfo, has func(FileMeta) bool, compute func(*FileMeta) error) (FileMeta, error) {
+	key, ok := MetaKeyOf(info)
+	if !ok {
+		var meta FileMeta
+		err := compute(&meta)
+		return meta, err
+	}
+
+	meta, hits := c.lookup(key)
+	if has(meta) {
+		hits.Add(1)
+		return meta, nil
+	}
+
+	c.misses.Add(1)
+	if err := compute(&meta); err != nil {
+		return meta, err
+	}
+	c.put(key, meta)
+	if c.store != nil {
+		if err := c.store.SaveMeta(path, key, meta); err != nil {
+			log.Printf("fileops: saving cached metadata: %v", err)
+		}
+	}
+	return meta, nil
+}
+
+// Detected returns the content-sniffed MIME type of the file.
+func (c *MetaCache) Detected(path string, info fs.FileInfo) (string, error) {
+	meta, err := c.get(path, info,
+		func(m FileMeta) bool { return m.HasDetected },
+		func(m *FileMeta) error {
+			detected, err := sniffFile(path)
+			if err != nil {
+				return err
+			}
+			m.Detected, m.HasDetected = detected, true
+			return nil
+		})
+	return meta.Detected, err
+}
+
+// Hash returns the hex SHA256 of the file.
+func (c *MetaCache) Hash(path string, info fs.FileInfo) (string, error) {
+	meta, err := c.get(path, info,
+		func(m FileMeta) bool { return m.Hash != "" },
+		func(m *FileMeta) error {
+			hash, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			m.Hash = hash
+			return nil
+		})
+	return meta.Hash, err
+}
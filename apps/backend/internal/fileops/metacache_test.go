@@ -0,0 +1,96 @@
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type memStore struct {
+	meta  map[MetaKey]FileMeta
+	saves int
+}
+
+func (s *memStore) LoadMeta(key MetaKey) (FileMeta, bool, error) {
+	m, ok := s.meta[key]
+	return m, ok, nil
+}
+
+func (s *memStore) SaveMeta(path string, key MetaKey, meta FileMeta) error {
+	s.meta[key] = meta
+	s.saves++
+	return nil
+}
+
+func TestMetaCacheHits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.txt")
+	if err := os.WriteFile(path, []byte("hello world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := MetaKeyOf(info); !ok {
+		t.Skip("no inode numbers on this platform")
+	}
+
+	store := &memStore{meta: map[MetaKey]FileMeta{}}
+	c := NewMetaCache(10, store)
+
+	want, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := c.Hash(path, info)
+		if err != nil || got != want {
+			t.Fatalf("Hash = %q, %v; want %q", got, err, want)
+		}
+	}
+	if st := c.Stats(); st.Misses != 1 || st.MemoryHits != 1 {
+		t.Errorf("Stats = %+v, want 1 miss and 1 memory hit", st)
+	}
+
+	// A fresh cache finds the hash in the store but still has to sniff.
+	c2 := NewMetaCache(10, store)
+	if _, err := c2.Hash(path, info); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.Detected(path, info); err != nil {
+		t.Fatal(err)
+	}
+	if st := c2.Stats(); st.StoreHits != 1 || st.Misses != 1 {
+		t.Errorf("Stats = %+v, want 1 store hit and 1 miss", st)
+	}
+
+	// Changing the mtime invalidates the entry.
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	info, _ = os.Stat(path)
+	if _, err := c2.Hash(path, info); err != nil {
+		t.Fatal(err)
+	}
+	if st := c2.Stats(); st.Misses != 2 {
+		t.Errorf("Misses = %d after mtime change, want 2", st.Misses)
+	}
+}
+
+func TestMetaCacheEviction(t *testing.T) {
+	c := NewMetaCache(2, nil)
+	for i := uint64(1); i <= 3; i++ {
+		c.put(MetaKey{Inode: i}, FileMeta{Hash: "h"})
+	}
+	if st := c.Stats(); st.Entries != 2 || st.Evictions != 1 {
+		t.Errorf("Stats = %+v, want 2 entries and 1 eviction", st)
+	}
+	if m, _ := c.lookup(MetaKey{Inode: 1}); m.Hash != "" {
+		t.Error("least recently used entry was not evicted")
+	}
+	if m, _ := c.lookup(MetaKey{Inode: 3}); m.Hash != "h" {
+		t.Error("newest entry missing")
+	}
+}
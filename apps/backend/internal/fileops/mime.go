@@ -1,10 +1,13 @@
 package fileops
 
 import (
+	"io"
+	"io/fs"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
@@ -51,33 +54,196 @@ var (
 	}
 )
 
-// DetectMimeType returns the MIME type of a file based on its extension and content
+// genericMimeTypes are detection results too vague to contradict the
+// extension: a .cbz is a zip and a .go file is text/plain by content alone.
+var genericMimeTypes = map[string]bool{
+	"application/octet-stream":  true,
+	"application/zip":           true,
+	"application/x-ole-storage": true,
+	"application/xml":           true,
+	"text/xml":                  true,
+	"text/plain":                true,
+}
+
+// mimeAliases maps equivalent spellings to a canonical type.
+var mimeAliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-icon":                 "image/vnd.microsoft.icon",
+	"image/x-ms-bmp":               "image/bmp",
+	"image/heif":                   "image/heic",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/x-wav":                  "audio/wav",
+	"audio/wave":                   "audio/wav",
+	"audio/x-flac":                 "audio/flac",
+	"audio/x-aiff":                 "audio/aiff",
+	"audio/x-m4a":                  "audio/mp4",
+	"video/avi":                    "video/x-msvideo",
+	"application/x-gzip":           "application/gzip",
+	"application/x-zip-compressed": "application/zip",
+	"application/x-rar-compressed": "application/vnd.rar",
+	"application/x-pdf":            "application/pdf",
+	"application/x-msdownload":     "application/vnd.microsoft.portable-executable",
+	"application/x-sqlite3":        "application/vnd.sqlite3",
+	"application/x-matroska":       "video/x-matroska",
+}
+
+// MimeDetection reports both what a file's content looks like and what its
+// extension claims. MimeType is the best guess of the two.
+type MimeDetection struct {
+	MimeType  string `json:"mimeType"`
+	Detected  string `json:"detected,omitempty"`
+	Extension string `json:"extension,omitempty"`
+	// Mismatch is set when content and extension disagree, e.g. a JPEG
+	// renamed to .txt.
+	Mismatch bool `json:"mismatch"`
+}
+
+// DetectMimeType returns the MIME type of a file based on its content and extension
 func DetectMimeType(path string) (string, error) {
-	file, err := os.Open(path)
+	d, err := DetectMime(path)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	return d.MimeType, nil
+}
 
-	ext := filepath.Ext(path)
+// DetectMime identifies a file by magic-byte signatures, falling back to
+// http.DetectContentType for text, and compares the result with the type
+// implied by the extension. Content results come from the metadata cache
+// when the file is unchanged.
+func DetectMime(path string) (MimeDetection, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return MimeDetection{}, err
+	}
+	return detectMimeInfo(path, info)
+}
 
-	// First try custom types
-	if mimeType, ok := customMimeTypes[ext]; ok {
-		return mimeType, nil
+func detectMimeInfo(path string, info fs.FileInfo) (MimeDetection, error) {
+	detected, err := CurrentMetaCache().Detected(path, info)
+	if err != nil {
+		return MimeDetection{}, err
 	}
+	return resolveMime(detected, extensionMimeType(path)), nil
+}
 
-	// Try to detect from extension using standard library
-	mimeType := mime.TypeByExtension(ext)
-	if mimeType != "" {
-		return mimeType, nil
+// sniffFile returns the content-derived MIME type of path, or "" for an
+// empty file.
+func sniffFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
 	}
+	defer file.Close()
 
-	// As a last resort, try to detect from file content
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
+	info, err := file.Stat()
 	if err != nil {
 		return "", err
 	}
+	if info.Size() == 0 {
+		return "", nil
+	}
+	if detected := sniffContent(file, info.Size()); detected != "" {
+		return detected, nil
+	}
 
+	buffer := make([]byte, 512)
+	n, err := file.ReadAt(buffer, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
 	return http.DetectContentType(buffer[:n]), nil
-}
\ No newline at end of file
+}
+
+// resolveMime picks the best type from the detected and extension-implied
+// types and decides whether they contradict each other.
+func resolveMime(detectedType, extensionType string) MimeDetection {
+	d := MimeDetection{Detected: detectedType, Extension: extensionType}
+	detected, ext := canonicalMime(detectedType), canonicalMime(extensionType)
+	switch {
+	case detectedType == "":
+		d.MimeType = extensionType
+	case ext == "" || detected == ext:
+		d.MimeType = detectedType
+	case genericMimeTypes[detected]:
+		d.MimeType = extensionType
+		d.Mismatch = detected == "text/plain" && isBinaryMime(ext)
+	default:
+		d.MimeType = detectedType
+		d.Mismatch = true
+	}
+	if d.MimeType == "" {
+		d.MimeType = "application/octet-stream"
+	}
+	return d
+}
+
+// extensionMimeType returns the type registered for the file's extension.
+func extensionMimeType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if mimeType, ok := customMimeTypes[ext]; ok {
+		return mimeType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// canonicalMime strips parameters and resolves aliases for comparison.
+func canonicalMime(mimeType string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	if alias, ok := mimeAliases[mimeType]; ok {
+		return alias
+	}
+	return mimeType
+}
+
+// isBinaryMime reports whether files of this type are never plain text.
+func isBinaryMime(mimeType string) bool {
+	for _, prefix := range []string{"image/", "audio/", "video/", "font/"} {
+		if strings.HasPrefix(mimeType, prefix) && mimeType != "image/svg+xml" {
+			return true
+		}
+	}
+	switch mimeType {
+	case "application/pdf", "application/zip", "application/gzip", "application/x-7z-compressed",
+		"application/vnd.rar", "application/x-tar", "application/wasm":
+		return true
+	}
+	return strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument.") ||
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument.")
+}
+
+// MimeCategory groups a MIME type into a broad category for organizing:
+// image, video, audio, document, spreadsheet, presentation, archive, text,
+// code, font or other.
+func MimeCategory(mimeType string) string {
+	mimeType = canonicalMime(mimeType)
+	major, minor, _ := strings.Cut(mimeType, "/")
+	switch major {
+	case "image", "video", "audio", "font":
+		return major
+	}
+	switch {
+	case mimeType == "application/pdf", mimeType == "application/msword", mimeType == "application/rtf",
+		mimeType == "application/epub+zip",
+		strings.Contains(minor, "wordprocessingml"), strings.Contains(minor, "opendocument.text"):
+		return "document"
+	case mimeType == "application/vnd.ms-excel", mimeType == "text/csv",
+		strings.Contains(minor, "spreadsheetml"), strings.Contains(minor, "opendocument.spreadsheet"):
+		return "spreadsheet"
+	case mimeType == "application/vnd.ms-powerpoint",
+		strings.Contains(minor, "presentationml"), strings.Contains(minor, "opendocument.presentation"):
+		return "presentation"
+	case mimeType == "application/zip", mimeType == "application/gzip", mimeType == "application/x-tar",
+		mimeType == "application/x-7z-compressed", mimeType == "application/vnd.rar",
+		mimeType == "application/x-bzip2", mimeType == "application/x-xz", mimeType == "application/zstd":
+		return "archive"
+	case mimeType == "text/plain", mimeType == "text/markdown":
+		return "text"
+	case major == "text", mimeType == "application/json", mimeType == "application/xml",
+		mimeType == "application/javascript", mimeType == "application/x-sh":
+		return "code"
+	}
+	return "other"
+}
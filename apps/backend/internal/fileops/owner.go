@@ -0,0 +1,40 @@
+package fileops
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+var (
+	ownerNames sync.Map // uid -> user name
+	groupNames sync.Map // gid -> group name
+)
+
+// lookupOwner resolves a uid to a user name, caching the result.
+// Unknown ids resolve to an empty string.
+func lookupOwner(uid uint32) string {
+	if name, ok := ownerNames.Load(uid); ok {
+		return name.(string)
+	}
+	name := ""
+	if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+		name = u.Username
+	}
+	ownerNames.Store(uid, name)
+	return name
+}
+
+// lookupGroup resolves a gid to a group name, caching the result.
+// Unknown ids resolve to an empty string.
+func lookupGroup(gid uint32) string {
+	if name, ok := groupNames.Load(gid); ok {
+		return name.(string)
+	}
+	name := ""
+	if g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10)); err == nil {
+		name = g.Name
+	}
+	groupNames.Store(gid, name)
+	return name
+}
@@ -0,0 +1,422 @@
+package fileops
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"file-manager-backend/internal/media"
+)
+
+// Query is a parsed metadata filter such as
+//
+//	size>100MB type:image/* modified:2023-01..2023-06 owner:alice
+//
+// Terms are separated by whitespace and must all match. A leading "-"
+// negates a term, values may be double-quoted, and bare words match
+// case-insensitively against the file name.
+//
+// Supported fields:
+//
+//	size      size>100MB, size<=1GB, size:10KB..2MB, size=0
+//	type      type:image/*, type:image (alias mime)
+//	modified  modified:2023, modified:2023-01..2023-06, modified>=2023-01-15
+//	accessed, changed, created   same forms as modified
+//	owner     owner:alice or owner:1000
+//	group     group:staff or group:20
+//	name      name:*.jpg (glob, case-insensitive)
+//	ext       ext:jpg
+//	is        is:file, is:dir, is:mismatch
+//
+// Embedded photo, audio and video metadata (see SetMediaLookup):
+//
+//	kind      kind:image, kind:audio, kind:video
+//	taken     same forms as modified, from EXIF, XMP or the container
+//	camera, lens, title, artist, album, genre, codec   substring, camera:canon
+//	keyword   keyword:beach (exact, case-insensitive)
+//	year, rating, width, height   year:1990..1999, rating>=4, width>=3840
+//	          (rating prefers the user's rating over the embedded one)
+//	duration  duration>10m, duration:30..90 (seconds)
+//
+// Classes assigned by the classification pipeline (see SetClassLookup):
+//
+//	class     class:invoice (confidence of at least MinClassConfidence)
+//
+// User annotations (see SetAnnotationLookup):
+//
+//	tag       tag:client-x (exact, case-insensitive)
+//	note      note:renewal (substring)
+type Query struct {
+	raw   string
+	terms []queryTerm
+}
+
+type queryTerm struct {
+	negate bool
+	match  func(*FileInfo) bool
+}
+
+// queryFields maps field names to term parsers. The op is one of
+// ":", "=", ">", ">=", "<", "<=".
+var queryFields = map[string]func(op, value string) (func(*FileInfo) bool, error){
+	"size":     parseSizeTerm,
+	"type":     parseTypeTerm,
+	"mime":     parseTypeTerm,
+	"modified": timeTerm(func(f *FileInfo) *time.Time { return &f.ModTime }),
+	"accessed": timeTerm(func(f *FileInfo) *time.Time { return &f.AccessTime }),
+	"changed":  timeTerm(func(f *FileInfo) *time.Time { return &f.ChangeTime }),
+	"created":  timeTerm(func(f *FileInfo) *time.Time { return f.BirthTime }),
+	"owner":    idTerm(func(f *FileInfo) (string, uint32) { return f.Owner, f.UID }),
+	"group":    idTerm(func(f *FileInfo) (string, uint32) { return f.Group, f.GID }),
+	"name":     parseNameTerm,
+	"ext":      parseExtTerm,
+	"is":       parseIsTerm,
+	"kind":     parseKindTerm,
+	"taken":    timeTerm(takenTime),
+	"camera":   mediaText((*media.Metadata).Camera),
+	"lens":     mediaText(func(m *media.Metadata) string { return m.Lens }),
+	"title":    mediaText(func(m *media.Metadata) string { return m.Title }),
+	"artist":   mediaText(func(m *media.Metadata) string { return m.Artist }),
+	"album":    mediaText(func(m *media.Metadata) string { return m.Album }),
+	"genre":    mediaText(func(m *media.Metadata) string { return m.Genre }),
+	"codec":    mediaText(func(m *media.Metadata) string { return m.VideoCodec + " " + m.AudioCodec }),
+	"keyword":  parseKeywordTerm,
+	"year":     mediaNumber(func(m *media.Metadata) float64 { return float64(m.Year) }, parseCount),
+	"rating":   numberTerm(userRating, parseCount),
+	"width":    mediaNumber(func(m *media.Metadata) float64 { return float64(m.Width) }, parseCount),
+	"height":   mediaNumber(func(m *media.Metadata) float64 { return float64(m.Height) }, parseCount),
+	"duration": mediaNumber(func(m *media.Metadata) float64 { return m.Duration }, parseSeconds),
+	"class":    parseClassTerm,
+	"tag":      parseTagTerm,
+	"note":     parseNoteTerm,
+}
+
+// ParseQuery parses a filter expression. An empty expression returns a nil
+// query, which matches everything.
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := tokenizeQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	q := &Query{raw: s}
+	for _, tok := range tokens {
+		term := queryTerm{}
+		if strings.HasPrefix(tok, "-") && len(tok) > 1 {
+			term.negate = true
+			tok = tok[1:]
+		}
+
+		field, op, value := splitTerm(tok)
+		if field == "" {
+			needle := strings.ToLower(value)
+			term.match = func(f *FileInfo) bool {
+				return strings.Contains(strings.ToLower(f.Name), needle)
+			}
+		} else {
+			parse, ok := queryFields[field]
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown field %q", ErrQueryInvalid, field)
+			}
+			if value == "" {
+				return nil, fmt.Errorf("%w: missing value for %q", ErrQueryInvalid, field)
+			}
+			if term.match, err = parse(op, value); err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrQueryInvalid, field, err)
+			}
+		}
+		q.terms = append(q.terms, term)
+	}
+	return q, nil
+}
+
+// String returns the expression the query was parsed from.
+func (q *Query) String() string {
+	if q == nil {
+		return ""
+	}
+	return q.raw
+}
+
+// Match reports whether f satisfies every term of the query. A nil query
+// matches everything.
+func (q *Query) Match(f *FileInfo) bool {
+	if q == nil {
+		return true
+	}
+	for _, term := range q.terms {
+		if term.match(f) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenizeQuery splits on whitespace outside double quotes and strips the
+// quotes.
+func tokenizeQuery(s string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	inQuote, hasToken := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasToken = true
+		case unicode.IsSpace(r) && !inQuote:
+			if hasToken {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				hasToken = false
+			}
+		default:
+			cur.WriteRune(r)
+			hasToken = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("%w: unterminated quote", ErrQueryInvalid)
+	}
+	if hasToken {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
+
+// splitTerm splits "field<op>value". Tokens without a recognised field
+// prefix are returned as a bare value.
+func splitTerm(tok string) (field, op, value string) {
+	i := strings.IndexAny(tok, ":=<>")
+	if i <= 0 {
+		return "", "", tok
+	}
+	field = strings.ToLower(tok[:i])
+	rest := tok[i:]
+	for _, candidate := range []string{">=", "<=", ":", "=", ">", "<"} {
+		if strings.HasPrefix(rest, candidate) {
+			return field, candidate, rest[len(candidate):]
+		}
+	}
+	return "", "", tok
+}
+
+func parseSizeTerm(op, value string) (func(*FileInfo) bool, error) {
+	if lo, hi, ok := strings.Cut(value, ".."); ok && (op == ":" || op == "=") {
+		min, max := int64(0), int64(-1)
+		var err error
+		if lo != "" {
+			if min, err = ParseSize(lo); err != nil {
+				return nil, err
+			}
+		}
+		if hi != "" {
+			if max, err = ParseSize(hi); err != nil {
+				return nil, err
+			}
+		}
+		return func(f *FileInfo) bool {
+			return f.Size >= min && (max < 0 || f.Size <= max)
+		}, nil
+	}
+
+	n, err := ParseSize(value)
+	if err != nil {
+		return nil, err
+	}
+	return func(f *FileInfo) bool { return compareInt(f.Size, op, n) }, nil
+}
+
+// ParseSize parses sizes such as "512", "10KB", "1.5 GiB" or "100M". Units
+// are binary multiples of 1024.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	var mult float64
+	switch strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I") {
+	case "":
+		mult = 1
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	default:
+		return 0, fmt.Errorf("invalid size unit %q", unit)
+	}
+	return int64(f * mult), nil
+}
+
+func compareInt(v int64, op string, n int64) bool {
+	switch op {
+	case ">":
+		return v > n
+	case ">=":
+		return v >= n
+	case "<":
+		return v < n
+	case "<=":
+		return v <= n
+	default:
+		return v == n
+	}
+}
+
+func parseTypeTerm(op, value string) (func(*FileInfo) bool, error) {
+	if op != ":" && op != "=" {
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+	pattern := strings.ToLower(value)
+	if !strings.Contains(pattern, "/") {
+		pattern += "/*"
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(f *FileInfo) bool {
+		mimeType, _, _ := strings.Cut(f.MimeType, ";")
+		matched, _ := path.Match(pattern, strings.ToLower(strings.TrimSpace(mimeType)))
+		return matched
+	}, nil
+}
+
+// timeTerm builds a parser for a date field. get returns nil when the
+// attribute is unknown, in which case the term never matches.
+func timeTerm(get func(*FileInfo) *time.Time) func(op, value string) (func(*FileInfo) bool, error) {
+	return func(op, value string) (func(*FileInfo) bool, error) {
+		var start, end time.Time
+		switch lo, hi, isRange := strings.Cut(value, ".."); {
+		case isRange && (op == ":" || op == "="):
+			var err error
+			if lo != "" {
+				if start, _, err = parseDatePeriod(lo); err != nil {
+					return nil, err
+				}
+			}
+			if hi != "" {
+				if _, end, err = parseDatePeriod(hi); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			periodStart, periodEnd, err := parseDatePeriod(value)
+			if err != nil {
+				return nil, err
+			}
+			switch op {
+			case ":", "=":
+				start, end = periodStart, periodEnd
+			case ">":
+				start = periodEnd
+			case ">=":
+				start = periodStart
+			case "<":
+				end = periodStart
+			case "<=":
+				end = periodEnd
+			}
+		}
+
+		return func(f *FileInfo) bool {
+			t := get(f)
+			if t == nil {
+				return false
+			}
+			if !start.IsZero() && t.Before(start) {
+				return false
+			}
+			if !end.IsZero() && !t.Before(end) {
+				return false
+			}
+			return true
+		}, nil
+	}
+}
+
+// parseDatePeriod parses a year, month, day or minute in local time and
+// returns the half-open interval [start, end) it covers.
+func parseDatePeriod(s string) (time.Time, time.Time, error) {
+	layouts := []struct {
+		layout string
+		next   func(time.Time) time.Time
+	}{
+		{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+		{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+		{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	}
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l.layout, s, time.Local); err == nil {
+			return t, l.next(t), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// idTerm matches a user or group by name or numeric id.
+func idTerm(get func(*FileInfo) (string, uint32)) func(op, value string) (func(*FileInfo) bool, error) {
+	return func(op, value string) (func(*FileInfo) bool, error) {
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("unsupported operator %q", op)
+		}
+		id, idErr := strconv.ParseUint(value, 10, 32)
+		return func(f *FileInfo) bool {
+			name, n := get(f)
+			return name == value || (idErr == nil && uint64(n) == id)
+		}, nil
+	}
+}
+
+func parseNameTerm(op, value string) (func(*FileInfo) bool, error) {
+	if op != ":" && op != "=" {
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+	pattern := strings.ToLower(value)
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(f *FileInfo) bool {
+		matched, _ := filepath.Match(pattern, strings.ToLower(f.Name))
+		return matched
+	}, nil
+}
+
+func parseExtTerm(op, value string) (func(*FileInfo) bool, error) {
+	if op != ":" && op != "=" {
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+	ext := "." + strings.TrimPrefix(strings.ToLower(value), ".")
+	return func(f *FileInfo) bool {
+		return !f.IsDirectory && strings.ToLower(filepath.Ext(f.Name)) == ext
+	}, nil
+}
+
+func parseIsTerm(op, value string) (func(*FileInfo) bool, error) {
+	switch strings.ToLower(value) {
+	case "dir", "directory", "folder":
+		return func(f *FileInfo) bool { return f.IsDirectory }, nil
+	case "file":
+		return func(f *FileInfo) bool { return !f.IsDirectory }, nil
+	case "mismatch":
+		return func(f *FileInfo) bool { return f.MimeMismatch }, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q", value)
+}
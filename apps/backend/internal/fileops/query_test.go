@@ -0,0 +1,155 @@
+package fileops
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/media"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"10KB", 10 << 10},
+		{"100MB", 100 << 20},
+		{"1.5GiB", 3 << 29},
+		{"2t", 2 << 40},
+	}
+	for _, tc := range tests {
+		got, err := ParseSize(tc.in)
+		if err != nil {
+			t.Errorf("ParseSize(%q) error: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+	if _, err := ParseSize("12XB"); err == nil {
+		t.Error("expected error for unknown unit")
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	photo := FileInfo{
+		Name:     "Holiday.JPG",
+		Size:     150 << 20,
+		MimeType: "image/jpeg",
+		ModTime:  time.Date(2023, 3, 14, 12, 0, 0, 0, time.Local),
+		Owner:    "alice",
+		UID:      1000,
+	}
+	doc := FileInfo{
+		Name:     "notes.txt",
+		Size:     2 << 10,
+		MimeType: "text/plain; charset=utf-8",
+		ModTime:  time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local),
+		Owner:    "bob",
+		UID:      1001,
+	}
+	dir := FileInfo{Name: "photos", IsDirectory: true, ModTime: photo.ModTime}
+
+	tests := []struct {
+		query string
+		want  []bool // photo, doc, dir
+	}{
+		{"size>100MB", []bool{true, false, false}},
+		{"size:1KB..10KB", []bool{false, true, false}},
+		{"type:image/*", []bool{true, false, false}},
+		{"type:text", []bool{false, true, false}},
+		{"type:text/plain", []bool{false, true, false}},
+		{"modified:2023-01..2023-06", []bool{true, false, true}},
+		{"modified:2023-03-14", []bool{true, false, true}},
+		{"modified>2023", []bool{false, true, false}},
+		{"modified<2024-01-02", []bool{true, false, true}},
+		{"owner:alice", []bool{true, false, false}},
+		{"owner:1001", []bool{false, true, false}},
+		{"name:*.jpg", []bool{true, false, false}},
+		{"ext:txt", []bool{false, true, false}},
+		{"is:dir", []bool{false, false, true}},
+		{"-is:dir", []bool{true, true, false}},
+		{"holiday", []bool{true, false, false}},
+		{`"holi" size>100MB type:image/* modified:2023-01..2023-06 owner:alice`, []bool{true, false, false}},
+		{"created:2023", []bool{false, false, false}},
+		{"", []bool{true, true, true}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, f := range []FileInfo{photo, doc, dir} {
+				if got := q.Match(&f); got != tc.want[i] {
+					t.Errorf("Match(%s) = %v, want %v", f.Name, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		"color:red",
+		"size>lots",
+		"modified:yesterday",
+		"is:socket",
+		"owner>alice",
+		`name:"unterminated`,
+		"size:",
+	} {
+		if _, err := ParseQuery(query); !errors.Is(err, ErrQueryInvalid) {
+			t.Errorf("ParseQuery(%q) error = %v, want ErrQueryInvalid", query, err)
+		}
+	}
+}
+
+func TestQueryMediaFields(t *testing.T) {
+	tags := map[string]*media.Metadata{
+		"/p/beach.jpg": {Kind: media.KindImage, Make: "Canon", Model: "Canon EOS R5", Rating: 4,
+			Keywords: []string{"Beach"}, DateTime: time.Date(2021, 7, 4, 10, 0, 0, 0, time.Local)},
+		"/p/song.mp3": {Kind: media.KindAudio, Artist: "The Band", Year: 1994, Duration: 245},
+	}
+	SetMediaLookup(func(path string) *media.Metadata { return tags[path] })
+	defer SetMediaLookup(nil)
+
+	files := []FileInfo{
+		{Name: "beach.jpg", Path: "/p/beach.jpg"},
+		{Name: "song.mp3", Path: "/p/song.mp3"},
+		{Name: "notes.txt", Path: "/p/notes.txt"},
+	}
+	tests := []struct {
+		query string
+		want  []bool // photo, song, text
+	}{
+		{"kind:photo", []bool{true, false, false}},
+		{"camera:eos", []bool{true, false, false}},
+		{"taken:2021-07", []bool{true, false, false}},
+		{"rating>=4", []bool{true, false, false}},
+		{"keyword:beach", []bool{true, false, false}},
+		{"artist:band year:1990..1999", []bool{false, true, false}},
+		{"duration>4m", []bool{false, true, false}},
+		{"duration<=200", []bool{false, false, false}},
+		{"-kind:audio", []bool{true, false, true}},
+	}
+	for _, tc := range tests {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q): %v", tc.query, err)
+		}
+		for i := range files {
+			f := files[i]
+			if got := q.Match(&f); got != tc.want[i] {
+				t.Errorf("%q: Match(%s) = %v, want %v", tc.query, f.Name, got, tc.want[i])
+			}
+		}
+	}
+	if _, err := ParseQuery("kind:document"); !errors.Is(err, ErrQueryInvalid) {
+		t.Errorf("kind:document error = %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package fileops
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// fillStatInfo copies POSIX attributes from the lstat result into fi.
+// Darwin records the birth time in the regular stat structure.
+func fillStatInfo(fi *FileInfo, info fs.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		fi.ChangeTime = info.ModTime()
+		fi.AccessTime = info.ModTime()
+		return
+	}
+	fi.UID = stat.Uid
+	fi.GID = stat.Gid
+	fi.Inode = stat.Ino
+	fi.Device = uint64(stat.Dev)
+	fi.Links = uint64(stat.Nlink)
+	fi.AccessTime = time.Unix(stat.Atimespec.Sec, stat.Atimespec.Nsec)
+	fi.ChangeTime = time.Unix(stat.Ctimespec.Sec, stat.Ctimespec.Nsec)
+	if stat.Birthtimespec.Sec != 0 || stat.Birthtimespec.Nsec != 0 {
+		t := time.Unix(stat.Birthtimespec.Sec, stat.Birthtimespec.Nsec)
+		fi.BirthTime = &t
+	}
+}
+
+// FileID returns the device and inode numbers identifying the file behind
+// info, or zeros when they are unavailable.
+func FileID(info fs.FileInfo) (dev, ino uint64) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev), stat.Ino
+	}
+	return 0, 0
+}
+
+// LinkCount returns the number of hard links to the file behind info, or 1
+// when it is unavailable.
+func LinkCount(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
@@ -0,0 +1,58 @@
+package fileops
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fillStatInfo copies POSIX attributes from the lstat result into fi and
+// asks statx for the birth time, which plain stat does not expose.
+func fillStatInfo(fi *FileInfo, info fs.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		fi.ChangeTime = info.ModTime()
+		fi.AccessTime = info.ModTime()
+		return
+	}
+	fi.UID = stat.Uid
+	fi.GID = stat.Gid
+	fi.Inode = stat.Ino
+	fi.Device = uint64(stat.Dev)
+	fi.Links = uint64(stat.Nlink)
+	fi.AccessTime = time.Unix(stat.Atim.Unix())
+	fi.ChangeTime = time.Unix(stat.Ctim.Unix())
+	fi.BirthTime = birthTime(fi.Path)
+}
+
+// birthTime returns the creation time via statx, or nil when the kernel or
+// filesystem does not report STATX_BTIME.
+func birthTime(path string) *time.Time {
+	var stx unix.Statx_t
+	err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stx)
+	if err != nil || stx.Mask&unix.STATX_BTIME == 0 {
+		return nil
+	}
+	t := time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))
+	return &t
+}
+
+// FileID returns the device and inode numbers identifying the file behind
+// info, or zeros when they are unavailable.
+func FileID(info fs.FileInfo) (dev, ino uint64) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev), stat.Ino
+	}
+	return 0, 0
+}
+
+// LinkCount returns the number of hard links to the file behind info, or 1
+// when it is unavailable.
+func LinkCount(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
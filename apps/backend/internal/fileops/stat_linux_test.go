@@ -0,0 +1,55 @@
+package fileops
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestListFilesPosixMetadata(t *testing.T) {
+	root := setup(t)
+	defer cleanup(root)
+
+	original := filepath.Join(root, "file1.txt")
+	if err := os.Link(original, filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ListFiles(root, DefaultListOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]FileInfo)
+	for _, f := range files {
+		byName[f.Name] = f
+	}
+
+	file1, link := byName["file1.txt"], byName["link.txt"]
+	if file1.Inode == 0 {
+		t.Error("expected non-zero inode")
+	}
+	if file1.Inode != link.Inode || file1.Device != link.Device {
+		t.Errorf("hardlinks should share inode identity: %d/%d vs %d/%d",
+			file1.Device, file1.Inode, link.Device, link.Inode)
+	}
+	if file1.Links != 2 {
+		t.Errorf("expected link count 2, got %d", file1.Links)
+	}
+	if byName["file2.jpg"].Links != 1 {
+		t.Errorf("expected link count 1, got %d", byName["file2.jpg"].Links)
+	}
+
+	if current, err := user.Current(); err == nil {
+		if file1.Owner != current.Username {
+			t.Errorf("expected owner %q, got %q", current.Username, file1.Owner)
+		}
+	}
+	if file1.ChangeTime.IsZero() || file1.AccessTime.IsZero() {
+		t.Error("expected ctime and atime to be set")
+	}
+	if file1.BirthTime != nil && file1.BirthTime.After(file1.ChangeTime) {
+		t.Errorf("birth time %v is after change time %v", file1.BirthTime, file1.ChangeTime)
+	}
+}
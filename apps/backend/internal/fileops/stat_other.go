@@ -0,0 +1,22 @@
+//go:build !linux && !darwin
+
+package fileops
+
+import "io/fs"
+
+// fillStatInfo falls back to the portable attributes on platforms without
+// a POSIX stat structure.
+func fillStatInfo(fi *FileInfo, info fs.FileInfo) {
+	fi.AccessTime = info.ModTime()
+	fi.ChangeTime = info.ModTime()
+}
+
+// FileID returns zeros on platforms without inode numbers.
+func FileID(info fs.FileInfo) (dev, ino uint64) {
+	return 0, 0
+}
+
+// LinkCount returns 1 on platforms without link counts.
+func LinkCount(info fs.FileInfo) uint64 {
+	return 1
+}
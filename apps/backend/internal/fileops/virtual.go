@@ -0,0 +1,88 @@
+package fileops
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+)
+
+// VirtualFS resolves a path that does not exist on disk, such as a folder
+// inside an archive, to a file system and the slash-separated folder it
+// names within it. It returns a nil fs.FS when p is not virtual.
+type VirtualFS func(p string) (fsys fs.FS, dir string, err error)
+
+var virtualFS atomic.Pointer[VirtualFS]
+
+// SetVirtualFS installs the resolver ListFiles consults for paths that are
+// missing or run through a regular file.
+func SetVirtualFS(resolve VirtualFS) {
+	virtualFS.Store(&resolve)
+}
+
+// resolveVirtual returns the virtual file system for root, if any. err is
+// the error os.ReadDir reported for root; a path into a virtual file
+// system that does not name a folder there yields ErrPathNotFound.
+func resolveVirtual(root string, err error) (fs.FS, string, error) {
+	if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+		return nil, "", err
+	}
+	resolve := virtualFS.Load()
+	if resolve == nil || *resolve == nil {
+		return nil, "", err
+	}
+	fsys, dir, rerr := (*resolve)(root)
+	if rerr != nil {
+		return nil, "", rerr
+	}
+	if fsys == nil {
+		return nil, "", err
+	}
+	if info, err := fs.Stat(fsys, dir); err != nil || !info.IsDir() {
+		return nil, "", ErrPathNotFound
+	}
+	return fsys, dir, nil
+}
+
+// virtualReadDir lists the folder of fsys that corresponds to p, a path
+// below root, which itself maps to dir.
+func virtualReadDir(fsys fs.FS, root, dir string) func(string) ([]fs.DirEntry, error) {
+	return func(p string) ([]fs.DirEntry, error) {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil, err
+		}
+		return fs.ReadDir(fsys, path.Join(dir, filepath.ToSlash(rel)))
+	}
+}
+
+// createVirtualInfo builds a FileInfo for an entry of a virtual file
+// system. There is no inode or owner, and the MIME type comes from the
+// extension alone since sniffing would mean extracting the entry.
+func createVirtualInfo(d fs.DirEntry, path string) (FileInfo, error) {
+	info, err := d.Info()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	fileInfo := FileInfo{
+		Name:        d.Name(),
+		Path:        path,
+		Size:        info.Size(),
+		IsDirectory: d.IsDir(),
+		ModTime:     info.ModTime(),
+		AccessTime:  info.ModTime(),
+		ChangeTime:  info.ModTime(),
+		Permissions: info.Mode().String(),
+		Virtual:     true,
+	}
+	if !d.IsDir() {
+		fileInfo.ExtensionMimeType = extensionMimeType(path)
+		fileInfo.MimeType = fileInfo.ExtensionMimeType
+		if fileInfo.MimeType == "" {
+			fileInfo.MimeType = "application/octet-stream"
+		}
+	}
+	return fileInfo, nil
+}
@@ -0,0 +1,305 @@
+// Package journal records file operations made through the API so they can
+// be undone.
+package journal
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/trash"
+)
+
+// Actions are the reversible changes an operation is made of.
+const (
+	// ActionMove moved Source to Target; undo moves it back.
+	ActionMove = "move"
+	// ActionCopy created Target as a copy of Source; undo trashes the copy.
+	ActionCopy = "copy"
+	// ActionTrash moved Source to the trash as Target; undo restores it.
+	ActionTrash = "trash"
+	// ActionMkdir created the folder Target; undo removes it if empty.
+	ActionMkdir = "mkdir"
+)
+
+// ErrChanged is returned when a path touched by an operation was modified
+// afterwards, so undoing it could lose data.
+var ErrChanged = errors.New("changed since the operation")
+
+// ErrNothingToUndo is returned when the journal has no operation left to
+// undo.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// Item is one change within an operation. Reverted is set on the items an
+// interrupted undo already reverted, which a retry skips.
+type Item struct {
+	Action   string `json:"action"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Reverted bool   `json:"reverted,omitempty"`
+
+	id            int64
+	size, modTime int64
+	inode         uint64
+}
+
+// Operation is one recorded API request.
+type Operation struct {
+	ID        int64      `json:"id"`
+	Kind      string     `json:"kind"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UndoneAt  *time.Time `json:"undoneAt,omitempty"`
+	Items     []Item     `json:"items"`
+}
+
+// Journal stores operations in SQLite.
+type Journal struct {
+	db  *sql.DB
+	bin *trash.Trash
+}
+
+// New returns a journal backed by db. Undone copies are moved to bin.
+func New(db *sql.DB, bin *trash.Trash) *Journal {
+	return &Journal{db: db, bin: bin}
+}
+
+// Record stores an operation of the given kind made of items, which must
+// already have been applied. Targets are fingerprinted now so later undos
+// can detect modifications. Empty operations are not recorded.
+func (j *Journal) Record(kind string, items []Item) (int64, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+	tx, err := j.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO operations (kind) VALUES (?)", kind)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range items {
+		info, err := os.Lstat(item.Target)
+		if err != nil {
+			return 0, fmt.Errorf("journal: fingerprinting %s: %w", item.Target, err)
+		}
+		_, inode := fileops.FileID(info)
+		if _, err := tx.Exec(`INSERT INTO operation_items (operation_id, action, source, target, size, mod_time, inode)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			id, item.Action, item.Source, item.Target, info.Size(), info.ModTime().UnixNano(), int64(inode)); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+// List returns the most recent operations, newest first.
+func (j *Journal) List(limit int) ([]Operation, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := j.db.Query("SELECT id, kind, created_at, undone_at FROM operations ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	var ops []Operation
+	for rows.Next() {
+		var op Operation
+		var undone sql.NullTime
+		if err := rows.Scan(&op.ID, &op.Kind, &op.CreatedAt, &undone); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if undone.Valid {
+			op.UndoneAt = &undone.Time
+		}
+		ops = append(ops, op)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range ops {
+		if ops[i].Items, err = j.items(ops[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// Undo reverts the last n operations that have not been undone, newest
+// first, and returns them. It stops at the first operation that cannot be
+// undone, returning the ones already reverted along with the error. An
+// operation is only undone when none of its targets changed since. If an
+// operation fails partway, the items reverted so far are recorded, so
+// undoing it again picks up where it stopped.
+func (j *Journal) Undo(n int) ([]Operation, error) {
+	if n <= 0 {
+		n = 1
+	}
+	var undone []Operation
+	for len(undone) < n {
+		op, err := j.last()
+		if err == sql.ErrNoRows {
+			if len(undone) == 0 {
+				return nil, ErrNothingToUndo
+			}
+			break
+		}
+		if err != nil {
+			return undone, err
+		}
+		if err := j.revert(op); err != nil {
+			return undone, fmt.Errorf("undoing %s #%d: %w", op.Kind, op.ID, err)
+		}
+		now := time.Now()
+		if _, err := j.db.Exec("UPDATE operations SET undone_at = ? WHERE id = ?", now, op.ID); err != nil {
+			return undone, err
+		}
+		op.UndoneAt = &now
+		undone = append(undone, op)
+	}
+	return undone, nil
+}
+
+// Prune forgets all but the newest keep operations.
+func (j *Journal) Prune(keep int) error {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	old := "SELECT id FROM operations ORDER BY id DESC LIMIT -1 OFFSET ?"
+	if _, err := tx.Exec("DELETE FROM operation_items WHERE operation_id IN ("+old+")", keep); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM operations WHERE id IN ("+old+")", keep); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// last returns the newest operation not yet undone.
+func (j *Journal) last() (Operation, error) {
+	var op Operation
+	err := j.db.QueryRow("SELECT id, kind, created_at FROM operations WHERE undone_at IS NULL ORDER BY id DESC LIMIT 1").
+		Scan(&op.ID, &op.Kind, &op.CreatedAt)
+	if err != nil {
+		return op, err
+	}
+	op.Items, err = j.items(op.ID)
+	return op, err
+}
+
+func (j *Journal) items(opID int64) ([]Item, error) {
+	rows, err := j.db.Query(`SELECT id, action, source, target, size, mod_time, inode, reverted
+		FROM operation_items WHERE operation_id = ? ORDER BY id`, opID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Item
+	for rows.Next() {
+		var item Item
+		var inode int64
+		if err := rows.Scan(&item.id, &item.Action, &item.Source, &item.Target, &item.size, &item.modTime, &inode, &item.Reverted); err != nil {
+			return nil, err
+		}
+		item.inode = uint64(inode)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// revert checks every item of op not yet reverted before changing
+// anything, then reverses them in reverse order, marking each one as it
+// succeeds. If a reversal still fails, the items reverted so far stay
+// reverted and marked.
+func (j *Journal) revert(op Operation) error {
+	for _, item := range op.Items {
+		if item.Reverted {
+			continue
+		}
+		if err := item.check(); err != nil {
+			return err
+		}
+	}
+	for i := len(op.Items) - 1; i >= 0; i-- {
+		item := &op.Items[i]
+		if item.Reverted {
+			continue
+		}
+		if err := j.revertItem(*item); err != nil {
+			return err
+		}
+		item.Reverted = true
+		if _, err := j.db.Exec("UPDATE operation_items SET reverted = 1 WHERE id = ?", item.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check verifies that the item's target is as it was left. Whether the
+// source can be put back is left to the reverting move or restore, which
+// never overwrite: an earlier item of the same operation may be what
+// currently occupies it.
+func (item Item) check() error {
+	info, err := os.Lstat(item.Target)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s no longer exists", ErrChanged, item.Target)
+	}
+	if err != nil {
+		return err
+	}
+	_, inode := fileops.FileID(info)
+	if info.Size() != item.size || info.ModTime().UnixNano() != item.modTime || inode != item.inode {
+		return fmt.Errorf("%w: %s was modified", ErrChanged, item.Target)
+	}
+	return nil
+}
+
+func (j *Journal) revertItem(item Item) error {
+	switch item.Action {
+	case ActionMove:
+		return fileops.Move(item.Target, item.Source, false)
+	case ActionCopy:
+		if j.bin == nil {
+			return fileops.Delete(item.Target)
+		}
+		_, err := j.bin.Trash(item.Target)
+		return err
+	case ActionTrash:
+		_, err := j.bin.Restore(item.Target)
+		return err
+	case ActionMkdir:
+		if err := os.Remove(item.Target); err != nil {
+			return fmt.Errorf("%w: %s is not empty", ErrChanged, item.Target)
+		}
+		return nil
+	}
+	return fmt.Errorf("journal: unknown action %q", item.Action)
+}
+
+// Paths returns every path an operation touched, for refreshing indexes
+// after an undo.
+func (op Operation) Paths() []string {
+	var paths []string
+	for _, item := range op.Items {
+		for _, p := range []string{item.Source, item.Target} {
+			if p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
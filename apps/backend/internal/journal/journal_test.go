@@ -0,0 +1,255 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/db/dbtest"
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/trash"
+)
+
+func setupJournal(t *testing.T) (*Journal, string) {
+	t.Helper()
+	conn := dbtest.Open(t)
+	root := t.TempDir()
+	for _, dir := range []string{"a", "b"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(conn, trash.New(filepath.Join(root, ".Trash"))), root
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestUndoMove(t *testing.T) {
+	j, root := setupJournal(t)
+	src := filepath.Join(root, "a", "f.txt")
+	dst := filepath.Join(root, "b", "f.txt")
+	writeFile(t, src, "data")
+
+	if err := fileops.Move(src, dst, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Record("move", []Item{{Action: ActionMove, Source: src, Target: dst}}); err != nil {
+		t.Fatal(err)
+	}
+
+	undone, err := j.Undo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undone) != 1 || undone[0].Kind != "move" {
+		t.Fatalf("Undo = %+v", undone)
+	}
+	if readFile(t, src) != "data" {
+		t.Error("file not moved back")
+	}
+	if _, err := j.Undo(1); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("second undo: err = %v", err)
+	}
+}
+
+func TestUndoRefusesModifiedTarget(t *testing.T) {
+	j, root := setupJournal(t)
+	src := filepath.Join(root, "a", "f.txt")
+	dst := filepath.Join(root, "b", "f.txt")
+	writeFile(t, src, "data")
+	if err := fileops.Move(src, dst, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Record("move", []Item{{Action: ActionMove, Source: src, Target: dst}}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, dst, "edited afterwards")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(dst, later, later)
+
+	if _, err := j.Undo(1); !errors.Is(err, ErrChanged) {
+		t.Fatalf("Undo: err = %v, want ErrChanged", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("modified file was moved back")
+	}
+}
+
+func TestUndoOverwriteAndDelete(t *testing.T) {
+	j, root := setupJournal(t)
+	src := filepath.Join(root, "a", "f.txt")
+	dst := filepath.Join(root, "b", "f.txt")
+	writeFile(t, src, "new")
+	writeFile(t, dst, "old")
+
+	// An overwriting move: the old file is trashed, then the new one moved.
+	replaced, err := j.bin.Trash(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fileops.Move(src, dst, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Record("move", []Item{
+		{Action: ActionTrash, Source: dst, Target: replaced.ID},
+		{Action: ActionMove, Source: src, Target: dst},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then a delete to the trash.
+	other := filepath.Join(root, "a", "g.txt")
+	writeFile(t, other, "g")
+	trashed, err := j.bin.Trash(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Record("delete", []Item{{Action: ActionTrash, Source: other, Target: trashed.ID}}); err != nil {
+		t.Fatal(err)
+	}
+
+	undone, err := j.Undo(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undone) != 2 || undone[0].Kind != "delete" || undone[1].Kind != "move" {
+		t.Fatalf("Undo = %+v", undone)
+	}
+	if readFile(t, other) != "g" || readFile(t, src) != "new" || readFile(t, dst) != "old" {
+		t.Error("files not restored to their original state")
+	}
+}
+
+func TestUndoResumesAfterPartialFailure(t *testing.T) {
+	j, root := setupJournal(t)
+	var items []Item
+	for _, name := range []string{"f.txt", "g.txt"} {
+		src := filepath.Join(root, "a", name)
+		dst := filepath.Join(root, "b", name)
+		writeFile(t, src, name)
+		if err := fileops.Move(src, dst, false); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, Item{Action: ActionMove, Source: src, Target: dst})
+	}
+	if _, err := j.Record("move", items); err != nil {
+		t.Fatal(err)
+	}
+
+	// Something new at f.txt's old place stops the undo after g.txt,
+	// which is reverted first, was already moved back.
+	blocker := filepath.Join(root, "a", "f.txt")
+	writeFile(t, blocker, "blocker")
+	if _, err := j.Undo(1); !errors.Is(err, fileops.ErrAlreadyExists) {
+		t.Fatalf("Undo with blocked source: err = %v", err)
+	}
+	if readFile(t, filepath.Join(root, "a", "g.txt")) != "g.txt" {
+		t.Fatal("g.txt should have been moved back")
+	}
+	history, err := j.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[0].UndoneAt != nil || history[0].Items[0].Reverted || !history[0].Items[1].Reverted {
+		t.Errorf("after partial undo: %+v", history[0])
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	undone, err := j.Undo(1)
+	if err != nil {
+		t.Fatalf("retrying undo: %v", err)
+	}
+	if len(undone) != 1 || readFile(t, filepath.Join(root, "a", "f.txt")) != "f.txt" {
+		t.Errorf("retry did not finish the undo: %+v", undone)
+	}
+}
+
+func TestUndoCopyAndMkdir(t *testing.T) {
+	j, root := setupJournal(t)
+	created, err := fileops.MakeDir(filepath.Join(root, "x", "y"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []Item
+	for _, dir := range created {
+		items = append(items, Item{Action: ActionMkdir, Target: dir})
+	}
+	if _, err := j.Record("mkdir", items); err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(root, "a", "f.txt")
+	dst := filepath.Join(root, "b", "f.txt")
+	writeFile(t, src, "data")
+	if err := fileops.Copy(src, dst, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Record("copy", []Item{{Action: ActionCopy, Source: src, Target: dst}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := j.Undo(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Error("copy still exists")
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Error("undoing a copy removed the original")
+	}
+	if _, err := os.Stat(filepath.Join(root, "x")); !os.IsNotExist(err) {
+		t.Error("created folders still exist")
+	}
+
+	ops, err := j.List(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 || ops[0].UndoneAt == nil || len(ops[1].Items) != 2 {
+		t.Errorf("List = %+v", ops)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	j, root := setupJournal(t)
+	dir := filepath.Join(root, "a")
+	for i := 0; i < 5; i++ {
+		if _, err := j.Record("mkdir", []Item{{Action: ActionMkdir, Target: dir}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Prune(2); err != nil {
+		t.Fatal(err)
+	}
+	ops, err := j.List(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 || ops[0].ID != 5 {
+		t.Errorf("after prune: %+v", ops)
+	}
+	var items int
+	j.db.QueryRow("SELECT COUNT(*) FROM operation_items").Scan(&items)
+	if items != 2 {
+		t.Errorf("%d items left, want 2", items)
+	}
+}
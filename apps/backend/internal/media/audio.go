@@ -0,0 +1,403 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxTagSize bounds how much tag data is read from a file.
+const maxTagSize = 16 << 20
+
+// readMP3 reads ID3v2 tags, falling back to ID3v1, and estimates the
+// duration from the first audio frame.
+func readMP3(f *os.File, m *Metadata) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	audioStart := int64(0)
+	var hdr [10]byte
+	if _, err := f.ReadAt(hdr[:], 0); err == nil && string(hdr[:3]) == "ID3" {
+		size := int64(syncsafe(hdr[6:10]))
+		audioStart = 10 + size
+		if size <= maxTagSize {
+			tag := make([]byte, size)
+			if _, err := f.ReadAt(tag, 10); err == nil || err == io.EOF {
+				parseID3v2(tag, hdr[3], hdr[5], m)
+			}
+		}
+	}
+	if m.Title == "" && m.Artist == "" && info.Size() >= 128 {
+		var v1 [128]byte
+		if _, err := f.ReadAt(v1[:], info.Size()-128); err == nil && string(v1[:3]) == "TAG" {
+			m.Title = latin1(bytes.TrimRight(v1[3:33], "\x00 "))
+			m.Artist = latin1(bytes.TrimRight(v1[33:63], "\x00 "))
+			m.Album = latin1(bytes.TrimRight(v1[63:93], "\x00 "))
+			m.Year, _ = strconv.Atoi(strings.TrimSpace(string(v1[93:97])))
+		}
+	}
+	m.AudioCodec = "mp3"
+	mp3Duration(f, audioStart, info.Size()-audioStart, m)
+	return nil
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// parseID3v2 reads the text frames of an ID3v2.2, 2.3 or 2.4 tag body.
+func parseID3v2(tag []byte, version, flags byte, m *Metadata) {
+	if flags&0x40 != 0 && len(tag) >= 4 { // extended header
+		size := int(binary.BigEndian.Uint32(tag))
+		if version == 4 {
+			size = int(syncsafe(tag))
+		} else {
+			size += 4
+		}
+		if size > len(tag) {
+			return
+		}
+		tag = tag[size:]
+	}
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(tag) >= headerLen && tag[0] != 0 {
+		id := string(tag[:idLen])
+		var size int
+		switch version {
+		case 2:
+			size = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 4:
+			size = int(syncsafe(tag[4:8]))
+		default:
+			size = int(binary.BigEndian.Uint32(tag[4:8]))
+		}
+		if size < 0 || headerLen+size > len(tag) {
+			return
+		}
+		body := tag[headerLen : headerLen+size]
+		tag = tag[headerLen+size:]
+
+		if id[0] != 'T' || len(body) < 1 {
+			continue
+		}
+		text := id3Text(body)
+		switch id {
+		case "TIT2", "TT2":
+			m.Title = text
+		case "TPE1", "TP1":
+			m.Artist = text
+		case "TALB", "TAL":
+			m.Album = text
+		case "TCON", "TCO":
+			m.Genre = id3Genre(text)
+		case "TRCK", "TRK":
+			track, _, _ := strings.Cut(text, "/")
+			m.Track, _ = strconv.Atoi(track)
+		case "TYER", "TYE", "TDRC":
+			if len(text) >= 4 {
+				m.Year, _ = strconv.Atoi(text[:4])
+			}
+		}
+	}
+}
+
+// id3Text decodes a text frame body: an encoding byte, then the text,
+// possibly several values separated by NULs of which the first is used.
+func id3Text(body []byte) string {
+	enc, data := body[0], body[1:]
+	var s string
+	switch enc {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		var order binary.ByteOrder = binary.BigEndian
+		if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+			order, data = binary.LittleEndian, data[2:]
+		} else if len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF {
+			data = data[2:]
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			u := order.Uint16(data[i:])
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		s = string(utf16.Decode(units))
+	case 3:
+		s, _, _ = strings.Cut(string(data), "\x00")
+	default:
+		s = latin1(data)
+		s, _, _ = strings.Cut(s, "\x00")
+	}
+	return strings.TrimSpace(s)
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// id3Genres are the first ID3v1 genres, which older taggers write as
+// "(17)" instead of a name.
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap", "Reggae", "Rock",
+	"Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack",
+	"Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+}
+
+func id3Genre(s string) string {
+	n := strings.TrimSuffix(strings.TrimPrefix(s, "("), ")")
+	if i, err := strconv.Atoi(n); err == nil && i >= 0 && i < len(id3Genres) {
+		return id3Genres[i]
+	}
+	return s
+}
+
+// MPEG audio bitrates in kbit/s for MPEG-1 and MPEG-2 Layer III, and
+// sample rates per version.
+var (
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	}
+	mp3SampleRates = map[byte][3]int{
+		3: {44100, 48000, 32000}, // MPEG-1
+		2: {22050, 24000, 16000}, // MPEG-2
+		0: {11025, 12000, 8000},  // MPEG-2.5
+	}
+)
+
+// mp3Duration reads the first frame header. A Xing or Info header gives
+// the exact frame count; otherwise the stream is assumed to be constant
+// bitrate.
+func mp3Duration(f *os.File, start, size int64, m *Metadata) {
+	buf := make([]byte, 4096)
+	n, _ := f.ReadAt(buf, start)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version := (buf[i+1] >> 3) & 3
+		layer := (buf[i+1] >> 1) & 3
+		bitrateIdx := buf[i+2] >> 4
+		rateIdx := (buf[i+2] >> 2) & 3
+		rates, ok := mp3SampleRates[version]
+		if layer != 1 || !ok || rateIdx == 3 || bitrateIdx == 0 || bitrateIdx == 15 {
+			continue // not a Layer III frame header
+		}
+		table, samples := 0, 1152.0
+		if version != 3 {
+			table, samples = 1, 576
+		}
+		m.SampleRate = rates[rateIdx]
+		bitrate := mp3Bitrates[table][bitrateIdx] * 1000
+
+		for _, marker := range [][]byte{[]byte("Xing"), []byte("Info")} {
+			p := bytes.Index(buf[i:], marker)
+			if p < 0 || p > 64 {
+				continue
+			}
+			p += i
+			if p+12 <= len(buf) && buf[p+7]&1 != 0 { // frame count present
+				frames := binary.BigEndian.Uint32(buf[p+8:])
+				m.Duration = float64(frames) * samples / float64(m.SampleRate)
+				return
+			}
+		}
+		m.Duration = float64(size-int64(i)) * 8 / float64(bitrate)
+		return
+	}
+}
+
+// readFLAC reads STREAMINFO and the Vorbis comment block.
+func readFLAC(f *os.File, m *Metadata) error {
+	m.AudioCodec = "flac"
+	var magic [4]byte
+	if _, err := f.ReadAt(magic[:], 0); err != nil || string(magic[:]) != "fLaC" {
+		return nil
+	}
+	offset := int64(4)
+	for {
+		var hdr [4]byte
+		if _, err := f.ReadAt(hdr[:], offset); err != nil {
+			return nil
+		}
+		last, typ := hdr[0]&0x80 != 0, hdr[0]&0x7F
+		size := int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3])
+		offset += 4
+		if size > maxTagSize {
+			return nil
+		}
+		switch typ {
+		case 0: // STREAMINFO
+			var si [18]byte
+			if _, err := f.ReadAt(si[:], offset); err == nil {
+				rate := int(si[10])<<12 | int(si[11])<<4 | int(si[12])>>4
+				total := int64(si[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(si[14:]))
+				if rate > 0 {
+					m.SampleRate = rate
+					m.Duration = float64(total) / float64(rate)
+				}
+			}
+		case 4: // VORBIS_COMMENT
+			block := make([]byte, size)
+			if _, err := f.ReadAt(block, offset); err == nil {
+				parseVorbisComments(block, m)
+			}
+		}
+		offset += size
+		if last {
+			return nil
+		}
+	}
+}
+
+// parseVorbisComments reads a vendor string and KEY=value comments, all
+// length-prefixed little-endian.
+func parseVorbisComments(b []byte, m *Metadata) {
+	next := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 0 || 4+n > len(b) {
+			return nil, false
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+	if _, ok := next(); !ok { // vendor
+		return
+	}
+	if len(b) < 4 {
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	for i := 0; i < count; i++ {
+		c, ok := next()
+		if !ok {
+			return
+		}
+		key, value, ok := strings.Cut(string(c), "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			m.Title = value
+		case "ARTIST":
+			m.Artist = value
+		case "ALBUM":
+			m.Album = value
+		case "GENRE":
+			m.Genre = value
+		case "TRACKNUMBER":
+			track, _, _ := strings.Cut(value, "/")
+			m.Track, _ = strconv.Atoi(track)
+		case "DATE", "YEAR":
+			if len(value) >= 4 {
+				m.Year, _ = strconv.Atoi(value[:4])
+			}
+		}
+	}
+}
+
+// readOgg reads the identification and comment headers of an Ogg Vorbis
+// or Opus stream, and the duration from the last page's granule position.
+func readOgg(f *os.File, m *Metadata) error {
+	packets := oggPackets(f, 2)
+	if len(packets) == 0 {
+		return nil
+	}
+	id := packets[0]
+	switch {
+	case len(id) >= 16 && string(id[:7]) == "\x01vorbis":
+		m.AudioCodec = "vorbis"
+		m.SampleRate = int(binary.LittleEndian.Uint32(id[12:]))
+	case len(id) >= 12 && string(id[:8]) == "OpusHead":
+		m.AudioCodec = "opus"
+		m.SampleRate = 48000 // Opus granule positions always count 48 kHz
+	default:
+		return nil
+	}
+	if len(packets) > 1 {
+		comment := packets[1]
+		switch {
+		case bytes.HasPrefix(comment, []byte("\x03vorbis")):
+			parseVorbisComments(comment[7:], m)
+		case bytes.HasPrefix(comment, []byte("OpusTags")):
+			parseVorbisComments(comment[8:], m)
+		}
+	}
+
+	info, err := f.Stat()
+	if err != nil || m.SampleRate == 0 {
+		return nil
+	}
+	tailSize := int64(65536)
+	if info.Size() < tailSize {
+		tailSize = info.Size()
+	}
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, info.Size()-tailSize); err != nil && err != io.EOF {
+		return nil
+	}
+	if i := bytes.LastIndex(tail, []byte("OggS")); i >= 0 && i+14 <= len(tail) {
+		granule := binary.LittleEndian.Uint64(tail[i+6:])
+		m.Duration = float64(granule) / float64(m.SampleRate)
+	}
+	return nil
+}
+
+// oggPackets reassembles the first n packets of an Ogg stream.
+func oggPackets(f *os.File, n int) [][]byte {
+	var packets [][]byte
+	var cur []byte
+	offset := int64(0)
+	for pages := 0; pages < 64 && len(packets) < n; pages++ {
+		var hdr [27]byte
+		if _, err := f.ReadAt(hdr[:], offset); err != nil || string(hdr[:4]) != "OggS" {
+			break
+		}
+		segments := make([]byte, hdr[26])
+		if _, err := f.ReadAt(segments, offset+27); err != nil {
+			break
+		}
+		offset += 27 + int64(len(segments))
+		for _, seg := range segments {
+			data := make([]byte, seg)
+			if _, err := f.ReadAt(data, offset); err != nil {
+				return packets
+			}
+			offset += int64(seg)
+			if len(cur)+len(data) > maxTagSize {
+				return packets
+			}
+			cur = append(cur, data...)
+			if seg < 255 {
+				packets = append(packets, cur)
+				cur = nil
+				if len(packets) == n {
+					return packets
+				}
+			}
+		}
+	}
+	return packets
+}
@@ -0,0 +1,345 @@
+// Package media reads metadata embedded in photos and other media files.
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoEXIF is returned for files without an EXIF block.
+var ErrNoEXIF = errors.New("no EXIF data")
+
+// EXIF holds the tags callers care about. Zero values mean the tag is
+// absent.
+type EXIF struct {
+	// DateTime is DateTimeOriginal, falling back to DateTime. EXIF dates
+	// have no zone unless OffsetTimeOriginal is set, so they are returned
+	// in UTC with the camera's wall clock reading.
+	DateTime     time.Time `json:"dateTime,omitempty"`
+	Make         string    `json:"make,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	Lens         string    `json:"lens,omitempty"`
+	Orientation  int       `json:"orientation,omitempty"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	ExposureTime float64   `json:"exposureTime,omitempty"`
+	FNumber      float64   `json:"fNumber,omitempty"`
+	ISO          int       `json:"iso,omitempty"`
+	FocalLength  float64   `json:"focalLength,omitempty"`
+	GPS          *GPS      `json:"gps,omitempty"`
+
+	// ThumbnailOffset and ThumbnailLength locate the embedded JPEG preview
+	// within the file, if there is one.
+	ThumbnailOffset int64 `json:"-"`
+	ThumbnailLength int64 `json:"-"`
+}
+
+// GPS is a position in decimal degrees and metres above sea level.
+type GPS struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+// TIFF tag numbers read by ReadEXIF.
+const (
+	tagImageWidth        = 0x0100
+	tagImageHeight       = 0x0101
+	tagMake              = 0x010F
+	tagModel             = 0x0110
+	tagOrientation       = 0x0112
+	tagDateTime          = 0x0132
+	tagThumbnailOffset   = 0x0201
+	tagThumbnailLength   = 0x0202
+	tagExposureTime      = 0x829A
+	tagFNumber           = 0x829D
+	tagExifIFD           = 0x8769
+	tagGPSIFD            = 0x8825
+	tagISO               = 0x8827
+	tagDateTimeOriginal  = 0x9003
+	tagOffsetTimeOrig    = 0x9011
+	tagFocalLength       = 0x920A
+	tagPixelXDimension   = 0xA002
+	tagPixelYDimension   = 0xA003
+	tagLensModel         = 0xA434
+	tagGPSLatitudeRef    = 1
+	tagGPSLatitude       = 2
+	tagGPSLongitudeRef   = 3
+	tagGPSLongitude      = 4
+	tagGPSAltitudeRef    = 5
+	tagGPSAltitude       = 6
+	maxIFDEntries        = 1000
+	jpegSegmentSearchMax = 64
+)
+
+// ReadEXIF reads the EXIF block of a JPEG file or of a TIFF-based file,
+// which includes most camera RAW formats (CR2, NEF, ARW, DNG).
+func ReadEXIF(path string) (*EXIF, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeEXIF(f)
+}
+
+// DecodeEXIF is ReadEXIF for an open file.
+func DecodeEXIF(r io.ReaderAt) (*EXIF, error) {
+	var head [4]byte
+	if _, err := r.ReadAt(head[:], 0); err != nil {
+		return nil, ErrNoEXIF
+	}
+	switch {
+	case head[0] == 0xFF && head[1] == 0xD8:
+		base, err := findJPEGExif(r)
+		if err != nil {
+			return nil, err
+		}
+		return decodeTIFF(r, base)
+	case bytes.Equal(head[:], []byte("II*\x00")), bytes.Equal(head[:], []byte("MM\x00*")):
+		return decodeTIFF(r, 0)
+	}
+	return nil, ErrNoEXIF
+}
+
+// findJPEGExif walks the JPEG markers up to the image data and returns the
+// offset of the TIFF header inside the APP1 Exif segment.
+func findJPEGExif(r io.ReaderAt) (int64, error) {
+	offset := int64(2)
+	for i := 0; i < jpegSegmentSearchMax; i++ {
+		var hdr [4]byte
+		if _, err := r.ReadAt(hdr[:], offset); err != nil {
+			return 0, ErrNoEXIF
+		}
+		if hdr[0] != 0xFF {
+			return 0, ErrNoEXIF
+		}
+		marker := hdr[1]
+		if marker == 0xD9 || marker == 0xDA { // end of image, start of scan
+			return 0, ErrNoEXIF
+		}
+		length := int64(binary.BigEndian.Uint16(hdr[2:]))
+		if marker == 0xE1 && length >= 8 {
+			var id [6]byte
+			if _, err := r.ReadAt(id[:], offset+4); err == nil && string(id[:]) == "Exif\x00\x00" {
+				return offset + 10, nil
+			}
+		}
+		offset += 2 + length
+	}
+	return 0, ErrNoEXIF
+}
+
+// tiffReader reads values relative to a TIFF header at base.
+type tiffReader struct {
+	r     io.ReaderAt
+	base  int64
+	order binary.ByteOrder
+}
+
+// ifdEntry is one raw directory entry.
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	value [4]byte // the value itself, or its offset when it is larger
+}
+
+func decodeTIFF(r io.ReaderAt, base int64) (*EXIF, error) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], base); err != nil {
+		return nil, ErrNoEXIF
+	}
+	t := &tiffReader{r: r, base: base}
+	switch string(hdr[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, ErrNoEXIF
+	}
+
+	ifd0, next, err := t.readIFD(int64(t.order.Uint32(hdr[4:])))
+	if err != nil {
+		return nil, err
+	}
+	x := &EXIF{}
+	x.Make = t.str(ifd0[tagMake])
+	x.Model = t.str(ifd0[tagModel])
+	x.Orientation = int(t.uint(ifd0[tagOrientation]))
+	x.Width = int(t.uint(ifd0[tagImageWidth]))
+	x.Height = int(t.uint(ifd0[tagImageHeight]))
+	x.DateTime = parseDate(t.str(ifd0[tagDateTime]), "")
+
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if exif, _, err := t.readIFD(int64(t.uint(e))); err == nil {
+			if d := parseDate(t.str(exif[tagDateTimeOriginal]), t.str(exif[tagOffsetTimeOrig])); !d.IsZero() {
+				x.DateTime = d
+			}
+			x.Lens = t.str(exif[tagLensModel])
+			x.ExposureTime = t.rational(exif[tagExposureTime], 0)
+			x.FNumber = t.rational(exif[tagFNumber], 0)
+			x.FocalLength = t.rational(exif[tagFocalLength], 0)
+			x.ISO = int(t.uint(exif[tagISO]))
+			if w := int(t.uint(exif[tagPixelXDimension])); w > 0 {
+				x.Width = w
+				x.Height = int(t.uint(exif[tagPixelYDimension]))
+			}
+		}
+	}
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps, _, err := t.readIFD(int64(t.uint(e))); err == nil {
+			x.GPS = t.gps(gps)
+		}
+	}
+	// IFD1 describes the embedded thumbnail.
+	if next != 0 {
+		if ifd1, _, err := t.readIFD(next); err == nil {
+			if off, n := t.uint(ifd1[tagThumbnailOffset]), t.uint(ifd1[tagThumbnailLength]); off > 0 && n > 0 {
+				x.ThumbnailOffset = base + int64(off)
+				x.ThumbnailLength = int64(n)
+			}
+		}
+	}
+	return x, nil
+}
+
+// readIFD reads the directory at offset and returns its entries by tag
+// and the offset of the next directory.
+func (t *tiffReader) readIFD(offset int64) (map[uint16]ifdEntry, int64, error) {
+	if offset <= 0 {
+		return nil, 0, ErrNoEXIF
+	}
+	var countBuf [2]byte
+	if _, err := t.r.ReadAt(countBuf[:], t.base+offset); err != nil {
+		return nil, 0, ErrNoEXIF
+	}
+	count := int(t.order.Uint16(countBuf[:]))
+	if count > maxIFDEntries {
+		return nil, 0, ErrNoEXIF
+	}
+	buf := make([]byte, count*12+4)
+	if _, err := t.r.ReadAt(buf, t.base+offset+2); err != nil && err != io.EOF {
+		return nil, 0, ErrNoEXIF
+	}
+	entries := make(map[uint16]ifdEntry, count)
+	for i := 0; i < count; i++ {
+		b := buf[i*12:]
+		e := ifdEntry{typ: t.order.Uint16(b[2:]), count: t.order.Uint32(b[4:])}
+		copy(e.value[:], b[8:12])
+		entries[t.order.Uint16(b)] = e
+	}
+	next := int64(t.order.Uint32(buf[count*12:]))
+	return entries, next, nil
+}
+
+// typeSizes is the byte size of each TIFF field type.
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// data returns the raw bytes of e's value.
+func (t *tiffReader) data(e ifdEntry) []byte {
+	size := typeSizes[e.typ] * int(e.count)
+	if size <= 0 || size > 1<<16 {
+		return nil
+	}
+	if size <= 4 {
+		return e.value[:size]
+	}
+	buf := make([]byte, size)
+	if _, err := t.r.ReadAt(buf, t.base+int64(t.order.Uint32(e.value[:]))); err != nil {
+		return nil
+	}
+	return buf
+}
+
+func (t *tiffReader) str(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	b := t.data(e)
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func (t *tiffReader) uint(e ifdEntry) uint32 {
+	switch e.typ {
+	case 3:
+		return uint32(t.order.Uint16(e.value[:]))
+	case 4, 9:
+		return t.order.Uint32(e.value[:])
+	}
+	return 0
+}
+
+// rational returns the i-th rational of e as a float.
+func (t *tiffReader) rational(e ifdEntry, i int) float64 {
+	if e.typ != 5 && e.typ != 10 {
+		return 0
+	}
+	b := t.data(e)
+	if len(b) < (i+1)*8 {
+		return 0
+	}
+	num, den := t.order.Uint32(b[i*8:]), t.order.Uint32(b[i*8+4:])
+	if den == 0 {
+		return 0
+	}
+	if e.typ == 10 {
+		return float64(int32(num)) / float64(int32(den))
+	}
+	return float64(num) / float64(den)
+}
+
+func (t *tiffReader) gps(ifd map[uint16]ifdEntry) *GPS {
+	lat, latOK := t.degrees(ifd[tagGPSLatitude])
+	lon, lonOK := t.degrees(ifd[tagGPSLongitude])
+	if !latOK || !lonOK {
+		return nil
+	}
+	if t.str(ifd[tagGPSLatitudeRef]) == "S" {
+		lat = -lat
+	}
+	if t.str(ifd[tagGPSLongitudeRef]) == "W" {
+		lon = -lon
+	}
+	g := &GPS{Latitude: lat, Longitude: lon, Altitude: t.rational(ifd[tagGPSAltitude], 0)}
+	if ref := t.data(ifd[tagGPSAltitudeRef]); len(ref) == 1 && ref[0] == 1 {
+		g.Altitude = -g.Altitude
+	}
+	return g
+}
+
+// degrees converts a degrees, minutes, seconds triple.
+func (t *tiffReader) degrees(e ifdEntry) (float64, bool) {
+	if e.count != 3 {
+		return 0, false
+	}
+	d := t.rational(e, 0) + t.rational(e, 1)/60 + t.rational(e, 2)/3600
+	return math.Round(d*1e7) / 1e7, true
+}
+
+// parseDate parses an EXIF "2006:01:02 15:04:05" date with an optional
+// "+01:00" offset.
+func parseDate(s, offset string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if offset != "" {
+		if d, err := time.Parse("2006:01:02 15:04:05-07:00", s+offset); err == nil {
+			return d
+		}
+	}
+	d, err := time.Parse("2006:01:02 15:04:05", s)
+	if err != nil {
+		return time.Time{}
+	}
+	return d
+}
@@ -0,0 +1,161 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tiffEntry is a tag for buildTIFF; value is a string, uint16, uint32 or
+// a slice of [2]uint32 rationals. Sub-IFDs are given by subIFD.
+type tiffEntry struct {
+	tag    uint16
+	value  interface{}
+	subIFD []tiffEntry
+}
+
+// buildTIFF lays out a little-endian TIFF with IFD0 made of entries.
+func buildTIFF(entries []tiffEntry) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	writeIFD(&buf, entries)
+	return buf.Bytes()
+}
+
+// writeIFD appends the directory at the current end of buf, followed by
+// its out-of-line values and sub-directories.
+func writeIFD(buf *bytes.Buffer, entries []tiffEntry) {
+	start := buf.Len()
+	dataStart := start + 2 + len(entries)*12 + 4
+	var data bytes.Buffer
+	var subs []int // entry index of each sub-IFD pointer
+	dir := new(bytes.Buffer)
+	binary.Write(dir, binary.LittleEndian, uint16(len(entries)))
+	for i, e := range entries {
+		binary.Write(dir, binary.LittleEndian, e.tag)
+		var typ uint16
+		var count uint32
+		var raw []byte
+		switch v := e.value.(type) {
+		case string:
+			typ, count, raw = 2, uint32(len(v)+1), append([]byte(v), 0)
+		case uint16:
+			typ, count = 3, 1
+			raw = binary.LittleEndian.AppendUint16(nil, v)
+		case uint32:
+			typ, count = 4, 1
+			raw = binary.LittleEndian.AppendUint32(nil, v)
+		case [][2]uint32:
+			typ, count = 5, uint32(len(v))
+			for _, r := range v {
+				raw = binary.LittleEndian.AppendUint32(raw, r[0])
+				raw = binary.LittleEndian.AppendUint32(raw, r[1])
+			}
+		case nil:
+			typ, count = 4, 1
+			raw = make([]byte, 4)
+			subs = append(subs, i)
+		}
+		binary.Write(dir, binary.LittleEndian, typ)
+		binary.Write(dir, binary.LittleEndian, count)
+		if len(raw) <= 4 {
+			dir.Write(append(raw, make([]byte, 4-len(raw))...))
+		} else {
+			binary.Write(dir, binary.LittleEndian, uint32(dataStart+data.Len()))
+			data.Write(raw)
+		}
+	}
+	binary.Write(dir, binary.LittleEndian, uint32(0))
+	buf.Write(dir.Bytes())
+	buf.Write(data.Bytes())
+	for _, i := range subs {
+		offset := buf.Len()
+		b := buf.Bytes()
+		binary.LittleEndian.PutUint32(b[start+2+i*12+8:], uint32(offset))
+		writeIFD(buf, entries[i].subIFD)
+	}
+}
+
+func sampleTIFF() []byte {
+	return buildTIFF([]tiffEntry{
+		{tag: tagMake, value: "Canon"},
+		{tag: tagModel, value: "EOS R5"},
+		{tag: tagOrientation, value: uint16(6)},
+		{tag: tagExifIFD, subIFD: []tiffEntry{
+			{tag: tagDateTimeOriginal, value: "2019:07:14 10:30:00"},
+			{tag: tagOffsetTimeOrig, value: "+02:00"},
+			{tag: tagFNumber, value: [][2]uint32{{28, 10}}},
+			{tag: tagLensModel, value: "RF24-105mm F4 L IS USM"},
+		}},
+		{tag: tagGPSIFD, subIFD: []tiffEntry{
+			{tag: tagGPSLatitudeRef, value: "N"},
+			{tag: tagGPSLatitude, value: [][2]uint32{{48, 1}, {51, 1}, {30, 1}}},
+			{tag: tagGPSLongitudeRef, value: "W"},
+			{tag: tagGPSLongitude, value: [][2]uint32{{2, 1}, {21, 1}, {0, 1}}},
+		}},
+	})
+}
+
+func checkSample(t *testing.T, x *EXIF) {
+	t.Helper()
+	want := time.Date(2019, 7, 14, 10, 30, 0, 0, time.FixedZone("", 2*3600))
+	if !x.DateTime.Equal(want) {
+		t.Errorf("DateTime = %v, want %v", x.DateTime, want)
+	}
+	if x.Make != "Canon" || x.Model != "EOS R5" || x.Lens != "RF24-105mm F4 L IS USM" {
+		t.Errorf("camera = %q %q %q", x.Make, x.Model, x.Lens)
+	}
+	if x.Orientation != 6 || x.FNumber != 2.8 {
+		t.Errorf("orientation %d, f/%v", x.Orientation, x.FNumber)
+	}
+	if x.GPS == nil || x.GPS.Latitude != 48.8583333 || x.GPS.Longitude != -2.35 {
+		t.Errorf("GPS = %+v", x.GPS)
+	}
+}
+
+func TestDecodeTIFF(t *testing.T) {
+	x, err := DecodeEXIF(bytes.NewReader(sampleTIFF()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSample(t, x)
+}
+
+func TestReadJPEG(t *testing.T) {
+	tiff := sampleTIFF()
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8})
+	// An APP0 segment before the EXIF one, as written by most encoders.
+	jpeg.Write([]byte{0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0})
+	jpeg.Write([]byte{0xFF, 0xE1})
+	binary.Write(&jpeg, binary.BigEndian, uint16(2+6+len(tiff)))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff)
+	jpeg.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, jpeg.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	x, err := ReadEXIF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSample(t, x)
+}
+
+func TestNoEXIF(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("plain text"),
+		{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02},
+		[]byte("II*\x00\xff\xff\xff\x7f"),
+	} {
+		if _, err := DecodeEXIF(bytes.NewReader(data)); err != ErrNoEXIF {
+			t.Errorf("%q: err = %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned by Read for files it has no reader for.
+var ErrUnsupported = errors.New("unsupported media type")
+
+// Kinds of media.
+const (
+	KindImage = "image"
+	KindAudio = "audio"
+	KindVideo = "video"
+)
+
+// Metadata is what Read found in a file. Fields that do not apply to the
+// kind of file, or that the file does not record, are zero.
+type Metadata struct {
+	Kind string `json:"kind"`
+	// DateTime is when a photo was taken or a video recorded.
+	DateTime    time.Time `json:"dateTime,omitempty"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Keywords    []string  `json:"keywords,omitempty"`
+	Rating      int       `json:"rating,omitempty"`
+
+	// Photos.
+	Make        string `json:"make,omitempty"`
+	Model       string `json:"model,omitempty"`
+	Lens        string `json:"lens,omitempty"`
+	Orientation int    `json:"orientation,omitempty"`
+	GPS         *GPS   `json:"gps,omitempty"`
+	EXIF        *EXIF  `json:"exif,omitempty"`
+
+	// Audio tags.
+	Artist string `json:"artist,omitempty"`
+	Album  string `json:"album,omitempty"`
+	Genre  string `json:"genre,omitempty"`
+	Year   int    `json:"year,omitempty"`
+	Track  int    `json:"track,omitempty"`
+
+	// Audio and video streams. Duration is in seconds.
+	Duration   float64 `json:"duration,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	VideoCodec string  `json:"videoCodec,omitempty"`
+	AudioCodec string  `json:"audioCodec,omitempty"`
+	SampleRate int     `json:"sampleRate,omitempty"`
+}
+
+// Camera returns the make and model, without repeating the make when the
+// model already starts with it as many manufacturers do.
+func (m *Metadata) Camera() string {
+	if m.Make == "" || strings.HasPrefix(strings.ToLower(m.Model), strings.ToLower(m.Make)) {
+		return m.Model
+	}
+	return strings.TrimSpace(m.Make + " " + m.Model)
+}
+
+// reader extracts metadata from one family of formats.
+type reader func(f *os.File, m *Metadata) error
+
+// readers maps extensions to a kind and reader.
+var readers = map[string]struct {
+	kind string
+	read reader
+}{
+	".jpg":  {KindImage, readPhoto},
+	".jpeg": {KindImage, readPhoto},
+	".tif":  {KindImage, readPhoto},
+	".tiff": {KindImage, readPhoto},
+	".png":  {KindImage, readPhoto},
+	".webp": {KindImage, readPhoto},
+	".heic": {KindImage, readPhoto},
+	".heif": {KindImage, readPhoto},
+	".cr2":  {KindImage, readPhoto},
+	".nef":  {KindImage, readPhoto},
+	".arw":  {KindImage, readPhoto},
+	".dng":  {KindImage, readPhoto},
+	".orf":  {KindImage, readPhoto},
+	".rw2":  {KindImage, readPhoto},
+	".pef":  {KindImage, readPhoto},
+	".srw":  {KindImage, readPhoto},
+	".mp3":  {KindAudio, readMP3},
+	".flac": {KindAudio, readFLAC},
+	".ogg":  {KindAudio, readOgg},
+	".oga":  {KindAudio, readOgg},
+	".opus": {KindAudio, readOgg},
+	".m4a":  {KindAudio, readMP4},
+	".m4b":  {KindAudio, readMP4},
+	".mp4":  {KindVideo, readMP4},
+	".m4v":  {KindVideo, readMP4},
+	".mov":  {KindVideo, readMP4},
+	".3gp":  {KindVideo, readMP4},
+}
+
+// Supported reports whether Read understands the file's extension.
+func Supported(path string) bool {
+	_, ok := readers[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// Read extracts the embedded metadata of the photo, audio or video file at
+// path. Missing tags are not an error; a file with none yields Metadata
+// with only Kind set.
+func Read(path string) (*Metadata, error) {
+	r, ok := readers[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := &Metadata{Kind: r.kind}
+	if err := r.read(f, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// headScanLimit bounds how much of a file is searched for embedded EXIF
+// and XMP blocks whose position is not known.
+const headScanLimit = 4 << 20
+
+var exifMarker = []byte("Exif\x00\x00")
+
+// readPhoto combines EXIF with XMP, which takes precedence for the fields
+// people edit afterwards such as title, keywords and rating.
+func readPhoto(f *os.File, m *Metadata) error {
+	head, err := readHead(f, headScanLimit)
+	if err != nil {
+		return err
+	}
+
+	x, err := DecodeEXIF(f)
+	if err == ErrNoEXIF {
+		// HEIF, PNG and WebP keep EXIF in a box or chunk of their own.
+		if i := bytes.Index(head, exifMarker); i >= 0 {
+			x, err = DecodeEXIF(bytes.NewReader(head[i+len(exifMarker):]))
+		}
+	}
+	if err == nil {
+		m.EXIF = x
+		m.DateTime = x.DateTime
+		m.Make, m.Model, m.Lens = x.Make, x.Model, x.Lens
+		m.Orientation = x.Orientation
+		m.Width, m.Height = x.Width, x.Height
+		m.GPS = x.GPS
+	}
+
+	if xmp := decodeXMP(head); xmp != nil {
+		xmp.apply(m)
+	}
+	return nil
+}
+
+// readHead returns up to limit bytes from the start of f.
+func readHead(f *os.File, limit int64) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < limit {
+		limit = info.Size()
+	}
+	buf := make([]byte, limit)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
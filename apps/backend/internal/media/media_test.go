@@ -0,0 +1,161 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeTemp(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func id3Frame(id, text string) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	binary.Write(&b, binary.BigEndian, uint32(len(text)+1))
+	b.Write([]byte{0, 0, 3}) // flags, then UTF-8 encoding
+	b.WriteString(text)
+	return b.Bytes()
+}
+
+func TestReadMP3(t *testing.T) {
+	var frames bytes.Buffer
+	frames.Write(id3Frame("TIT2", "Song"))
+	frames.Write(id3Frame("TPE1", "Band"))
+	frames.Write(id3Frame("TALB", "Album"))
+	frames.Write(id3Frame("TRCK", "3/12"))
+	frames.Write(id3Frame("TYER", "1999"))
+	frames.Write(id3Frame("TCON", "(17)"))
+	size := frames.Len()
+
+	var file bytes.Buffer
+	file.WriteString("ID3\x03\x00\x00")
+	file.Write([]byte{byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)})
+	file.Write(frames.Bytes())
+	// One second of 128 kbit/s MPEG-1 Layer III at 44.1 kHz.
+	audio := make([]byte, 16000)
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x00})
+	file.Write(audio)
+
+	m, err := Read(writeTemp(t, "a.mp3", file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{Kind: KindAudio, Title: "Song", Artist: "Band", Album: "Album", Track: 3, Year: 1999,
+		Genre: "Rock", AudioCodec: "mp3", SampleRate: 44100, Duration: 1}
+	if !reflect.DeepEqual(*m, want) {
+		t.Errorf("Read = %+v\nwant %+v", *m, want)
+	}
+}
+
+func TestReadFLAC(t *testing.T) {
+	var comments bytes.Buffer
+	le := func(n int) { binary.Write(&comments, binary.LittleEndian, uint32(n)) }
+	le(6)
+	comments.WriteString("vendor")
+	le(2)
+	for _, c := range []string{"TITLE=Night", "artist=Someone"} {
+		le(len(c))
+		comments.WriteString(c)
+	}
+
+	var file bytes.Buffer
+	file.WriteString("fLaC")
+	file.Write([]byte{0, 0, 0, 34})
+	si := make([]byte, 34)
+	// 48000 Hz, 96000 samples.
+	si[10], si[11], si[12] = 0x0B, 0xB8, 0x00
+	binary.BigEndian.PutUint32(si[14:], 96000)
+	file.Write(si)
+	file.Write([]byte{0x84, 0, byte(comments.Len() >> 8), byte(comments.Len())})
+	file.Write(comments.Bytes())
+
+	m, err := Read(writeTemp(t, "a.flac", file.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Night" || m.Artist != "Someone" || m.Duration != 2 || m.SampleRate != 48000 {
+		t.Errorf("Read = %+v", *m)
+	}
+}
+
+// box builds an MP4 box.
+func box(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func TestReadMP4(t *testing.T) {
+	mvhd := make([]byte, 100)
+	created := uint32(time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC).Sub(mp4Epoch) / time.Second)
+	binary.BigEndian.PutUint32(mvhd[4:], created)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 90500)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], 1920<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 1080<<16)
+	hdlr := append(make([]byte, 8), "vide"...)
+	hdlr = append(hdlr, make([]byte, 12)...)
+	stsd := append(make([]byte, 8), box("avc1", make([]byte, 78))...)
+	data := append(append(binary.BigEndian.AppendUint32(nil, 1), 0, 0, 0, 0), "Holiday"...)
+
+	file := bytes.Join([][]byte{
+		box("ftyp", []byte("isom\x00\x00\x00\x00")),
+		box("moov",
+			box("mvhd", mvhd),
+			box("trak",
+				box("tkhd", tkhd),
+				box("mdia", box("hdlr", hdlr), box("minf", box("stbl", box("stsd", stsd))))),
+			box("udta", box("meta", make([]byte, 4), box("ilst", box("\xa9nam", box("data", data)))))),
+		box("mdat", make([]byte, 32)),
+	}, nil)
+
+	m, err := Read(writeTemp(t, "a.mp4", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Kind != KindVideo || m.VideoCodec != "avc1" || m.Width != 1920 || m.Height != 1080 {
+		t.Errorf("stream = %+v", *m)
+	}
+	if m.Duration != 90.5 || m.Title != "Holiday" || m.DateTime.Year() != 2020 {
+		t.Errorf("header = %+v", *m)
+	}
+}
+
+func TestReadPhotoXMP(t *testing.T) {
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmp:Rating="4" xmp:CreateDate="2018-02-03T04:05:06">
+<dc:subject><rdf:Bag><rdf:li>beach</rdf:li><rdf:li>sunset</rdf:li></rdf:Bag></dc:subject>
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Evening</rdf:li></rdf:Alt></dc:title>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+	var jpeg bytes.Buffer
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&jpeg, binary.BigEndian, uint16(2+len(xmp)))
+	jpeg.WriteString(xmp)
+	jpeg.Write([]byte{0xFF, 0xD9})
+
+	m, err := Read(writeTemp(t, "a.jpg", jpeg.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rating != 4 || m.Title != "Evening" || !reflect.DeepEqual(m.Keywords, []string{"beach", "sunset"}) {
+		t.Errorf("XMP = %+v", *m)
+	}
+	if !m.DateTime.Equal(time.Date(2018, 2, 3, 4, 5, 6, 0, time.UTC)) {
+		t.Errorf("DateTime = %v", m.DateTime)
+	}
+	if _, err := Read(writeTemp(t, "a.txt", nil)); err != ErrUnsupported {
+		t.Errorf("text file: err = %v", err)
+	}
+}
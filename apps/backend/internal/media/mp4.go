@@ -0,0 +1,211 @@
+package media
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mp4Epoch is the origin of MP4 timestamps.
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// mp4Containers are the boxes whose children readMP4 descends into.
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "ilst": true,
+}
+
+// mp4Track collects what one trak box says.
+type mp4Track struct {
+	handler       string
+	codec         string
+	width, height int
+	sampleRate    int
+}
+
+// readMP4 reads the movie header, track headers and iTunes-style tags of
+// an ISO base media file (MP4, M4A, MOV, 3GP).
+func readMP4(f *os.File, m *Metadata) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var tracks []*mp4Track
+	var track *mp4Track
+	var walk func(start, end int64, depth int)
+	walk = func(start, end int64, depth int) {
+		for offset := start; offset+8 <= end; {
+			var hdr [16]byte
+			if _, err := f.ReadAt(hdr[:8], offset); err != nil {
+				return
+			}
+			size := int64(binary.BigEndian.Uint32(hdr[:4]))
+			typ := string(hdr[4:8])
+			headerLen := int64(8)
+			switch size {
+			case 0:
+				size = end - offset
+			case 1:
+				if _, err := f.ReadAt(hdr[8:16], offset+8); err != nil {
+					return
+				}
+				size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+				headerLen = 16
+			}
+			if size < headerLen || offset+size > end {
+				return
+			}
+			body, bodyEnd := offset+headerLen, offset+size
+
+			switch {
+			case typ == "trak":
+				track = &mp4Track{}
+				tracks = append(tracks, track)
+				walk(body, bodyEnd, depth+1)
+			case mp4Containers[typ] && depth < 8:
+				walk(body, bodyEnd, depth+1)
+			case typ == "meta" && depth < 8:
+				// meta is a full box: version and flags precede its
+				// children.
+				walk(body+4, bodyEnd, depth+1)
+			case typ == "mvhd":
+				readMvhd(f, body, m)
+			case typ == "tkhd" && track != nil:
+				readTkhd(f, body, track)
+			case typ == "hdlr" && track != nil:
+				var h [12]byte
+				if _, err := f.ReadAt(h[:], body); err == nil {
+					track.handler = string(h[8:12])
+				}
+			case typ == "stsd" && track != nil:
+				readStsd(f, body, track)
+			case len(typ) == 4 && (typ[0] == 0xA9 || typ == "trkn" || typ == "gnre"):
+				readIlstItem(f, typ, body, bodyEnd, m)
+			}
+			offset += size
+		}
+	}
+	walk(0, info.Size(), 0)
+
+	for _, t := range tracks {
+		switch t.handler {
+		case "vide":
+			m.Kind = KindVideo
+			if m.VideoCodec == "" {
+				m.VideoCodec = t.codec
+				m.Width, m.Height = t.width, t.height
+			}
+		case "soun":
+			if m.AudioCodec == "" {
+				m.AudioCodec = t.codec
+				m.SampleRate = t.sampleRate
+			}
+		}
+	}
+	if m.Kind == KindVideo && m.VideoCodec == "" {
+		m.Kind = KindAudio // an .mp4 holding only sound
+	}
+	return nil
+}
+
+func readMvhd(f *os.File, body int64, m *Metadata) {
+	var b [32]byte
+	if _, err := f.ReadAt(b[:], body); err != nil && err != io.EOF {
+		return
+	}
+	var created, timescale, duration uint64
+	if b[0] == 1 {
+		created = binary.BigEndian.Uint64(b[4:])
+		timescale = uint64(binary.BigEndian.Uint32(b[20:]))
+		duration = binary.BigEndian.Uint64(b[24:])
+	} else {
+		created = uint64(binary.BigEndian.Uint32(b[4:]))
+		timescale = uint64(binary.BigEndian.Uint32(b[12:]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:]))
+	}
+	if timescale > 0 {
+		m.Duration = float64(duration) / float64(timescale)
+	}
+	if created > 0 && m.DateTime.IsZero() {
+		m.DateTime = mp4Epoch.Add(time.Duration(created) * time.Second)
+	}
+}
+
+func readTkhd(f *os.File, body int64, t *mp4Track) {
+	var version [1]byte
+	if _, err := f.ReadAt(version[:], body); err != nil {
+		return
+	}
+	// Width and height are the last two 16.16 fixed-point fields.
+	offset := body + 76
+	if version[0] == 1 {
+		offset = body + 88
+	}
+	var wh [8]byte
+	if _, err := f.ReadAt(wh[:], offset); err != nil {
+		return
+	}
+	t.width = int(binary.BigEndian.Uint32(wh[:4]) >> 16)
+	t.height = int(binary.BigEndian.Uint32(wh[4:]) >> 16)
+}
+
+// readStsd takes the codec from the first sample entry's format, and the
+// sample rate for audio entries.
+func readStsd(f *os.File, body int64, t *mp4Track) {
+	var b [48]byte
+	if _, err := f.ReadAt(b[:], body); err != nil && err != io.EOF {
+		return
+	}
+	t.codec = strings.TrimSpace(string(b[12:16]))
+	if t.handler == "soun" {
+		t.sampleRate = int(binary.BigEndian.Uint32(b[40:]) >> 16)
+	}
+}
+
+// readIlstItem reads the data box of one iTunes metadata item.
+func readIlstItem(f *os.File, typ string, body, end int64, m *Metadata) {
+	n := end - body
+	if n < 16 || n > maxTagSize {
+		return
+	}
+	b := make([]byte, n)
+	if _, err := f.ReadAt(b, body); err != nil {
+		return
+	}
+	if string(b[4:8]) != "data" {
+		return
+	}
+	dataLen := int(binary.BigEndian.Uint32(b[:4]))
+	if dataLen < 16 || dataLen > len(b) {
+		return
+	}
+	value := b[16:dataLen]
+	text := strings.TrimSpace(string(value))
+	switch typ {
+	case "\xa9nam":
+		m.Title = text
+	case "\xa9ART":
+		m.Artist = text
+	case "\xa9alb":
+		m.Album = text
+	case "\xa9gen":
+		m.Genre = text
+	case "\xa9day":
+		if len(text) >= 4 {
+			m.Year, _ = strconv.Atoi(text[:4])
+		}
+	case "\xa9cmt", "\xa9des":
+		m.Description = text
+	case "trkn":
+		if len(value) >= 4 {
+			m.Track = int(binary.BigEndian.Uint16(value[2:]))
+		}
+	case "gnre":
+		if len(value) >= 2 {
+			m.Genre = id3Genre("(" + strconv.Itoa(int(binary.BigEndian.Uint16(value))-1) + ")")
+		}
+	}
+}
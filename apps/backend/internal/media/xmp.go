@@ -0,0 +1,135 @@
+package media
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	xmpStart = []byte("<x:xmpmeta")
+	xmpEnd   = []byte("</x:xmpmeta>")
+)
+
+// xmpData holds the XMP properties Read uses, by local name.
+type xmpData struct {
+	values   map[string]string
+	keywords []string
+}
+
+// decodeXMP finds an XMP packet in data and parses it. It returns nil when
+// there is none or it is not well-formed.
+func decodeXMP(data []byte) *xmpData {
+	start := bytes.Index(data, xmpStart)
+	if start < 0 {
+		return nil
+	}
+	end := bytes.Index(data[start:], xmpEnd)
+	if end < 0 {
+		return nil
+	}
+	packet := data[start : start+end+len(xmpEnd)]
+
+	x := &xmpData{values: make(map[string]string)}
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	dec.Strict = false
+	var stack []string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			// Simple properties are often written as attributes of
+			// rdf:Description.
+			for _, attr := range t.Attr {
+				if _, ok := x.values[attr.Name.Local]; !ok {
+					x.values[attr.Name.Local] = attr.Value
+				}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" || len(stack) == 0 {
+				continue
+			}
+			// Lists and alternatives are rdf:Bag/Seq/Alt of rdf:li inside
+			// the property element.
+			prop := stack[len(stack)-1]
+			if prop == "li" && len(stack) >= 3 {
+				prop = stack[len(stack)-3]
+				if prop == "subject" {
+					x.keywords = append(x.keywords, text)
+					continue
+				}
+			}
+			if _, ok := x.values[prop]; !ok {
+				x.values[prop] = text
+			}
+		}
+	}
+	if len(x.values) == 0 && len(x.keywords) == 0 {
+		return nil
+	}
+	return x
+}
+
+// apply copies the XMP properties into m.
+func (x *xmpData) apply(m *Metadata) {
+	if v := x.values["title"]; v != "" {
+		m.Title = v
+	}
+	if v := x.values["description"]; v != "" {
+		m.Description = v
+	}
+	if len(x.keywords) > 0 {
+		m.Keywords = x.keywords
+	}
+	if v, err := strconv.Atoi(x.values["Rating"]); err == nil && v > 0 {
+		m.Rating = v
+	}
+	if m.DateTime.IsZero() {
+		for _, key := range []string{"DateTimeOriginal", "DateCreated", "CreateDate"} {
+			if d := parseXMPDate(x.values[key]); !d.IsZero() {
+				m.DateTime = d
+				break
+			}
+		}
+	}
+	if m.Make == "" {
+		m.Make = x.values["Make"]
+	}
+	if m.Model == "" {
+		m.Model = x.values["Model"]
+	}
+	if m.Lens == "" {
+		m.Lens = x.values["LensModel"]
+	}
+}
+
+// xmpDateLayouts are the ISO 8601 forms XMP allows.
+var xmpDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseXMPDate(s string) time.Time {
+	for _, layout := range xmpDateLayouts {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,181 @@
+// Package metadata stores what the media package extracts from photos,
+// audio and video in SQLite, keyed by content hash so that copies share
+// one entry and edited files are read again.
+package metadata
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/media"
+)
+
+// ExtractorVersion is stored with each entry. Bump it when the media
+// readers learn new fields, so older entries are extracted again.
+const ExtractorVersion = 1
+
+// maxQueued caps the files waiting for background extraction, so a
+// listing of a huge folder cannot queue without bound. Files beyond it
+// are queued again by a later listing.
+const maxQueued = 10000
+
+// Store reads and caches file metadata.
+type Store struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	queue   []string
+	pending map[string]bool
+	failed  map[fileops.MetaKey]bool // versions media could not read
+	working bool
+}
+
+// New returns a store backed by db.
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Get returns the metadata of the file at path and its content hash,
+// extracting and saving it on first use. Files media cannot read yield
+// media.ErrUnsupported.
+func (s *Store) Get(path string) (*media.Metadata, string, error) {
+	if !media.Supported(path) {
+		return nil, "", media.ErrUnsupported
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", fileops.MapError(err)
+	}
+	if info.IsDir() {
+		return nil, "", media.ErrUnsupported
+	}
+	hash, err := fileops.FileHash(path)
+	if err != nil {
+		return nil, "", fileops.MapError(err)
+	}
+
+	m, err := s.load(hash)
+	if err != nil {
+		return nil, "", err
+	}
+	if m != nil {
+		return m, hash, nil
+	}
+	if m, err = media.Read(path); err != nil {
+		return nil, "", fileops.MapError(err)
+	}
+	if err := s.save(hash, m); err != nil {
+		return nil, "", err
+	}
+	return m, hash, nil
+}
+
+// Lookup is Get for listings and queries. It only answers from the table
+// when the file's hash is already known, so files are never read; others
+// are queued for extraction in the background and show up in a later
+// listing.
+func (s *Store) Lookup(path string) *media.Metadata {
+	if !media.Supported(path) {
+		return nil
+	}
+	if hash := fileops.KnownHash(path); hash != "" {
+		if m, err := s.load(hash); m != nil || err != nil {
+			return m
+		}
+	}
+	s.enqueue(path)
+	return nil
+}
+
+func (s *Store) enqueue(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := fileops.MetaKeyOf(info); ok && s.failed[key] {
+		return
+	}
+	if s.pending == nil {
+		s.pending = make(map[string]bool)
+	}
+	if s.pending[path] || len(s.queue) >= maxQueued {
+		return
+	}
+	s.pending[path] = true
+	s.queue = append(s.queue, path)
+	if !s.working {
+		s.working = true
+		go s.work()
+	}
+}
+
+// work extracts queued files until the queue is empty. Files media cannot
+// read are remembered by version, so listings do not queue them again
+// until they change.
+func (s *Store) work() {
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.working = false
+			s.mu.Unlock()
+			return
+		}
+		path := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		info, statErr := os.Stat(path)
+		_, _, err := s.Get(path)
+
+		s.mu.Lock()
+		delete(s.pending, path)
+		if err != nil && statErr == nil {
+			if key, ok := fileops.MetaKeyOf(info); ok {
+				if s.failed == nil || len(s.failed) >= maxQueued {
+					s.failed = make(map[fileops.MetaKey]bool)
+				}
+				s.failed[key] = true
+			}
+		}
+		s.mu.Unlock()
+		if err != nil && !errors.Is(err, media.ErrUnsupported) && !errors.Is(err, fileops.ErrPathNotFound) {
+			log.Printf("metadata: %s: %v", path, err)
+		}
+	}
+}
+
+// load returns the saved metadata for hash, or nil when there is none from
+// the current extractor version.
+func (s *Store) load(hash string) (*media.Metadata, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM media_metadata WHERE hash = ? AND version = ?`,
+		hash, ExtractorVersion).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m media.Metadata
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, nil // corrupt entry; extract again
+	}
+	return &m, nil
+}
+
+func (s *Store) save(hash string, m *media.Metadata) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO media_metadata (hash, version, data) VALUES (?, ?, ?)`,
+		hash, ExtractorVersion, string(data))
+	return err
+}
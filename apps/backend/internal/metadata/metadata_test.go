@@ -0,0 +1,105 @@
+package metadata
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/db/dbtest"
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/media"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	conn := dbtest.Open(t)
+	return New(conn)
+}
+
+// photo is a minimal JPEG carrying an XMP rating.
+func photo(rating byte) []byte {
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="` + string(rating) + `"/></rdf:RDF></x:xmpmeta>`
+	b := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte((len(xmp) + 2) >> 8), byte(len(xmp) + 2)}
+	b = append(b, xmp...)
+	return append(b, 0xFF, 0xD9)
+}
+
+func TestStoreGet(t *testing.T) {
+	s := newTestStore(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.jpg")
+	if err := os.WriteFile(path, photo('3'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, hash, err := s.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Kind != media.KindImage || m.Rating != 3 || hash == "" {
+		t.Fatalf("Get = %+v, %q", m, hash)
+	}
+
+	// A copy is answered from the table without reading the file.
+	var n int
+	s.db.QueryRow(`SELECT COUNT(*) FROM media_metadata WHERE hash = ?`, hash).Scan(&n)
+	if n != 1 {
+		t.Fatalf("stored %d rows, want 1", n)
+	}
+	if _, err := s.db.Exec(`UPDATE media_metadata SET data = '{"kind":"image","rating":5}'`); err != nil {
+		t.Fatal(err)
+	}
+	copyPath := filepath.Join(dir, "b.jpeg")
+	if err := os.WriteFile(copyPath, photo('3'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Listings never read the copy; it is hashed in the background and
+	// then answered from the table.
+	if m := s.Lookup(copyPath); m != nil {
+		t.Errorf("Lookup(copy) before hashing = %+v, want nil", m)
+	}
+	if m := waitLookup(s, copyPath); m == nil || m.Rating != 5 {
+		t.Errorf("Lookup(copy) = %+v, want stored entry", m)
+	}
+
+	// Entries from another extractor version are read again.
+	if _, err := s.db.Exec(`UPDATE media_metadata SET version = 0`); err != nil {
+		t.Fatal(err)
+	}
+	if m, _, err := s.Get(copyPath); err != nil || m.Rating != 3 {
+		t.Errorf("Get after version bump = %+v, %v", m, err)
+	}
+}
+
+func TestStoreErrors(t *testing.T) {
+	s := newTestStore(t)
+	dir := t.TempDir()
+	text := filepath.Join(dir, "a.txt")
+	os.WriteFile(text, []byte("hello"), 0o644)
+
+	if _, _, err := s.Get(text); !errors.Is(err, media.ErrUnsupported) {
+		t.Errorf("Get(text) error = %v, want ErrUnsupported", err)
+	}
+	if _, _, err := s.Get(filepath.Join(dir, "missing.jpg")); !errors.Is(err, fileops.ErrPathNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrPathNotFound", err)
+	}
+	if m := s.Lookup(text); m != nil {
+		t.Errorf("Lookup(text) = %+v, want nil", m)
+	}
+}
+
+// waitLookup repeats Lookup until the background extraction it queues
+// has finished.
+func waitLookup(s *Store, path string) *media.Metadata {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m := s.Lookup(path)
+		if m != nil || time.Now().After(deadline) {
+			return m
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,239 @@
+// Package naming expands name templates such as
+// "{exif.date:2006-01-02}_{counter:04}{ext}" for a file.
+package naming
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/media"
+)
+
+// token is one {key:arg} placeholder, or a literal when key is empty.
+type token struct {
+	literal string
+	key     string
+	arg     string
+}
+
+// Template is a parsed name template.
+type Template struct {
+	source string
+	tokens []token
+}
+
+// Fields lists the placeholders Parse accepts.
+var Fields = []string{
+	"name", "ext", "parent", "counter", "date",
+	"exif.date", "exif.year", "exif.month", "exif.day",
+	"exif.make", "exif.model", "exif.lens", "mime.category", "class",
+}
+
+// Parse compiles a template. Placeholders are written {key} or {key:arg};
+// "{{" and "}}" stand for literal braces. Unknown keys are rejected with
+// an error wrapping fileops.ErrPatternInvalid.
+func Parse(s string) (*Template, error) {
+	t := &Template{source: s}
+	var lit strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '{' && i+1 < len(s) && s[i+1] == '{', c == '}' && i+1 < len(s) && s[i+1] == '}':
+			lit.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unclosed { in %q", fileops.ErrPatternInvalid, s)
+			}
+			key, arg, _ := strings.Cut(s[i+1:i+end], ":")
+			if !knownField(key) {
+				return nil, fmt.Errorf("%w: unknown field {%s}", fileops.ErrPatternInvalid, key)
+			}
+			if key == "counter" && arg != "" {
+				if _, err := strconv.Atoi(arg); err != nil {
+					return nil, fmt.Errorf("%w: counter width %q", fileops.ErrPatternInvalid, arg)
+				}
+			}
+			if lit.Len() > 0 {
+				t.tokens = append(t.tokens, token{literal: lit.String()})
+				lit.Reset()
+			}
+			t.tokens = append(t.tokens, token{key: key, arg: arg})
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("%w: unmatched } in %q", fileops.ErrPatternInvalid, s)
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	if lit.Len() > 0 {
+		t.tokens = append(t.tokens, token{literal: lit.String()})
+	}
+	return t, nil
+}
+
+func knownField(key string) bool {
+	for _, f := range Fields {
+		if f == key {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the template as written.
+func (t *Template) String() string {
+	return t.source
+}
+
+// File supplies the values for one file. EXIF data is read on first use.
+type File struct {
+	Path    string
+	Info    fs.FileInfo
+	Counter int
+
+	exif    *media.EXIF
+	exifErr error
+}
+
+// EXIF returns the file's EXIF data, or nil when it has none.
+func (f *File) EXIF() *media.EXIF {
+	if f.exif == nil && f.exifErr == nil {
+		f.exif, f.exifErr = media.ReadEXIF(f.Path)
+	}
+	return f.exif
+}
+
+// Expand fills in the template for f. Values never contain path
+// separators; the template's own literals are kept as written, so an
+// organize layout can place files in subfolders. Callers producing a
+// single name, such as batch rename, must reject separators themselves.
+func (t *Template) Expand(f *File) string {
+	var sb strings.Builder
+	for _, tok := range t.tokens {
+		if tok.key == "" {
+			sb.WriteString(tok.literal)
+			continue
+		}
+		sb.WriteString(sanitize(f.value(tok.key, tok.arg)))
+	}
+	return sb.String()
+}
+
+func (f *File) value(key, arg string) string {
+	base := filepath.Base(f.Path)
+	ext := filepath.Ext(base)
+	if f.Info != nil && f.Info.IsDir() {
+		ext = ""
+	}
+	switch key {
+	case "name":
+		return applyCase(strings.TrimSuffix(base, ext), arg)
+	case "ext":
+		return applyCase(ext, arg)
+	case "parent":
+		return filepath.Base(filepath.Dir(f.Path))
+	case "counter":
+		width, _ := strconv.Atoi(arg)
+		return fmt.Sprintf("%0*d", width, f.Counter)
+	case "date":
+		return formatDate(f.modTime(), arg)
+	case "mime.category":
+		d, err := fileops.DetectMime(f.Path)
+		if err != nil {
+			return "other"
+		}
+		return fileops.MimeCategory(d.MimeType)
+	case "class":
+		return bestClass(fileops.LookupClasses(f.Path))
+	}
+
+	// EXIF fields. Files without a capture date fall back to their
+	// modification time so a mixed folder can still be named by date.
+	x := f.EXIF()
+	switch key {
+	case "exif.date", "exif.year", "exif.month", "exif.day":
+		date := f.modTime()
+		if x != nil && !x.DateTime.IsZero() {
+			date = x.DateTime
+		}
+		switch key {
+		case "exif.year":
+			arg = "2006"
+		case "exif.month":
+			arg = "01"
+		case "exif.day":
+			arg = "02"
+		}
+		return formatDate(date, arg)
+	}
+	if x == nil {
+		return "unknown"
+	}
+	var v string
+	switch key {
+	case "exif.make":
+		v = x.Make
+	case "exif.model":
+		v = x.Model
+	case "exif.lens":
+		v = x.Lens
+	}
+	if v == "" {
+		return "unknown"
+	}
+	return v
+}
+
+// bestClass returns the class with the highest confidence, preferring the
+// alphabetically first on ties, or "unclassified".
+func bestClass(classes map[string]float64) string {
+	best, conf := "unclassified", fileops.MinClassConfidence
+	for class, c := range classes {
+		if c > conf || (c == conf && (best == "unclassified" || class < best)) {
+			best, conf = class, c
+		}
+	}
+	return best
+}
+
+func (f *File) modTime() time.Time {
+	if f.Info == nil {
+		return time.Time{}
+	}
+	return f.Info.ModTime()
+}
+
+func formatDate(t time.Time, layout string) string {
+	if layout == "" {
+		layout = "2006-01-02"
+	}
+	return t.Format(layout)
+}
+
+func applyCase(s, mode string) string {
+	switch mode {
+	case "lower":
+		return strings.ToLower(s)
+	case "upper":
+		return strings.ToUpper(s)
+	}
+	return s
+}
+
+// sanitize keeps a value from introducing path separators or control
+// characters into a name.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,68 @@
+package naming
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+func TestExpand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "IMG_0001.JPG")
+	if err := os.WriteFile(path, []byte("not really a jpeg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	os.Chtimes(path, mtime, mtime)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &File{Path: path, Info: info, Counter: 7}
+
+	for template, want := range map[string]string{
+		"{exif.date:2006-01-02}_{counter:04}{ext:lower}": "2021-03-04_0007.jpg",
+		"{name:lower}-{date:15.04}":                      "img_0001-05.06",
+		"{exif.year}/{exif.month}/{name}{ext}":           "2021/03/IMG_0001.JPG",
+		"{exif.make} {{raw}}":                            "unknown {raw}",
+		"Sorted/{mime.category}/":                        "Sorted/image/",
+	} {
+		tmpl, err := Parse(template)
+		if err != nil {
+			t.Errorf("%s: %v", template, err)
+			continue
+		}
+		if got := tmpl.Expand(file); got != want {
+			t.Errorf("%s = %q, want %q", template, got, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, template := range []string{"{nope}", "{name", "name}", "{counter:x}"} {
+		if _, err := Parse(template); !errors.Is(err, fileops.ErrPatternInvalid) {
+			t.Errorf("%s: err = %v", template, err)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	if got := sanitize("a/b\\c\nd"); got != "a_b_c_d" {
+		t.Errorf("sanitize = %q", got)
+	}
+}
+
+func TestBestClass(t *testing.T) {
+	for want, classes := range map[string]map[string]float64{
+		"unclassified": nil,
+		"invoice":      {"invoice": 0.9, "text": 0.9, "scan": 0.45},
+		"receipt":      {"invoice": 0.6, "receipt": 0.7},
+	} {
+		if got := bestClass(classes); got != want {
+			t.Errorf("bestClass(%v) = %q, want %q", classes, got, want)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package organize
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/journal"
+	"file-manager-backend/internal/naming"
+	"file-manager-backend/internal/trash"
+)
+
+// maxRuns is how many finished runs are remembered for Runs.
+const maxRuns = 50
+
+// HashIndex finds files by content hash. catalog.Store implements it.
+type HashIndex interface {
+	PathsWithHash(hash string) ([]string, error)
+}
+
+// Organizer stores rules and runs them.
+type Organizer struct {
+	db     *sql.DB
+	hashes HashIndex
+	bin    *trash.Trash
+	ops    *journal.Journal
+
+	// Changed is called with every path a run creates or removes, so
+	// indexes can follow.
+	Changed func(path string)
+
+	mu      sync.Mutex
+	running map[int64]bool
+	runs    []*Result
+	watched map[int64]*watchState
+}
+
+// New returns an organizer storing rules in db. Duplicates are looked up
+// in hashes, trashed duplicates go to bin and completed runs are recorded
+// in ops so they can be undone.
+func New(db *sql.DB, hashes HashIndex, bin *trash.Trash, ops *journal.Journal) *Organizer {
+	return &Organizer{
+		db:      db,
+		hashes:  hashes,
+		bin:     bin,
+		ops:     ops,
+		running: make(map[int64]bool),
+		watched: make(map[int64]*watchState),
+	}
+}
+
+// Change is what a run does, or would do, with one file.
+type Change struct {
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+	// Duplicate is a file below the target, or planned earlier in the
+	// same run, with the same contents. The file is then skipped or
+	// trashed rather than placed at Target.
+	Duplicate string `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Plan lists the changes a rule would make.
+type Plan struct {
+	Rule    Rule     `json:"rule"`
+	Changes []Change `json:"changes"`
+}
+
+// Result reports a finished run.
+type Result struct {
+	Rule       Rule      `json:"rule"`
+	StartedAt  time.Time `json:"startedAt"`
+	EndedAt    time.Time `json:"endedAt"`
+	Placed     int       `json:"placed"`
+	Duplicates int       `json:"duplicates"`
+	Failed     int       `json:"failed"`
+	Changes    []Change  `json:"changes"`
+}
+
+// Preview plans r without touching any file. r need not be stored.
+func (o *Organizer) Preview(r Rule) (*Plan, error) {
+	c, err := r.compile()
+	if err != nil {
+		return nil, err
+	}
+	return o.plan(c)
+}
+
+// Apply runs r, stored or not, and records the result.
+func (o *Organizer) Apply(r Rule) (*Result, error) {
+	c, err := r.compile()
+	if err != nil {
+		return nil, err
+	}
+	if r.ID != 0 {
+		o.mu.Lock()
+		if o.running[r.ID] {
+			o.mu.Unlock()
+			return nil, ErrRunning
+		}
+		o.running[r.ID] = true
+		o.mu.Unlock()
+		defer func() {
+			o.mu.Lock()
+			delete(o.running, r.ID)
+			o.mu.Unlock()
+		}()
+	}
+
+	res := &Result{Rule: r, StartedAt: time.Now()}
+	plan, err := o.plan(c)
+	if err != nil {
+		return nil, err
+	}
+	o.apply(c, plan, res)
+	res.EndedAt = time.Now()
+
+	if r.ID != 0 {
+		if err := o.markRun(r.ID, res.StartedAt); err != nil {
+			log.Printf("organize: recording run of rule %d: %v", r.ID, err)
+		}
+	}
+	o.mu.Lock()
+	o.runs = append(o.runs, res)
+	if len(o.runs) > maxRuns {
+		o.runs = o.runs[len(o.runs)-maxRuns:]
+	}
+	o.mu.Unlock()
+	return res, nil
+}
+
+// Run applies the stored rule with the given id.
+func (o *Organizer) Run(id int64) (*Result, error) {
+	r, err := o.Rule(id)
+	if err != nil {
+		return nil, err
+	}
+	return o.Apply(r)
+}
+
+// Runs returns the recent runs, newest first.
+func (o *Organizer) Runs() []Result {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	runs := make([]Result, 0, len(o.runs))
+	for i := len(o.runs) - 1; i >= 0; i-- {
+		runs = append(runs, *o.runs[i])
+	}
+	return runs
+}
+
+// candidates lists the files below the rule's source that match its
+// filter, leaving out hidden entries and, when the target lies inside the
+// source, anything already organized.
+func candidates(c *compiled) ([]fileops.FileInfo, error) {
+	opts := fileops.ListOptions{Depth: 1, Query: c.filter}
+	if c.Recursive {
+		opts.Depth = 0
+	}
+	files, err := fileops.ListFiles(c.Source, opts)
+	if err != nil {
+		return nil, err
+	}
+	nested := fileops.IsWithin(c.Target, c.Source)
+	kept := files[:0]
+	for _, f := range files {
+		if f.IsDirectory || f.Virtual || (nested && fileops.IsWithin(f.Path, c.Target)) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return kept, nil
+}
+
+func (o *Organizer) plan(c *compiled) (*Plan, error) {
+	files, err := candidates(c)
+	if err != nil {
+		return nil, err
+	}
+	o.warmTarget(c.Target, files)
+
+	sources := make(map[string]bool, len(files))
+	for _, f := range files {
+		sources[f.Path] = true
+	}
+	planned := make(map[string]bool)  // targets claimed so far
+	byHash := make(map[string]string) // hash -> target claimed for it
+
+	plan := &Plan{Rule: *c.Rule, Changes: []Change{}}
+	for i, f := range files {
+		change := Change{Path: f.Path}
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			change.Error = err.Error()
+			plan.Changes = append(plan.Changes, change)
+			continue
+		}
+
+		rel := c.layout.Expand(&naming.File{Path: f.Path, Info: info, Counter: i + 1})
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			rel += f.Name
+		}
+		target := filepath.Join(c.Target, rel)
+		if !fileops.IsWithin(target, c.Target) {
+			change.Error = "layout places the file outside the target"
+			plan.Changes = append(plan.Changes, change)
+			continue
+		}
+		if target == f.Path {
+			continue // already in place
+		}
+
+		hash, err := fileops.FileHash(f.Path)
+		if err != nil {
+			change.Error = err.Error()
+			plan.Changes = append(plan.Changes, change)
+			continue
+		}
+		if dup := byHash[hash]; dup != "" {
+			change.Duplicate = dup
+		} else if dup := o.existingCopy(hash, c.Target, sources); dup != "" {
+			change.Duplicate = dup
+		}
+		if change.Duplicate != "" {
+			plan.Changes = append(plan.Changes, change)
+			continue
+		}
+
+		change.Target = freeName(target, planned)
+		planned[change.Target] = true
+		byHash[hash] = change.Target
+		plan.Changes = append(plan.Changes, change)
+	}
+	return plan, nil
+}
+
+// warmTarget hashes the files below target that are the same size as a
+// candidate, so the hash catalog can answer duplicate lookups. Hashes are
+// cached by inode and mtime, so only new or changed files are read.
+func (o *Organizer) warmTarget(target string, files []fileops.FileInfo) {
+	sizes := make(map[int64]bool, len(files))
+	for _, f := range files {
+		sizes[f.Size] = true
+	}
+	filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && sizes[info.Size()] {
+			fileops.FileHash(path)
+		}
+		return nil
+	})
+}
+
+// existingCopy returns a file below target holding contents with the
+// given hash, ignoring the files being organized.
+func (o *Organizer) existingCopy(hash, target string, sources map[string]bool) string {
+	paths, err := o.hashes.PathsWithHash(hash)
+	if err != nil {
+		log.Printf("organize: looking up duplicates: %v", err)
+		return ""
+	}
+	for _, p := range paths {
+		if sources[p] || !fileops.IsWithin(p, target) {
+			continue
+		}
+		// The catalog may be stale; confirm the file still matches.
+		if current, err := fileops.FileHash(p); err == nil && current == hash {
+			return p
+		}
+	}
+	return ""
+}
+
+// freeName returns target, or target with a " (n)" suffix when the name
+// is taken on disk or by an earlier change.
+func freeName(target string, planned map[string]bool) string {
+	ext := filepath.Ext(target)
+	base := strings.TrimSuffix(target, ext)
+	name := target
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) && !planned[name] {
+			return name
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+}
+
+// apply carries out plan, recording what was done in res and the journal.
+func (o *Organizer) apply(c *compiled, plan *Plan, res *Result) {
+	op := fileops.Move
+	action := journal.ActionMove
+	if c.Action == ActionCopy {
+		op, action = fileops.Copy, journal.ActionCopy
+	}
+
+	var items []journal.Item
+	for _, change := range plan.Changes {
+		switch {
+		case change.Error != "":
+		case change.Duplicate != "":
+			res.Duplicates++
+			if c.Action != ActionMove || c.Duplicates != DuplicatesTrash {
+				break
+			}
+			item, err := o.bin.Trash(change.Path)
+			if err != nil {
+				change.Error = err.Error()
+				break
+			}
+			items = append(items, journal.Item{Action: journal.ActionTrash, Source: item.OriginalPath, Target: item.ID})
+			o.changed(change.Path)
+		default:
+			created, err := fileops.MakeDir(filepath.Dir(change.Target), true)
+			for _, dir := range created {
+				items = append(items, journal.Item{Action: journal.ActionMkdir, Target: dir})
+			}
+			if err == nil {
+				err = op(change.Path, change.Target, false)
+			}
+			if err != nil {
+				change.Error = err.Error()
+				break
+			}
+			res.Placed++
+			items = append(items, journal.Item{Action: action, Source: change.Path, Target: change.Target})
+			o.changed(change.Path)
+			o.changed(change.Target)
+		}
+		if change.Error != "" {
+			res.Failed++
+		}
+		res.Changes = append(res.Changes, change)
+	}
+	if res.Changes == nil {
+		res.Changes = []Change{}
+	}
+	if _, err := o.ops.Record("organize", items); err != nil {
+		log.Printf("organize: recording operation: %v", err)
+	}
+}
+
+func (o *Organizer) changed(path string) {
+	if o.Changed != nil {
+		o.Changed(path)
+	}
+}
@@ -0,0 +1,179 @@
+package organize
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/catalog"
+	"file-manager-backend/internal/db/dbtest"
+	"file-manager-backend/internal/journal"
+	"file-manager-backend/internal/trash"
+)
+
+func setupOrganizer(t *testing.T) (*Organizer, *journal.Journal, string) {
+	t.Helper()
+	conn := dbtest.Open(t)
+	store := catalog.NewStore(conn)
+	dbtest.UseMetaCache(t, store)
+
+	root := t.TempDir()
+	bin := trash.New(filepath.Join(root, ".Trash"))
+	ops := journal.New(conn, bin)
+	return New(conn, store, bin, ops), ops, root
+}
+
+func writeFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, mtime, mtime)
+}
+
+func TestPreviewAndApply(t *testing.T) {
+	o, ops, root := setupOrganizer(t)
+	inbox, photos := filepath.Join(root, "inbox"), filepath.Join(root, "photos")
+	may := time.Date(2022, 5, 1, 12, 0, 0, 0, time.Local)
+	writeFile(t, filepath.Join(inbox, "a.jpg"), "first", may)
+	writeFile(t, filepath.Join(inbox, "b.jpg"), "second", may)
+	writeFile(t, filepath.Join(inbox, "b copy.jpg"), "second", may)
+	writeFile(t, filepath.Join(inbox, "c.jpg"), "third", may)
+	writeFile(t, filepath.Join(inbox, "notes.txt"), "not a photo", may)
+	// c.jpg is already organized; another a.jpg has different contents.
+	writeFile(t, filepath.Join(photos, "old", "c-original.jpg"), "third", may)
+	writeFile(t, filepath.Join(photos, "2022", "05", "a.jpg"), "unrelated", may)
+
+	rule := Rule{Source: inbox, Target: photos, Layout: "{exif.year}/{exif.month}/", Filter: "ext:jpg"}
+	plan, err := o.Preview(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Change{
+		"a.jpg":      {Target: filepath.Join(photos, "2022", "05", "a (2).jpg")},
+		"b copy.jpg": {Target: filepath.Join(photos, "2022", "05", "b copy.jpg")},
+		"b.jpg":      {Duplicate: filepath.Join(photos, "2022", "05", "b copy.jpg")},
+		"c.jpg":      {Duplicate: filepath.Join(photos, "old", "c-original.jpg")},
+	}
+	if len(plan.Changes) != len(want) {
+		t.Fatalf("plan = %+v", plan.Changes)
+	}
+	for _, c := range plan.Changes {
+		w := want[filepath.Base(c.Path)]
+		if c.Target != w.Target || c.Duplicate != w.Duplicate || c.Error != "" {
+			t.Errorf("%s: got %+v, want %+v", filepath.Base(c.Path), c, w)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(inbox, "a.jpg")); err != nil {
+		t.Fatal("Preview touched the source")
+	}
+
+	rule.Duplicates = DuplicatesTrash
+	res, err := o.Apply(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Placed != 2 || res.Duplicates != 2 || res.Failed != 0 {
+		t.Errorf("result = %+v", res)
+	}
+	if data, err := os.ReadFile(filepath.Join(photos, "2022", "05", "a (2).jpg")); err != nil || string(data) != "first" {
+		t.Errorf("a.jpg not placed: %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(inbox)
+	if len(entries) != 1 || entries[0].Name() != "notes.txt" {
+		t.Errorf("inbox left with %v", entries)
+	}
+
+	// The whole run is undone as one operation.
+	if _, err := ops.Undo(1); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg", "b copy.jpg", "c.jpg"} {
+		if _, err := os.Stat(filepath.Join(inbox, name)); err != nil {
+			t.Errorf("%s not restored: %v", name, err)
+		}
+	}
+}
+
+func TestRuleStore(t *testing.T) {
+	o, _, root := setupOrganizer(t)
+	r := Rule{Source: filepath.Join(root, "in"), Target: filepath.Join(root, "docs"),
+		Layout: "{mime.category}/", Action: ActionCopy, Interval: "1h", Enabled: true}
+	if err := o.SaveRule(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.ID == 0 || r.Name != "in" || r.Duplicates != DuplicatesSkip {
+		t.Errorf("saved rule = %+v", r)
+	}
+	r.Watch = true
+	if err := o.SaveRule(&r); err != nil {
+		t.Fatal(err)
+	}
+	got, err := o.Rule(r.ID)
+	if err != nil || !got.Watch || got.Layout != "{mime.category}/" {
+		t.Errorf("Rule = %+v, %v", got, err)
+	}
+
+	now := time.Now()
+	if !o.due(got, now) {
+		t.Error("never-run rule should be due")
+	}
+	if err := o.markRun(r.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = o.Rule(r.ID)
+	if o.due(got, now.Add(30*time.Minute)) || !o.due(got, now.Add(time.Hour)) {
+		t.Errorf("due with last run %v", got.LastRun)
+	}
+
+	if err := o.DeleteRule(r.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Rule(r.ID); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("Rule after delete: %v", err)
+	}
+
+	for _, bad := range []Rule{
+		{Source: root, Target: root},
+		{Source: root, Target: "/x", Action: "delete"},
+		{Source: root, Target: "/x", Layout: "{nope}/"},
+		{Source: root, Target: "/x", Filter: "size>lots"},
+		{Source: root, Target: "/x", Interval: "5s"},
+	} {
+		if err := o.SaveRule(&bad); err == nil {
+			t.Errorf("SaveRule(%+v) succeeded", bad)
+		}
+	}
+}
+
+func TestWatchSettles(t *testing.T) {
+	o, _, root := setupOrganizer(t)
+	inbox := filepath.Join(root, "inbox")
+	writeFile(t, filepath.Join(inbox, "a.jpg"), "a", time.Now())
+	r := Rule{ID: 1, Source: inbox, Target: filepath.Join(root, "out"), Watch: true}
+
+	steps := []struct {
+		change func()
+		want   bool
+	}{
+		{nil, false}, // first sight
+		{nil, true},  // unchanged since: run
+		{nil, false}, // nothing new
+		{func() { writeFile(t, filepath.Join(inbox, "b.jpg"), "b", time.Now()) }, false},
+		{func() { writeFile(t, filepath.Join(inbox, "b.jpg"), "bb", time.Now().Add(time.Second)) }, false},
+		{nil, true},
+	}
+	for i, s := range steps {
+		if s.change != nil {
+			s.change()
+		}
+		if got := o.settled(r); got != s.want {
+			t.Errorf("step %d: settled = %v, want %v", i, got, s.want)
+		}
+	}
+}
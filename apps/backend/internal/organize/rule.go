@@ -0,0 +1,212 @@
+// Package organize moves or copies files from a source folder into a
+// layout below a target folder, such as Photos/{exif.year}/{exif.month}/,
+// skipping files whose contents are already there. Rules are stored in
+// SQLite and can run on demand, on a schedule or when their source
+// changes.
+package organize
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/naming"
+)
+
+// Actions a rule can take with matching files.
+const (
+	ActionMove = "move"
+	ActionCopy = "copy"
+)
+
+// Duplicate policies. Files whose contents already exist below the target
+// are left alone, or moved to the trash for move rules.
+const (
+	DuplicatesSkip  = "skip"
+	DuplicatesTrash = "trash"
+)
+
+// MinInterval is the shortest schedule a rule may have.
+const MinInterval = time.Minute
+
+var (
+	// ErrRuleNotFound is returned for unknown rule ids.
+	ErrRuleNotFound = errors.New("organize rule not found")
+	// ErrRunning is returned when a rule is started while it is running.
+	ErrRunning = errors.New("organize rule is already running")
+)
+
+// Rule describes where files come from and where they go.
+type Rule struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Layout is a naming template for the path below Target. One ending
+	// in "/" names a folder and files keep their names.
+	Layout string `json:"layout"`
+	Action string `json:"action"`
+	// Filter is a query limiting which files are organized; see
+	// fileops.ParseQuery.
+	Filter     string `json:"filter,omitempty"`
+	Recursive  bool   `json:"recursive"`
+	Duplicates string `json:"duplicates"`
+	// Interval schedules the rule, as a Go duration such as "6h".
+	Interval string `json:"interval,omitempty"`
+	// Watch runs the rule whenever its source settles after a change.
+	Watch   bool       `json:"watch"`
+	Enabled bool       `json:"enabled"`
+	LastRun *time.Time `json:"lastRun,omitempty"`
+}
+
+// compiled is a validated rule ready to plan with.
+type compiled struct {
+	*Rule
+	layout   *naming.Template
+	filter   *fileops.Query
+	interval time.Duration
+}
+
+// compile fills in defaults and checks every field of r.
+func (r *Rule) compile() (*compiled, error) {
+	if r.Source == "" || r.Target == "" {
+		return nil, fmt.Errorf("%w: source and target are required", fileops.ErrInvalidPath)
+	}
+	r.Source, r.Target = filepath.Clean(r.Source), filepath.Clean(r.Target)
+	if r.Source == r.Target {
+		return nil, fmt.Errorf("%w: source and target are the same folder", fileops.ErrInvalidPath)
+	}
+	if r.Name == "" {
+		r.Name = filepath.Base(r.Source)
+	}
+	if r.Action == "" {
+		r.Action = ActionMove
+	}
+	if r.Action != ActionMove && r.Action != ActionCopy {
+		return nil, fmt.Errorf("%w: unknown action %q", fileops.ErrInvalidPath, r.Action)
+	}
+	if r.Duplicates == "" {
+		r.Duplicates = DuplicatesSkip
+	}
+	if r.Duplicates != DuplicatesSkip && r.Duplicates != DuplicatesTrash {
+		return nil, fmt.Errorf("%w: unknown duplicate policy %q", fileops.ErrInvalidPath, r.Duplicates)
+	}
+
+	c := &compiled{Rule: r}
+	layout := r.Layout
+	if layout == "" {
+		layout = "/"
+	}
+	if filepath.IsAbs(layout) && layout != "/" {
+		return nil, fmt.Errorf("%w: layout must be relative to the target", fileops.ErrPatternInvalid)
+	}
+	var err error
+	if c.layout, err = naming.Parse(strings.TrimPrefix(layout, "/")); err != nil {
+		return nil, err
+	}
+	if c.filter, err = fileops.ParseQuery(r.Filter); err != nil {
+		return nil, err
+	}
+	if r.Interval != "" {
+		if c.interval, err = time.ParseDuration(r.Interval); err != nil || c.interval < MinInterval {
+			return nil, fmt.Errorf("%w: interval must be a duration of at least %v", fileops.ErrInvalidPath, MinInterval)
+		}
+	}
+	return c, nil
+}
+
+// Rules returns the stored rules by id.
+func (o *Organizer) Rules() ([]Rule, error) {
+	rows, err := o.db.Query(`SELECT id, name, source, target, layout, action, filter, recursive,
+		duplicates, interval, watch, enabled, last_run FROM organize_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []Rule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// Rule returns the stored rule with the given id.
+func (o *Organizer) Rule(id int64) (Rule, error) {
+	row := o.db.QueryRow(`SELECT id, name, source, target, layout, action, filter, recursive,
+		duplicates, interval, watch, enabled, last_run FROM organize_rules WHERE id = ?`, id)
+	r, err := scanRule(row)
+	if err == sql.ErrNoRows {
+		return Rule{}, ErrRuleNotFound
+	}
+	return r, err
+}
+
+// SaveRule validates r and stores it, creating it when r.ID is zero.
+func (o *Organizer) SaveRule(r *Rule) error {
+	if _, err := r.compile(); err != nil {
+		return err
+	}
+	if r.ID == 0 {
+		res, err := o.db.Exec(`INSERT INTO organize_rules (name, source, target, layout, action, filter,
+			recursive, duplicates, interval, watch, enabled) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.Name, r.Source, r.Target, r.Layout, r.Action, r.Filter, r.Recursive, r.Duplicates,
+			r.Interval, r.Watch, r.Enabled)
+		if err != nil {
+			return err
+		}
+		r.ID, err = res.LastInsertId()
+		return err
+	}
+	res, err := o.db.Exec(`UPDATE organize_rules SET name = ?, source = ?, target = ?, layout = ?,
+		action = ?, filter = ?, recursive = ?, duplicates = ?, interval = ?, watch = ?, enabled = ?
+		WHERE id = ?`,
+		r.Name, r.Source, r.Target, r.Layout, r.Action, r.Filter, r.Recursive, r.Duplicates,
+		r.Interval, r.Watch, r.Enabled, r.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+// DeleteRule removes a stored rule.
+func (o *Organizer) DeleteRule(id int64) error {
+	res, err := o.db.Exec(`DELETE FROM organize_rules WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+func (o *Organizer) markRun(id int64, at time.Time) error {
+	_, err := o.db.Exec(`UPDATE organize_rules SET last_run = ? WHERE id = ?`, at.UTC(), id)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRule(row scanner) (Rule, error) {
+	var r Rule
+	var lastRun sql.NullTime
+	err := row.Scan(&r.ID, &r.Name, &r.Source, &r.Target, &r.Layout, &r.Action, &r.Filter,
+		&r.Recursive, &r.Duplicates, &r.Interval, &r.Watch, &r.Enabled, &lastRun)
+	if lastRun.Valid {
+		r.LastRun = &lastRun.Time
+	}
+	return r, err
+}
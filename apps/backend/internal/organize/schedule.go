@@ -0,0 +1,152 @@
+package organize
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchState tracks a watched rule's source between polls. A rule runs
+// once its source has changed and then stayed the same for a whole poll,
+// so files still being copied from a card are not picked up half-written.
+type watchState struct {
+	signature uint64
+	pending   bool
+}
+
+// Schedule runs due scheduled rules and settled watched rules, checking
+// every poll until ctx is cancelled. Watched folders are polled rather
+// than subscribed to, so they work on network shares too.
+func (o *Organizer) Schedule(ctx context.Context, poll time.Duration) {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.tick(time.Now())
+		}
+	}
+}
+
+// tick starts every rule that is due at now.
+func (o *Organizer) tick(now time.Time) {
+	rules, err := o.Rules()
+	if err != nil {
+		log.Printf("organize: loading rules: %v", err)
+		return
+	}
+	seen := make(map[int64]bool, len(rules))
+	for _, r := range rules {
+		seen[r.ID] = true
+		if !r.Enabled {
+			continue
+		}
+		if o.due(r, now) || o.settled(r) {
+			go o.runScheduled(r)
+		}
+	}
+
+	o.mu.Lock()
+	for id := range o.watched {
+		if !seen[id] {
+			delete(o.watched, id)
+		}
+	}
+	o.mu.Unlock()
+}
+
+// due reports whether a scheduled rule's interval has passed since it
+// last ran.
+func (o *Organizer) due(r Rule, now time.Time) bool {
+	if r.Interval == "" {
+		return false
+	}
+	interval, err := time.ParseDuration(r.Interval)
+	if err != nil || interval < MinInterval {
+		return false
+	}
+	return r.LastRun == nil || !now.Before(r.LastRun.Add(interval))
+}
+
+// settled reports whether a watched rule's source changed and has been
+// quiet since the previous poll. A source seen for the first time counts
+// as changed, so files that arrived while the server was down are handled.
+func (o *Organizer) settled(r Rule) bool {
+	if !r.Watch {
+		return false
+	}
+	sig := sourceSignature(r)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	st, ok := o.watched[r.ID]
+	if !ok {
+		o.watched[r.ID] = &watchState{signature: sig, pending: true}
+		return false
+	}
+	if sig != st.signature {
+		st.signature, st.pending = sig, true
+		return false
+	}
+	if st.pending {
+		st.pending = false
+		return true
+	}
+	return false
+}
+
+func (o *Organizer) runScheduled(r Rule) {
+	res, err := o.Apply(r)
+	if err == ErrRunning {
+		return
+	}
+	if err != nil {
+		log.Printf("organize: rule %d (%s): %v", r.ID, r.Name, err)
+		return
+	}
+	if res.Placed > 0 || res.Failed > 0 {
+		log.Printf("organize: rule %d (%s) placed %d files, %d duplicates, %d failed",
+			r.ID, r.Name, res.Placed, res.Duplicates, res.Failed)
+	}
+}
+
+// sourceSignature summarizes the names, sizes and modification times of
+// the visible files in a rule's source.
+func sourceSignature(r Rule) uint64 {
+	source, target := filepath.Clean(r.Source), filepath.Clean(r.Target)
+	h := fnv.New64a()
+	filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != source && (strings.HasPrefix(d.Name(), ".") || path == target) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path != source && !r.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		h.Write([]byte(path))
+		var buf [16]byte
+		binary.LittleEndian.PutUint64(buf[:8], uint64(info.Size()))
+		binary.LittleEndian.PutUint64(buf[8:], uint64(info.ModTime().UnixNano()))
+		h.Write(buf[:])
+		return nil
+	})
+	return h.Sum64()
+}
@@ -0,0 +1,225 @@
+// Package rename previews and applies batch renames of a selection of
+// files, driven by a name template or a regular expression.
+package rename
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/naming"
+)
+
+// ErrConflict is returned by Apply when the plan has conflicts.
+var ErrConflict = errors.New("rename conflicts")
+
+// cycleConflict explains a rename that is part of a cycle, such as two
+// files swapping names, which Apply does not support.
+const cycleConflict = "names form a cycle; swapping names is not supported, rename through a temporary name first"
+
+// Rule describes how new names are made. Either Template or Find is set.
+type Rule struct {
+	// Template is a naming template producing the whole new name, e.g.
+	// "{exif.date:2006-01-02}_{counter:04}{ext}". Files stay in their
+	// folder: a name containing a path separator is a conflict.
+	Template string `json:"template"`
+	// Find is a regular expression replaced by Replace in each name;
+	// Replace may refer to groups as $1 or ${name}.
+	Find    string `json:"find"`
+	Replace string `json:"replace"`
+	// Start and Step drive {counter}; Start defaults to 1 and Step to 1.
+	Start *int `json:"start"`
+	Step  int  `json:"step"`
+}
+
+// Change is the planned rename of one path. Conflict explains why it
+// cannot be applied; a Change with NewPath equal to Path is left alone.
+type Change struct {
+	Path     string `json:"path"`
+	NewPath  string `json:"newPath"`
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// Plan is the outcome of Preview, in selection order.
+type Plan struct {
+	Changes   []Change `json:"changes"`
+	Conflicts int      `json:"conflicts"`
+}
+
+// Preview computes the new name of every path without touching the disk.
+// Names are checked for validity, for clashes with each other and with
+// files outside the selection, and for cycles such as swapping two names.
+// Cycles are reported as conflicts rather than resolved through temporary
+// names, so a swap has to be done as two batches.
+func Preview(paths []string, rule Rule) (*Plan, error) {
+	newName, err := compile(rule)
+	if err != nil {
+		return nil, err
+	}
+	start, step := 1, rule.Step
+	if rule.Start != nil {
+		start = *rule.Start
+	}
+	if step == 0 {
+		step = 1
+	}
+
+	plan := &Plan{Changes: make([]Change, len(paths))}
+	sources := make(map[string]int, len(paths))
+	for i, p := range paths {
+		p = filepath.Clean(p)
+		plan.Changes[i] = Change{Path: p, NewPath: p}
+		if _, dup := sources[p]; dup {
+			plan.Changes[i].Conflict = "selected twice"
+			continue
+		}
+		sources[p] = i
+	}
+
+	targets := make(map[string]int, len(paths))
+	for i := range plan.Changes {
+		c := &plan.Changes[i]
+		if c.Conflict != "" {
+			continue
+		}
+		info, err := os.Lstat(c.Path)
+		if err != nil {
+			c.Conflict = "not found"
+			continue
+		}
+		file := &naming.File{Path: c.Path, Info: info, Counter: start + i*step}
+		name := newName(file)
+		if strings.ContainsAny(name, `/\`) {
+			c.Conflict = fmt.Sprintf("new name %q contains a path separator; rename keeps files in their folder", name)
+			continue
+		}
+		if !fileops.ValidName(name) {
+			c.Conflict = fmt.Sprintf("invalid name %q", name)
+			continue
+		}
+		c.NewPath = filepath.Join(filepath.Dir(c.Path), name)
+		if other, dup := targets[c.NewPath]; dup {
+			c.Conflict = "same new name as " + plan.Changes[other].Path
+			continue
+		}
+		targets[c.NewPath] = i
+		if c.NewPath == c.Path {
+			continue
+		}
+		if _, err := os.Lstat(c.NewPath); err == nil {
+			if _, renamed := sources[c.NewPath]; !renamed {
+				c.Conflict = "already exists"
+			}
+		}
+	}
+
+	// A target that is renamed away in turn is fine as long as the chain
+	// ends; one that stays put or loops back is not.
+	for i := range plan.Changes {
+		c := &plan.Changes[i]
+		if c.Conflict != "" || c.NewPath == c.Path {
+			continue
+		}
+		seen := map[string]bool{c.Path: true}
+		for next := c.NewPath; ; {
+			j, ok := sources[next]
+			if !ok {
+				break
+			}
+			if plan.Changes[j].NewPath == next {
+				c.Conflict = "already exists"
+				break
+			}
+			if seen[plan.Changes[j].NewPath] {
+				c.Conflict = cycleConflict
+				break
+			}
+			seen[next] = true
+			next = plan.Changes[j].NewPath
+		}
+	}
+
+	for _, c := range plan.Changes {
+		if c.Conflict != "" {
+			plan.Conflicts++
+		}
+	}
+	return plan, nil
+}
+
+// compile turns rule into a function producing a file's new name.
+func compile(rule Rule) (func(*naming.File) string, error) {
+	switch {
+	case rule.Template != "" && rule.Find != "":
+		return nil, fmt.Errorf("%w: template and find are exclusive", fileops.ErrPatternInvalid)
+	case rule.Template != "":
+		tmpl, err := naming.Parse(rule.Template)
+		if err != nil {
+			return nil, err
+		}
+		return tmpl.Expand, nil
+	case rule.Find != "":
+		re, err := regexp.Compile(rule.Find)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", fileops.ErrPatternInvalid, err)
+		}
+		return func(f *naming.File) string {
+			return re.ReplaceAllString(filepath.Base(f.Path), rule.Replace)
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: template or find required", fileops.ErrPatternInvalid)
+}
+
+// Apply renames the files of a conflict-free plan and returns the changes
+// made, in the order they were made. Renames are ordered so a name is
+// vacated before it is reused. If one fails, those already done are
+// reverted and the error is returned.
+func Apply(plan *Plan) ([]Change, error) {
+	if plan.Conflicts > 0 {
+		return nil, ErrConflict
+	}
+	pending := make(map[string]Change)
+	for _, c := range plan.Changes {
+		if c.NewPath != c.Path {
+			pending[c.Path] = c
+		}
+	}
+
+	var done []Change
+	for len(pending) > 0 {
+		progressed := false
+		for _, c := range plan.Changes {
+			if _, ok := pending[c.Path]; !ok {
+				continue
+			}
+			if _, blocked := pending[c.NewPath]; blocked {
+				continue
+			}
+			if err := fileops.Move(c.Path, c.NewPath, false); err != nil {
+				return nil, rollback(done, fmt.Errorf("renaming %s: %w", c.Path, err))
+			}
+			done = append(done, c)
+			delete(pending, c.Path)
+			progressed = true
+		}
+		if !progressed {
+			return nil, rollback(done, ErrConflict)
+		}
+	}
+	return done, nil
+}
+
+// rollback reverts done in reverse order and returns err, noting any
+// rename that could not be reverted.
+func rollback(done []Change, err error) error {
+	for i := len(done) - 1; i >= 0; i-- {
+		if rerr := fileops.Move(done[i].NewPath, done[i].Path, false); rerr != nil {
+			return fmt.Errorf("%w; %s could not be renamed back: %v", err, done[i].NewPath, rerr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package rename
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"file-manager-backend/internal/fileops"
+)
+
+func setup(t *testing.T, names ...string) (string, []string) {
+	t.Helper()
+	root := t.TempDir()
+	var paths []string
+	for _, name := range names {
+		p := filepath.Join(root, name)
+		if err := os.WriteFile(p, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	return root, paths
+}
+
+func content(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestTemplateRename(t *testing.T) {
+	root, paths := setup(t, "b.txt", "a.txt", "c.log")
+	start := 10
+	plan, err := Preview(paths, Rule{Template: "doc_{counter:3}{ext}", Start: &start, Step: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Conflicts != 0 {
+		t.Fatalf("conflicts: %+v", plan.Changes)
+	}
+	want := []string{"doc_010.txt", "doc_015.txt", "doc_020.log"}
+	for i, c := range plan.Changes {
+		if c.NewPath != filepath.Join(root, want[i]) {
+			t.Errorf("%s -> %s, want %s", c.Path, c.NewPath, want[i])
+		}
+	}
+	if _, err := os.Stat(paths[0]); err != nil {
+		t.Error("preview renamed a file")
+	}
+
+	done, err := Apply(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 3 || content(t, filepath.Join(root, "doc_015.txt")) != "a.txt" {
+		t.Errorf("Apply = %+v", done)
+	}
+}
+
+func TestRegexConflicts(t *testing.T) {
+	_, paths := setup(t, "f1.txt", "f2.txt", "other.txt", "f3.txt")
+
+	// Every name gets a suffix, so none of them clash.
+	plan, err := Preview([]string{paths[0], paths[1], paths[3]}, Rule{Find: `f(\d)`, Replace: "f${1}x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Conflicts != 0 {
+		t.Fatalf("unexpected conflicts: %+v", plan.Changes)
+	}
+
+	plan, err = Preview([]string{paths[0], paths[1]}, Rule{Find: `^f\d`, Replace: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Conflicts != 2 || plan.Changes[0].Conflict != "already exists" {
+		t.Errorf("clash: %+v", plan.Changes)
+	}
+	if _, err := Apply(plan); !errors.Is(err, ErrConflict) {
+		t.Errorf("Apply with conflicts: err = %v", err)
+	}
+
+	plan, err = Preview([]string{paths[0], paths[1]}, Rule{Find: `f1|f2`, Replace: "x/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Conflicts != 2 {
+		t.Errorf("separator in name accepted: %+v", plan.Changes)
+	}
+
+	if _, err := Preview(paths, Rule{Find: "("}); !errors.Is(err, fileops.ErrPatternInvalid) {
+		t.Errorf("bad regex: err = %v", err)
+	}
+}
+
+func TestChainOrderAndCycle(t *testing.T) {
+	root, paths := setup(t, "1.txt", "2.txt", "3.txt")
+	plan, err := Preview(paths[:2], Rule{Template: "{counter}{ext}", Start: intPtr(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 -> 3 is blocked by 3.txt, which is not selected.
+	if plan.Changes[1].Conflict != "already exists" {
+		t.Errorf("blocked target: %+v", plan.Changes)
+	}
+
+	plan, err = Preview(paths, Rule{Template: "{counter}{ext}", Start: intPtr(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 -> 4, then 2 -> 3, then 1 -> 2.
+	done, err := Apply(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done[0].Path != paths[2] || content(t, filepath.Join(root, "4.txt")) != "3.txt" || content(t, filepath.Join(root, "2.txt")) != "1.txt" {
+		t.Errorf("chain applied as %+v", done)
+	}
+
+	plan, err = Preview([]string{filepath.Join(root, "2.txt"), filepath.Join(root, "3.txt")}, Rule{Find: `^\d`, Replace: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Changes[1].Conflict != "same new name as "+filepath.Join(root, "2.txt") {
+		t.Errorf("duplicate names: %+v", plan.Changes)
+	}
+
+	// 2 -> 3 and 3 -> 2 would need a temporary name.
+	plan, err = Preview([]string{filepath.Join(root, "2.txt"), filepath.Join(root, "3.txt")}, Rule{Template: "{counter}{ext}", Start: intPtr(3), Step: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Conflicts != 2 || plan.Changes[0].Conflict != cycleConflict {
+		t.Errorf("swap: %+v", plan.Changes)
+	}
+}
+
+func TestSeparatorRejected(t *testing.T) {
+	_, paths := setup(t, "1.txt")
+	plan, err := Preview(paths, Rule{Template: "sub/{name}{ext}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Conflicts != 1 || !strings.Contains(plan.Changes[0].Conflict, "path separator") {
+		t.Errorf("subfolder template: %+v", plan.Changes)
+	}
+}
+
+func intPtr(i int) *int { return &i }
@@ -0,0 +1,351 @@
+package search
+
+import (
+	"database/sql"
+	"html"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"file-manager-backend/internal/extract"
+	"file-manager-backend/internal/fileops"
+)
+
+// maxContentFileSize skips documents too large to be worth extracting.
+const maxContentFileSize = 64 << 20
+
+// ContentStats summarises one content rescan of a root.
+type ContentStats struct {
+	Root      string        `json:"root"`
+	Files     int           `json:"files"`
+	Extracted int           `json:"extracted"`
+	Linked    int           `json:"linked"`
+	Removed   int           `json:"removed"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// ContentOptions controls a content search.
+type ContentOptions struct {
+	Limit int
+	Root  string
+	// Rules filters hits with the same hidden, include, exclude and regex
+	// rules as ListFiles.
+	Rules fileops.ListOptions
+}
+
+// ContentResult is a file whose text matched, with a highlighted excerpt.
+// Snippet is HTML-escaped with matches wrapped in <mark>.
+type ContentResult struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Hash    string    `json:"hash"`
+	Snippet string    `json:"snippet"`
+}
+
+// EnableContent turns on full-text indexing during rescans. Only files
+// passing rules are extracted.
+func (ix *Index) EnableContent(rules fileops.ListOptions) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.contentRules = &rules
+}
+
+// RescanContent extracts the text of new or modified documents below root.
+// Text is stored once per content hash, so duplicate files share a single
+// index entry; documents no longer referenced by any file are dropped.
+func (ix *Index) RescanContent(root string) (ContentStats, error) {
+	if root == "" {
+		return ContentStats{}, fileops.ErrInvalidPath
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return ContentStats{}, fileops.ErrInvalidPath
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	rules := fileops.ListOptions{}
+	if ix.contentRules != nil {
+		rules = *ix.contentRules
+	}
+
+	start := time.Now()
+	stats := ContentStats{Root: root}
+	generation := start.UnixNano()
+
+	b, err := ix.newBatch()
+	if err != nil {
+		return stats, err
+	}
+	defer b.rollback()
+
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if path != root && !rules.Matches(d.Name(), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !extract.Supported(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxContentFileSize {
+			return nil
+		}
+
+		stats.Files++
+		extracted, linked, err := b.indexContent(root, path, info, generation)
+		if err != nil {
+			return err
+		}
+		if extracted {
+			stats.Extracted++
+		} else if linked {
+			stats.Linked++
+		}
+
+		if b.n >= batchSize {
+			if err := b.flush(ix); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if walkErr != nil {
+		if os.IsNotExist(walkErr) {
+			return stats, fileops.ErrPathNotFound
+		}
+		return stats, walkErr
+	}
+
+	res, err := b.tx.Exec("DELETE FROM content_files WHERE root = ? AND seen <> ?", root, generation)
+	if err != nil {
+		return stats, err
+	}
+	removed, _ := res.RowsAffected()
+	stats.Removed = int(removed)
+	if err := b.pruneContentDocs(); err != nil {
+		return stats, err
+	}
+	if err := b.tx.Commit(); err != nil {
+		return stats, err
+	}
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// indexContent records one document, extracting its text unless an
+// identical file was indexed before.
+func (b *batch) indexContent(root, path string, info fs.FileInfo, generation int64) (extracted, linked bool, err error) {
+	b.n++
+	modTime := info.ModTime().UnixNano()
+
+	res, err := b.tx.Exec("UPDATE content_files SET seen = ? WHERE path = ? AND mod_time = ? AND size = ?",
+		generation, path, modTime, info.Size())
+	if err != nil {
+		return false, false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return false, false, nil
+	}
+
+	hash, err := fileops.FileHash(path)
+	if err != nil {
+		return false, false, nil // unreadable files are skipped
+	}
+	_, err = b.tx.Exec(`INSERT INTO content_files (path, root, hash, size, mod_time, seen) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET hash = excluded.hash, size = excluded.size,
+			mod_time = excluded.mod_time, seen = excluded.seen`,
+		path, root, hash, info.Size(), modTime, generation)
+	if err != nil {
+		return false, false, err
+	}
+
+	var id int64
+	err = b.tx.QueryRow("SELECT id FROM content_docs WHERE hash = ?", hash).Scan(&id)
+	if err == nil {
+		return false, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, false, err
+	}
+
+	// Documents that fail to parse are stored with an empty body so they
+	// are not retried on every rescan.
+	text, _ := extract.Text(path)
+	res, err = b.tx.Exec("INSERT INTO content_docs (hash) VALUES (?)", hash)
+	if err != nil {
+		return false, false, err
+	}
+	if id, err = res.LastInsertId(); err != nil {
+		return false, false, err
+	}
+	_, err = b.tx.Exec("INSERT INTO content_fts (rowid, body) VALUES (?, ?)", id, text)
+	return err == nil, false, err
+}
+
+// pruneContentDocs removes documents no file refers to any more.
+func (b *batch) pruneContentDocs() error {
+	orphans := "SELECT id FROM content_docs WHERE hash NOT IN (SELECT hash FROM content_files)"
+	if _, err := b.tx.Exec("DELETE FROM content_fts WHERE rowid IN (" + orphans + ")"); err != nil {
+		return err
+	}
+	_, err := b.tx.Exec("DELETE FROM content_docs WHERE id IN (" + orphans + ")")
+	return err
+}
+
+// SearchContent finds files whose text matches query. Words must all
+// appear; "quoted phrases" must appear verbatim and a trailing * matches a
+// prefix.
+func (ix *Index) SearchContent(query string, opts ContentOptions) ([]ContentResult, error) {
+	match := contentMatchExpr(query)
+	if match == "" {
+		return nil, nil
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+
+	root := ""
+	if opts.Root != "" {
+		root = filepath.Clean(opts.Root)
+	}
+
+	// Path rules are applied after the match, so documents are fetched a
+	// page at a time until enough of them pass or the matches run out.
+	pageSize := opts.Limit * 4
+	var results []ContentResult
+	for offset := 0; len(results) < opts.Limit; offset += pageSize {
+		hits, err := ix.contentHits(match, pageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hits {
+			if results, err = ix.appendContentFiles(results, h, root, opts.Rules); err != nil {
+				return nil, err
+			}
+			if len(results) >= opts.Limit {
+				return results[:opts.Limit], nil
+			}
+		}
+		if len(hits) < pageSize {
+			break
+		}
+	}
+	return results, nil
+}
+
+// contentHit is a matching document, by hash, with its highlighted snippet.
+type contentHit struct{ hash, snippet string }
+
+// contentHits returns one page of documents matching an FTS expression.
+func (ix *Index) contentHits(match string, limit, offset int) ([]contentHit, error) {
+	rows, err := ix.db.Query(`SELECT d.hash, snippet(content_fts, char(2), char(3), '…', -1, 24)
+		FROM content_fts JOIN content_docs d ON d.id = content_fts.rowid
+		WHERE content_fts MATCH ? ORDER BY d.id LIMIT ? OFFSET ?`, match, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hits []contentHit
+	for rows.Next() {
+		var h contentHit
+		if err := rows.Scan(&h.hash, &h.snippet); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// appendContentFiles adds the files holding a matching document that lie
+// below root and pass rules.
+func (ix *Index) appendContentFiles(results []ContentResult, h contentHit, root string, rules fileops.ListOptions) ([]ContentResult, error) {
+	snippet := html.EscapeString(h.snippet)
+	snippet = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(snippet)
+
+	files, err := ix.db.Query("SELECT path, size, mod_time FROM content_files WHERE hash = ? ORDER BY path", h.hash)
+	if err != nil {
+		return results, err
+	}
+	defer files.Close()
+	for files.Next() {
+		r := ContentResult{Hash: h.hash, Snippet: snippet}
+		var modTime int64
+		if err := files.Scan(&r.Path, &r.Size, &modTime); err != nil {
+			return results, err
+		}
+		if root != "" && !strings.HasPrefix(r.Path, root+string(filepath.Separator)) {
+			continue
+		}
+		if !pathMatches(rules, root, r.Path) {
+			continue
+		}
+		r.Name = filepath.Base(r.Path)
+		r.ModTime = time.Unix(0, modTime)
+		results = append(results, r)
+	}
+	return results, files.Err()
+}
+
+// pathMatches applies ListOptions rules to every component of path below
+// root, so excluding "node_modules" hides everything inside it.
+func pathMatches(rules fileops.ListOptions, root, path string) bool {
+	rel := path
+	if root != "" {
+		if r, err := filepath.Rel(root, path); err == nil {
+			rel = r
+		}
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if !rules.Matches(part, i < len(parts)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// contentMatchExpr turns user input into an FTS4 MATCH expression,
+// dropping operators and punctuation the user did not mean literally.
+func contentMatchExpr(query string) string {
+	var terms []string
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			words := strings.FieldsFunc(part, notWordRune)
+			if len(words) > 0 {
+				terms = append(terms, `"`+strings.ToLower(strings.Join(words, " "))+`"`)
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			prefix := strings.HasSuffix(field, "*")
+			for _, w := range strings.FieldsFunc(field, notWordRune) {
+				terms = append(terms, strings.ToLower(w))
+			}
+			if prefix && len(terms) > 0 {
+				terms[len(terms)-1] += "*"
+			}
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+func notWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,132 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"file-manager-backend/internal/fileops"
+)
+
+func TestContentMatchExpr(t *testing.T) {
+	tests := map[string]string{
+		`revenue growth`:      "revenue growth",
+		`"net revenue" OR q3`: `"net revenue" or q3`,
+		`inv* (draft)`:        "inv* draft",
+		`C++ — "  "`:          "c",
+		`  `:                  "",
+	}
+	for in, want := range tests {
+		if got := contentMatchExpr(in); got != want {
+			t.Errorf("contentMatchExpr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRescanContentAndSearch(t *testing.T) {
+	ix, root := setupIndex(t)
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("notes/meeting notes.md", "Discussed the <b>quarterly</b> revenue forecast with finance.")
+	write("notes/copy of meeting.md", "Discussed the <b>quarterly</b> revenue forecast with finance.")
+	write("node_modules/pkg/readme.md", "quarterly revenue in a dependency")
+	write("notes/todo.txt", "buy milk")
+
+	ix.EnableContent(fileops.ListOptions{Exclude: []string{"node_modules"}})
+	stats, err := ix.RescanContent(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The placeholder invoice PDF is attempted too and stored empty.
+	if stats.Extracted != 3 || stats.Linked != 1 {
+		t.Errorf("expected 3 extracted and 1 linked duplicate, got %+v", stats)
+	}
+
+	results, err := ix.SearchContent("quarterly revenue", ContentOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected both copies, got %+v", results)
+	}
+	if results[0].Hash != results[1].Hash {
+		t.Error("duplicates should share a hash")
+	}
+	if !strings.Contains(results[0].Snippet, "<mark>quarterly</mark>") || !strings.Contains(results[0].Snippet, "&lt;b&gt;") {
+		t.Errorf("unexpected snippet %q", results[0].Snippet)
+	}
+
+	results, err = ix.SearchContent("forecast", ContentOptions{Rules: fileops.ListOptions{Exclude: []string{"copy*"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Name != "meeting notes.md" {
+		t.Errorf("exclude rule not applied: %+v", results)
+	}
+
+	if err := os.Remove(filepath.Join(root, "notes", "meeting notes.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "notes", "copy of meeting.md")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = ix.RescanContent(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Removed != 2 {
+		t.Errorf("expected 2 removed, got %+v", stats)
+	}
+	results, err = ix.SearchContent("quarterly", ContentOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results after delete, got %+v", results)
+	}
+}
+
+func TestSearchContentFillsLimitPastFilteredMatches(t *testing.T) {
+	ix, root := setupIndex(t)
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Indexed first, the excluded drafts fill the first pages of matches.
+	for i := 0; i < 12; i++ {
+		write(filepath.Join("drafts", strings.Repeat("d", i+1)+".md"), "budget draft "+strings.Repeat("x", i))
+	}
+	ix.EnableContent(fileops.ListOptions{})
+	if _, err := ix.RescanContent(root); err != nil {
+		t.Fatal(err)
+	}
+	write("final/a.md", "budget final a")
+	write("final/b.md", "budget final b")
+	if _, err := ix.RescanContent(root); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := ix.SearchContent("budget", ContentOptions{
+		Limit: 2,
+		Rules: fileops.ListOptions{Exclude: []string{"drafts"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("expected both final documents, got %+v", results)
+	}
+}
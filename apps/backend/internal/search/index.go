@@ -0,0 +1,492 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"file-manager-backend/internal/db"
+	"file-manager-backend/internal/fileops"
+)
+
+// batchSize is the number of entries written per transaction during a
+// rescan, keeping memory flat and letting readers in between batches.
+const batchSize = 5000
+
+// maxQueued caps the changed paths waiting to be indexed. Changes beyond
+// it are picked up by the next rescan.
+const maxQueued = 10000
+
+// Index is a persistent filename index over registered root folders.
+// Entries live in search_entries and their tokenised names in the FTS4
+// table search_names, sharing the same rowid.
+type Index struct {
+	db *sql.DB
+	mu sync.Mutex // serialises writers
+
+	// contentRules enables full-text indexing when non-nil; see
+	// EnableContent.
+	contentRules *fileops.ListOptions
+
+	// Paths passed to Changed, waiting for the background worker.
+	qmu     sync.Mutex
+	queue   []string
+	pending map[string]bool
+	working bool
+}
+
+// ScanStats summarises one rescan of a root.
+type ScanStats struct {
+	Root     string        `json:"root"`
+	Seen     int           `json:"seen"`
+	Added    int           `json:"added"`
+	Updated  int           `json:"updated"`
+	Removed  int           `json:"removed"`
+	Duration time.Duration `json:"duration"`
+}
+
+// NewIndex returns an index stored in db.
+func NewIndex(db *sql.DB) *Index {
+	return &Index{db: db}
+}
+
+// AddRoot registers a folder to be indexed. It does not scan it; call
+// Rescan afterwards.
+func (ix *Index) AddRoot(root string) error {
+	if root == "" {
+		return fileops.ErrInvalidPath
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return fileops.ErrInvalidPath
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fileops.ErrPathNotFound
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return fileops.ErrInvalidPath
+	}
+	_, err = ix.db.Exec("INSERT OR IGNORE INTO search_roots (path) VALUES (?)", root)
+	return err
+}
+
+// RemoveRoot unregisters a folder and drops its entries.
+func (ix *Index) RemoveRoot(root string) error {
+	if root == "" {
+		return fileops.ErrInvalidPath
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return fileops.ErrInvalidPath
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM search_names WHERE rowid IN (SELECT id FROM search_entries WHERE root = ?)", root); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM search_entries WHERE root = ?", root); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM content_files WHERE root = ?", root); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM search_roots WHERE path = ?", root); err != nil {
+		return err
+	}
+	b := &batch{tx: tx}
+	if err := b.pruneContentDocs(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Roots returns the registered root folders.
+func (ix *Index) Roots() ([]string, error) {
+	rows, err := ix.db.Query("SELECT path FROM search_roots ORDER BY path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var roots []string
+	for rows.Next() {
+		var root string
+		if err := rows.Scan(&root); err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return roots, rows.Err()
+}
+
+// Rescan walks root and brings its entries up to date. Unchanged entries
+// are only touched to mark them as seen; entries that no longer exist are
+// removed. Hidden files and folders are skipped, matching ListFiles.
+func (ix *Index) Rescan(root string) (ScanStats, error) {
+	if root == "" {
+		return ScanStats{}, fileops.ErrInvalidPath
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return ScanStats{}, fileops.ErrInvalidPath
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	start := time.Now()
+	stats := ScanStats{Root: root}
+	generation := start.UnixNano()
+
+	b, err := ix.newBatch()
+	if err != nil {
+		return stats, err
+	}
+	defer b.rollback()
+
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil // unreadable subtrees are skipped
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		added, updated, err := b.upsert(root, path, info, generation)
+		if err != nil {
+			return err
+		}
+		stats.Seen++
+		if added {
+			stats.Added++
+		} else if updated {
+			stats.Updated++
+		}
+
+		if b.n >= batchSize {
+			if err := b.flush(ix); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if walkErr != nil {
+		if os.IsNotExist(walkErr) {
+			return stats, fileops.ErrPathNotFound
+		}
+		return stats, walkErr
+	}
+
+	if _, err := b.tx.Exec("DELETE FROM search_names WHERE rowid IN (SELECT id FROM search_entries WHERE root = ? AND seen <> ?)", root, generation); err != nil {
+		return stats, err
+	}
+	res, err := b.tx.Exec("DELETE FROM search_entries WHERE root = ? AND seen <> ?", root, generation)
+	if err != nil {
+		return stats, err
+	}
+	removed, _ := res.RowsAffected()
+	stats.Removed = int(removed)
+
+	if _, err := b.tx.Exec("UPDATE search_roots SET last_scan = CURRENT_TIMESTAMP WHERE path = ?", root); err != nil {
+		return stats, err
+	}
+	if err := b.tx.Commit(); err != nil {
+		return stats, err
+	}
+	stats.Duration = time.Since(start)
+	return stats, nil
+}
+
+// RescanAll rescans every registered root, logging failures. When content
+// indexing is enabled, document text is refreshed as well.
+func (ix *Index) RescanAll() []ScanStats {
+	roots, err := ix.Roots()
+	if err != nil {
+		log.Printf("search: listing roots: %v", err)
+		return nil
+	}
+	var all []ScanStats
+	for _, root := range roots {
+		if stats, err := ix.Refresh(root); err == nil {
+			all = append(all, stats)
+		}
+	}
+	return all
+}
+
+// Refresh rescans one root's names and, when enabled, its document text,
+// logging failures.
+func (ix *Index) Refresh(root string) (ScanStats, error) {
+	stats, err := ix.Rescan(root)
+	if err != nil {
+		log.Printf("search: rescanning %s: %v", root, err)
+		return stats, err
+	}
+	if ix.contentEnabled() {
+		if _, err := ix.RescanContent(root); err != nil {
+			log.Printf("search: indexing content of %s: %v", root, err)
+		}
+	}
+	return stats, nil
+}
+
+// Run rescans all roots every interval until ctx is cancelled.
+func (ix *Index) Run(ctx context.Context, interval time.Duration) {
+	ix.RescanAll()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ix.RescanAll()
+		}
+	}
+}
+
+func (ix *Index) contentEnabled() bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.contentRules != nil
+}
+
+// Changed queues path for Update on a background goroutine and returns at
+// once, so mutating API handlers and change watchers do not wait for a
+// rescan that holds the index. Failures are logged.
+func (ix *Index) Changed(path string) {
+	ix.qmu.Lock()
+	defer ix.qmu.Unlock()
+	if ix.pending == nil {
+		ix.pending = make(map[string]bool)
+	}
+	if ix.pending[path] || len(ix.queue) >= maxQueued {
+		return
+	}
+	ix.pending[path] = true
+	ix.queue = append(ix.queue, path)
+	if !ix.working {
+		ix.working = true
+		go ix.work()
+	}
+}
+
+// work applies queued changes until the queue is empty.
+func (ix *Index) work() {
+	for {
+		ix.qmu.Lock()
+		if len(ix.queue) == 0 {
+			ix.working = false
+			ix.qmu.Unlock()
+			return
+		}
+		path := ix.queue[0]
+		ix.queue = ix.queue[1:]
+		delete(ix.pending, path)
+		ix.qmu.Unlock()
+
+		if err := ix.Update(path); err != nil {
+			log.Printf("search: updating %s: %v", path, err)
+		}
+	}
+}
+
+// Update re-indexes a path after it was created or modified; for a folder,
+// everything below it is indexed too. A path that no longer exists is
+// removed. Paths outside every registered root are ignored. It waits for
+// any rescan in progress; see Changed.
+func (ix *Index) Update(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fileops.ErrInvalidPath
+	}
+	root, err := ix.rootFor(path)
+	if err != nil || root == "" {
+		return err
+	}
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return ix.Remove(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	b, err := ix.newBatch()
+	if err != nil {
+		return err
+	}
+	defer b.rollback()
+	generation := time.Now().UnixNano()
+	if !info.IsDir() {
+		if _, _, err := b.upsert(root, path, info, generation); err != nil {
+			return err
+		}
+		return b.tx.Commit()
+	}
+
+	walkErr := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != path && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if _, _, err := b.upsert(root, p, info, generation); err != nil {
+			return err
+		}
+		if b.n >= batchSize {
+			if err := b.flush(ix); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	return b.tx.Commit()
+}
+
+// Remove drops path and everything below it from the index.
+func (ix *Index) Remove(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fileops.ErrInvalidPath
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	prefix := db.EscapeLike(path+string(filepath.Separator)) + "%"
+	where := "path = ? OR path LIKE ? ESCAPE '\\'"
+	if _, err := tx.Exec("DELETE FROM search_names WHERE rowid IN (SELECT id FROM search_entries WHERE "+where+")", path, prefix); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM search_entries WHERE "+where, path, prefix); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rootFor returns the registered root containing path, or "".
+func (ix *Index) rootFor(path string) (string, error) {
+	roots, err := ix.Roots()
+	if err != nil {
+		return "", err
+	}
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return root, nil
+		}
+	}
+	return "", nil
+}
+
+// batch groups index writes into one transaction.
+type batch struct {
+	tx *sql.Tx
+	n  int
+}
+
+func (ix *Index) newBatch() (*batch, error) {
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &batch{tx: tx}, nil
+}
+
+// flush commits the batch and starts the next transaction in place. If
+// that fails, b keeps the committed transaction, so the deferred rollback
+// stays harmless.
+func (b *batch) flush(ix *Index) error {
+	if err := b.tx.Commit(); err != nil {
+		return err
+	}
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	b.tx, b.n = tx, 0
+	return nil
+}
+
+func (b *batch) rollback() {
+	b.tx.Rollback()
+}
+
+// upsert records one entry, reporting whether it was new or changed.
+func (b *batch) upsert(root, path string, info fs.FileInfo, generation int64) (added, updated bool, err error) {
+	b.n++
+	modTime := info.ModTime().UnixNano()
+
+	res, err := b.tx.Exec("UPDATE search_entries SET seen = ? WHERE path = ? AND mod_time = ? AND size = ?",
+		generation, path, modTime, info.Size())
+	if err != nil {
+		return false, false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return false, false, nil
+	}
+
+	res, err = b.tx.Exec("UPDATE search_entries SET seen = ?, mod_time = ?, size = ?, is_dir = ? WHERE path = ?",
+		generation, modTime, info.Size(), info.IsDir(), path)
+	if err != nil {
+		return false, false, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return false, true, nil
+	}
+
+	name := filepath.Base(path)
+	res, err = b.tx.Exec(`INSERT INTO search_entries (root, path, name, is_dir, size, mod_time, seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, root, path, name, info.IsDir(), info.Size(), modTime, generation)
+	if err != nil {
+		return false, false, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return false, false, err
+	}
+	_, err = b.tx.Exec("INSERT INTO search_names (rowid, words, grams) VALUES (?, ?, ?)",
+		id, strings.Join(nameWords(name), " "), strings.Join(trigrams(name), " "))
+	return err == nil, false, err
+}
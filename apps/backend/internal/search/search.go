@@ -0,0 +1,309 @@
+package search
+
+import (
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"file-manager-backend/internal/db"
+	"file-manager-backend/internal/fileops"
+)
+
+// Mode selects how query words are matched against names.
+type Mode string
+
+const (
+	// ModePrefix matches names containing words starting with each query
+	// word, e.g. "hol pho" finds "Holiday Photos.zip".
+	ModePrefix Mode = "prefix"
+	// ModeFuzzy matches names sharing trigrams with the query, tolerating
+	// typos such as "holdiay".
+	ModeFuzzy Mode = "fuzzy"
+	// ModeAuto runs a prefix search and tops it up with fuzzy matches.
+	ModeAuto Mode = "auto"
+)
+
+// fuzzyCandidates caps how many trigram hits are ranked per fuzzy query.
+const fuzzyCandidates = 5000
+
+// Options controls a search.
+type Options struct {
+	Mode  Mode
+	Limit int
+	// Root restricts results to paths below this folder.
+	Root string
+	// Filter keeps only hits whose current metadata matches it.
+	Filter *fileops.Query
+}
+
+// Result is one ranked hit.
+type Result struct {
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	IsDirectory bool      `json:"isDirectory"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+	Score       float64   `json:"score"`
+}
+
+// Search looks up names matching query, ranked by relevance and recency.
+func (ix *Index) Search(query string, opts Options) ([]Result, error) {
+	words := nameWords(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+	if opts.Mode == "" {
+		opts.Mode = ModeAuto
+	}
+
+	queryGrams := trigrams(query)
+	hits := make(map[string]*Result)
+	if opts.Mode != ModeFuzzy {
+		terms := make([]string, len(words))
+		for i, w := range words {
+			terms[i] = "words:" + w + "*"
+		}
+		if err := ix.collect(hits, strings.Join(terms, " "), opts.Root, opts.Limit*20); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Mode == ModeFuzzy || (opts.Mode == ModeAuto && len(hits) < opts.Limit) {
+		if len(queryGrams) > 0 {
+			terms := make([]string, len(queryGrams))
+			for i, g := range queryGrams {
+				terms[i] = "grams:" + g
+			}
+			if err := ix.collect(hits, strings.Join(terms, " OR "), opts.Root, fuzzyCandidates); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	now := time.Now()
+	results := make([]Result, 0, len(hits))
+	for _, r := range hits {
+		r.Score = score(words, queryGrams, r, now)
+		if opts.Mode == ModeFuzzy || r.Score >= minScore {
+			results = append(results, *r)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+	if opts.Filter != nil {
+		results = filterResults(results, opts.Filter, opts.Limit)
+	}
+	if len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	return results, nil
+}
+
+// filterResults keeps up to limit results matching q, in order. Hits that
+// no longer exist are dropped.
+func filterResults(results []Result, q *fileops.Query, limit int) []Result {
+	kept := results[:0]
+	for _, r := range results {
+		if len(kept) == limit {
+			break
+		}
+		info, err := fileops.Stat(r.Path)
+		if err == nil && q.Match(&info) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// collect adds FTS matches for match to hits.
+func (ix *Index) collect(hits map[string]*Result, match, root string, limit int) error {
+	sqlQuery := `SELECT e.path, e.name, e.is_dir, e.size, e.mod_time
+		FROM search_names JOIN search_entries e ON e.id = search_names.rowid
+		WHERE search_names MATCH ?`
+	args := []interface{}{match}
+	if root != "" {
+		root = filepath.Clean(root)
+		sqlQuery += " AND (e.path = ? OR e.path LIKE ? ESCAPE '\\')"
+		args = append(args, root, db.EscapeLike(root+string(filepath.Separator))+"%")
+	}
+	sqlQuery += " LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := ix.db.Query(sqlQuery, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r Result
+		var modTime int64
+		if err := rows.Scan(&r.Path, &r.Name, &r.IsDirectory, &r.Size, &modTime); err != nil {
+			return err
+		}
+		if _, ok := hits[r.Path]; ok {
+			continue
+		}
+		r.ModTime = time.Unix(0, modTime)
+		hits[r.Path] = &r
+	}
+	return rows.Err()
+}
+
+// Entries returns the indexed files and folders below root, ordered by
+// path. Unlike Search it needs no query, so callers can filter the whole
+// index themselves.
+func (ix *Index) Entries(root string) ([]Result, error) {
+	root = filepath.Clean(root)
+	rows, err := ix.db.Query(`SELECT path, name, is_dir, size, mod_time FROM search_entries
+		WHERE path = ? OR path LIKE ? ESCAPE '\' ORDER BY path`,
+		root, db.EscapeLike(root+string(filepath.Separator))+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []Result
+	for rows.Next() {
+		var r Result
+		var modTime int64
+		if err := rows.Scan(&r.Path, &r.Name, &r.IsDirectory, &r.Size, &modTime); err != nil {
+			return nil, err
+		}
+		r.ModTime = time.Unix(0, modTime)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// Covers reports whether path lies in a registered root.
+func (ix *Index) Covers(path string) (bool, error) {
+	root, err := ix.rootFor(filepath.Clean(path))
+	return root != "", err
+}
+
+// minScore drops weak fuzzy matches from auto-mode results.
+const minScore = 0.3
+
+// score ranks a hit. Word-prefix matches dominate, trigram similarity
+// covers typos and a small bonus favours recently modified files.
+func score(words, queryGrams []string, r *Result, now time.Time) float64 {
+	nameLower := strings.ToLower(r.Name)
+	stem := strings.TrimSuffix(nameLower, strings.ToLower(filepath.Ext(r.Name)))
+	query := strings.Join(words, " ")
+
+	var s float64
+	switch {
+	case stem == query || nameLower == query:
+		s += 2
+	case strings.HasPrefix(nameLower, words[0]):
+		s += 1
+	}
+
+	nameWordSet := nameWords(r.Name)
+	matched := 0
+	for _, w := range words {
+		for _, nw := range nameWordSet {
+			if strings.HasPrefix(nw, w) {
+				matched++
+				break
+			}
+		}
+	}
+	s += float64(matched) / float64(len(words))
+
+	s += jaccard(queryGrams, trigrams(r.Name))
+
+	age := now.Sub(r.ModTime).Hours() / 24
+	if age < 0 {
+		age = 0
+	}
+	s += 0.25 * math.Exp(-age/30)
+	return s
+}
+
+func jaccard(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := make(map[string]bool, len(a))
+	for _, g := range a {
+		set[g] = true
+	}
+	inter := 0
+	union := len(set)
+	seen := make(map[string]bool, len(b))
+	for _, g := range b {
+		if seen[g] {
+			continue
+		}
+		seen[g] = true
+		if set[g] {
+			inter++
+		} else {
+			union++
+		}
+	}
+	return float64(inter) / float64(union)
+}
+
+// nameWords splits a file name into lower-case words on punctuation,
+// camelCase and letter/digit boundaries: "IMG_2041-editedFinal.jpg"
+// becomes img 2041 edited final jpg.
+func nameWords(name string) []string {
+	var words []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, strings.ToLower(string(cur)))
+			cur = cur[:0]
+		}
+	}
+	var prev rune
+	for _, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case len(cur) > 0 && unicode.IsUpper(r) && unicode.IsLower(prev),
+			len(cur) > 0 && unicode.IsDigit(r) != unicode.IsDigit(prev):
+			flush()
+			cur = append(cur, r)
+		default:
+			cur = append(cur, r)
+		}
+		prev = r
+	}
+	flush()
+	return words
+}
+
+// trigrams returns the distinct three-rune windows of each word of name.
+// Words shorter than three runes are kept whole.
+func trigrams(name string) []string {
+	seen := make(map[string]bool)
+	var grams []string
+	add := func(g string) {
+		if !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	for _, w := range nameWords(name) {
+		runes := []rune(w)
+		if len(runes) < 3 {
+			add(w)
+			continue
+		}
+		for i := 0; i+3 <= len(runes); i++ {
+			add(string(runes[i : i+3]))
+		}
+	}
+	return grams
+}
@@ -0,0 +1,238 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/db/dbtest"
+	"file-manager-backend/internal/fileops"
+)
+
+func setupIndex(t *testing.T) (*Index, string) {
+	conn := dbtest.Open(t)
+
+	root := t.TempDir()
+	for _, name := range []string{
+		"Holiday Photos/IMG_2041.jpg",
+		"Holiday Photos/beach.png",
+		"invoices/invoice-2023-03.pdf",
+		"invoices/InvoiceSummary.xlsx",
+		"notes/meeting notes.md",
+		".git/config",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ix := NewIndex(conn)
+	if err := ix.AddRoot(root); err != nil {
+		t.Fatal(err)
+	}
+	return ix, root
+}
+
+func names(results []Result) []string {
+	var out []string
+	for _, r := range results {
+		out = append(out, r.Name)
+	}
+	return out
+}
+
+func TestNameWords(t *testing.T) {
+	got := nameWords("IMG_2041-editedFinal.jpg")
+	want := []string{"img", "2041", "edited", "final", "jpg"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("nameWords = %v, want %v", got, want)
+	}
+}
+
+func TestRescanAndSearch(t *testing.T) {
+	ix, root := setupIndex(t)
+
+	stats, err := ix.Rescan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// root, 3 folders and 5 files; .git is skipped
+	if stats.Seen != 9 || stats.Added != 9 {
+		t.Errorf("first scan stats = %+v", stats)
+	}
+
+	results, err := ix.Search("invoice", Options{Mode: ModePrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(results); len(got) != 3 || got[0] != "invoices" {
+		t.Errorf("prefix search = %v", got)
+	}
+
+	results, err = ix.Search("hol pho", Options{Mode: ModePrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(results); len(got) != 1 || got[0] != "Holiday Photos" {
+		t.Errorf("multi-word prefix search = %v", got)
+	}
+
+	results, err = ix.Search("invoce summry", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(results); len(got) == 0 || got[0] != "InvoiceSummary.xlsx" {
+		t.Errorf("fuzzy search = %v", got)
+	}
+
+	filter, err := fileops.ParseQuery("is:file ext:pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = ix.Search("invoice", Options{Mode: ModePrefix, Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(results); len(got) != 1 || got[0] != "invoice-2023-03.pdf" {
+		t.Errorf("filtered search = %v", got)
+	}
+
+	results, err = ix.Search("config", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("hidden entries should not be indexed, got %v", names(results))
+	}
+}
+
+func TestRescanRemovesStaleEntries(t *testing.T) {
+	ix, root := setupIndex(t)
+	if _, err := ix.Rescan(root); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(root, "invoices")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ix.Rescan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Removed != 3 || stats.Added != 0 {
+		t.Errorf("second scan stats = %+v", stats)
+	}
+
+	results, err := ix.Search("invoice", Options{Mode: ModePrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results, got %v", names(results))
+	}
+}
+
+func TestUpdateAndRemove(t *testing.T) {
+	ix, root := setupIndex(t)
+	if _, err := ix.Rescan(root); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(root, "notes", "quarterly report.docx")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Update(path); err != nil {
+		t.Fatal(err)
+	}
+	results, err := ix.Search("quarterly", Options{Mode: ModePrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Path != path {
+		t.Errorf("expected %s, got %v", path, names(results))
+	}
+
+	if err := ix.Remove(filepath.Join(root, "notes")); err != nil {
+		t.Fatal(err)
+	}
+	results, err = ix.Search("quarterly", Options{Mode: ModePrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected removal, got %v", names(results))
+	}
+}
+
+func TestUpdateFolder(t *testing.T) {
+	ix, root := setupIndex(t)
+	if _, err := ix.Rescan(root); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(root, "archive", "2019")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "tax return.pdf")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Update(filepath.Join(root, "archive")); err != nil {
+		t.Fatal(err)
+	}
+	results, err := ix.Search("tax", Options{Mode: ModePrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Path != path {
+		t.Errorf("expected %s, got %v", path, names(results))
+	}
+}
+
+func TestChangedDoesNotWaitForRescan(t *testing.T) {
+	ix, root := setupIndex(t)
+	if _, err := ix.Rescan(root); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "notes", "quarterly report.docx")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Holding the writer lock stands in for a long rescan.
+	ix.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		ix.Changed(path)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Changed waited for the index lock")
+	}
+	ix.mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		results, err := ix.Search("quarterly", Options{Mode: ModePrefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) == 1 && results[0].Path == path {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queued change not indexed, got %v", names(results))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
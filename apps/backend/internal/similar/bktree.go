@@ -0,0 +1,65 @@
+package similar
+
+// bkTree indexes 64-bit hashes by Hamming distance, so the hashes near a
+// given one are found without comparing it against every other hash.
+// Each child edge is labelled with the distance between the child and its
+// parent; by the triangle inequality, only children whose label lies
+// within max of the query's distance to the parent can hold matches.
+type bkTree struct {
+	root *bkNode
+}
+
+type bkNode struct {
+	hash     uint64
+	items    []int // indices of the images with this hash
+	children map[int]*bkNode
+}
+
+// add records that image i has hash.
+func (t *bkTree) add(hash uint64, i int) {
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, items: []int{i}}
+		return
+	}
+	n := t.root
+	for {
+		d := Distance(n.hash, hash)
+		if d == 0 {
+			n.items = append(n.items, i)
+			return
+		}
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*bkNode)
+			}
+			n.children[d] = &bkNode{hash: hash, items: []int{i}}
+			return
+		}
+		n = child
+	}
+}
+
+// within calls fn for every image whose hash is at most max bits away
+// from hash.
+func (t *bkTree) within(hash uint64, max int, fn func(i int)) {
+	if t.root == nil {
+		return
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := Distance(n.hash, hash)
+		if d <= max {
+			for _, i := range n.items {
+				fn(i)
+			}
+		}
+		for label, child := range n.children {
+			if label >= d-max && label <= d+max {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
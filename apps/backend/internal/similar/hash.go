@@ -0,0 +1,103 @@
+package similar
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// DHash is the difference hash of img: a 9x8 grayscale version is reduced
+// to one bit per horizontally adjacent pair, set when the left pixel is
+// brighter. It is cheap and survives scaling and recompression.
+func DHash(img image.Image) uint64 {
+	g := grayscale(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if g[y*9+x] > g[y*9+x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// PHash is the perceptual hash of img: the lowest 8x8 frequencies of the
+// discrete cosine transform of a 32x32 grayscale version, one bit per
+// frequency, set when it is above the median. It also tolerates small
+// changes of brightness, contrast and colour.
+func PHash(img image.Image) uint64 {
+	const n = 32
+	g := grayscale(img, n, n)
+
+	// Separable DCT-II, keeping only the 8 lowest frequencies per axis.
+	var cos [8][n]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < n; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+	var rows [n][8]float64
+	for y := 0; y < n; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < n; x++ {
+				sum += g[y*n+x] * cos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				sum += rows[y][u] * cos[v][y]
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+
+	// The DC term is the average brightness and is left out of the median.
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+	var h uint64
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// Distance is the number of bits in which two hashes differ.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// grayscale averages img down to w by h luma values, row by row.
+func grayscale(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/w)
+			var sum float64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, bl, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+				}
+			}
+			out[y*w+x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return out
+}
@@ -0,0 +1,256 @@
+// Package similar finds near-duplicate images: the same photo re-saved at
+// another quality, resized or exported again, which the SHA-256 duplicate
+// check misses. Each image gets a difference hash and a perceptual hash,
+// stored in SQLite by content hash, and images whose hashes differ in few
+// bits are clustered together.
+package similar
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/thumbnail"
+)
+
+// ErrInvalid is returned for report options out of range.
+var ErrInvalid = errors.New("invalid similarity options")
+
+// Algorithms compared by Report.
+const (
+	AlgorithmPHash = "phash"
+	AlgorithmDHash = "dhash"
+)
+
+// DefaultThreshold is the largest Hamming distance, out of 64 bits, at
+// which two images still count as the same picture.
+const DefaultThreshold = 10
+
+// MaxThreshold bounds the threshold; beyond it unrelated images match.
+const MaxThreshold = 32
+
+// hashVersion is stored with each row; bumping it recomputes every hash
+// after a change to the hashing.
+const hashVersion = 1
+
+// hashSize is the edge of the image the hashes are computed from.
+const hashSize = 64
+
+// Hashes are the perceptual hashes and upright dimensions of an image.
+type Hashes struct {
+	DHash  uint64
+	PHash  uint64
+	Width  int
+	Height int
+}
+
+// Store computes image hashes and keeps them by content hash, so copies
+// and moved files are not decoded again.
+type Store struct {
+	db *sql.DB
+}
+
+// New returns a store backed by db.
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Get returns the hashes of the image at path, computing and storing them
+// on first use. Files thumbnail.Supported rejects return
+// thumbnail.ErrUnsupported.
+func (s *Store) Get(path string) (Hashes, error) {
+	if !thumbnail.Supported(path) {
+		return Hashes{}, thumbnail.ErrUnsupported
+	}
+	hash, err := fileops.FileHash(path)
+	if err != nil {
+		return Hashes{}, fileops.MapError(err)
+	}
+	var h Hashes
+	var dhash, phash int64
+	err = s.db.QueryRow(`SELECT dhash, phash, width, height FROM image_hashes WHERE hash = ? AND version = ?`,
+		hash, hashVersion).Scan(&dhash, &phash, &h.Width, &h.Height)
+	if err == nil {
+		h.DHash, h.PHash = uint64(dhash), uint64(phash)
+		return h, nil
+	}
+	if err != sql.ErrNoRows {
+		return Hashes{}, err
+	}
+
+	img, full, err := thumbnail.Scaled(path, hashSize)
+	if err != nil {
+		return Hashes{}, err
+	}
+	h = Hashes{DHash: DHash(img), PHash: PHash(img), Width: full.X, Height: full.Y}
+	// For raw files Scaled only sees the embedded preview; the metadata
+	// knows the size of the sensor image.
+	if m := fileops.LookupMedia(path); m != nil && m.Width > 0 && m.Height > 0 {
+		h.Width, h.Height = m.Width, m.Height
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO image_hashes (hash, dhash, phash, width, height, version)
+		VALUES (?, ?, ?, ?, ?, ?)`, hash, int64(h.DHash), int64(h.PHash), h.Width, h.Height, hashVersion)
+	return h, err
+}
+
+// Options configure a similar-images report.
+type Options struct {
+	// Root is the folder searched, including everything below it.
+	Root string
+	// Threshold is the largest Hamming distance between an image and the
+	// best image of its cluster; zero means DefaultThreshold.
+	Threshold int
+	// Algorithm is AlgorithmPHash, the default, or AlgorithmDHash.
+	Algorithm string
+}
+
+// Image is one member of a cluster.
+type Image struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Distance is the Hamming distance to the best image of the cluster.
+	Distance int `json:"distance"`
+}
+
+// Cluster is a group of near-duplicate images.
+type Cluster struct {
+	// Best is the path of the image with the highest resolution, the one
+	// to keep.
+	Best string `json:"best"`
+	// Images lists the members, best first.
+	Images []Image `json:"images"`
+}
+
+// Report lists the clusters of near-duplicate images below a root. Each
+// image belongs to at most one cluster; Image.Distance, never above
+// Threshold, is measured to the cluster's best image.
+type Report struct {
+	Root      string    `json:"root"`
+	Threshold int       `json:"threshold"`
+	Algorithm string    `json:"algorithm"`
+	Scanned   int       `json:"scanned"`
+	Failed    int       `json:"failed"`
+	Clusters  []Cluster `json:"clusters"`
+}
+
+// Report hashes the images below opts.Root and clusters them around their
+// best image: every member is within opts.Threshold of the cluster's best
+// image, though two members may be up to twice that apart. Images that
+// cannot be decoded are logged and counted as failed. Clusters are
+// ordered by size, largest first.
+func (s *Store) Report(ctx context.Context, opts Options) (Report, error) {
+	if opts.Threshold == 0 {
+		opts.Threshold = DefaultThreshold
+	}
+	if opts.Threshold < 0 || opts.Threshold > MaxThreshold {
+		return Report{}, fmt.Errorf("%w: threshold must be between 0 and %d", ErrInvalid, MaxThreshold)
+	}
+	if opts.Algorithm == "" {
+		opts.Algorithm = AlgorithmPHash
+	}
+	if opts.Algorithm != AlgorithmPHash && opts.Algorithm != AlgorithmDHash {
+		return Report{}, fmt.Errorf("%w: unknown algorithm %q", ErrInvalid, opts.Algorithm)
+	}
+
+	files, err := fileops.ListFiles(opts.Root, fileops.ListOptions{})
+	if err != nil {
+		return Report{}, err
+	}
+	report := Report{Root: opts.Root, Threshold: opts.Threshold, Algorithm: opts.Algorithm, Clusters: []Cluster{}}
+	var images []Image
+	var hashes []uint64
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return Report{}, err
+		}
+		if f.IsDirectory || !thumbnail.Supported(f.Path) {
+			continue
+		}
+		report.Scanned++
+		h, err := s.Get(f.Path)
+		if err != nil {
+			log.Printf("similar: %s: %v", f.Path, err)
+			report.Failed++
+			continue
+		}
+		images = append(images, Image{Path: f.Path, Size: f.Size, Width: h.Width, Height: h.Height})
+		if opts.Algorithm == AlgorithmDHash {
+			hashes = append(hashes, h.DHash)
+		} else {
+			hashes = append(hashes, h.PHash)
+		}
+	}
+
+	report.Clusters = append(report.Clusters, cluster(images, hashes, opts.Threshold)...)
+	sort.Slice(report.Clusters, func(i, j int) bool {
+		a, b := report.Clusters[i], report.Clusters[j]
+		if len(a.Images) != len(b.Images) {
+			return len(a.Images) > len(b.Images)
+		}
+		return a.Best < b.Best
+	})
+	return report, nil
+}
+
+// cluster groups images, whose hashes are given in the same order, into
+// clusters of at least two around their best image.
+func cluster(images []Image, hashes []uint64, threshold int) []Cluster {
+	var clusters []Cluster
+	// Images are taken best first; each one not yet in a cluster becomes
+	// the best image of a new cluster holding every unclustered image
+	// within the threshold of it. Members are therefore all close to the
+	// image they would be replaced by, and chains of small differences
+	// do not merge unrelated pictures.
+	order := make([]int, len(images))
+	var tree bkTree
+	for i := range images {
+		order[i] = i
+		tree.add(hashes[i], i)
+	}
+	sort.Slice(order, func(a, b int) bool { return better(images[order[a]], images[order[b]]) })
+	clustered := make([]bool, len(images))
+	for _, best := range order {
+		if clustered[best] {
+			continue
+		}
+		var members []int
+		tree.within(hashes[best], threshold, func(i int) {
+			if !clustered[i] {
+				members = append(members, i)
+			}
+		})
+		if len(members) < 2 {
+			continue
+		}
+		for _, i := range members {
+			clustered[i] = true
+		}
+		sort.Slice(members, func(a, b int) bool { return better(images[members[a]], images[members[b]]) })
+		c := Cluster{Best: images[best].Path}
+		for _, i := range members {
+			img := images[i]
+			img.Distance = Distance(hashes[i], hashes[best])
+			c.Images = append(c.Images, img)
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// better reports whether a should be kept over b: more pixels win, then
+// the larger file, which usually has the higher quality, then the path.
+func better(a, b Image) bool {
+	if pa, pb := a.Width*a.Height, b.Width*b.Height; pa != pb {
+		return pa > pb
+	}
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	return a.Path < b.Path
+}
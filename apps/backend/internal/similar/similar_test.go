@@ -0,0 +1,188 @@
+package similar
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/draw"
+
+	"file-manager-backend/internal/catalog"
+	"file-manager-backend/internal/db/dbtest"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	conn := dbtest.Open(t)
+	dbtest.UseMetaCache(t, catalog.NewStore(conn))
+	return New(conn)
+}
+
+// picture draws a scene of overlapping discs on a gradient; seeds give
+// unrelated scenes.
+func picture(w, h int, seed int64) image.Image {
+	r := rand.New(rand.NewSource(seed))
+	type disc struct {
+		x, y, radius float64
+		c            color.RGBA
+	}
+	discs := make([]disc, 12)
+	for i := range discs {
+		discs[i] = disc{r.Float64(), r.Float64(), 0.05 + 0.2*r.Float64(),
+			color.RGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255}}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			u, v := float64(x)/float64(w), float64(y)/float64(h)
+			c := color.RGBA{uint8(120 * u), uint8(120 * v), 90, 255}
+			for _, d := range discs {
+				if (u-d.x)*(u-d.x)+(v-d.y)*(v-d.y) < d.radius*d.radius {
+					c = d.c
+				}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func scaled(img image.Image, w, h int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func writeImage(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if filepath.Ext(path) == ".png" {
+		err = png.Encode(f, img)
+	} else {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 40})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashes(t *testing.T) {
+	original := picture(800, 600, 1)
+	export := scaled(original, 320, 240)
+	other := picture(800, 600, 2)
+	for name, hash := range map[string]func(image.Image) uint64{"dhash": DHash, "phash": PHash} {
+		if d := Distance(hash(original), hash(export)); d > 4 {
+			t.Errorf("%s: resized copy at distance %d", name, d)
+		}
+		if d := Distance(hash(original), hash(other)); d <= DefaultThreshold {
+			t.Errorf("%s: unrelated image at distance %d", name, d)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	s := newTestStore(t)
+	root := t.TempDir()
+	original := picture(800, 600, 1)
+	os.Mkdir(filepath.Join(root, "exports"), 0o755)
+	writeImage(t, filepath.Join(root, "original.png"), original)
+	writeImage(t, filepath.Join(root, "exports", "small.jpg"), scaled(original, 400, 300))
+	writeImage(t, filepath.Join(root, "exports", "tiny.jpg"), scaled(original, 200, 150))
+	writeImage(t, filepath.Join(root, "other.jpg"), picture(640, 480, 2))
+	os.WriteFile(filepath.Join(root, "broken.jpg"), []byte("not a jpeg"), 0o644)
+	os.WriteFile(filepath.Join(root, "notes.txt"), []byte("text"), 0o644)
+
+	for _, algorithm := range []string{"", AlgorithmDHash} {
+		report, err := s.Report(context.Background(), Options{Root: root, Algorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Scanned != 5 || report.Failed != 1 || report.Threshold != DefaultThreshold {
+			t.Errorf("%s: scanned %d, failed %d, threshold %d", algorithm, report.Scanned, report.Failed, report.Threshold)
+		}
+		if len(report.Clusters) != 1 {
+			t.Fatalf("%s: clusters = %+v", algorithm, report.Clusters)
+		}
+		c := report.Clusters[0]
+		if c.Best != filepath.Join(root, "original.png") || len(c.Images) != 3 {
+			t.Fatalf("%s: cluster = %+v", algorithm, c)
+		}
+		if c.Images[0].Width != 800 || c.Images[0].Distance != 0 || c.Images[2].Width != 200 {
+			t.Errorf("%s: images = %+v", algorithm, c.Images)
+		}
+	}
+
+	if report, _ := s.Report(context.Background(), Options{Root: root, Threshold: 0}); len(report.Clusters) != 1 {
+		t.Errorf("default threshold not applied: %+v", report.Clusters)
+	}
+	for _, bad := range []Options{{Root: root, Threshold: 40}, {Root: root, Threshold: -1}, {Root: root, Algorithm: "ahash"}} {
+		if _, err := s.Report(context.Background(), bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Report(%+v) = %v", bad, err)
+		}
+	}
+
+	// Hashes are stored once per content; the broken file has none.
+	h, err := s.Get(filepath.Join(root, "original.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	s.db.QueryRow(`SELECT COUNT(*) FROM image_hashes`).Scan(&n)
+	if n != 4 || h.Width != 800 || h.Height != 600 {
+		t.Errorf("stored %d rows, hashes %+v", n, h)
+	}
+}
+
+func TestBKTreeWithin(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	hashes := make([]uint64, 500)
+	var tree bkTree
+	for i := range hashes {
+		// Flip a few bits of a handful of bases, so neighbours exist.
+		hashes[i] = uint64(rng.Intn(5))*0x9E3779B97F4A7C15 ^ 1<<uint(rng.Intn(64)) ^ 1<<uint(rng.Intn(64))
+		tree.add(hashes[i], i)
+	}
+	for _, max := range []int{0, 3, 10} {
+		for q := 0; q < 20; q++ {
+			query := hashes[rng.Intn(len(hashes))]
+			got := make(map[int]bool)
+			tree.within(query, max, func(i int) { got[i] = true })
+			for i, h := range hashes {
+				if want := Distance(h, query) <= max; got[i] != want {
+					t.Fatalf("within(%x, %d): image %d reported %v, want %v", query, max, i, got[i], want)
+				}
+			}
+		}
+	}
+}
+
+func TestClusterAroundBest(t *testing.T) {
+	// a, b and c form a chain: a-b and b-c are within the threshold but
+	// a-c is not, so c must not join the cluster of a.
+	images := []Image{
+		{Path: "a.jpg", Width: 400, Height: 300},
+		{Path: "b.jpg", Width: 200, Height: 150},
+		{Path: "c.jpg", Width: 100, Height: 75},
+	}
+	hashes := []uint64{0, 0xF, 0xFF}
+	clusters := cluster(images, hashes, 4)
+	if len(clusters) != 1 || clusters[0].Best != "a.jpg" || len(clusters[0].Images) != 2 {
+		t.Fatalf("clusters = %+v", clusters)
+	}
+	for _, img := range clusters[0].Images {
+		if img.Distance > 4 {
+			t.Errorf("%s is %d bits from the best image", img.Path, img.Distance)
+		}
+	}
+}
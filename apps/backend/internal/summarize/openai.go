@@ -0,0 +1,112 @@
+package summarize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// prompt asks for a JSON answer so summary and tags can be told apart.
+const prompt = `You summarize documents for a file manager. Reply with only a JSON object of the form
+{"summary": "...", "tags": ["...", "..."]}
+where summary is two or three plain sentences on what the document is and what it says, and tags are up to five short lower-case topic words.`
+
+// OpenAI is a Provider for any server speaking the OpenAI chat completions
+// API, such as llama.cpp, Ollama or vLLM.
+type OpenAI struct {
+	// Endpoint is the API base URL, e.g. "http://localhost:11434/v1".
+	Endpoint string
+	Model    string
+	// APIKey is sent as a bearer token when set.
+	APIKey string
+	Client *http.Client
+}
+
+// NewOpenAI returns a provider for the API at endpoint.
+func NewOpenAI(endpoint, model, apiKey string) *OpenAI {
+	return &OpenAI{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Model:    model,
+		APIKey:   apiKey,
+		Client:   &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// Name implements Provider.
+func (p *OpenAI) Name() string {
+	if p.Model == "" {
+		return "openai"
+	}
+	return p.Model
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Summarize implements Provider.
+func (p *OpenAI) Summarize(ctx context.Context, name, text string) (*Summary, error) {
+	body, err := json.Marshal(struct {
+		Model       string        `json:"model,omitempty"`
+		Messages    []chatMessage `json:"messages"`
+		Temperature float64       `json:"temperature"`
+	}{
+		Model: p.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: "File name: " + name + "\n\n" + text},
+		},
+		Temperature: 0.2,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Endpoint+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%w: %s: %s", ErrProvider, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return nil, fmt.Errorf("%w: decoding response: %v", ErrProvider, err)
+	}
+	if len(completion.Choices) == 0 {
+		return nil, fmt.Errorf("%w: empty response", ErrProvider)
+	}
+	return parseAnswer(completion.Choices[0].Message.Content), nil
+}
+
+// parseAnswer reads the JSON object the prompt asks for. Models often wrap
+// it in prose or code fences, so the outermost braces are used; an answer
+// without valid JSON is taken as the summary itself.
+func parseAnswer(content string) *Summary {
+	var sum Summary
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start >= 0 && end > start && json.Unmarshal([]byte(content[start:end+1]), &sum) == nil && sum.Summary != "" {
+		return &sum
+	}
+	return &Summary{Summary: strings.TrimSpace(content)}
+}
@@ -0,0 +1,314 @@
+// Package summarize asks a language model for a short summary and
+// suggested tags of a document's extracted text. Results are stored in
+// SQLite by content hash, so copies share one summary and each document is
+// sent at most once. Calls are rate-limited, and files matching the privacy
+// exclude list are never sent.
+package summarize
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"file-manager-backend/internal/extract"
+	"file-manager-backend/internal/fileops"
+)
+
+var (
+	// ErrExcluded is returned for files matching the privacy exclude list.
+	ErrExcluded = errors.New("file is excluded from summarization")
+	// ErrNoText is returned for documents without extractable text.
+	ErrNoText = errors.New("document has no text to summarize")
+	// ErrProvider wraps failures reported by the provider.
+	ErrProvider = errors.New("summary provider failed")
+)
+
+// DefaultExclude lists files that are never sent to a provider, whatever
+// the configuration says: keys, credentials and password stores.
+var DefaultExclude = []string{
+	".ssh", ".gnupg", ".env", "*.env",
+	"*.key", "*.pem", "*.p12", "*.pfx", "id_rsa*", "id_ed25519*",
+	"*.kdbx", "*password*", "*secret*", "*credential*",
+}
+
+// MaxTags caps how many suggested tags are kept.
+const MaxTags = 8
+
+// Summary is what a provider made of a document.
+type Summary struct {
+	Summary string   `json:"summary"`
+	Tags    []string `json:"tags"`
+	// Model names the provider and model that wrote the summary.
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Provider turns document text into a summary. name is the file name,
+// which often helps the model.
+type Provider interface {
+	// Name identifies the provider and model in stored summaries.
+	Name() string
+	Summarize(ctx context.Context, name, text string) (*Summary, error)
+}
+
+// Options tune a Summarizer.
+type Options struct {
+	// Exclude lists extra name patterns, matched case-insensitively
+	// against the file name and each folder above it. Patterns containing
+	// a slash are matched against the whole path instead.
+	Exclude []string
+	// MinInterval is the least time between two provider calls.
+	MinInterval time.Duration
+	// MaxChars caps how much text is sent per document.
+	MaxChars int
+}
+
+// Summarizer stores and rate-limits provider summaries.
+type Summarizer struct {
+	db       *sql.DB
+	provider Provider
+	opts     Options
+
+	// mu guards next, the earliest time the next provider call may
+	// start, MinInterval after the previous one started and ended, and
+	// running, which holds a channel per content hash being
+	// summarized that is closed when the call ends. It is never held
+	// across a provider call.
+	mu      sync.Mutex
+	next    time.Time
+	running map[string]chan struct{}
+}
+
+// New returns a summarizer calling provider and storing results in db.
+func New(db *sql.DB, provider Provider, opts Options) *Summarizer {
+	opts.Exclude = append(append([]string(nil), DefaultExclude...), opts.Exclude...)
+	if opts.MaxChars <= 0 {
+		opts.MaxChars = 12000
+	}
+	return &Summarizer{db: db, provider: provider, opts: opts, running: make(map[string]chan struct{})}
+}
+
+// Excluded reports whether the file at path must not be sent to the
+// provider. Both the path as given and, when it differs, the path with
+// symbolic links resolved are checked, so a link cannot smuggle an
+// excluded file out under another name.
+func (s *Summarizer) Excluded(path string) bool {
+	if s.excluded(path) {
+		return true
+	}
+	real, err := filepath.EvalSymlinks(path)
+	return err == nil && s.excluded(real)
+}
+
+func (s *Summarizer) excluded(path string) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	lower := strings.ToLower(path)
+	parts := strings.Split(lower, "/")
+	for _, pattern := range s.opts.Exclude {
+		pattern = strings.ToLower(pattern)
+		if strings.Contains(pattern, "/") {
+			// Match the path and each folder above it, so "/home/*/tax"
+			// covers everything inside.
+			for p := lower; p != "/" && p != "."; p = filepath.ToSlash(filepath.Dir(p)) {
+				if ok, _ := filepath.Match(pattern, p); ok {
+					return true
+				}
+			}
+			continue
+		}
+		for _, part := range parts {
+			if ok, _ := filepath.Match(pattern, part); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Cached returns the stored summary of the file at path without calling
+// the provider, or nil when there is none or the file is excluded.
+func (s *Summarizer) Cached(path string) (*Summary, error) {
+	if s.Excluded(path) || !extract.Supported(path) {
+		return nil, nil
+	}
+	hash, err := fileops.FileHash(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	return s.load(hash)
+}
+
+// Summarize returns the summary of the document at path, asking the
+// provider on first use. Calls wait for MinInterval since the previous one
+// or until ctx is done.
+func (s *Summarizer) Summarize(ctx context.Context, path string) (*Summary, error) {
+	if s.Excluded(path) {
+		return nil, ErrExcluded
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	if info.IsDir() {
+		return nil, fileops.ErrInvalidPath
+	}
+	if !extract.Supported(path) {
+		return nil, extract.ErrUnsupported
+	}
+	hash, err := fileops.FileHash(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	if sum, err := s.load(hash); sum != nil || err != nil {
+		return sum, err
+	}
+
+	text, err := extract.Text(path)
+	if err != nil {
+		return nil, fileops.MapError(err)
+	}
+	text = truncate(strings.TrimSpace(text), s.opts.MaxChars)
+	if text == "" {
+		return nil, ErrNoText
+	}
+
+	cached, done, err := s.reserve(ctx, hash)
+	if done == nil {
+		// A concurrent call summarized the same content meanwhile.
+		return cached, err
+	}
+	defer done()
+	sum, err := s.provider.Summarize(ctx, filepath.Base(path), text)
+	if err != nil {
+		if errors.Is(err, ErrProvider) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	sum.Summary = strings.TrimSpace(sum.Summary)
+	sum.Tags = cleanTags(sum.Tags)
+	sum.Model = s.provider.Name()
+	sum.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	if err := s.save(hash, sum); err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// reserve waits for the turn to summarize hash: until no other call is
+// summarizing the same content and MinInterval has passed since the
+// previous call, or until ctx is done. If the content got a
+// summary meanwhile, that summary is returned with a nil done. Otherwise
+// done must be called once the provider call ends.
+func (s *Summarizer) reserve(ctx context.Context, hash string) (*Summary, func(), error) {
+	for {
+		if sum, err := s.load(hash); sum != nil || err != nil {
+			return sum, nil, err
+		}
+		s.mu.Lock()
+		busy, ok := s.running[hash]
+		if !ok {
+			break
+		}
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-busy:
+		}
+	}
+	ch := make(chan struct{})
+	s.running[hash] = ch
+	start := time.Now()
+	if s.next.After(start) {
+		start = s.next
+	}
+	s.next = start.Add(s.opts.MinInterval)
+	s.mu.Unlock()
+
+	done := func() {
+		s.mu.Lock()
+		if end := time.Now().Add(s.opts.MinInterval); end.After(s.next) {
+			s.next = end
+		}
+		delete(s.running, hash)
+		s.mu.Unlock()
+		close(ch)
+	}
+	if wait := time.Until(start); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			done()
+			return nil, nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil, done, nil
+}
+
+func (s *Summarizer) load(hash string) (*Summary, error) {
+	var sum Summary
+	var tags string
+	err := s.db.QueryRow(`SELECT summary, tags, model, created_at FROM document_summaries WHERE hash = ?`, hash).
+		Scan(&sum.Summary, &tags, &sum.Model, &sum.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tags), &sum.Tags); err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
+
+func (s *Summarizer) save(hash string, sum *Summary) error {
+	tags, err := json.Marshal(sum.Tags)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO document_summaries (hash, summary, tags, model, created_at)
+		VALUES (?, ?, ?, ?, ?)`, hash, sum.Summary, string(tags), sum.Model, sum.CreatedAt)
+	return err
+}
+
+// cleanTags lower-cases and de-duplicates suggested tags, keeping at most
+// MaxTags.
+func cleanTags(tags []string) []string {
+	out := []string{}
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		t = strings.ToLower(strings.Join(strings.Fields(t), " "))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+		if len(out) == MaxTags {
+			break
+		}
+	}
+	return out
+}
+
+// truncate cuts s to at most max bytes without splitting a character.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
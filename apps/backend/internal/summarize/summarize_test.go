@@ -0,0 +1,205 @@
+package summarize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/catalog"
+	"file-manager-backend/internal/db/dbtest"
+)
+
+func newTestSummarizer(t *testing.T, p Provider, opts Options) *Summarizer {
+	t.Helper()
+	conn := dbtest.Open(t)
+	dbtest.UseMetaCache(t, catalog.NewStore(conn))
+	return New(conn, p, opts)
+}
+
+// stubServer answers chat completions with answer and counts requests.
+func stubServer(t *testing.T, answer string, calls *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer k" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Model    string        `json:"model"`
+			Messages []chatMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Model != "tiny" || len(req.Messages) != 2 {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": chatMessage{Role: "assistant", Content: answer}}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSummarize(t *testing.T) {
+	var calls int32
+	srv := stubServer(t, "Sure!\n```json\n{\"summary\": \"A lease for a flat.\", \"tags\": [\"Lease\", \"housing\", \"lease\"]}\n```", &calls)
+	s := newTestSummarizer(t, NewOpenAI(srv.URL+"/v1/", "tiny", "k"), Options{MinInterval: 50 * time.Millisecond})
+
+	dir := t.TempDir()
+	lease, copied := filepath.Join(dir, "lease.txt"), filepath.Join(dir, "copy.txt")
+	for _, path := range []string{lease, copied} {
+		if err := os.WriteFile(path, []byte("This agreement is made between the parties."), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sum, err := s.Cached(lease); sum != nil || err != nil {
+		t.Fatalf("Cached before = %v, %v", sum, err)
+	}
+	sum, err := s.Summarize(context.Background(), lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Summary != "A lease for a flat." || !reflect.DeepEqual(sum.Tags, []string{"lease", "housing"}) || sum.Model != "tiny" {
+		t.Errorf("summary = %+v", sum)
+	}
+
+	// The copy has the same content, so the stored summary is reused.
+	if got, err := s.Summarize(context.Background(), copied); err != nil || got.Summary != sum.Summary {
+		t.Errorf("copy = %+v, %v", got, err)
+	}
+	if got, err := s.Cached(copied); err != nil || got == nil || !reflect.DeepEqual(got.Tags, sum.Tags) {
+		t.Errorf("Cached = %+v, %v", got, err)
+	}
+	if calls != 1 {
+		t.Errorf("provider called %d times, want 1", calls)
+	}
+
+	// Different content is rate-limited behind the previous call.
+	if err := os.WriteFile(copied, []byte("Something else entirely."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := s.Summarize(context.Background(), copied); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || time.Since(start) < 40*time.Millisecond {
+		t.Errorf("calls = %d after %v", calls, time.Since(start))
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	var calls int32
+	srv := stubServer(t, `{"summary": "x"}`, &calls)
+	s := newTestSummarizer(t, NewOpenAI(srv.URL+"/v1", "tiny", "k"), Options{Exclude: []string{"Medical", "/home/*/tax"}})
+
+	for path, want := range map[string]bool{
+		"/home/a/docs/notes.txt":         false,
+		"/home/a/.ssh/config":            true,
+		"/home/a/passwords.txt":          true,
+		"/home/a/medical/scan.pdf":       true,
+		"/home/a/tax/2023/return.pdf":    true,
+		"/home/a/taxes/2023/return.pdf":  false,
+		"/home/a/docs/server.KEY":        true,
+		"/home/a/docs/environment.txt":   false,
+		"/srv/share/Secret Plans/a.docx": true,
+	} {
+		if got := s.Excluded(path); got != want {
+			t.Errorf("Excluded(%s) = %v, want %v", path, got, want)
+		}
+	}
+
+	dir := filepath.Join(t.TempDir(), "medical")
+	os.Mkdir(dir, 0o755)
+	path := filepath.Join(dir, "results.txt")
+	os.WriteFile(path, []byte("blood test"), 0o644)
+	if _, err := s.Summarize(context.Background(), path); !errors.Is(err, ErrExcluded) {
+		t.Errorf("Summarize excluded = %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("provider called for an excluded file")
+	}
+
+	// A link to an excluded file is excluded too.
+	link := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.Symlink(path, link); err != nil {
+		t.Skip(err)
+	}
+	if !s.Excluded(link) {
+		t.Errorf("Excluded(link to %s) = false", path)
+	}
+}
+
+func TestProviderError(t *testing.T) {
+	var calls int32
+	srv := stubServer(t, "", &calls)
+	s := newTestSummarizer(t, NewOpenAI(srv.URL+"/v1", "tiny", "wrong"), Options{})
+	path := filepath.Join(t.TempDir(), "a.txt")
+	os.WriteFile(path, []byte("hello"), 0o644)
+	if _, err := s.Summarize(context.Background(), path); !errors.Is(err, ErrProvider) {
+		t.Errorf("err = %v", err)
+	}
+	if sum, _ := s.Cached(path); sum != nil {
+		t.Errorf("failure was cached: %+v", sum)
+	}
+}
+
+func TestParseAnswer(t *testing.T) {
+	if got := parseAnswer("Just prose."); got.Summary != "Just prose." || got.Tags != nil {
+		t.Errorf("prose = %+v", got)
+	}
+	if got := parseAnswer(`{"summary": "", "tags": ["a"]}`); got.Summary != `{"summary": "", "tags": ["a"]}` {
+		t.Errorf("empty summary = %+v", got)
+	}
+}
+
+// blockingProvider holds every call until release is closed.
+type blockingProvider struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p blockingProvider) Name() string { return "blocking" }
+
+func (p blockingProvider) Summarize(ctx context.Context, name, text string) (*Summary, error) {
+	p.started <- struct{}{}
+	<-p.release
+	return &Summary{Summary: name}, nil
+}
+
+func TestSummarizeWaitRespectsContext(t *testing.T) {
+	p := blockingProvider{started: make(chan struct{}, 2), release: make(chan struct{})}
+	s := newTestSummarizer(t, p, Options{MinInterval: time.Hour})
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	os.WriteFile(a, []byte("first document"), 0o644)
+	os.WriteFile(b, []byte("second document"), 0o644)
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := s.Summarize(context.Background(), a)
+		first <- err
+	}()
+	<-p.started
+
+	// The second call waits for its turn, but gives up with its context
+	// while the first is still with the provider.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.Summarize(ctx, b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting call = %v, want deadline exceeded", err)
+	}
+	close(p.release)
+	if err := <-first; err != nil {
+		t.Error(err)
+	}
+}
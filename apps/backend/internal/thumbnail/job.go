@@ -0,0 +1,137 @@
+package thumbnail
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// maxJobs is how many finished jobs are remembered for Jobs.
+const maxJobs = 50
+
+// Job pre-generates thumbnails for the images in a folder.
+type Job struct {
+	ID        int        `json:"id"`
+	Dir       string     `json:"dir"`
+	Recursive bool       `json:"recursive"`
+	Sizes     []int      `json:"sizes"`
+	Total     int        `json:"total"`
+	Done      int        `json:"done"`
+	Failed    int        `json:"failed"`
+	StartedAt time.Time  `json:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Generate starts a background job creating thumbnails of every
+// supported file in dir, and in its subfolders when recursive is set.
+// Hidden entries are skipped.
+func (s *Service) Generate(dir string, recursive bool, sizes []int) (Job, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return Job{}, fileops.MapError(err)
+	}
+	if !info.IsDir() {
+		return Job{}, fileops.ErrInvalidPath
+	}
+
+	s.mu.Lock()
+	s.nextJob++
+	job := &Job{ID: s.nextJob, Dir: filepath.Clean(dir), Recursive: recursive, Sizes: sizes, StartedAt: time.Now()}
+	s.jobs = append(s.jobs, job)
+	if len(s.jobs) > maxJobs {
+		s.jobs = s.jobs[len(s.jobs)-maxJobs:]
+	}
+	snapshot := *job
+	s.mu.Unlock()
+
+	go s.run(job)
+	return snapshot, nil
+}
+
+// Jobs returns the recent jobs, newest first.
+func (s *Service) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for i := len(s.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, *s.jobs[i])
+	}
+	return jobs
+}
+
+func (s *Service) run(job *Job) {
+	var files []string
+	err := filepath.WalkDir(job.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != job.Dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path != job.Dir && !job.Recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && Supported(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	s.mu.Lock()
+	job.Total = len(files)
+	if err != nil {
+		job.Error = err.Error()
+	}
+	s.mu.Unlock()
+
+	// A couple of workers keep the disk busy while decoding without
+	// starving interactive thumbnail requests.
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				var failed bool
+				for _, size := range job.Sizes {
+					if _, _, err := s.Get(path, size); err != nil {
+						failed = true
+						log.Printf("Thumbnail for %s failed: %v", path, err)
+						break
+					}
+				}
+				s.mu.Lock()
+				if failed {
+					job.Failed++
+				} else {
+					job.Done++
+				}
+				s.mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range files {
+		paths <- path
+	}
+	close(paths)
+	wg.Wait()
+
+	s.mu.Lock()
+	now := time.Now()
+	job.EndedAt = &now
+	s.mu.Unlock()
+}
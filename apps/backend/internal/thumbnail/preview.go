@@ -0,0 +1,83 @@
+package thumbnail
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+
+	"file-manager-backend/internal/media"
+)
+
+const (
+	// previewScanLimit bounds how much of a RAW file is searched for
+	// embedded JPEGs; cameras put their previews near the start.
+	previewScanLimit = 32 << 20
+	// maxPreviewCandidates bounds how many JPEG headers are inspected.
+	maxPreviewCandidates = 32
+)
+
+var jpegSOI = []byte{0xFF, 0xD8, 0xFF}
+
+// embeddedPreview returns the largest JPEG embedded in a RAW or HEIC
+// file. The EXIF thumbnail is tried first since its location is known;
+// the file is then searched for JPEG headers, which finds the larger
+// previews most cameras add and the EXIF thumbnail inside HEIC files.
+func embeddedPreview(f *os.File) (image.Image, error) {
+	type candidate struct {
+		offset, length int64
+		pixels         int
+	}
+	var best candidate
+
+	if x, err := media.DecodeEXIF(f); err == nil && x.ThumbnailLength > 0 {
+		r := io.NewSectionReader(f, x.ThumbnailOffset, x.ThumbnailLength)
+		if cfg, err := jpeg.DecodeConfig(r); err == nil {
+			best = candidate{x.ThumbnailOffset, x.ThumbnailLength, cfg.Width * cfg.Height}
+		}
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	limit := info.Size()
+	if limit > previewScanLimit {
+		limit = previewScanLimit
+	}
+	buf := make([]byte, limit)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	for start, tried := 0, 0; tried < maxPreviewCandidates; tried++ {
+		i := bytes.Index(buf[start:], jpegSOI)
+		if i < 0 {
+			break
+		}
+		offset := int64(start + i)
+		start += i + len(jpegSOI)
+		if offset == best.offset {
+			continue
+		}
+		r := io.NewSectionReader(f, offset, info.Size()-offset)
+		cfg, err := jpeg.DecodeConfig(r)
+		if err != nil || cfg.Width*cfg.Height <= best.pixels || cfg.Width*cfg.Height > maxPixels {
+			continue
+		}
+		best = candidate{offset, info.Size() - offset, cfg.Width * cfg.Height}
+	}
+
+	if best.pixels == 0 {
+		return nil, fmt.Errorf("%w: no embedded preview", ErrUnsupported)
+	}
+	img, err := jpeg.Decode(io.NewSectionReader(f, best.offset, best.length))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,268 @@
+// Package thumbnail generates and caches resized previews of images.
+// Thumbnails are stored by content hash, so copies of a photo share one
+// and a file that changes gets a new one.
+package thumbnail
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"file-manager-backend/internal/fileops"
+	"file-manager-backend/internal/media"
+)
+
+// ErrUnsupported is returned for files that have no thumbnail.
+var ErrUnsupported = errors.New("no thumbnail for this file type")
+
+// Sizes maps size names to the longest edge in pixels.
+var Sizes = map[string]int{
+	"small":  128,
+	"medium": 256,
+	"large":  512,
+}
+
+// DefaultSize is used when a request names no size.
+const DefaultSize = "medium"
+
+// Quality is the JPEG quality thumbnails are written with.
+const Quality = 82
+
+// maxPixels refuses images whose decoded form would need more than about
+// 1 GB of memory.
+const maxPixels = 250_000_000
+
+// decodedExtensions are decoded directly; rawExtensions only yield their
+// embedded JPEG preview.
+var (
+	decodedExtensions = map[string]bool{
+		".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	}
+	rawExtensions = map[string]bool{
+		".cr2": true, ".cr3": true, ".nef": true, ".arw": true, ".dng": true, ".orf": true,
+		".rw2": true, ".raf": true, ".pef": true, ".srw": true, ".heic": true, ".heif": true,
+	}
+)
+
+// Supported reports whether a thumbnail can be made for path.
+func Supported(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return decodedExtensions[ext] || rawExtensions[ext]
+}
+
+// Service creates thumbnails on demand and keeps them in a cache folder.
+type Service struct {
+	dir string
+
+	mu       sync.Mutex
+	inflight map[string]*call
+	jobs     []*Job
+	nextJob  int
+}
+
+// call lets concurrent requests for the same thumbnail wait for a single
+// generation.
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+// New returns a service caching thumbnails in dir.
+func New(dir string) *Service {
+	return &Service{dir: dir, inflight: make(map[string]*call)}
+}
+
+// ParseSize resolves a size name, defaulting to DefaultSize.
+func ParseSize(name string) (int, error) {
+	if name == "" {
+		name = DefaultSize
+	}
+	px, ok := Sizes[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown thumbnail size %q", fileops.ErrInvalidPath, name)
+	}
+	return px, nil
+}
+
+// Get returns the path of the cached thumbnail of path with the given
+// longest edge, generating it first if needed. It returns the content
+// hash too, which callers can use as a validator.
+func (s *Service) Get(path string, size int) (thumbPath, hash string, err error) {
+	if !Supported(path) {
+		return "", "", ErrUnsupported
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", fileops.MapError(err)
+	}
+	if info.IsDir() {
+		return "", "", ErrUnsupported
+	}
+	hash, err = fileops.FileHash(path)
+	if err != nil {
+		return "", "", fileops.MapError(err)
+	}
+	thumbPath = filepath.Join(s.dir, hash[:2], fmt.Sprintf("%s-%d.jpg", hash, size))
+	if _, err := os.Stat(thumbPath); err == nil {
+		return thumbPath, hash, nil
+	}
+
+	s.mu.Lock()
+	if c, ok := s.inflight[thumbPath]; ok {
+		s.mu.Unlock()
+		<-c.done
+		return thumbPath, hash, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	s.inflight[thumbPath] = c
+	s.mu.Unlock()
+
+	c.err = generate(path, thumbPath, size)
+	s.mu.Lock()
+	delete(s.inflight, thumbPath)
+	s.mu.Unlock()
+	close(c.done)
+	return thumbPath, hash, c.err
+}
+
+// generate writes the thumbnail of src to dst, through a temporary file so
+// readers never see a partial image.
+func generate(src, dst string, size int) error {
+	thumb, _, err := Scaled(src, size)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: Quality}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Scaled decodes the image at path, scales it so its longer edge is at
+// most size and turns it upright. It also returns the dimensions of the
+// upright original; for raw files those of the embedded preview.
+func Scaled(path string, size int) (image.Image, image.Point, error) {
+	img, orientation, err := decode(path)
+	if err != nil {
+		return nil, image.Point{}, err
+	}
+	b := img.Bounds()
+	full := image.Pt(b.Dx(), b.Dy())
+	if orientation >= 5 && orientation <= 8 {
+		full = image.Pt(full.Y, full.X)
+	}
+	return orient(resize(img, size), orientation), full, nil
+}
+
+// decode returns the image to scale down and its EXIF orientation.
+func decode(path string) (image.Image, int, error) {
+	orientation := 1
+	if x, err := media.ReadEXIF(path); err == nil && x.Orientation != 0 {
+		orientation = x.Orientation
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fileops.MapError(err)
+	}
+	defer f.Close()
+
+	if rawExtensions[strings.ToLower(filepath.Ext(path))] {
+		img, err := embeddedPreview(f)
+		return img, orientation, err
+	}
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, 0, fmt.Errorf("%w: image too large", ErrUnsupported)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, 0, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return img, orientation, nil
+}
+
+// resize scales img so its longer edge is at most size, over a white
+// background since JPEG has no transparency. Smaller images are not
+// enlarged.
+func resize(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/b.Dx())
+		} else {
+			w, h = max(1, w*size/b.Dy()), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// orient applies an EXIF orientation so the thumbnail appears upright.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if orientation >= 5 {
+		w, h = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = b.Dx()-1-x, y
+			case 3: // rotated 180°
+				dx, dy = b.Dx()-1-x, b.Dy()-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, b.Dy()-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = b.Dy()-1-y, x
+			case 7: // transversed
+				dx, dy = b.Dy()-1-y, b.Dx()-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, b.Dx()-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
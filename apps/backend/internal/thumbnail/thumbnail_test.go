@@ -0,0 +1,155 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xFF})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decodeJPEG(t *testing.T, path string) image.Config {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestGetSharesByContent(t *testing.T) {
+	dir := t.TempDir()
+	s := New(filepath.Join(dir, "cache"))
+	a := filepath.Join(dir, "a.png")
+	b := filepath.Join(dir, "copy of a.png")
+	writePNG(t, a, 400, 200)
+	data, _ := os.ReadFile(a)
+	os.WriteFile(b, data, 0o644)
+
+	thumbA, hash, err := s.Get(a, Sizes["small"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := decodeJPEG(t, thumbA); cfg.Width != 128 || cfg.Height != 64 {
+		t.Errorf("thumbnail is %dx%d, want 128x64", cfg.Width, cfg.Height)
+	}
+	thumbB, hashB, err := s.Get(b, Sizes["small"])
+	if err != nil || thumbB != thumbA || hashB != hash {
+		t.Errorf("copy got %s (%v), want shared %s", thumbB, err, thumbA)
+	}
+
+	// Small images are not enlarged.
+	thumbL, _, err := s.Get(a, Sizes["large"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := decodeJPEG(t, thumbL); cfg.Width != 400 {
+		t.Errorf("large thumbnail is %d wide, want 400", cfg.Width)
+	}
+
+	if _, _, err := s.Get(filepath.Join(dir, "notes.txt"), 128); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("text file: err = %v", err)
+	}
+	if _, err := ParseSize("huge"); err == nil {
+		t.Error("ParseSize accepted huge")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	rotated := orient(img, 6)
+	if b := rotated.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Fatalf("rotated bounds %v", b)
+	}
+	// The top-left pixel ends up top-right after a clockwise turn.
+	if r, _, _, _ := rotated.At(1, 0).RGBA(); r != 0xFFFF {
+		t.Error("pixel not rotated clockwise")
+	}
+}
+
+func TestEmbeddedPreview(t *testing.T) {
+	var preview bytes.Buffer
+	if err := jpeg.Encode(&preview, image.NewGray(image.Rect(0, 0, 300, 200)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var small bytes.Buffer
+	jpeg.Encode(&small, image.NewGray(image.Rect(0, 0, 30, 20)), nil)
+
+	// A fake RAW file: sensor data around a small and a large preview.
+	var raw bytes.Buffer
+	raw.WriteString("not a tiff header, just sensor data")
+	raw.Write(small.Bytes())
+	raw.Write(bytes.Repeat([]byte{0xFF, 0xD8, 0xFF, 0x00}, 4))
+	raw.Write(preview.Bytes())
+	raw.WriteString("trailing data")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "IMG_1.CR2")
+	if err := os.WriteFile(path, raw.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	thumb, _, err := New(filepath.Join(dir, "cache")).Get(path, Sizes["medium"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := decodeJPEG(t, thumb); cfg.Width != 256 {
+		t.Errorf("preview thumbnail is %d wide, want 256 from the larger preview", cfg.Width)
+	}
+}
+
+func TestGenerateJob(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "a.png"), 50, 50)
+	os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+	writePNG(t, filepath.Join(dir, "sub", "b.png"), 50, 50)
+	os.WriteFile(filepath.Join(dir, "broken.jpg"), []byte("nope"), 0o644)
+
+	s := New(filepath.Join(t.TempDir(), "cache"))
+	job, err := s.Generate(dir, false, []int{Sizes["small"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jobs := s.Jobs()
+		if jobs[0].ID == job.ID && jobs[0].EndedAt != nil {
+			if jobs[0].Total != 2 || jobs[0].Done != 1 || jobs[0].Failed != 1 {
+				t.Errorf("job = %+v", jobs[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,127 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// ServeFile writes the contents of path to w. Range, If-Range,
+// If-None-Match and If-Modified-Since requests are honoured, so browsers
+// can seek in videos and download managers can resume. With attachment
+// set the browser is asked to save the file instead of displaying it.
+func ServeFile(w http.ResponseWriter, r *http.Request, path string, attachment bool) error {
+	if path == "" {
+		return fileops.ErrInvalidPath
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return statError(err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return statError(err)
+	}
+	if info.IsDir() {
+		return fileops.ErrInvalidPath
+	}
+
+	mimeType, err := fileops.DetectMimeType(path)
+	if err != nil || mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	setHeaders(w, filepath.Base(path), mimeType, info, attachment)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	return nil
+}
+
+// ServeStream writes body, described by info, to w. It is used for files
+// that cannot seek, such as entries inside compressed archives, so ranges
+// are not supported; conditional requests still are.
+func ServeStream(w http.ResponseWriter, r *http.Request, body io.Reader, info fs.FileInfo, attachment bool) error {
+	if info.IsDir() {
+		return fileops.ErrInvalidPath
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(info.Name())))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	setHeaders(w, info.Name(), mimeType, info, attachment)
+	w.Header().Set("Accept-Ranges", "none")
+	etag := w.Header().Get("ETag")
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	// Once the body has started an error can only cut the response short.
+	io.Copy(w, body)
+	return nil
+}
+
+// activeTypes can run script when a browser displays them, which would
+// happen on the API's own origin.
+var activeTypes = map[string]bool{
+	"text/html":              true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"text/xml":               true,
+	"application/xml":        true,
+	"text/javascript":        true,
+	"application/javascript": true,
+}
+
+// isActive reports whether mimeType is one of activeTypes, ignoring
+// parameters such as the charset.
+func isActive(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	return err != nil || activeTypes[strings.ToLower(mediaType)]
+}
+
+// setHeaders describes the response. Active types are always sent as
+// attachments and sandboxed, so a stored HTML or SVG file cannot run
+// script with access to the API.
+func setHeaders(w http.ResponseWriter, name, mimeType string, info fs.FileInfo, attachment bool) {
+	disposition := "inline"
+	if isActive(mimeType) {
+		attachment = true
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	if attachment {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": name}))
+	w.Header().Set("ETag", ETag(info))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// ETag returns a strong validator derived from size and mtime, which
+// changes whenever the file is rewritten without reading its contents.
+func ETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+func statError(err error) error {
+	switch {
+	case os.IsNotExist(err):
+		return fileops.ErrPathNotFound
+	case os.IsPermission(err):
+		return fileops.ErrPermissionDenied
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+func TestServeFileRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clip.txt")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	rec := httptest.NewRecorder()
+	if err := ServeFile(rec, req, path, true); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", rec.Code)
+	}
+	if got := rec.Body.String(); got != "2345" {
+		t.Errorf("body = %q, want %q", got, "2345")
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Errorf("Content-Range = %q", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename=clip.txt` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("missing ETag")
+	}
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	if err := ServeFile(rec, req, path, false); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("conditional status = %d, want 304", rec.Code)
+	}
+}
+
+func TestServeFileSandboxesActiveTypes(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"page.html": "<html><script>alert(1)</script></html>",
+		"logo.svg":  `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		if err := ServeFile(rec, httptest.NewRequest(http.MethodGet, "/", nil), path, false); err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment") {
+			t.Errorf("%s: Content-Disposition = %q, want attachment", name, got)
+		}
+		if got := rec.Header().Get("Content-Security-Policy"); got != "sandbox" {
+			t.Errorf("%s: Content-Security-Policy = %q", name, got)
+		}
+	}
+
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte("plain"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	if err := ServeFile(rec, httptest.NewRequest(http.MethodGet, "/", nil), path, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "inline") {
+		t.Errorf("plain text: Content-Disposition = %q, want inline", got)
+	}
+}
+
+func TestServeFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := ServeFile(rec, req, filepath.Join(dir, "missing"), false); !errors.Is(err, fileops.ErrPathNotFound) {
+		t.Errorf("missing file: err = %v", err)
+	}
+	if err := ServeFile(rec, req, dir, false); !errors.Is(err, fileops.ErrInvalidPath) {
+		t.Errorf("directory: err = %v", err)
+	}
+}
+
+// failingReader returns its data and then an error, like a dropped
+// connection.
+type failingReader struct{ r io.Reader }
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestUploadResume(t *testing.T) {
+	target := t.TempDir()
+	m, err := NewUploads(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(strings.Repeat("abcdefgh", 1000))
+
+	u, err := m.Create(target, "big.bin", int64(len(data)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first chunk is interrupted part way through.
+	u, err = m.Write(u.ID, 0, &failingReader{bytes.NewReader(data[:3000])})
+	if err == nil {
+		t.Fatal("expected error from interrupted write")
+	}
+	if u.Offset != 3000 {
+		t.Fatalf("offset after interruption = %d, want 3000", u.Offset)
+	}
+
+	// A fresh manager over the same staging directory sees the progress.
+	m, err = NewUploads(m.dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = m.Get(u.ID)
+	if err != nil || u.Offset != 3000 {
+		t.Fatalf("Get = %+v, %v", u, err)
+	}
+	if _, err := m.Write(u.ID, 0, bytes.NewReader(data)); !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("stale offset: err = %v", err)
+	}
+
+	u, err = m.Write(u.ID, 3000, bytes.NewReader(data[3000:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Complete() || u.Path != filepath.Join(target, "big.bin") {
+		t.Fatalf("upload = %+v, want complete", u)
+	}
+	got, err := os.ReadFile(u.Path)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("uploaded file differs (err %v)", err)
+	}
+	if _, err := m.Get(u.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("finished upload still present: %v", err)
+	}
+}
+
+func TestUploadCreate(t *testing.T) {
+	target := t.TempDir()
+	m, err := NewUploads(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "exists.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, file string
+		size       int64
+		want       error
+	}{
+		{"too large", "a.txt", 101, ErrUploadTooLarge},
+		{"conflict", "exists.txt", 1, fileops.ErrAlreadyExists},
+		{"traversal", "../a.txt", 1, fileops.ErrInvalidPath},
+		{"empty name", "", 1, fileops.ErrInvalidPath},
+	}
+	for _, tt := range tests {
+		if _, err := m.Create(target, tt.file, tt.size, false); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	u, err := m.Create(target, "empty.txt", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path == "" {
+		t.Error("empty upload was not completed on creation")
+	}
+	if _, err := m.Get("../../etc/passwd"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("invalid id: err = %v", err)
+	}
+}
+
+func TestUploadExpire(t *testing.T) {
+	m, err := NewUploads(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := m.Create(t.TempDir(), "a.txt", 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := m.Expire(time.Hour); n != 0 {
+		t.Errorf("Expire removed %d fresh uploads", n)
+	}
+	if n, _ := m.Expire(-time.Second); n != 1 {
+		t.Errorf("Expire removed %d uploads, want 1", n)
+	}
+	if err := m.Terminate(u.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("Terminate after expiry: err = %v", err)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	meta, err := ParseMetadata("filename cmVwb3J0LnBkZg==,dir L3RtcA==, overwrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["filename"] != "report.pdf" || meta["dir"] != "/tmp" {
+		t.Errorf("meta = %v", meta)
+	}
+	if _, ok := meta["overwrite"]; !ok {
+		t.Error("key without value missing")
+	}
+	if _, err := ParseMetadata("filename !!!"); err == nil {
+		t.Error("expected error for invalid base64")
+	}
+}
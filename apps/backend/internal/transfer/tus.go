@@ -0,0 +1,31 @@
+package transfer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// TusVersion is the version of the tus resumable upload protocol spoken by
+// the upload endpoints. The creation and termination extensions are
+// supported.
+const TusVersion = "1.0.0"
+
+// ParseMetadata decodes a tus Upload-Metadata header: comma-separated
+// pairs of a key and an optional base64 value.
+func ParseMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for %q", key)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
@@ -0,0 +1,291 @@
+package transfer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadTooLarge = errors.New("upload too large")
+	ErrUploadBusy     = errors.New("upload is being written")
+)
+
+// Upload is a file being received in chunks. Data is appended to a staging
+// file until Offset reaches Size, then moved to Dir/Name.
+type Upload struct {
+	ID        string    `json:"id"`
+	Dir       string    `json:"dir"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	Overwrite bool      `json:"overwrite"`
+	Created   time.Time `json:"created"`
+	// Path is set once the upload is complete and moved into place.
+	Path string `json:"path,omitempty"`
+}
+
+// Complete reports whether all bytes have been received.
+func (u *Upload) Complete() bool {
+	return u.Offset >= u.Size
+}
+
+// Uploads manages resumable uploads staged in a directory. Each upload is
+// a pair of files, <id>.json with its description and <id>.part with the
+// bytes received so far, so uploads survive server restarts; the offset is
+// always the size of the .part file.
+type Uploads struct {
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// NewUploads stages uploads in dir, creating it if needed. maxSize limits
+// the size of a single upload; zero means no limit.
+func NewUploads(dir string, maxSize int64) (*Uploads, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Uploads{dir: dir, maxSize: maxSize, busy: make(map[string]bool)}, nil
+}
+
+// MaxSize returns the upload size limit, or zero if there is none.
+func (m *Uploads) MaxSize() int64 {
+	return m.maxSize
+}
+
+// Create starts an upload of size bytes that will be saved as dir/name.
+// Empty files are completed immediately.
+func (m *Uploads) Create(dir, name string, size int64, overwrite bool) (*Upload, error) {
+	if dir == "" || !fileops.ValidName(name) || size < 0 {
+		return nil, fileops.ErrInvalidPath
+	}
+	if m.maxSize > 0 && size > m.maxSize {
+		return nil, ErrUploadTooLarge
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, statError(err)
+	}
+	if !info.IsDir() {
+		return nil, fileops.ErrInvalidPath
+	}
+	if !overwrite {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			return nil, fileops.ErrAlreadyExists
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	u := &Upload{ID: id, Dir: dir, Name: name, Size: size, Overwrite: overwrite, Created: time.Now()}
+	f, err := os.OpenFile(m.partPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if err := m.saveInfo(u); err != nil {
+		os.Remove(m.partPath(id))
+		return nil, err
+	}
+	if u.Complete() {
+		if err := m.finish(u); err != nil {
+			return u, err
+		}
+	}
+	return u, nil
+}
+
+// Get returns the current state of an unfinished upload.
+func (m *Uploads) Get(id string) (*Upload, error) {
+	if !validID(id) {
+		return nil, ErrUploadNotFound
+	}
+	data, err := os.ReadFile(m.infoPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	u := &Upload{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(m.partPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	u.Offset = info.Size()
+	return u, nil
+}
+
+// Write appends the bytes read from r to the upload. offset must equal the
+// number of bytes already received. Whatever arrives before r fails is
+// kept, so the client can resume from the returned offset. When the last
+// byte arrives the file is moved into place and Path is set.
+func (m *Uploads) Write(id string, offset int64, r io.Reader) (*Upload, error) {
+	if !m.acquire(id) {
+		return nil, ErrUploadBusy
+	}
+	defer m.release(id)
+
+	u, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != u.Offset {
+		return u, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(m.partPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return u, err
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(r, u.Size-u.Offset))
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	u.Offset += n
+	if copyErr != nil {
+		return u, copyErr
+	}
+
+	if u.Complete() {
+		if err := m.finish(u); err != nil {
+			return u, err
+		}
+	}
+	return u, nil
+}
+
+// Terminate abandons an upload and deletes the received bytes.
+func (m *Uploads) Terminate(id string) error {
+	if !m.acquire(id) {
+		return ErrUploadBusy
+	}
+	defer m.release(id)
+
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	return m.remove(id)
+}
+
+// Expire removes unfinished uploads created more than maxAge ago and
+// returns how many were removed.
+func (m *Uploads) Expire(maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		u, err := m.Get(id)
+		if err != nil || u.Created.After(cutoff) {
+			continue
+		}
+		if m.Terminate(id) == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// finish moves a complete upload to its destination.
+func (m *Uploads) finish(u *Upload) error {
+	dst := filepath.Join(u.Dir, u.Name)
+	if !u.Overwrite {
+		if _, err := os.Lstat(dst); err == nil {
+			return fileops.ErrAlreadyExists
+		}
+	}
+	if err := fileops.MoveFile(m.partPath(u.ID), dst); err != nil {
+		return statError(err)
+	}
+	u.Path = dst
+	return m.remove(u.ID)
+}
+
+func (m *Uploads) remove(id string) error {
+	if err := os.Remove(m.partPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(m.infoPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (m *Uploads) saveInfo(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := m.infoPath(u.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.infoPath(u.ID))
+}
+
+func (m *Uploads) acquire(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.busy[id] {
+		return false
+	}
+	m.busy[id] = true
+	return true
+}
+
+func (m *Uploads) release(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.busy, id)
+}
+
+func (m *Uploads) partPath(id string) string { return filepath.Join(m.dir, id+".part") }
+func (m *Uploads) infoPath(id string) string { return filepath.Join(m.dir, id+".json") }
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating upload id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validID rejects anything that is not an id produced by newID, so ids
+// taken from URLs cannot escape the staging directory.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,443 @@
+// Package trash implements the freedesktop.org Trash specification, so
+// files deleted through the API can be restored from the desktop trash and
+// the other way round.
+package trash
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// dateLayout is the DeletionDate format: local time without a zone.
+const dateLayout = "2006-01-02T15:04:05"
+
+// ErrNotInTrash is returned for ids that do not name a trashed item.
+var ErrNotInTrash = errors.New("not in trash")
+
+// Item is a trashed file or folder. ID is the location of the item inside
+// the trash and is what Restore and Delete expect.
+type Item struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	OriginalPath string    `json:"originalPath"`
+	DeletionDate time.Time `json:"deletionDate"`
+	Size         int64     `json:"size"`
+	IsDirectory  bool      `json:"isDirectory"`
+}
+
+// Trash moves files to the home trash or, for files on other volumes, to
+// the trash at the top of that volume.
+type Trash struct {
+	home   string
+	uid    int
+	mounts func() []string
+}
+
+// New returns a trash whose home trash directory is home; see HomeDir.
+func New(home string) *Trash {
+	return &Trash{home: home, uid: os.Getuid(), mounts: mountPoints}
+}
+
+// HomeDir returns the home trash location, $XDG_DATA_HOME/Trash, which
+// defaults to ~/.local/share/Trash.
+func HomeDir() (string, error) {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dataHome) {
+		return filepath.Join(dataHome, "Trash"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "Trash"), nil
+}
+
+// trashDir is one trash directory with its files and info subdirectories.
+// Paths in its .trashinfo files are relative to topdir, or absolute when
+// topdir is empty, as in the home trash.
+type trashDir struct {
+	dir    string
+	topdir string
+}
+
+func (d trashDir) filesDir() string { return filepath.Join(d.dir, "files") }
+func (d trashDir) infoDir() string  { return filepath.Join(d.dir, "info") }
+
+func (d trashDir) create() error {
+	if err := os.MkdirAll(d.filesDir(), 0o700); err != nil {
+		return err
+	}
+	return os.MkdirAll(d.infoDir(), 0o700)
+}
+
+// Trash moves path to the trash and returns the trashed item.
+func (t *Trash) Trash(path string) (Item, error) {
+	if path == "" {
+		return Item{}, fileops.ErrInvalidPath
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return Item{}, fileops.ErrInvalidPath
+	}
+	if path == filepath.Dir(path) {
+		return Item{}, fileops.ErrInvalidPath
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Item{}, fileops.MapError(err)
+	}
+
+	d, err := t.dirFor(path, info)
+	if err != nil {
+		return Item{}, err
+	}
+	if fileops.IsWithin(path, d.dir) || path == d.dir {
+		return Item{}, fileops.ErrInvalidPath // already in the trash
+	}
+
+	stored := path
+	if d.topdir != "" {
+		if stored, err = filepath.Rel(d.topdir, path); err != nil {
+			return Item{}, err
+		}
+	}
+	now := time.Now()
+	name, err := reserveName(d, filepath.Base(path), stored, now)
+	if err != nil {
+		return Item{}, err
+	}
+	id := filepath.Join(d.filesDir(), name)
+	if err := fileops.MoveFile(path, id); err != nil {
+		os.Remove(filepath.Join(d.infoDir(), name+".trashinfo"))
+		return Item{}, fileops.MapError(err)
+	}
+	return Item{
+		ID:           id,
+		Name:         filepath.Base(path),
+		OriginalPath: path,
+		DeletionDate: now.Truncate(time.Second),
+		Size:         itemSize(info),
+		IsDirectory:  info.IsDir(),
+	}, nil
+}
+
+// dirFor picks the trash directory for a file: the home trash when the
+// file is on the same device, otherwise the trash at the top of the
+// file's volume, falling back to the home trash if that cannot be used.
+func (t *Trash) dirFor(path string, info fs.FileInfo) (trashDir, error) {
+	home := trashDir{dir: t.home}
+	if err := home.create(); err != nil {
+		return home, fileops.MapError(err)
+	}
+	homeInfo, err := os.Stat(t.home)
+	if err != nil {
+		return home, fileops.MapError(err)
+	}
+	fileDev, _ := fileops.FileID(info)
+	homeDev, _ := fileops.FileID(homeInfo)
+	if fileDev == homeDev || t.uid < 0 {
+		return home, nil
+	}
+
+	topdir := mountRoot(path, fileDev)
+	for _, d := range t.volumeDirs(topdir) {
+		if err := d.create(); err == nil {
+			return d, nil
+		}
+	}
+	return home, nil
+}
+
+// volumeDirs returns the candidate trash directories at the top of a
+// volume: $topdir/.Trash/$uid, usable only when .Trash is a real directory
+// with the sticky bit set, and $topdir/.Trash-$uid.
+func (t *Trash) volumeDirs(topdir string) []trashDir {
+	var dirs []trashDir
+	shared := filepath.Join(topdir, ".Trash")
+	if info, err := os.Lstat(shared); err == nil && info.IsDir() && info.Mode()&fs.ModeSticky != 0 {
+		dirs = append(dirs, trashDir{dir: filepath.Join(shared, strconv.Itoa(t.uid)), topdir: topdir})
+	}
+	return append(dirs, trashDir{dir: filepath.Join(topdir, ".Trash-"+strconv.Itoa(t.uid)), topdir: topdir})
+}
+
+// mountRoot returns the top directory of the volume holding path.
+func mountRoot(path string, dev uint64) string {
+	dir := filepath.Dir(path)
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		info, err := os.Stat(parent)
+		if err != nil {
+			return dir
+		}
+		if d, _ := fileops.FileID(info); d != dev {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// reserveName claims a free name in the trash by creating its .trashinfo
+// file exclusively, which the spec requires to happen before the move.
+func reserveName(d trashDir, base, stored string, now time.Time) (string, error) {
+	content := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: stored}).EscapedPath(), now.Format(dateLayout))
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name = fmt.Sprintf("%s.%d%s", stem, i, ext)
+		}
+		if _, err := os.Lstat(filepath.Join(d.filesDir(), name)); err == nil {
+			continue
+		}
+		f, err := os.OpenFile(filepath.Join(d.infoDir(), name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fileops.MapError(err)
+		}
+		_, err = f.WriteString(content)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+		return name, nil
+	}
+}
+
+// List returns the items in the home trash and in the trash directories of
+// mounted volumes, most recently deleted first.
+func (t *Trash) List() ([]Item, error) {
+	var items []Item
+	for _, d := range t.dirs() {
+		entries, err := os.ReadDir(d.infoDir())
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutSuffix(e.Name(), ".trashinfo")
+			if !ok {
+				continue
+			}
+			item, err := readItem(d, name)
+			if err != nil {
+				continue // orphaned or malformed entries are skipped
+			}
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletionDate.After(items[j].DeletionDate)
+	})
+	return items, nil
+}
+
+// Restore moves a trashed item back to where it was deleted from and
+// returns that path. Missing parent folders are recreated.
+func (t *Trash) Restore(id string) (string, error) {
+	d, name, err := t.locate(id)
+	if err != nil {
+		return "", err
+	}
+	item, err := readItem(d, name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(item.OriginalPath); err == nil {
+		return "", fileops.ErrAlreadyExists
+	}
+	if err := os.MkdirAll(filepath.Dir(item.OriginalPath), 0o755); err != nil {
+		return "", fileops.MapError(err)
+	}
+	if err := fileops.MoveFile(item.ID, item.OriginalPath); err != nil {
+		return "", fileops.MapError(err)
+	}
+	return item.OriginalPath, fileops.MapError(os.Remove(filepath.Join(d.infoDir(), name+".trashinfo")))
+}
+
+// Delete permanently removes one trashed item.
+func (t *Trash) Delete(id string) error {
+	d, name, err := t.locate(id)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(d.filesDir(), name)); err != nil {
+		return fileops.MapError(err)
+	}
+	return fileops.MapError(os.Remove(filepath.Join(d.infoDir(), name+".trashinfo")))
+}
+
+// Empty permanently removes everything in every trash directory and
+// returns the number of items removed.
+func (t *Trash) Empty() (int, error) {
+	items, err := t.List()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, item := range items {
+		if err := t.Delete(item.ID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// locate checks that id is an item in one of the trash directories, so
+// ids from requests cannot point anywhere else.
+func (t *Trash) locate(id string) (trashDir, string, error) {
+	name := filepath.Base(id)
+	filesDir := filepath.Dir(filepath.Clean(id))
+	for _, d := range t.dirs() {
+		if d.filesDir() != filesDir || !fileops.ValidName(name) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(d.infoDir(), name+".trashinfo")); err != nil {
+			return d, "", ErrNotInTrash
+		}
+		return d, name, nil
+	}
+	return trashDir{}, "", ErrNotInTrash
+}
+
+// dirs returns the home trash and the existing trash directories of
+// mounted volumes.
+func (t *Trash) dirs() []trashDir {
+	dirs := []trashDir{{dir: t.home}}
+	if t.uid < 0 {
+		return dirs
+	}
+	for _, mount := range t.mounts() {
+		for _, d := range t.volumeDirs(mount) {
+			if info, err := os.Stat(d.infoDir()); err == nil && info.IsDir() && d.dir != t.home {
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	return dirs
+}
+
+// mountPoints lists mounted filesystems from /proc/self/mounts. Where it
+// is unavailable only the home trash is used.
+func mountPoints() []string {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var mounts []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		// Spaces and other special characters are octal-escaped.
+		mount := unescapeMount(fields[1])
+		if !seen[mount] {
+			seen[mount] = true
+			mounts = append(mounts, mount)
+		}
+	}
+	return mounts
+}
+
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// readItem parses the .trashinfo file of name.
+func readItem(d trashDir, name string) (Item, error) {
+	data, err := os.ReadFile(filepath.Join(d.infoDir(), name+".trashinfo"))
+	if err != nil {
+		return Item{}, fileops.MapError(err)
+	}
+	var stored, date string
+	inSection := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inSection = line == "[Trash Info]"
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !inSection || !ok {
+			continue
+		}
+		switch key {
+		case "Path":
+			stored = value
+		case "DeletionDate":
+			date = value
+		}
+	}
+	original, err := url.PathUnescape(stored)
+	if err != nil || original == "" {
+		return Item{}, fmt.Errorf("trash: invalid Path in %s.trashinfo", name)
+	}
+	if !filepath.IsAbs(original) {
+		if d.topdir == "" {
+			return Item{}, fmt.Errorf("trash: relative Path in home trash entry %s", name)
+		}
+		original = filepath.Join(d.topdir, original)
+	}
+
+	id := filepath.Join(d.filesDir(), name)
+	info, err := os.Lstat(id)
+	if err != nil {
+		return Item{}, fileops.MapError(err)
+	}
+	deleted, _ := time.ParseInLocation(dateLayout, date, time.Local)
+	return Item{
+		ID:           id,
+		Name:         filepath.Base(original),
+		OriginalPath: filepath.Clean(original),
+		DeletionDate: deleted,
+		Size:         itemSize(info),
+		IsDirectory:  info.IsDir(),
+	}, nil
+}
+
+// itemSize reports file sizes; folders are reported as zero rather than
+// walking their contents.
+func itemSize(info fs.FileInfo) int64 {
+	if info.IsDir() {
+		return 0
+	}
+	return info.Size()
+}
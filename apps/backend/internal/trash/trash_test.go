@@ -0,0 +1,164 @@
+package trash
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"file-manager-backend/internal/fileops"
+)
+
+func setupTrash(t *testing.T) (*Trash, string) {
+	t.Helper()
+	root := t.TempDir()
+	home := filepath.Join(root, "Trash")
+	data := filepath.Join(root, "data")
+	if err := os.MkdirAll(filepath.Join(data, "docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tr := New(home)
+	// Ignore trash directories on the machine running the tests.
+	tr.mounts = func() []string { return nil }
+	return tr, data
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	tr, data := setupTrash(t)
+	path := filepath.Join(data, "docs", "my report.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := tr.Trash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("file still exists after trashing")
+	}
+	info, err := os.ReadFile(filepath.Join(tr.home, "info", "my report.txt.trashinfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(info), "[Trash Info]\nPath=") || !strings.Contains(string(info), "my%20report.txt\n") {
+		t.Errorf("unexpected trashinfo:\n%s", info)
+	}
+
+	items, err := tr.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].OriginalPath != path || items[0].Size != 5 || items[0].ID != item.ID {
+		t.Fatalf("List = %+v", items)
+	}
+
+	restored, err := tr.Restore(item.ID)
+	if err != nil || restored != path {
+		t.Fatalf("Restore = %q, %v", restored, err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "hello" {
+		t.Errorf("restored content = %q, %v", b, err)
+	}
+	if items, _ := tr.List(); len(items) != 0 {
+		t.Errorf("trash not empty after restore: %+v", items)
+	}
+}
+
+func TestTrashNameCollision(t *testing.T) {
+	tr, data := setupTrash(t)
+	path := filepath.Join(data, "a.txt")
+	var ids []string
+	for i := 0; i < 2; i++ {
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		item, err := tr.Trash(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, item.ID)
+	}
+	if filepath.Base(ids[1]) != "a.2.txt" {
+		t.Errorf("second item stored as %q, want a.2.txt", filepath.Base(ids[1]))
+	}
+
+	// Restoring onto an existing file is refused.
+	if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Restore(ids[0]); !errors.Is(err, fileops.ErrAlreadyExists) {
+		t.Errorf("restore over existing: err = %v", err)
+	}
+
+	n, err := tr.Empty()
+	if err != nil || n != 2 {
+		t.Errorf("Empty = %d, %v", n, err)
+	}
+}
+
+func TestRestoreRecreatesParent(t *testing.T) {
+	tr, data := setupTrash(t)
+	dir := filepath.Join(data, "docs")
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	item, err := tr.Trash(filepath.Join(dir, "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Restore(item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.txt")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLocateRejectsForeignPaths(t *testing.T) {
+	tr, data := setupTrash(t)
+	outside := filepath.Join(data, "docs")
+	for _, id := range []string{outside, filepath.Join(tr.home, "files", "..", "..", "data"), ""} {
+		if err := tr.Delete(id); !errors.Is(err, ErrNotInTrash) {
+			t.Errorf("Delete(%q): err = %v", id, err)
+		}
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Error("folder outside the trash was removed")
+	}
+}
+
+func TestReadItemRelativePath(t *testing.T) {
+	top := t.TempDir()
+	d := trashDir{dir: filepath.Join(top, ".Trash-1000"), topdir: top}
+	if err := d.create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(d.filesDir(), "c.txt"), []byte("abc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info := "[Trash Info]\nPath=photos/c.txt\nDeletionDate=2024-05-06T07:08:09\n"
+	if err := os.WriteFile(filepath.Join(d.infoDir(), "c.txt.trashinfo"), []byte(info), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	item, err := readItem(d, "c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.OriginalPath != filepath.Join(top, "photos", "c.txt") {
+		t.Errorf("OriginalPath = %q", item.OriginalPath)
+	}
+	if item.DeletionDate.Format(dateLayout) != "2024-05-06T07:08:09" {
+		t.Errorf("DeletionDate = %v", item.DeletionDate)
+	}
+}
+
+func TestUnescapeMount(t *testing.T) {
+	if got := unescapeMount(`/media/My\040Disk`); got != "/media/My Disk" {
+		t.Errorf("unescapeMount = %q", got)
+	}
+}
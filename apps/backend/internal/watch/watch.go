@@ -0,0 +1,204 @@
+// Package watch reports changes below folders as they happen, so indexes
+// can be updated between periodic rescans. It uses inotify on Linux; on
+// other platforms New returns ErrUnsupported and callers rely on rescans.
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"file-manager-backend/internal/fileops"
+)
+
+// ErrUnsupported is returned by New on platforms without a change
+// notification backend.
+var ErrUnsupported = errors.New("watch: not supported on this platform")
+
+// ErrLimit is returned by Add when the system allows no more watches; on
+// Linux the limit is fs.inotify.max_user_watches.
+var ErrLimit = errors.New("watch: system watch limit reached")
+
+// DefaultDelay is how long changes are collected before they are reported.
+const DefaultDelay = time.Second
+
+// maxPending caps the paths collected within one delay. Beyond it, the
+// watched roots are reported instead, so a bulk copy turns into one
+// refresh per root rather than an unbounded list.
+const maxPending = 10000
+
+// Watcher watches registered roots and every visible folder below them.
+// Hidden files and folders are ignored, matching the search index.
+type Watcher struct {
+	// Delay is how long changes are collected, and repeated changes to
+	// the same path merged, before they are reported.
+	Delay time.Duration
+
+	b      *backend
+	events chan string
+	done   chan struct{}
+
+	mu    sync.Mutex
+	roots map[string]bool
+}
+
+// New starts a watcher with no roots.
+func New() (*Watcher, error) {
+	b, err := newBackend()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		Delay:  DefaultDelay,
+		b:      b,
+		events: make(chan string, 1024),
+		done:   make(chan struct{}),
+		roots:  make(map[string]bool),
+	}
+	go b.read(w.handle, w.overflow)
+	return w, nil
+}
+
+// Add watches root and the folders below it. Folders that cannot be
+// watched are skipped, except when the system's watch limit is reached,
+// which is returned so the caller knows changes may be missed.
+func (w *Watcher) Add(root string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return fileops.ErrInvalidPath
+	}
+	if err := w.b.watch(root); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.roots[root] = true
+	w.mu.Unlock()
+	return w.addBelow(root)
+}
+
+// Remove stops watching root and everything below it.
+func (w *Watcher) Remove(root string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return fileops.ErrInvalidPath
+	}
+	w.mu.Lock()
+	delete(w.roots, root)
+	w.mu.Unlock()
+	w.b.unwatch(root)
+	return nil
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() error {
+	close(w.done)
+	return w.b.close()
+}
+
+// Run calls changed for every path created, modified, moved or removed
+// below a root, until ctx is cancelled or the watcher is closed. Changes
+// are reported after Delay, each path once, in sorted order.
+func (w *Watcher) Run(ctx context.Context, changed func(path string)) {
+	pending := make(map[string]bool)
+	timer := time.NewTimer(w.Delay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.done:
+			return
+		case path := <-w.events:
+			if len(pending) == 0 {
+				timer.Reset(w.Delay)
+			}
+			if len(pending) < maxPending {
+				pending[path] = true
+			} else {
+				pending = make(map[string]bool)
+				for _, root := range w.Roots() {
+					pending[root] = true
+				}
+			}
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			pending = make(map[string]bool)
+			for _, path := range paths {
+				changed(path)
+			}
+		}
+	}
+}
+
+// Roots returns the watched roots.
+func (w *Watcher) Roots() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	roots := make([]string, 0, len(w.roots))
+	for root := range w.roots {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// addBelow watches the visible folders below dir.
+func (w *Watcher) addBelow(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir || !d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if err := w.b.watch(path); err != nil {
+			if errors.Is(err, ErrLimit) {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// handle is called by the backend for each change. New folders are
+// watched right away so files created in them are not missed.
+func (w *Watcher) handle(path string, isDir, created, removed bool) {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return
+	}
+	if isDir && created {
+		if err := w.b.watch(path); err == nil {
+			w.addBelow(path)
+		}
+	}
+	if isDir && removed {
+		w.b.unwatch(path)
+	}
+	w.send(path)
+}
+
+// overflow is called when the backend dropped events; every root is
+// reported so callers refresh everything.
+func (w *Watcher) overflow() {
+	for _, root := range w.Roots() {
+		w.send(root)
+	}
+}
+
+func (w *Watcher) send(path string) {
+	select {
+	case w.events <- path:
+	case <-w.done:
+	}
+}
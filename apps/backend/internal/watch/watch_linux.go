@@ -0,0 +1,112 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mask selects the inotify events that change a folder's entries or a
+// file's contents.
+const mask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_CLOSE_WRITE | unix.IN_ATTRIB | unix.IN_ONLYDIR
+
+// backend reads inotify events. The descriptor is non-blocking and wrapped
+// in an os.File, so reads go through the runtime poller and closing the
+// file ends read.
+type backend struct {
+	fd int
+	f  *os.File
+
+	mu   sync.Mutex
+	dirs map[int]string // watch descriptor to folder
+	wds  map[string]int
+}
+
+func newBackend() (*backend, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	return &backend{
+		fd:   fd,
+		f:    os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int]string),
+		wds:  make(map[string]int),
+	}, nil
+}
+
+func (b *backend) watch(dir string) error {
+	wd, err := unix.InotifyAddWatch(b.fd, dir, mask)
+	if err == unix.ENOSPC {
+		return ErrLimit
+	}
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dirs[wd] = dir
+	b.wds[dir] = wd
+	return nil
+}
+
+// unwatch drops the watches on dir and every folder below it.
+func (b *backend) unwatch(dir string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prefix := dir + string(filepath.Separator)
+	for path, wd := range b.wds {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			unix.InotifyRmWatch(b.fd, uint32(wd))
+			delete(b.wds, path)
+			delete(b.dirs, wd)
+		}
+	}
+}
+
+// read decodes events until the backend is closed.
+func (b *backend) read(handle func(path string, isDir, created, removed bool), overflow func()) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := b.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + unix.SizeofInotifyEvent
+			off = nameStart + int(ev.Len)
+			if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+				overflow()
+				continue
+			}
+
+			b.mu.Lock()
+			dir, ok := b.dirs[int(ev.Wd)]
+			if ev.Mask&unix.IN_IGNORED != 0 && ok {
+				delete(b.dirs, int(ev.Wd))
+				if b.wds[dir] == int(ev.Wd) {
+					delete(b.wds, dir)
+				}
+			}
+			b.mu.Unlock()
+			if !ok || ev.Len == 0 || off > n {
+				continue
+			}
+
+			name := strings.TrimRight(string(buf[nameStart:off]), "\x00")
+			handle(filepath.Join(dir, name), ev.Mask&unix.IN_ISDIR != 0,
+				ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0,
+				ev.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0)
+		}
+	}
+}
+
+func (b *backend) close() error {
+	return b.f.Close()
+}
@@ -0,0 +1,105 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// collect runs w until want has been reported or the timeout passes.
+func collect(t *testing.T, w *Watcher, want ...string) map[string]bool {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got := make(map[string]bool)
+	changed := make(chan string)
+	go w.Run(ctx, func(path string) {
+		select {
+		case changed <- path:
+		case <-ctx.Done():
+		}
+	})
+	for {
+		done := true
+		for _, p := range want {
+			done = done && got[p]
+		}
+		if done {
+			return got
+		}
+		select {
+		case p := <-changed:
+			got[p] = true
+		case <-ctx.Done():
+			t.Fatalf("reported %v, want %v", got, want)
+		}
+	}
+}
+
+func TestWatcherReportsChanges(t *testing.T) {
+	root := t.TempDir()
+	existing := filepath.Join(root, "existing")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Delay = 10 * time.Millisecond
+	if err := w.Add(root); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(existing, "a.txt")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A folder created after Add is watched as well.
+	added := filepath.Join(root, "added")
+	if err := os.Mkdir(added, 0755); err != nil {
+		t.Fatal(err)
+	}
+	collect(t, w, file, added)
+
+	inner := filepath.Join(added, "b.txt")
+	if err := os.WriteFile(inner, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".hidden"), []byte("h"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	got := collect(t, w, inner, file)
+	if got[filepath.Join(root, ".hidden")] {
+		t.Error("hidden files should not be reported")
+	}
+}
+
+func TestWatcherRemove(t *testing.T) {
+	root := t.TempDir()
+	w, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Add(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Remove(root); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Roots()) != 0 {
+		t.Errorf("Roots() = %v after Remove", w.Roots())
+	}
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	if len(w.b.wds) != 0 {
+		t.Errorf("%d watches left after Remove", len(w.b.wds))
+	}
+}
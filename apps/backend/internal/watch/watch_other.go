@@ -0,0 +1,18 @@
+//go:build !linux
+
+package watch
+
+// backend is a stub on platforms without a change notification backend.
+type backend struct{}
+
+func newBackend() (*backend, error) {
+	return nil, ErrUnsupported
+}
+
+func (b *backend) watch(dir string) error { return ErrUnsupported }
+
+func (b *backend) unwatch(dir string) {}
+
+func (b *backend) read(handle func(path string, isDir, created, removed bool), overflow func()) {}
+
+func (b *backend) close() error { return nil }